@@ -16,4 +16,6 @@ go get github.com/BurntSushi/toml
 
 go get github.com/siddontang/go-bson/bson
 
+go get github.com/yuin/gopher-lua
+
 go get github.com/siddontang/go-tokuft/tokuft
\ No newline at end of file
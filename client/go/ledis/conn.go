@@ -64,6 +64,44 @@ func (c *Conn) Do(cmd string, args ...interface{}) (interface{}, error) {
 	}
 }
 
+// Send writes the command to the server without reading the reply,
+// it is used with Receive for the pub/sub commands.
+func (c *Conn) Send(cmd string, args ...interface{}) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	if err := c.writeCommand(cmd, args); err != nil {
+		c.finalize()
+		return err
+	}
+
+	if err := c.bw.Flush(); err != nil {
+		c.finalize()
+		return err
+	}
+
+	return nil
+}
+
+// Receive reads a reply or a pushed message from the server.
+func (c *Conn) Receive() (interface{}, error) {
+	if c.c == nil {
+		return nil, errors.New("ledis: connection is not established")
+	}
+
+	if reply, err := c.readReply(); err != nil {
+		c.finalize()
+		return nil, err
+	} else {
+		if e, ok := reply.(Error); ok {
+			return reply, e
+		} else {
+			return reply, nil
+		}
+	}
+}
+
 func (c *Conn) finalize() {
 	if c.c != nil {
 		c.c.Close()
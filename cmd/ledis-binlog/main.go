@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/siddontang/ledisdb/ledis"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -16,9 +17,26 @@ var startDateTime = flag.String("start-datetime", "",
 var stopDateTime = flag.String("stop-datetime", "",
 	"Stop reading the binary log at the first event having a timestamp equal to or earlier than the datetime argument.")
 
+var verify = flag.Bool("verify", false, "Check the checksums of all the events instead of printing them.")
+var repair = flag.Bool("repair", false, "Truncate the log file at its first partial or corrupt event, the events after are lost.")
+
+var format = flag.String("format", "text",
+	"Output format: text, resp for the commands replaying the events, which can be piped to redis-cli --pipe, or json for one decoded event per line.")
+
+var dbIndex = flag.Int("db", -1, "Only the events of the db index.")
+var dataType = flag.String("type", "", "Only the events of the data type: kv, hash, list, set, zset, bit or hll.")
+var keyPrefix = flag.String("key-prefix", "", "Only the events of the keys with the prefix.")
+var keyPattern = flag.String("key-pattern", "", "Only the events of the keys matching the glob-style pattern, like KEYS.")
+
 var startTime uint32 = 0
 var stopTime uint32 = 0xFFFFFFFF
 
+var filterType byte
+
+var out = bufio.NewWriterSize(os.Stdout, 4096)
+
+var output eventOutput
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s [options] log_file\n", os.Args[0])
@@ -28,6 +46,29 @@ func main() {
 	flag.Parse()
 
 	logFile := flag.Arg(0)
+
+	if *verify {
+		n, pos, err := ledis.VerifyBinLogFile(logFile)
+		if err != nil {
+			fmt.Printf("%s: bad event after %d events at offset %d: %s\n", logFile, n, pos, err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: %d events ok\n", logFile, n)
+		return
+	}
+
+	if *repair {
+		n, truncated, err := ledis.RepairBinLogFile(logFile)
+		if err != nil {
+			fmt.Printf("%s: repair error: %s\n", logFile, err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: %d events kept, %d bytes truncated\n", logFile, n, truncated)
+		return
+	}
+
 	f, err := os.Open(logFile)
 	if err != nil {
 		println(err.Error())
@@ -55,31 +96,72 @@ func main() {
 		stopTime = uint32(t.Unix())
 	}
 
-	rb := bufio.NewReaderSize(f, 4096)
-	err = ledis.ReadEventFromReader(rb, printEvent)
+	if len(*dataType) > 0 {
+		if filterType = dataTypes[*dataType]; filterType == 0 {
+			println("invalid type: ", *dataType)
+			return
+		}
+	}
+
+	switch *format {
+	case "text":
+		output = new(textOutput)
+	case "resp":
+		output = new(respOutput)
+	case "json":
+		output = new(jsonOutput)
+	default:
+		println("invalid format: ", *format)
+		return
+	}
+
+	err = ledis.ReadEventFromBinLog(f, printEvent)
+	out.Flush()
+
+	output.close()
+
 	if err != nil {
 		println("read event error: ", err.Error())
 		return
 	}
 }
 
-func printEvent(createTime uint32, event []byte) error {
-	if createTime < startTime || createTime > stopTime {
-		return nil
-	}
+var dataTypes = map[string]byte{
+	"kv":   ledis.KVType,
+	"hash": ledis.HashType,
+	"list": ledis.ListType,
+	"set":  ledis.SetType,
+	"zset": ledis.ZSetType,
+	"bit":  ledis.BitType,
+	"hll":  ledis.HLLType,
+}
 
-	t := time.Unix(int64(createTime), 0)
+func matchEvent(e *ledis.BinLogEvent) bool {
+	if *dbIndex >= 0 && int(e.DB) != *dbIndex {
+		return false
+	} else if filterType != 0 && e.DataType != filterType {
+		return false
+	} else if e.Key == nil {
+		//FLUSHDB works on all the keys
+		return true
+	} else if len(*keyPrefix) > 0 && !strings.HasPrefix(ledis.String(e.Key), *keyPrefix) {
+		return false
+	} else if len(*keyPattern) > 0 && !ledis.PatternMatch([]byte(*keyPattern), e.Key) {
+		return false
+	}
 
-	fmt.Printf("%s ", t.Format(TimeFormat))
+	return true
+}
 
-	s, err := ledis.FormatBinLogEvent(event)
-	if err != nil {
-		fmt.Printf("%s", err.Error())
-	} else {
-		fmt.Printf(s)
+func printEvent(createTime uint32, id uint64, event []byte) error {
+	if createTime < startTime || createTime > stopTime {
+		return nil
 	}
 
-	fmt.Printf("\n")
+	e, err := ledis.DecodeBinLogEvent(event)
+	if err == nil && !matchEvent(e) {
+		return nil
+	}
 
-	return nil
+	return output.write(createTime, id, event, e, err)
 }
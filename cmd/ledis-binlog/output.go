@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/siddontang/ledisdb/ledis"
+	"os"
+	"strconv"
+	"time"
+)
+
+type eventOutput interface {
+	//	write the event, e is the event decoded, or err if it can not be decoded
+	write(createTime uint32, id uint64, event []byte, e *ledis.BinLogEvent, err error) error
+	close()
+}
+
+type textOutput struct{}
+
+func (o *textOutput) write(createTime uint32, id uint64, event []byte, e *ledis.BinLogEvent, err error) error {
+	t := time.Unix(int64(createTime), 0)
+
+	fmt.Fprintf(out, "%s #%d ", t.Format(TimeFormat), id)
+
+	if err == nil {
+		var s string
+		if s, err = ledis.FormatBinLogEvent(event); err == nil {
+			out.WriteString(s)
+		}
+	}
+
+	if err != nil {
+		out.WriteString(err.Error())
+	}
+
+	out.WriteByte('\n')
+
+	return nil
+}
+
+func (o *textOutput) close() {}
+
+//	the command prefixes of the data types, like HEXPIREAT for hash
+var commandPrefix = map[byte]string{
+	ledis.KVType:   "",
+	ledis.HashType: "H",
+	ledis.ListType: "L",
+	ledis.SetType:  "S",
+	ledis.ZSetType: "Z",
+	ledis.BitType:  "B",
+	ledis.HLLType:  "PF",
+}
+
+//	the command replaying e, nil if e writes a key derived from the others, like the
+//	hash size. ok is false if e can not be replayed by a command, like the list items.
+func eventCommand(e *ledis.BinLogEvent) (args []string, ok bool) {
+	key := ledis.String(e.Key)
+	prefix := commandPrefix[e.DataType]
+
+	if e.Type == ledis.BinLogTypeCommand {
+		switch e.Command {
+		case ledis.BinLogCommandExpireAt:
+			return []string{prefix + "EXPIREAT", key, strconv.FormatInt(e.Num, 10)}, true
+		case ledis.BinLogCommandFlushDB:
+			return nil, false
+		default:
+			return []string{ledis.BinLogCommandName[e.Command], key}, true
+		}
+	}
+
+	put := e.Type == ledis.BinLogTypePut
+	field := ledis.String(e.Field)
+
+	switch e.KeyType {
+	case ledis.KVType:
+		if put {
+			return []string{"SET", key, ledis.String(e.Value)}, true
+		}
+		return []string{"DEL", key}, true
+	case ledis.HashType:
+		if put {
+			return []string{"HSET", key, field, ledis.String(e.Value)}, true
+		}
+		return []string{"HDEL", key, field}, true
+	case ledis.SetType:
+		if put {
+			return []string{"SADD", key, field}, true
+		}
+		return []string{"SREM", key, field}, true
+	case ledis.ZSetType:
+		if put {
+			return []string{"ZADD", key, strconv.FormatInt(e.Num, 10), field}, true
+		}
+		return []string{"ZREM", key, field}, true
+	case ledis.ExpTimeType:
+		if put {
+			return []string{prefix + "EXPIREAT", key, strconv.FormatInt(e.Num, 10)}, true
+		}
+		return []string{prefix + "PERSIST", key}, true
+	case ledis.TrashType:
+		//the key is unlinked, its sub-keys are deleted later
+		if put {
+			return []string{prefix + "CLEAR", key}, true
+		}
+		return nil, true
+	case ledis.HLLType:
+		if put {
+			return nil, false
+		}
+		return []string{"PFCLEAR", key}, true
+	case ledis.ListType, ledis.BitType:
+		return nil, false
+	default:
+		return nil, true
+	}
+}
+
+//	write the events as RESP commands, the ones can not be replayed are counted
+type respOutput struct {
+	db      int
+	skipped int
+	invalid int
+}
+
+func (o *respOutput) write(createTime uint32, id uint64, event []byte, e *ledis.BinLogEvent, err error) error {
+	if err != nil {
+		o.invalid++
+		return nil
+	}
+
+	args, ok := eventCommand(e)
+	if !ok {
+		o.skipped++
+		return nil
+	} else if args == nil {
+		return nil
+	}
+
+	if o.db != int(e.DB)+1 {
+		//the db is unknown at first
+		o.db = int(e.DB) + 1
+		writeRESPCommand([]string{"SELECT", strconv.Itoa(int(e.DB))})
+	}
+
+	writeRESPCommand(args)
+	return nil
+}
+
+func (o *respOutput) close() {
+	if o.skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d events can not be replayed by commands, like the writes of list, bitmap, hll and FLUSHDB\n", o.skipped)
+	}
+
+	if o.invalid > 0 {
+		fmt.Fprintf(os.Stderr, "%d invalid events\n", o.invalid)
+	}
+}
+
+func writeRESPCommand(args []string) {
+	fmt.Fprintf(out, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(out, "$%d\r\n", len(arg))
+		out.WriteString(arg)
+		out.WriteString("\r\n")
+	}
+}
+
+//	one event per line as json, the bytes not valid UTF-8 are replaced
+type jsonEvent struct {
+	Time     string  `json:"time"`
+	ID       uint64  `json:"id"`
+	Op       string  `json:"op"`
+	Command  string  `json:"command,omitempty"`
+	DB       uint8   `json:"db"`
+	KeyType  string  `json:"key_type,omitempty"`
+	DataType string  `json:"data_type"`
+	Key      *string `json:"key,omitempty"`
+	Field    *string `json:"field,omitempty"`
+	Value    *string `json:"value,omitempty"`
+	Seq      *int64  `json:"seq,omitempty"`
+	Score    *int64  `json:"score,omitempty"`
+	When     *int64  `json:"when,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+var eventOps = map[uint8]string{
+	ledis.BinLogTypePut:      "put",
+	ledis.BinLogTypeDeletion: "delete",
+	ledis.BinLogTypeCommand:  "command",
+}
+
+type jsonOutput struct{}
+
+func (o *jsonOutput) write(createTime uint32, id uint64, event []byte, e *ledis.BinLogEvent, err error) error {
+	j := new(jsonEvent)
+	j.Time = time.Unix(int64(createTime), 0).Format(TimeFormat)
+	j.ID = id
+
+	if err != nil {
+		j.Error = err.Error()
+	} else {
+		j.Op = eventOps[e.Type]
+		j.DB = e.DB
+		j.DataType = ledis.TypeName[e.DataType]
+
+		if e.Type == ledis.BinLogTypeCommand {
+			j.Command = ledis.BinLogCommandName[e.Command]
+		} else {
+			j.KeyType = ledis.TypeName[e.KeyType]
+		}
+
+		if e.Key != nil {
+			j.Key = jsonString(e.Key)
+		}
+
+		if e.Field != nil {
+			j.Field = jsonString(e.Field)
+		}
+
+		num := e.Num
+		switch e.KeyType {
+		case ledis.ListType, ledis.BitType:
+			j.Seq = &num
+		case ledis.ZSetType, ledis.ZScoreType:
+			j.Score = &num
+		case ledis.ExpTimeType:
+			j.When = &num
+		}
+
+		if e.Command == ledis.BinLogCommandExpireAt {
+			j.When = &num
+		}
+
+		if e.Type == ledis.BinLogTypePut && e.KeyType != ledis.ZSetType {
+			j.Value = jsonString(e.Value)
+		}
+	}
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	out.Write(data)
+	out.WriteByte('\n')
+	return nil
+}
+
+func (o *jsonOutput) close() {}
+
+func jsonString(b []byte) *string {
+	s := string(b)
+	return &s
+}
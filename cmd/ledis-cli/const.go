@@ -1,4 +1,4 @@
-//This file was generated by ./generate.py on Fri Aug 15 2014 16:40:03 +0800 
+// This file was generated by ./generate.py on Mon Oct 19 2026 01:52:31 +0000
 package main
 
 var helpCommands = [][]string{
@@ -6,21 +6,37 @@ var helpCommands = [][]string{
 	{"BDELETE", "key", "ZSet"},
 	{"BEXPIRE", "key seconds", "Bitmap"},
 	{"BEXPIREAT", "key timestamp", "Bitmap"},
+	{"BFIELD", "key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]", "Bitmap"},
 	{"BGET", "key", "Bitmap"},
 	{"BGETBIT", "key offset", "Bitmap"},
+	{"BINLOG", "LIST | PURGE TO index | PURGE BEFORE timestamp", "Replication"},
 	{"BMSETBIT", "key offset value [offset value ...]", "Bitmap"},
 	{"BOPT", "operation destkey key [key ...]", "Bitmap"},
 	{"BPERSIST", "key", "Bitmap"},
+	{"BPOS", "key bit [start end]", "Bitmap"},
 	{"BSETBIT", "key offset value", "Bitmap"},
 	{"BTTL", "key", "Bitmap"},
+	{"COPY", "source destination [DB destination-db] [REPLACE]", "Key"},
 	{"DECR", "key", "KV"},
 	{"DECRBY", "key decrement", "KV"},
 	{"DEL", "key [key ...]", "KV"},
+	{"DISCARD", "-", "Transaction"},
+	{"DUMP", "key", "Key"},
 	{"ECHO", "message", "Server"},
+	{"EVAL", "script numkeys [key ...] [arg ...]", "Script"},
+	{"EVALSHA", "sha1 numkeys [key ...] [arg ...]", "Script"},
+	{"EXEC", "-", "Transaction"},
 	{"EXISTS", "key", "KV"},
 	{"EXPIRE", "key seconds", "KV"},
 	{"EXPIREAT", "key timestamp", "KV"},
 	{"FULLSYNC", "-", "Replication"},
+	{"GEOADD", "key longitude latitude member [longitude latitude member ...]", "Geo"},
+	{"GEODIST", "key member1 member2 [m|km|ft|mi]", "Geo"},
+	{"GEOHASH", "key member [member ...]", "Geo"},
+	{"GEOPOS", "key member [member ...]", "Geo"},
+	{"GEORADIUS", "key longitude latitude radius m|km|ft|mi [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count] [ASC|DESC]", "Geo"},
+	{"GEORADIUSBYMEMBER", "key member radius m|km|ft|mi [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count] [ASC|DESC]", "Geo"},
+	{"GEOSEARCH", "key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius m|km|ft|mi|BYBOX width height m|km|ft|mi [ASC|DESC] [COUNT count] [WITHCOORD] [WITHDIST] [WITHHASH]", "Geo"},
 	{"GET", "key", "KV"},
 	{"GETSET", " key value", "KV"},
 	{"HCLEAR", "key", "Hash"},
@@ -42,6 +58,7 @@ var helpCommands = [][]string{
 	{"HVALS", "key", "Hash"},
 	{"INCR", "key", "KV"},
 	{"INCRBY", "key increment", "KV"},
+	{"INFO", "[section]", "Server"},
 	{"LCLEAR", "key", "List"},
 	{"LEXPIRE", "key seconds", "List"},
 	{"LEXPIREAT", "key timestamp", "List"},
@@ -54,14 +71,33 @@ var helpCommands = [][]string{
 	{"LRANGE", "key start stop", "List"},
 	{"LTTL", "key", "List"},
 	{"MGET", "key [key ...]", "KV"},
+	{"MOVE", "key db", "Key"},
 	{"MSET", "key value [key value ...]", "KV"},
+	{"MULTI", "-", "Transaction"},
 	{"PERSIST", "key", "KV"},
+	{"PFADD", "key [element ...]", "HyperLogLog"},
+	{"PFCLEAR", "key", "HyperLogLog"},
+	{"PFCOUNT", "key [key ...]", "HyperLogLog"},
+	{"PFEXPIRE", "key seconds", "HyperLogLog"},
+	{"PFEXPIREAT", "key timestamp", "HyperLogLog"},
+	{"PFMERGE", "destkey sourcekey [sourcekey ...]", "HyperLogLog"},
+	{"PFPERSIST", "key", "HyperLogLog"},
+	{"PFTTL", "key", "HyperLogLog"},
 	{"PING", "-", "Server"},
+	{"PSUBSCRIBE", "pattern [pattern ...]", "PubSub"},
+	{"PUBLISH", "channel message", "PubSub"},
+	{"PUBSUB", "CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT", "PubSub"},
+	{"PUNSUBSCRIBE", "[pattern ...]", "PubSub"},
+	{"RENAME", "key newkey", "Key"},
+	{"RENAMENX", "key newkey", "Key"},
+	{"RESTORE", "key ttl serialized-value [REPLACE]", "Key"},
+	{"ROLE", "-", "Replication"},
 	{"RPOP", "key", "List"},
 	{"RPUSH", "key value [value ...]", "List"},
 	{"SADD", "key member [member ...]", "Set"},
 	{"SCARD", "key", "Set"},
 	{"SCLEAR", "key", "Set"},
+	{"SCRIPT", "LOAD script | EXISTS sha1 [sha1 ...] | FLUSH | KILL", "Script"},
 	{"SDIFF", "key [key ...]", "Set"},
 	{"SDIFFSTORE", "destination key [key ...]", "Set"},
 	{"SELECT", "index", "Server"},
@@ -72,16 +108,21 @@ var helpCommands = [][]string{
 	{"SINTER", "key [key ...]", "Set"},
 	{"SINTERSTORE", "destination key [key ...]", "Set"},
 	{"SISMEMBER", "key member", "Set"},
-	{"SLAVEOF", "host port", "Replication"},
+	{"SLAVEOF", "host port [FORCE]", "Replication"},
 	{"SMCLEAR", "key [key ...]", "Set"},
 	{"SMEMBERS", "key", "Set"},
 	{"SPERSIST", "key", "Set"},
 	{"SREM", "key member [member ...]", "Set"},
 	{"STTL", "key", "Set"},
+	{"SUBSCRIBE", "channel [channel ...]", "PubSub"},
 	{"SUNION", "key [key ...]", "Set"},
 	{"SUNIONSTORE", "destination key [key ...]", "Set"},
-	{"SYNC", "index offset", "Replication"},
+	{"SYNC", "lastid [STREAM [port]]", "Replication"},
 	{"TTL", "key", "KV"},
+	{"UNSUBSCRIBE", "[channel ...]", "PubSub"},
+	{"UNWATCH", "-", "Transaction"},
+	{"WAIT", "numslaves timeout", "Replication"},
+	{"WATCH", "key [key ...]", "Transaction"},
 	{"ZADD", "key score member [score member ...]", "ZSet"},
 	{"ZCARD", "key", "ZSet"},
 	{"ZCLEAR", "key", "ZSet"},
@@ -89,6 +130,7 @@ var helpCommands = [][]string{
 	{"ZEXPIRE", "key seconds", "ZSet"},
 	{"ZEXPIREAT", "key timestamp", "ZSet"},
 	{"ZINCRBY", "key increment member", "ZSet"},
+	{"ZINTERSTORE", "destkey numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]", "ZSet"},
 	{"ZMCLEAR", "key [key ...]", "ZSet"},
 	{"ZPERSIST", "key", "ZSet"},
 	{"ZRANGE", "key start stop [WITHSCORES]", "ZSet"},
@@ -102,4 +144,5 @@ var helpCommands = [][]string{
 	{"ZREVRANK", "key member", "ZSet"},
 	{"ZSCORE", "key member", "ZSet"},
 	{"ZTTL", "key", "ZSet"},
+	{"ZUNIONSTORE", "destkey numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]", "ZSet"},
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/siddontang/ledisdb/config"
+	"github.com/siddontang/ledisdb/ledis"
+	"strings"
+)
+
+var configPath = flag.String("config", "", "ledisdb config file")
+var rebuild = flag.Bool("rebuild", false, "rebuild key type index for single namespace mode")
+
+func main() {
+	flag.Parse()
+
+	if len(*configPath) == 0 {
+		println("need ledis config file")
+		return
+	}
+
+	cfg, err := config.NewConfigWithFile(*configPath)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	if len(cfg.DataDir) == 0 {
+		println("must set data dir")
+		return
+	}
+
+	ldb, err := ledis.Open(cfg)
+	if err != nil {
+		println("ledis open error ", err.Error())
+		return
+	}
+	defer ldb.Close()
+
+	var total int
+	for i := 0; i < int(ledis.MaxDBNumber); i++ {
+		db, _ := ldb.Select(i)
+
+		var collisions []ledis.KeyTypeCollision
+		if *rebuild {
+			var n int64
+			if n, collisions, err = db.RebuildKeyTypeIndex(); err != nil {
+				println("rebuild db", i, "error", err.Error())
+				return
+			} else if n > 0 {
+				fmt.Printf("db %d: %d keys indexed\n", i, n)
+			}
+		} else {
+			if collisions, err = db.KeyTypeCollisions(); err != nil {
+				println("check db", i, "error", err.Error())
+				return
+			}
+		}
+
+		for _, c := range collisions {
+			names := make([]string, len(c.Types))
+			for j, t := range c.Types {
+				names[j] = ledis.TypeName[t]
+			}
+
+			fmt.Printf("db %d: %q is %s\n", i, c.Key, strings.Join(names, ", "))
+		}
+
+		total += len(collisions)
+	}
+
+	if total > 0 {
+		fmt.Printf("%d keys hold more than one data type, resolve them before enabling single_namespace\n", total)
+	} else {
+		println("OK")
+	}
+}
@@ -66,8 +66,8 @@ func loadDump(cfg *config.Config, ldb *ledis.Ledis) error {
 
 	//master enable binlog, here output this like mysql
 	if head.LogFileIndex != 0 && head.LogPos != 0 {
-		format := "MASTER_LOG_FILE='binlog.%07d', MASTER_LOG_POS=%d;\n"
-		fmt.Printf(format, head.LogFileIndex, head.LogPos)
+		format := "MASTER_LOG_FILE='binlog.%07d', MASTER_LOG_POS=%d, MASTER_LAST_ID=%d;\n"
+		fmt.Printf(format, head.LogFileIndex, head.LogPos, head.LastID)
 	}
 
 	return nil
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/siddontang/ledisdb/config"
+	"github.com/siddontang/ledisdb/ledis"
+	"time"
+)
+
+var TimeFormat = "2006-01-02 15:04:05"
+
+var configPath = flag.String("config", "", "ledisdb config file")
+var dumpPath = flag.String("dump_file", "", "ledisdb dump file")
+var binLogPath = flag.String("binlog_path", "", "bin_log directory of the master dumped")
+var stopDateTime = flag.String("stop_datetime", "", "replay the events logged until the datetime, like 2006-01-02 15:04:05")
+var stopID = flag.Uint64("stop_id", 0, "replay the batches until the id, like the #id printed by ledis-binlog")
+var dryRun = flag.Bool("dry_run", false, "only print how many events would be replayed, the data is not changed")
+
+func main() {
+	flag.Parse()
+
+	if len(*configPath) == 0 {
+		println("need ledis config file")
+		return
+	}
+
+	cfg, err := config.NewConfigWithFile(*configPath)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	if len(*dumpPath) == 0 {
+		println("need dump file")
+		return
+	}
+
+	if len(*binLogPath) == 0 {
+		println("need binlog path")
+		return
+	}
+
+	if len(cfg.DataDir) == 0 {
+		println("must set data dir")
+		return
+	}
+
+	opts := &ledis.RestoreOptions{LogPath: *binLogPath, StopID: *stopID, DryRun: *dryRun}
+
+	if len(*stopDateTime) > 0 {
+		if opts.StopTime, err = time.ParseInLocation(TimeFormat, *stopDateTime, time.Local); err != nil {
+			println("parse stop_datetime error: ", err.Error())
+			return
+		}
+	}
+
+	ldb, err := ledis.Open(cfg)
+	if err != nil {
+		println("ledis open error ", err.Error())
+		return
+	}
+
+	res, err := ldb.Restore(*dumpPath, opts)
+	ldb.Close()
+
+	if res != nil {
+		printResult(res)
+	}
+
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	if *dryRun {
+		println("Dry run OK")
+	} else {
+		println("Restore OK")
+	}
+}
+
+func printResult(res *ledis.RestoreResult) {
+	format := "MASTER_LOG_FILE='binlog.%07d', MASTER_LOG_POS=%d, MASTER_LAST_ID=%d;\n"
+
+	fmt.Printf("dump at "+format, res.Dump.LogFileIndex, res.Dump.LogPos, res.Dump.LastID)
+	if *dryRun {
+		fmt.Printf("%d events would be replayed", res.Events)
+	} else {
+		fmt.Printf("%d events replayed", res.Events)
+	}
+	if res.Events > 0 {
+		fmt.Printf(", the last one logged at %s", time.Unix(int64(res.LastTime), 0).Format(TimeFormat))
+	}
+	fmt.Printf("\n")
+	fmt.Printf("restored to "+format, res.LogFileIndex, res.LogPos, res.LastID)
+}
@@ -23,6 +23,12 @@ const (
 
 	DefaultBinLogFileSize int = MaxBinLogFileSize
 	DefaultBinLogFileNum  int = 10
+
+	DefaultLazyFreeThreshold int = 64
+
+	DefaultSemiSyncTimeout int = 1000
+
+	DefaultLuaTimeLimit int = 5000
 )
 
 type LevelDBConfig struct {
@@ -41,6 +47,17 @@ type LMDBConfig struct {
 type BinLogConfig struct {
 	MaxFileSize int `toml:"max_file_size" json:"max_file_size"`
 	MaxFileNum  int `toml:"max_file_num" json:"max_file_num"`
+
+	//purge the log files older than max_age seconds, or out of max_total_size bytes in total, 0 to disable,
+	//the log files still needed by the connected slaves are kept
+	MaxAge       int `toml:"max_age" json:"max_age"`
+	MaxTotalSize int `toml:"max_total_size" json:"max_total_size"`
+
+	//fsync the binlog after every group commit
+	Sync bool `toml:"sync" json:"sync"`
+
+	//log HCLEAR, LCLEAR, SCLEAR, ZCLEAR, EXPIREAT and FLUSHDB as command events instead of their writes
+	CommandEvents bool `toml:"command_events" json:"command_events"`
 }
 
 type Config struct {
@@ -60,7 +77,29 @@ type Config struct {
 
 	SlaveOf string `toml:"slaveof" json:"slaveof"`
 
+	//a slave refuses the writes from the clients, which would diverge from the master
+	SlaveReadOnly bool `toml:"slave_read_only" json:"slave_read_only"`
+
+	//a write is replied after the number of slaves ack it, or semi_sync_timeout milliseconds, 0 to disable
+	SemiSyncSlaves  int `toml:"semi_sync_slaves" json:"semi_sync_slaves"`
+	SemiSyncTimeout int `toml:"semi_sync_timeout" json:"semi_sync_timeout"`
+
 	AccessLog string `toml:"access_log" json:"access_log"`
+
+	//if true, a key can only hold one data type like redis
+	SingleNamespace bool `toml:"single_namespace" json:"single_namespace"`
+
+	//the max messages buffered for a subscriber, it is disconnected if too slow to receive them
+	PubSubBufferSize int `toml:"pubsub_buffer_size" json:"pubsub_buffer_size"`
+
+	//the key events published to the subscribers, like redis notify-keyspace-events, empty to disable
+	NotifyKeyspaceEvents string `toml:"notify_keyspace_events" json:"notify_keyspace_events"`
+
+	//a hash, list, set or zset with more items is deleted lazily in the background, 0 to disable
+	LazyFreeThreshold int `toml:"lazyfree_threshold" json:"lazyfree_threshold"`
+
+	//the max milliseconds a script runs, it is aborted and its writes are discarded after
+	LuaTimeLimit int `toml:"lua_time_limit" json:"lua_time_limit"`
 }
 
 func NewConfigWithFile(fileName string) (*Config, error) {
@@ -103,9 +142,22 @@ func NewConfigDefault() *Config {
 	// disable replication
 	cfg.SlaveOf = ""
 
+	cfg.SlaveReadOnly = true
+
+	// no semi-sync replication
+	cfg.SemiSyncSlaves = 0
+	cfg.SemiSyncTimeout = DefaultSemiSyncTimeout
+
 	// disable access log
 	cfg.AccessLog = ""
 
+	// same key may exist in different data types
+	cfg.SingleNamespace = false
+
+	cfg.LazyFreeThreshold = DefaultLazyFreeThreshold
+
+	cfg.LuaTimeLimit = DefaultLuaTimeLimit
+
 	return cfg
 }
 
@@ -19,6 +19,11 @@ func TestConfig(t *testing.T) {
 	dstCfg.LevelDB.MaxOpenFiles = 1024
 	dstCfg.LMDB.MapSize = 524288000
 	dstCfg.LMDB.NoSync = true
+	dstCfg.PubSubBufferSize = 1024
+	dstCfg.LazyFreeThreshold = 64
+	dstCfg.LuaTimeLimit = 5000
+	dstCfg.SlaveReadOnly = true
+	dstCfg.SemiSyncTimeout = 1000
 
 	cfg, err := NewConfigWithFile("./config.toml")
 	if err != nil {
@@ -2,13 +2,18 @@ package ledis
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/siddontang/go-log/log"
 	"github.com/siddontang/ledisdb/config"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,10 +27,40 @@ ledis-bin.00003
 
 log file format
 
-timestamp(bigendian uint32, seconds)|PayloadLen(bigendian uint32)|PayloadData
+header|event...
 
+header : "lbin"|version(bigendian uint32)|first id(bigendian uint64)
+event  : timestamp(bigendian uint32, seconds)|id(bigendian uint64)|PayloadLen(bigendian uint32)|PayloadData|checksum(bigendian uint32)
+
+checksum is the CRC32C of all the former bytes of the event. The events sent to slaves have the
+same format without header. A log file without header is written by the old version, whose events
+have no checksum, the events of version 1 have no id, and its header no first id.
+
+id is the sequence id of the batch the event is logged with, all the events of a batch have the
+same id, and the ids never go back. Slaves log the events replicated with the ids of the master,
+so a slave can resume the replication from any server logging the same ids.
 */
 
+const (
+	BinLogVersion uint32 = 2
+
+	binLogHeaderSize = 16
+
+	//	the max bytes of the events between two entries of the id index
+	binLogIDIndexInterval = 64 * 1024
+
+	//	the max payload size of an event, a longer one has a corrupt length
+	maxBinLogEventSize = 512 * 1024 * 1024
+)
+
+var (
+	binLogMagic = []byte("lbin")
+
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+	ErrBinLogCorrupt = errors.New("binlog event checksum mismatch")
+)
+
 type BinLog struct {
 	path string
 
@@ -38,6 +73,26 @@ type BinLog struct {
 	indexName    string
 	logNames     []string
 	lastLogIndex int64
+
+	//	the size of the current log file
+	logPos int64
+
+	//	the id of the last batch logged
+	lastID uint64
+
+	//	sparse index of the batch ids to the log positions, sorted by id
+	ids []binLogIDPos
+
+	//	the last batch ids synced by the connected slaves, see binlog_purge.go
+	slaves map[string]uint64
+}
+
+//	the events before pos of the log file at index have ids not greater than id,
+//	the ones at and after pos not less than id.
+type binLogIDPos struct {
+	id    uint64
+	index int64
+	pos   int64
 }
 
 func NewBinLog(cfg *config.Config) (*BinLog, error) {
@@ -53,14 +108,55 @@ func NewBinLog(cfg *config.Config) (*BinLog, error) {
 	}
 
 	l.logNames = make([]string, 0, 16)
+	l.slaves = make(map[string]uint64)
 
 	if err := l.loadIndex(); err != nil {
 		return nil, err
 	}
 
+	if err := l.checkLastLogFile(); err != nil {
+		return nil, err
+	}
+
+	if err := l.loadIDs(); err != nil {
+		return nil, err
+	}
+
+	if _, err := l.purgeRetention(); err != nil {
+		return nil, err
+	}
+
 	return l, nil
 }
 
+//	load the id index entries of the log files from their headers
+func (l *BinLog) loadIDs() error {
+	for _, name := range l.logNames {
+		index, err := parseLogFileIndex(name)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path.Join(l.path, name))
+		if err != nil {
+			return err
+		}
+
+		st, _ := f.Stat()
+		version, firstID, err := readBinLogHeader(bufio.NewReaderSize(f, 64))
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		if version >= 2 && st.Size() > binLogHeaderSize {
+			l.ids = append(l.ids, binLogIDPos{firstID, index, binLogHeaderSize})
+		}
+	}
+
+	return nil
+}
+
 func (l *BinLog) flushIndex() error {
 	data := strings.Join(l.logNames, "\n")
 
@@ -112,13 +208,6 @@ func (l *BinLog) loadIndex() error {
 			}
 		}
 	}
-	if l.cfg.MaxFileNum > 0 && len(l.logNames) > l.cfg.MaxFileNum {
-		//remove oldest logfile
-		if err := l.Purge(len(l.logNames) - l.cfg.MaxFileNum); err != nil {
-			return err
-		}
-	}
-
 	var err error
 	if len(l.logNames) == 0 {
 		l.lastLogIndex = 1
@@ -141,7 +230,7 @@ func (l *BinLog) getLogFile() string {
 	return l.FormatLogFileName(l.lastLogIndex)
 }
 
-func (l *BinLog) openNewLogFile() error {
+func (l *BinLog) openNewLogFile(firstID uint64) error {
 	var err error
 	lastName := l.getLogFile()
 
@@ -151,11 +240,15 @@ func (l *BinLog) openNewLogFile() error {
 		return err
 	}
 
-	if l.cfg.MaxFileNum > 0 && len(l.logNames) == l.cfg.MaxFileNum {
-		l.purge(1)
+	if err = writeBinLogHeader(l.logFile, firstID); err != nil {
+		log.Error("write logfile header error %s", err.Error())
+		l.logFile.Close()
+		l.logFile = nil
+		return err
 	}
 
 	l.logNames = append(l.logNames, lastName)
+	l.logPos = binLogHeaderSize
 
 	if l.logWb == nil {
 		l.logWb = bufio.NewWriterSize(l.logFile, 1024)
@@ -167,6 +260,10 @@ func (l *BinLog) openNewLogFile() error {
 		return err
 	}
 
+	if _, err = l.purgeRetention(); err != nil {
+		log.Error("purge logfile error %s", err.Error())
+	}
+
 	return nil
 }
 
@@ -175,8 +272,7 @@ func (l *BinLog) checkLogFileSize() bool {
 		return false
 	}
 
-	st, _ := l.logFile.Stat()
-	if st.Size() >= int64(l.cfg.MaxFileSize) {
+	if l.logPos >= int64(l.cfg.MaxFileSize) {
 		l.lastLogIndex++
 
 		l.logFile.Close()
@@ -195,6 +291,14 @@ func (l *BinLog) purge(n int) {
 
 	copy(l.logNames[0:], l.logNames[n:])
 	l.logNames = l.logNames[0 : len(l.logNames)-n]
+
+	//	drop the id index entries of the log files purged
+	i := len(l.ids)
+	if len(l.logNames) > 0 {
+		first, _ := parseLogFileIndex(l.logNames[0])
+		i = sort.Search(len(l.ids), func(i int) bool { return l.ids[i].index >= first })
+	}
+	l.ids = append(l.ids[0:0], l.ids[i:]...)
 }
 
 func (l *BinLog) Close() {
@@ -216,9 +320,42 @@ func (l *BinLog) LogFilePos() int64 {
 	if l.logFile == nil {
 		return 0
 	} else {
-		st, _ := l.logFile.Stat()
-		return st.Size()
+		return l.logPos
+	}
+}
+
+//	the id of the last batch logged
+func (l *BinLog) LastID() uint64 {
+	return l.lastID
+}
+
+//	the batches logged later have ids not less than id
+func (l *BinLog) advanceID(id uint64) {
+	if id > l.lastID {
+		l.lastID = id
+	}
+}
+
+//	the log position to read the batches after id from, the id index is searched
+//	for the nearest position before them. ok is false if some of them are purged.
+func (l *BinLog) findID(id uint64) (index int64, pos int64, ok bool) {
+	if id > l.lastID {
+		//	not logged by us
+		return 0, 0, false
+	}
+
+	i := sort.Search(len(l.ids), func(i int) bool { return l.ids[i].id > id })
+	if i > 0 {
+		return l.ids[i-1].index, l.ids[i-1].pos, true
+	} else if len(l.ids) > 0 && l.ids[0].id == id+1 && l.FormatLogFileName(l.ids[0].index) == l.logNames[0] {
+		//	the batches after id start at the oldest log file
+		return l.ids[0].index, l.ids[0].pos, true
+	} else if id == l.lastID {
+		//	nothing logged after id
+		return l.lastLogIndex, l.LogFilePos(), true
 	}
+
+	return 0, 0, false
 }
 
 func (l *BinLog) LogFileIndex() int64 {
@@ -226,6 +363,10 @@ func (l *BinLog) LogFileIndex() int64 {
 }
 
 func (l *BinLog) FormatLogFileName(index int64) string {
+	return formatLogFileName(index)
+}
+
+func formatLogFileName(index int64) string {
 	return fmt.Sprintf("ledis-bin.%07d", index)
 }
 
@@ -255,40 +396,375 @@ func (l *BinLog) Purge(n int) error {
 	return l.flushIndex()
 }
 
+//	truncate the events after pos of the log file at index, which are not committed with the data,
+//	the log files after index are truncated to empty. Returns the bytes truncated.
+func (l *BinLog) TruncateAfter(index int64, pos int64) (int64, error) {
+	var n int64
+	for _, name := range l.logNames {
+		logIndex, err := strconv.ParseInt(path.Ext(name)[1:], 10, 64)
+		if err != nil {
+			return n, err
+		}
+
+		size := pos
+		if logIndex < index {
+			continue
+		} else if logIndex > index {
+			size = 0
+		}
+
+		logPath := path.Join(l.path, name)
+		st, err := os.Stat(logPath)
+		if err != nil {
+			return n, err
+		} else if st.Size() <= size {
+			continue
+		}
+
+		if err = os.Truncate(logPath, size); err != nil {
+			return n, err
+		}
+		n += st.Size() - size
+	}
+
+	l.dropIDsAfter(index, pos)
+
+	return n, nil
+}
+
+//	drop the id index entries of the events after pos of the log file at index
+func (l *BinLog) dropIDsAfter(index int64, pos int64) {
+	for i, e := range l.ids {
+		if e.index > index || (e.index == index && e.pos >= pos) {
+			l.ids = l.ids[0:i]
+			break
+		}
+	}
+}
+
+//	drop the events logged after pos of the log file at index, whose data failed to commit,
+//	lastID is the id of the last batch before them. The log file is not reopened if it is
+//	closed for its size meanwhile, the next events go to a new one.
+func (l *BinLog) rollback(index int64, pos int64, lastID uint64) error {
+	if pos < binLogHeaderSize {
+		//	the log file is opened for the events, keep its header
+		pos = binLogHeaderSize
+	}
+
+	l.lastID = lastID
+	l.dropIDsAfter(index, pos)
+
+	if l.logFile != nil && l.lastLogIndex == index {
+		//	the buffered events are dropped too
+		l.logWb.Reset(l.logFile)
+		if err := l.logFile.Truncate(pos); err != nil {
+			return err
+		} else if _, err = l.logFile.Seek(pos, os.SEEK_SET); err != nil {
+			return err
+		}
+		l.logPos = pos
+		return nil
+	}
+
+	if err := os.Truncate(l.FormatLogFilePath(index), pos); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//	log args as a new batch
 func (l *BinLog) Log(args ...[]byte) error {
+	return l.LogWithID(l.lastID+1, args...)
+}
+
+//	log args as a batch with id, which is the last id if less than it, like the events
+//	replicated after the local ones.
+func (l *BinLog) LogWithID(id uint64, args ...[]byte) error {
 	var err error
 
+	if id < l.lastID {
+		id = l.lastID
+	}
+
 	if l.logFile == nil {
-		if err = l.openNewLogFile(); err != nil {
+		if err = l.openNewLogFile(id); err != nil {
 			return err
 		}
+		l.ids = append(l.ids, binLogIDPos{id, l.lastLogIndex, l.logPos})
+	} else if n := len(l.ids); n == 0 || l.ids[n-1].index != l.lastLogIndex || l.logPos-l.ids[n-1].pos >= binLogIDIndexInterval {
+		l.ids = append(l.ids, binLogIDPos{id, l.lastLogIndex, l.logPos})
 	}
 
 	//we treat log many args as a batch, so use same createTime
 	createTime := uint32(time.Now().Unix())
 
 	for _, data := range args {
-		payLoadLen := uint32(len(data))
-
-		if err := binary.Write(l.logWb, binary.BigEndian, createTime); err != nil {
+		if err = writeBinLogEvent(l.logWb, createTime, id, data); err != nil {
 			return err
 		}
+		l.logPos += int64(binLogEventHeadSize + len(data) + 4)
+	}
+
+	l.lastID = id
 
-		if err := binary.Write(l.logWb, binary.BigEndian, payLoadLen); err != nil {
+	if err = l.logWb.Flush(); err != nil {
+		log.Error("write log error %s", err.Error())
+		return err
+	}
+
+	if l.cfg.Sync {
+		if err = l.logFile.Sync(); err != nil {
+			log.Error("sync log error %s", err.Error())
 			return err
 		}
+	}
 
-		if _, err := l.logWb.Write(data); err != nil {
-			return err
+	l.checkLogFileSize()
+
+	return nil
+}
+
+func writeBinLogHeader(w io.Writer, firstID uint64) error {
+	buf := make([]byte, binLogHeaderSize)
+	copy(buf, binLogMagic)
+	binary.BigEndian.PutUint32(buf[4:], BinLogVersion)
+	binary.BigEndian.PutUint64(buf[8:], firstID)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+//	the header size of the log files of version
+func binLogHeaderLen(version uint32) int64 {
+	switch version {
+	case 0:
+		return 0
+	case 1:
+		return 8
+	default:
+		return binLogHeaderSize
+	}
+}
+
+//	read the header of a log file, version 0 if it has no header
+func readBinLogHeader(rb *bufio.Reader) (version uint32, firstID uint64, err error) {
+	buf, err := rb.Peek(8)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	} else if len(buf) < 8 || !bytes.Equal(buf[0:4], binLogMagic) {
+		if len(buf) == 0 {
+			//	empty file
+			return BinLogVersion, 0, nil
 		}
+		return 0, 0, nil
 	}
 
-	if err = l.logWb.Flush(); err != nil {
-		log.Error("write log error %s", err.Error())
-		return err
+	version = binary.BigEndian.Uint32(buf[4:])
+	if version > BinLogVersion {
+		return 0, 0, fmt.Errorf("unsupported binlog version %d", version)
 	}
 
-	l.checkLogFileSize()
+	size := int(binLogHeaderLen(version))
+	if buf, err = rb.Peek(size); err != nil {
+		return 0, 0, err
+	}
 
+	if version >= 2 {
+		firstID = binary.BigEndian.Uint64(buf[8:16])
+	}
+
+	_, err = rb.Discard(size)
+	return version, firstID, err
+}
+
+const binLogEventHeadSize = 16
+
+func writeBinLogEvent(w io.Writer, createTime uint32, id uint64, data []byte) error {
+	var head [binLogEventHeadSize]byte
+	binary.BigEndian.PutUint32(head[0:4], createTime)
+	binary.BigEndian.PutUint64(head[4:12], id)
+	binary.BigEndian.PutUint32(head[12:16], uint32(len(data)))
+
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], crc32.Update(crc32.Checksum(head[:], crc32cTable), crc32cTable, data))
+
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	} else if _, err = w.Write(data); err != nil {
+		return err
+	} else if _, err = w.Write(tail[:]); err != nil {
+		return err
+	}
 	return nil
 }
+
+//	read the binlog events one by one, checking their checksums if version > 0
+type binLogReader struct {
+	r       io.Reader
+	version uint32
+
+	//	the bytes of the whole events read
+	pos int64
+
+	//	the offset the events end at, like the size of the log file, -1 if unknown
+	size int64
+
+	//	the offset the event read last ends at, past size if it is partial
+	end int64
+
+	data []byte
+}
+
+func newBinLogReader(r io.Reader, version uint32) *binLogReader {
+	return &binLogReader{r: r, version: version, size: -1}
+}
+
+//	the next event, which is valid until the next call. Returns io.EOF at the end of events,
+//	io.ErrUnexpectedEOF for a partial event, or ErrBinLogCorrupt.
+func (r *binLogReader) next() (createTime uint32, id uint64, event []byte, err error) {
+	headSize := 8
+	if r.version >= 2 {
+		headSize = binLogEventHeadSize
+	}
+
+	var head [binLogEventHeadSize]byte
+	if _, err = io.ReadFull(r.r, head[0:headSize]); err != nil {
+		return
+	}
+
+	createTime = binary.BigEndian.Uint32(head[0:4])
+	if r.version >= 2 {
+		id = binary.BigEndian.Uint64(head[4:12])
+	}
+	dataLen := int64(binary.BigEndian.Uint32(head[headSize-4 : headSize]))
+
+	size := dataLen
+	if r.version > 0 {
+		size += 4
+	}
+
+	//	the length is not checked by the checksum yet, never allocate for a bad one
+	if dataLen > maxBinLogEventSize {
+		//	never written, the event is not a partial one
+		r.end = r.pos + int64(headSize)
+		err = ErrBinLogCorrupt
+		return
+	}
+
+	r.end = r.pos + int64(headSize) + size
+	if r.size >= 0 && r.end > r.size {
+		err = ErrBinLogCorrupt
+		return
+	}
+
+	if int64(cap(r.data)) < size {
+		r.data = make([]byte, size)
+	}
+	r.data = r.data[0:size]
+
+	if _, err = io.ReadFull(r.r, r.data); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+		return
+	} else if err != nil {
+		return
+	}
+
+	event = r.data[0:dataLen]
+	if r.version > 0 {
+		checksum := binary.BigEndian.Uint32(r.data[dataLen:])
+		if crc32.Update(crc32.Checksum(head[0:headSize], crc32cTable), crc32cTable, event) != checksum {
+			err = ErrBinLogCorrupt
+			return
+		}
+	}
+
+	r.pos = r.end
+	return
+}
+
+//	whether the bad event read last is the final one of the events, like a partial event
+//	at the tail of a log file written by a crash
+func (r *binLogReader) atTail() bool {
+	return r.size >= 0 && r.end >= r.size
+}
+
+//	VerifyBinLogFile checks all the events of a log file, returns the number of valid events
+//	and the offset after them. err is not nil if the file has a partial or corrupt event at pos.
+func VerifyBinLogFile(filePath string) (n int, pos int64, err error) {
+	n, r, err := verifyBinLogFile(filePath)
+	if r == nil {
+		return n, 0, err
+	}
+	return n, r.pos, err
+}
+
+func verifyBinLogFile(filePath string) (n int, r *binLogReader, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rb := bufio.NewReaderSize(f, 4096)
+
+	r = newBinLogReader(rb, 0)
+	r.size = st.Size()
+	if r.version, _, err = readBinLogHeader(rb); err != nil {
+		return 0, nil, err
+	} else if r.size > 0 {
+		r.pos = binLogHeaderLen(r.version)
+	}
+
+	for {
+		if _, _, _, err = r.next(); err == io.EOF {
+			return n, r, nil
+		} else if err != nil {
+			return n, r, err
+		}
+		n++
+	}
+}
+
+//	RepairBinLogFile truncates a log file at its first partial or corrupt event, the events
+//	after are lost. Returns the number of valid events and the bytes truncated.
+func RepairBinLogFile(filePath string) (n int, truncated int64, err error) {
+	n, r, err := verifyBinLogFile(filePath)
+	if err == nil {
+		return n, 0, nil
+	} else if err != io.ErrUnexpectedEOF && err != ErrBinLogCorrupt {
+		return n, 0, err
+	}
+
+	if err = os.Truncate(filePath, r.pos); err != nil {
+		return n, 0, err
+	}
+	return n, r.size - r.pos, nil
+}
+
+//	truncate the partial event at the tail of the last log file, written by a crash. A corrupt
+//	event before the tail is never truncated, the committed events after it would be lost.
+func (l *BinLog) checkLastLogFile() error {
+	if len(l.logNames) == 0 {
+		return nil
+	}
+
+	logPath := path.Join(l.path, l.logNames[len(l.logNames)-1])
+	n, r, err := verifyBinLogFile(logPath)
+	if err == nil {
+		return nil
+	} else if err != io.ErrUnexpectedEOF && err != ErrBinLogCorrupt {
+		return err
+	} else if err == ErrBinLogCorrupt && !r.atTail() {
+		log.Error("binlog %s has a corrupt event after %d events at %d", logPath, n, r.pos)
+		return fmt.Errorf("binlog %s is corrupt at %d, check it with ledis-binlog -verify, "+
+			"then truncate it with ledis-binlog -repair if the events after can be lost", logPath, r.pos)
+	}
+
+	log.Error("binlog %s has a partial event after %d events, %s, truncate it at %d", logPath, n, err.Error(), r.pos)
+	return os.Truncate(logPath, r.pos)
+}
@@ -0,0 +1,237 @@
+package ledis
+
+import (
+	"errors"
+	"github.com/siddontang/go-log/log"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+/*
+Retention of the log files:
+
+	the oldest log files are purged when there are more than max_file_num, they are older
+	than max_age seconds, or the log files are more than max_total_size bytes in total. It is
+	checked when a new log file is opened and every minute, so an idle master purges too.
+
+The log files having the batches after the last id synced by a connected slave are kept,
+so are the current one, even when they are out of the retention or purged by BINLOG PURGE.
+*/
+
+const binLogPurgeInterval = 60 * time.Second
+
+var errBinLogDisabled = errors.New("binlog not enabled")
+
+func parseLogFileIndex(name string) (int64, error) {
+	return strconv.ParseInt(path.Ext(name)[1:], 10, 64)
+}
+
+//	the index of the oldest log file needed by the slaves, ok is false if none is needed
+func (l *BinLog) slaveLogIndex() (index int64, ok bool) {
+	if len(l.slaves) == 0 {
+		return 0, false
+	}
+
+	var lastID uint64 = math.MaxUint64
+	for _, id := range l.slaves {
+		if id < lastID {
+			lastID = id
+		}
+	}
+
+	//	not ok if the batches are lost already, the slave will do a full sync
+	index, _, ok = l.findID(lastID)
+	return
+}
+
+//	the number of the oldest log files can be purged, without the current one
+//	and the ones needed by the slaves
+func (l *BinLog) purgeableNum() int {
+	n := len(l.logNames)
+	if n > 0 && l.logNames[n-1] == l.getLogFile() {
+		n--
+	}
+
+	if keep, ok := l.slaveLogIndex(); ok {
+		for i := 0; i < n; i++ {
+			if index, _ := parseLogFileIndex(l.logNames[i]); index >= keep {
+				return i
+			}
+		}
+	}
+
+	return n
+}
+
+//	purge the oldest n log files, but the ones can not be purged, returns the number purged
+func (l *BinLog) purgeOldest(n int) (int, error) {
+	if m := l.purgeableNum(); n > m {
+		n = m
+	}
+
+	if n <= 0 {
+		return 0, nil
+	}
+
+	l.purge(n)
+	return n, l.flushIndex()
+}
+
+//	purge the oldest log files out of the retention
+func (l *BinLog) purgeRetention() (int, error) {
+	n := 0
+	if l.cfg.MaxFileNum > 0 && len(l.logNames) > l.cfg.MaxFileNum {
+		n = len(l.logNames) - l.cfg.MaxFileNum
+	}
+
+	if l.cfg.MaxAge > 0 || l.cfg.MaxTotalSize > 0 {
+		expired := time.Now().Add(-time.Duration(l.cfg.MaxAge) * time.Second)
+
+		var total int64
+		sizes := make([]int64, len(l.logNames))
+		for i, name := range l.logNames {
+			st, err := os.Stat(path.Join(l.path, name))
+			if err != nil {
+				return 0, err
+			}
+
+			sizes[i] = st.Size()
+			total += st.Size()
+
+			if l.cfg.MaxAge > 0 && st.ModTime().Before(expired) && i >= n {
+				n = i + 1
+			}
+		}
+
+		for i := 0; l.cfg.MaxTotalSize > 0 && total > int64(l.cfg.MaxTotalSize) && i < len(sizes); i++ {
+			total -= sizes[i]
+			if i >= n {
+				n = i + 1
+			}
+		}
+	}
+
+	return l.purgeOldest(n)
+}
+
+//	purge the log files before the one at index
+func (l *BinLog) purgeTo(index int64) (int, error) {
+	n := 0
+	for _, name := range l.logNames {
+		if i, err := parseLogFileIndex(name); err != nil {
+			return 0, err
+		} else if i >= index {
+			break
+		}
+		n++
+	}
+
+	return l.purgeOldest(n)
+}
+
+//	purge the log files whose last events are logged before t
+func (l *BinLog) purgeBefore(t time.Time) (int, error) {
+	n := 0
+	for _, name := range l.logNames {
+		if st, err := os.Stat(path.Join(l.path, name)); err != nil {
+			return 0, err
+		} else if !st.ModTime().Before(t) {
+			break
+		}
+		n++
+	}
+
+	return l.purgeOldest(n)
+}
+
+//	PurgeBinLogTo purges the log files before the one at index, returns the number purged,
+//	the log files needed by the slaves are kept.
+func (l *Ledis) PurgeBinLogTo(index int64) (int, error) {
+	if l.binlog == nil {
+		return 0, errBinLogDisabled
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	return l.binlog.purgeTo(index)
+}
+
+//	PurgeBinLogBefore purges the log files whose events are all logged before t, returns
+//	the number purged, the log files needed by the slaves are kept.
+func (l *Ledis) PurgeBinLogBefore(t time.Time) (int, error) {
+	if l.binlog == nil {
+		return 0, errBinLogDisabled
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	return l.binlog.purgeBefore(t)
+}
+
+//	BinLogNames returns the names of the log files, the oldest first
+func (l *Ledis) BinLogNames() ([]string, error) {
+	if l.binlog == nil {
+		return nil, errBinLogDisabled
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	names := make([]string, len(l.binlog.logNames))
+	copy(names, l.binlog.logNames)
+	return names, nil
+}
+
+//	SetSlaveLastID records the last batch id synced by a connected slave, the log files
+//	having the batches after it are not purged until RemoveSlave.
+func (l *Ledis) SetSlaveLastID(slave string, lastID uint64) {
+	if l.binlog == nil {
+		return
+	}
+
+	l.Lock()
+	l.binlog.slaves[slave] = lastID
+	l.Unlock()
+}
+
+func (l *Ledis) RemoveSlave(slave string) {
+	if l.binlog == nil {
+		return
+	}
+
+	l.Lock()
+	delete(l.binlog.slaves, slave)
+	l.Unlock()
+}
+
+func (l *Ledis) binLogPurgeCycle() {
+	if l.binlog == nil {
+		return
+	}
+
+	l.jobs.Add(1)
+	go func() {
+		tick := time.NewTicker(binLogPurgeInterval)
+		end := false
+		for !end {
+			select {
+			case <-tick.C:
+				l.Lock()
+				if _, err := l.binlog.purgeRetention(); err != nil {
+					log.Error("purge binlog error %s", err.Error())
+				}
+				l.Unlock()
+			case <-l.quit:
+				end = true
+			}
+		}
+
+		tick.Stop()
+		l.jobs.Done()
+	}()
+}
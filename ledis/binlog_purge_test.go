@@ -0,0 +1,135 @@
+package ledis
+
+import (
+	"fmt"
+	"github.com/siddontang/ledisdb/config"
+	"os"
+	"testing"
+	"time"
+)
+
+func openPurgeLedis(t *testing.T, name string) *Ledis {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_binlog_purge/" + name
+	cfg.BinLog.MaxFileNum = 100
+	cfg.BinLog.MaxFileSize = 1024
+
+	os.RemoveAll(cfg.DataDir)
+
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ := l.Select(0)
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("purge_%d", i))
+		db.Set(key, key)
+	}
+
+	return l
+}
+
+func TestBinLogPurge(t *testing.T) {
+	l := openPurgeLedis(t, "manual")
+	defer l.Close()
+
+	names, _ := l.BinLogNames()
+	if len(names) < 5 {
+		t.Fatal(len(names))
+	}
+
+	index, _ := parseLogFileIndex(names[2])
+	if n, err := l.PurgeBinLogTo(index); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	} else if names, _ = l.BinLogNames(); names[0] != l.binlog.FormatLogFileName(index) {
+		t.Fatal(names)
+	}
+
+	//	a slave keeps the log files after its last id
+	l.SetSlaveLastID("slave", l.binlog.ids[1].id)
+
+	if n, err := l.PurgeBinLogBefore(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	l.RemoveSlave("slave")
+
+	//	the current log file is never purged
+	if _, err := l.PurgeBinLogBefore(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if names, _ = l.BinLogNames(); len(names) > 1 {
+		t.Fatal(names)
+	} else if len(names) == 1 && l.binlog.logFile != nil && names[0] != l.binlog.LogFileName() {
+		t.Fatal(names)
+	}
+
+	info := &MasterInfo{LastID: 1}
+	if _, err := l.ReadEventsTo(info, new(nopWriter)); err != nil {
+		t.Fatal(err)
+	} else if info.LogFileIndex != -1 {
+		t.Fatal(info.LogFileIndex)
+	}
+}
+
+func TestBinLogRetention(t *testing.T) {
+	l := openPurgeLedis(t, "retention")
+	defer l.Close()
+
+	names, _ := l.BinLogNames()
+	total := int64(0)
+	for _, name := range names {
+		st, _ := os.Stat(l.binlog.FormatLogFilePath(mustLogFileIndex(name)))
+		total += st.Size()
+	}
+
+	//	by the total size
+	l.binlog.cfg.MaxTotalSize = int(total / 2)
+	if n, err := l.binlog.purgeRetention(); err != nil {
+		t.Fatal(err)
+	} else if n < len(names)/2-1 || n > len(names)/2+1 {
+		t.Fatal(n, len(names))
+	}
+
+	//	by the age, but the ones needed by the slave
+	l.SetSlaveLastID("slave", l.binlog.ids[2].id)
+
+	names, _ = l.BinLogNames()
+	past := time.Now().Add(-time.Hour)
+	for _, name := range names {
+		os.Chtimes(l.binlog.FormatLogFilePath(mustLogFileIndex(name)), past, past)
+	}
+
+	l.binlog.cfg.MaxAge = 60
+	if n, err := l.binlog.purgeRetention(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	l.RemoveSlave("slave")
+
+	if _, err := l.binlog.purgeRetention(); err != nil {
+		t.Fatal(err)
+	} else if names, _ = l.BinLogNames(); len(names) > 1 {
+		t.Fatal(names)
+	}
+}
+
+func mustLogFileIndex(name string) int64 {
+	index, err := parseLogFileIndex(name)
+	if err != nil {
+		panic(err)
+	}
+	return index
+}
+
+type nopWriter struct{}
+
+func (w *nopWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
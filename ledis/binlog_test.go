@@ -1,7 +1,9 @@
 package ledis
 
 import (
+	"bytes"
 	"github.com/siddontang/ledisdb/config"
+	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -35,3 +37,202 @@ func TestBinLog(t *testing.T) {
 		t.Fatal(len(fs))
 	}
 }
+
+func TestBinLogChecksum(t *testing.T) {
+	cfg := new(config.Config)
+
+	cfg.BinLog.MaxFileNum = 10
+	cfg.BinLog.MaxFileSize = 1024 * 1024
+	cfg.DataDir = "/tmp/ledis_binlog_checksum"
+
+	os.RemoveAll(cfg.DataDir)
+
+	b, err := NewBinLog(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := b.Log(encodeBinLogPut([]byte("key"), []byte("value"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logPath := b.FormatLogFilePath(b.LogFileIndex())
+	size := b.LogFilePos()
+	b.Close()
+
+	if n, pos, err := VerifyBinLogFile(logPath); err != nil {
+		t.Fatal(err)
+	} else if n != 10 || pos != size {
+		t.Fatal(n, pos, size)
+	}
+
+	//	a partial event at the tail is truncated at startup
+	f, _ := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0666)
+	f.Write([]byte{0, 0, 0, 1, 0, 0, 0, 100, 1})
+	f.Close()
+
+	if _, pos, err := VerifyBinLogFile(logPath); err != io.ErrUnexpectedEOF || pos != size {
+		t.Fatal(err, pos)
+	}
+
+	if b, err = NewBinLog(cfg); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	if n, _, err := VerifyBinLogFile(logPath); err != nil || n != 10 {
+		t.Fatal(err, n)
+	}
+
+	//	a bit flip is detected
+	data, _ := ioutil.ReadFile(logPath)
+	data[len(data)-6] ^= 1
+	ioutil.WriteFile(logPath, data, 0666)
+
+	if n, _, err := VerifyBinLogFile(logPath); err != ErrBinLogCorrupt || n != 9 {
+		t.Fatal(err, n)
+	}
+
+	//	so are the events sent to slaves
+	if err = ReadEventFromReader(bytes.NewReader(data[binLogHeaderSize:]), func(uint32, uint64, []byte) error {
+		return nil
+	}); err != ErrBinLogCorrupt {
+		t.Fatal(err)
+	}
+
+	//	a corrupt event ending at the tail is truncated at startup
+	if b, err = NewBinLog(cfg); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	if n, _, err := VerifyBinLogFile(logPath); err != nil || n != 9 {
+		t.Fatal(err, n)
+	}
+	data, _ = ioutil.ReadFile(logPath)
+
+	//	a corrupt length is refused before reading the event
+	head := binLogHeaderSize + 2*(binLogEventHeadSize+len(encodeBinLogPut([]byte("key"), []byte("value")))+4)
+	data[head+12] = 0xFF
+	ioutil.WriteFile(logPath, data, 0666)
+
+	if n, pos, err := VerifyBinLogFile(logPath); err != ErrBinLogCorrupt || n != 2 || pos != int64(head) {
+		t.Fatal(err, n, pos)
+	}
+
+	//	the corrupt events before the tail are never truncated at startup
+	if _, err = NewBinLog(cfg); err == nil {
+		t.Fatal("must refuse a corrupt binlog")
+	}
+
+	if n, truncated, err := RepairBinLogFile(logPath); err != nil || n != 2 || truncated != int64(len(data)-head) {
+		t.Fatal(err, n, truncated)
+	}
+
+	if b, err = NewBinLog(cfg); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+}
+
+func TestBinLogRollback(t *testing.T) {
+	cfg := new(config.Config)
+
+	cfg.BinLog.MaxFileNum = 10
+	cfg.BinLog.MaxFileSize = 1024
+	cfg.DataDir = "/tmp/ledis_binlog_rollback"
+
+	os.RemoveAll(cfg.DataDir)
+
+	b, err := NewBinLog(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	event := encodeBinLogPut([]byte("key"), []byte("value"))
+	if err = b.Log(event); err != nil {
+		t.Fatal(err)
+	}
+
+	//	the events of a failed commit are dropped, the next ones are appended in place
+	index, pos, lastID := b.LogFileIndex(), b.LogFilePos(), b.LastID()
+	if err = b.Log(event, event); err != nil {
+		t.Fatal(err)
+	} else if err = b.rollback(index, pos, lastID); err != nil {
+		t.Fatal(err)
+	} else if b.LastID() != lastID || b.LogFilePos() != pos {
+		t.Fatal(b.LastID(), b.LogFilePos())
+	}
+
+	if err = b.Log(event); err != nil {
+		t.Fatal(err)
+	} else if n, _, err := VerifyBinLogFile(b.FormatLogFilePath(index)); err != nil || n != 2 {
+		t.Fatal(err, n)
+	}
+
+	//	so are the ones closing the log file for its size
+	index, pos, lastID = b.LogFileIndex(), b.LogFilePos(), b.LastID()
+	if err = b.Log(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	} else if b.LogFileIndex() != index+1 {
+		t.Fatal(b.LogFileIndex())
+	} else if err = b.rollback(index, pos, lastID); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, end, err := VerifyBinLogFile(b.FormatLogFilePath(index)); err != nil || n != 2 || end != pos {
+		t.Fatal(err, n, end)
+	}
+
+	if err = b.Log(event); err != nil {
+		t.Fatal(err)
+	} else if b.LastID() != lastID+1 {
+		t.Fatal(b.LastID())
+	} else if e := b.ids[len(b.ids)-1]; e != (binLogIDPos{lastID + 1, index + 1, binLogHeaderSize}) {
+		t.Fatal(b.ids)
+	}
+}
+
+func TestDecodeBinLogEvent(t *testing.T) {
+	l, err := openIDLedis("decode", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	db, _ := l.Select(2)
+
+	key := []byte("key")
+	member := []byte("member")
+
+	checkEvent := func(event []byte, tp uint8, keyType byte, dataType byte, field []byte, value []byte, num int64) {
+		e, err := DecodeBinLogEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		} else if e.Type != tp || e.DB != 2 || e.KeyType != keyType || e.DataType != dataType {
+			t.Fatal(e.Type, e.DB, e.KeyType, e.DataType)
+		} else if !bytes.Equal(e.Key, key) || !bytes.Equal(e.Field, field) || !bytes.Equal(e.Value, value) || e.Num != num {
+			t.Fatal(string(e.Key), string(e.Field), string(e.Value), e.Num)
+		}
+	}
+
+	checkEvent(encodeBinLogPut(db.encodeKVKey(key), []byte("value")), BinLogTypePut, KVType, KVType, nil, []byte("value"), 0)
+	checkEvent(encodeBinLogDelete(db.hEncodeHashKey(key, member)), BinLogTypeDeletion, HashType, HashType, member, nil, 0)
+	checkEvent(encodeBinLogPut(db.zEncodeSetKey(key, member), PutInt64(-10)), BinLogTypePut, ZSetType, ZSetType, member, PutInt64(-10), -10)
+	checkEvent(encodeBinLogPut(db.expEncodeTimeKey(SetType, key, 1000), key), BinLogTypePut, ExpTimeType, SetType, nil, key, 1000)
+
+	checkEvent(encodeBinLogCommand(BinLogCommandHClear, []byte{2}, key), BinLogTypeCommand, 0, HashType, nil, nil, 0)
+
+	if e, err := DecodeBinLogEvent(encodeBinLogCommand(BinLogCommandFlushDB, []byte{2}, []byte{ListType})); err != nil {
+		t.Fatal(err)
+	} else if e.Command != BinLogCommandFlushDB || e.DataType != ListType || e.Key != nil {
+		t.Fatal(e.Command, e.DataType, e.Key)
+	}
+
+	if _, err := DecodeBinLogEvent([]byte{BinLogTypePut, 0}); err == nil {
+		t.Fatal("must error")
+	}
+}
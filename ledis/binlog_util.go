@@ -54,13 +54,106 @@ func decodeBinLogPut(sz []byte) ([]byte, []byte, error) {
 	return sz[3 : 3+keyLen], sz[3+keyLen:], nil
 }
 
+//	command event : BinLogTypeCommand|commandType|[len(bigendian uint16)|arg]...
 func encodeBinLogCommand(commandType uint8, args ...[]byte) []byte {
-	//to do
-	return nil
+	n := 2
+	for _, arg := range args {
+		n += 2 + len(arg)
+	}
+
+	buf := make([]byte, n)
+	buf[0] = BinLogTypeCommand
+	buf[1] = commandType
+
+	pos := 2
+	for _, arg := range args {
+		binary.BigEndian.PutUint16(buf[pos:], uint16(len(arg)))
+		pos += 2
+		copy(buf[pos:], arg)
+		pos += len(arg)
+	}
+
+	return buf
 }
 
 func decodeBinLogCommand(sz []byte) (uint8, [][]byte, error) {
-	return 0, nil, errBinLogCommandType
+	if len(sz) < 2 || sz[0] != BinLogTypeCommand {
+		return 0, nil, errBinLogCommandType
+	}
+
+	args := make([][]byte, 0, 4)
+	for pos := 2; pos < len(sz); {
+		if pos+2 > len(sz) {
+			return 0, nil, errBinLogCommandType
+		}
+
+		n := int(binary.BigEndian.Uint16(sz[pos:]))
+		pos += 2
+		if pos+n > len(sz) {
+			return 0, nil, errBinLogCommandType
+		}
+
+		args = append(args, sz[pos:pos+n])
+		pos += n
+	}
+
+	return sz[1], args, nil
+}
+
+//	the args of a command event: db index, then
+//
+//		HCLEAR, LCLEAR, SCLEAR, ZCLEAR : key
+//		EXPIREAT                       : data type, key, when(bigendian int64)
+//		FLUSHDB                        : data type
+func checkBinLogCommand(commandType uint8, args [][]byte) error {
+	if len(args) == 0 || len(args[0]) != 1 || args[0][0] >= MaxDBNumber {
+		return errBinLogCommandType
+	}
+
+	switch commandType {
+	case BinLogCommandHClear, BinLogCommandLClear, BinLogCommandSClear, BinLogCommandZClear:
+		if len(args) != 2 {
+			return errBinLogCommandType
+		}
+	case BinLogCommandExpireAt:
+		if len(args) != 4 || len(args[1]) != 1 || len(args[3]) != 8 {
+			return errBinLogCommandType
+		}
+	case BinLogCommandFlushDB:
+		if len(args) != 2 || len(args[1]) != 1 {
+			return errBinLogCommandType
+		}
+	default:
+		return errBinLogCommandType
+	}
+	return nil
+}
+
+func formatBinLogCommand(buf []byte, event []byte) ([]byte, error) {
+	commandType, args, err := decodeBinLogCommand(event)
+	if err != nil {
+		return nil, err
+	} else if err = checkBinLogCommand(commandType, args); err != nil {
+		return nil, err
+	}
+
+	buf = append(buf, "COMMAND "...)
+	buf = append(buf, BinLogCommandName[commandType]...)
+	buf = append(buf, fmt.Sprintf(" DB:%2d ", args[0][0])...)
+
+	switch commandType {
+	case BinLogCommandExpireAt:
+		buf = append(buf, fmt.Sprintf("%s ", TypeName[args[1][0]])...)
+		buf = strconv.AppendQuote(buf, String(args[2]))
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, int64(binary.BigEndian.Uint64(args[3])), 10)
+	case BinLogCommandFlushDB:
+		buf = append(buf, TypeName[args[1][0]]...)
+	default:
+		buf = strconv.AppendQuote(buf, String(args[1]))
+	}
+
+	return buf, nil
 }
 
 func FormatBinLogEvent(event []byte) (string, error) {
@@ -79,6 +172,11 @@ func FormatBinLogEvent(event []byte) (string, error) {
 	case BinLogTypeDeletion:
 		k, err = decodeBinLogDelete(event)
 		buf = append(buf, "DELETE "...)
+	case BinLogTypeCommand:
+		if buf, err = formatBinLogCommand(buf, event); err != nil {
+			return "", err
+		}
+		return String(buf), nil
 	default:
 		err = errInvalidBinLogEvent
 	}
@@ -170,6 +268,20 @@ func formatDataKey(buf []byte, k []byte) ([]byte, error) {
 			buf = append(buf, ' ')
 			buf = strconv.AppendInt(buf, score, 10)
 		}
+	case SetType:
+		if key, member, err := db.sDecodeSetKey(k); err != nil {
+			return nil, err
+		} else {
+			buf = strconv.AppendQuote(buf, String(key))
+			buf = append(buf, ' ')
+			buf = strconv.AppendQuote(buf, String(member))
+		}
+	case SSizeType:
+		if key, err := db.sDecodeSizeKey(k); err != nil {
+			return nil, err
+		} else {
+			buf = strconv.AppendQuote(buf, String(key))
+		}
 	case BitType:
 		if key, seq, err := db.bDecodeBinKey(k); err != nil {
 			return nil, err
@@ -184,6 +296,18 @@ func formatDataKey(buf []byte, k []byte) ([]byte, error) {
 		} else {
 			buf = strconv.AppendQuote(buf, String(key))
 		}
+	case HLLType:
+		if key, err := db.pfDecodeKey(k); err != nil {
+			return nil, err
+		} else {
+			buf = strconv.AppendQuote(buf, String(key))
+		}
+	case KeyTypeType:
+		if key, err := db.ktDecodeTypeKey(k); err != nil {
+			return nil, err
+		} else {
+			buf = strconv.AppendQuote(buf, String(key))
+		}
 	case ExpTimeType:
 		if tp, key, t, err := db.expDecodeTimeKey(k); err != nil {
 			return nil, err
@@ -202,9 +326,176 @@ func formatDataKey(buf []byte, k []byte) ([]byte, error) {
 			buf = append(buf, ' ')
 			buf = strconv.AppendQuote(buf, String(key))
 		}
+	case TrashType:
+		if tp, key, err := db.trashDecodeKey(k); err != nil {
+			return nil, err
+		} else {
+			buf = append(buf, TypeName[tp]...)
+			buf = append(buf, ' ')
+			buf = strconv.AppendQuote(buf, String(key))
+		}
 	default:
 		return nil, errInvalidBinLogEvent
 	}
 
 	return buf, nil
 }
+
+//	BinLogEvent is a binlog event decoded by DecodeBinLogEvent
+type BinLogEvent struct {
+	//	BinLogTypePut, BinLogTypeDeletion or BinLogTypeCommand
+	Type uint8
+
+	//	the command type of a command event, like BinLogCommandHClear
+	Command uint8
+
+	DB uint8
+
+	//	the type of the data key written, like HSizeType, 0 for a command event
+	KeyType byte
+
+	//	the data type the key belongs to, like HashType for HSizeType, the data type of
+	//	the expire time and trash records, or the one of EXPIREAT and FLUSHDB
+	DataType byte
+
+	//	the user key, nil for FLUSHDB
+	Key []byte
+
+	//	the hash field, or the set or zset member
+	Field []byte
+
+	Value []byte
+
+	//	the list or bitmap sequence, the zset score, or the expire time
+	Num int64
+}
+
+//	DecodeBinLogEvent decodes the data key written by event, or the args of a command event
+func DecodeBinLogEvent(event []byte) (*BinLogEvent, error) {
+	if len(event) == 0 {
+		return nil, errInvalidBinLogEvent
+	}
+
+	e := &BinLogEvent{Type: event[0]}
+
+	var k []byte
+	var err error
+	switch e.Type {
+	case BinLogTypePut:
+		k, e.Value, err = decodeBinLogPut(event)
+	case BinLogTypeDeletion:
+		k, err = decodeBinLogDelete(event)
+	case BinLogTypeCommand:
+		err = e.decodeCommand(event)
+		return e, err
+	default:
+		err = errInvalidBinLogEvent
+	}
+
+	if err != nil {
+		return nil, err
+	} else if err = e.decodeDataKey(k); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *BinLogEvent) decodeCommand(event []byte) error {
+	commandType, args, err := decodeBinLogCommand(event)
+	if err != nil {
+		return err
+	} else if err = checkBinLogCommand(commandType, args); err != nil {
+		return err
+	}
+
+	e.Command = commandType
+	e.DB = args[0][0]
+
+	switch commandType {
+	case BinLogCommandHClear:
+		e.DataType, e.Key = HashType, args[1]
+	case BinLogCommandLClear:
+		e.DataType, e.Key = ListType, args[1]
+	case BinLogCommandSClear:
+		e.DataType, e.Key = SetType, args[1]
+	case BinLogCommandZClear:
+		e.DataType, e.Key = ZSetType, args[1]
+	case BinLogCommandExpireAt:
+		e.DataType, e.Key = args[1][0], args[2]
+		e.Num = int64(binary.BigEndian.Uint64(args[3]))
+	case BinLogCommandFlushDB:
+		e.DataType = args[1][0]
+	}
+
+	return nil
+}
+
+func (e *BinLogEvent) decodeDataKey(k []byte) error {
+	if len(k) < 2 {
+		return errInvalidBinLogEvent
+	}
+
+	db := new(DB)
+	db.index = k[0]
+
+	e.DB = k[0]
+	e.KeyType = k[1]
+	e.DataType = k[1]
+
+	var err error
+	var seq int32
+	var bitSeq uint32
+
+	switch k[1] {
+	case KVType:
+		e.Key, err = db.decodeKVKey(k)
+	case HashType:
+		e.Key, e.Field, err = db.hDecodeHashKey(k)
+	case HSizeType:
+		e.DataType = HashType
+		e.Key, err = db.hDecodeSizeKey(k)
+	case ListType:
+		e.Key, seq, err = db.lDecodeListKey(k)
+		e.Num = int64(seq)
+	case LMetaType:
+		e.DataType = ListType
+		e.Key, err = db.lDecodeMetaKey(k)
+	case ZSetType:
+		e.Key, e.Field, err = db.zDecodeSetKey(k)
+		if err == nil && e.Type == BinLogTypePut {
+			e.Num, err = Int64(e.Value, nil)
+		}
+	case ZSizeType:
+		e.DataType = ZSetType
+		e.Key, err = db.zDecodeSizeKey(k)
+	case ZScoreType:
+		e.DataType = ZSetType
+		e.Key, e.Field, e.Num, err = db.zDecodeScoreKey(k)
+	case BitType:
+		e.Key, bitSeq, err = db.bDecodeBinKey(k)
+		e.Num = int64(bitSeq)
+	case BitMetaType:
+		e.DataType = BitType
+		e.Key, err = db.bDecodeMetaKey(k)
+	case SetType:
+		e.Key, e.Field, err = db.sDecodeSetKey(k)
+	case SSizeType:
+		e.DataType = SetType
+		e.Key, err = db.sDecodeSizeKey(k)
+	case HLLType:
+		e.Key, err = db.pfDecodeKey(k)
+	case KeyTypeType:
+		e.Key, err = db.ktDecodeTypeKey(k)
+	case ExpTimeType:
+		e.DataType, e.Key, e.Num, err = db.expDecodeTimeKey(k)
+	case ExpMetaType:
+		e.DataType, e.Key, err = db.expDecodeMetaKey(k)
+	case TrashType:
+		e.DataType, e.Key, err = db.trashDecodeKey(k)
+	default:
+		err = errInvalidBinLogEvent
+	}
+
+	return err
+}
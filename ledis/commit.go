@@ -0,0 +1,264 @@
+package ledis
+
+import (
+	"encoding/binary"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/ledisdb/store"
+	"sync"
+)
+
+/*
+Group commit coalesces the txs committed concurrently:
+
+	a committing tx is queued, the first one finding no leader leads the group,
+	it takes all the queued txs and writes them with one write batch and one binlog
+	append, which is fsynced once if binlog sync is enabled, then wakes up every tx
+	with its result. Then it hands the leadership to the first tx queued meanwhile,
+	which leads the next group, so every leader returns after its own group.
+
+The txs in a group lock different keys, so their writes never conflict, a tx whose
+key type claims fail is dropped from the group alone. The group is written under the
+Ledis mutex, so replication and dump see whole groups only.
+
+The data and the binlog are committed atomically: the events are appended to the binlog
+first, then the binlog position after them is put in the same write batch as the data:
+
+	binlog commit key : MaxDBNumber | 0 -> log file index(bigendian int64)|log pos(bigendian int64)|last id(bigendian uint64)
+
+If the process dies between the two steps, the events after the committed position are
+truncated at startup, they were never acknowledged to the writers. If the write batch fails
+to commit, the events are truncated at once, before any slave reads them.
+
+Every group is logged as a batch with a new id, but a slave logs its local writes with the
+last id replicated, so the ids of the master are never taken.
+*/
+
+var binLogCommitKey = []byte{MaxDBNumber, 0}
+
+const (
+	//	max txs written in one group
+	maxCommitGroup = 256
+)
+
+type commitQueue struct {
+	sync.Mutex
+
+	queue   []*tx
+	leading bool
+}
+
+func (q *commitQueue) commit(t *tx) error {
+	q.Lock()
+	q.queue = append(q.queue, t)
+	if q.leading {
+		q.Unlock()
+
+		select {
+		case err := <-t.done:
+			return err
+		case <-t.lead:
+			//	t is the first of the queue now
+			q.Lock()
+		}
+	}
+
+	q.leading = true
+
+	n := len(q.queue)
+	if n > maxCommitGroup {
+		n = maxCommitGroup
+	}
+
+	group := make([]*tx, n)
+	copy(group, q.queue)
+	q.queue = append(q.queue[0:0], q.queue[n:]...)
+	q.Unlock()
+
+	t.l.writeGroup(group)
+
+	q.Lock()
+	if len(q.queue) > 0 {
+		q.queue[0].lead <- struct{}{}
+	} else {
+		q.leading = false
+	}
+	q.Unlock()
+
+	return <-t.done
+}
+
+//	write the txs of a group in one write batch and one binlog append
+func (l *Ledis) writeGroup(group []*tx) {
+	l.Lock()
+
+	wb := l.ldb.NewWriteBatch()
+
+	committed := make([]*tx, 0, len(group))
+	var events [][]byte
+	var sessions []*Session
+	for _, t := range group {
+		if err := t.applyClaims(); err != nil {
+			t.Rollback()
+			t.done <- err
+			continue
+		}
+
+		if err := t.writeTo(wb); err != nil {
+			//	never happens, the events are encoded by t
+			t.Rollback()
+			t.done <- err
+			continue
+		}
+
+		committed = append(committed, t)
+		if l.binlog != nil {
+			e := t.binLogEvents()
+			if len(e) > 0 && t.session != nil {
+				sessions = append(sessions, t.session)
+			}
+			events = append(events, e...)
+		}
+	}
+
+	err := l.commitWithBinLog(wb, events)
+	if err == nil {
+		for _, t := range committed {
+			t.touchVersions()
+		}
+
+		//	the events are appended as the last batch
+		for _, s := range sessions {
+			s.committed(l.binlog.LastID())
+		}
+	}
+
+	l.Unlock()
+
+	for _, t := range committed {
+		t.Rollback()
+		t.done <- err
+	}
+}
+
+//	append events to the binlog as a new batch, then commit wb with the binlog position after them,
+//	the Ledis mutex must be held.
+func (l *Ledis) commitWithBinLog(wb store.WriteBatch, events [][]byte) error {
+	if l.binlog == nil || len(events) == 0 {
+		return wb.Commit()
+	}
+
+	id := l.binlog.LastID()
+	if !l.slave {
+		id++
+	}
+
+	if err := l.commitWithBinLogID(wb, id, events); err != nil {
+		return err
+	}
+
+	if !l.slave {
+		l.setCommitID(id)
+	}
+	return nil
+}
+
+//	like commitWithBinLog, but logs events with id, used by the replication
+func (l *Ledis) commitWithBinLogID(wb store.WriteBatch, id uint64, events [][]byte) error {
+	if l.binlog == nil || len(events) == 0 {
+		return wb.Commit()
+	}
+
+	index, pos, lastID := l.binlog.LogFileIndex(), l.binlog.LogFilePos(), l.binlog.LastID()
+
+	err := l.binlog.LogWithID(id, events...)
+	if err == nil {
+		buf := make([]byte, 24)
+		binary.BigEndian.PutUint64(buf[0:8], uint64(l.binlog.LogFileIndex()))
+		binary.BigEndian.PutUint64(buf[8:16], uint64(l.binlog.LogFilePos()))
+		binary.BigEndian.PutUint64(buf[16:24], l.binlog.LastID())
+		wb.Put(binLogCommitKey, buf)
+
+		err = wb.Commit()
+	}
+
+	if err != nil {
+		//	the events are never sent to slaves, nor appended after
+		if e := l.binlog.rollback(index, pos, lastID); e != nil {
+			log.Error("rollback binlog to %s:%d error %s", l.binlog.FormatLogFileName(index), pos, e.Error())
+		}
+		return err
+	}
+
+	l.commitIndex = l.binlog.LogFileIndex()
+	l.commitPos = l.binlog.LogFilePos()
+	return nil
+}
+
+//	BinLogLastID returns the id of the last batch committed whole, which can be synced by slaves
+func (l *Ledis) BinLogLastID() uint64 {
+	l.Lock()
+	id := l.commitID
+	l.Unlock()
+	return id
+}
+
+//	BinLogWait returns a channel closed once a batch after lastID is committed whole
+func (l *Ledis) BinLogWait(lastID uint64) <-chan struct{} {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.commitID > lastID {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return l.commitWait
+}
+
+//	the Ledis mutex must be held
+func (l *Ledis) setCommitID(id uint64) {
+	if id == l.commitID {
+		return
+	}
+
+	l.commitID = id
+	close(l.commitWait)
+	l.commitWait = make(chan struct{})
+}
+
+//	SetSlaveMode is set when the replication from a master starts, and unset when it stops.
+//	A slave logs its local writes with the last id replicated.
+func (l *Ledis) SetSlaveMode(slave bool) {
+	l.Lock()
+	l.slave = slave
+	l.Unlock()
+}
+
+//	truncate the binlog events not committed with the data by the last run
+func (l *Ledis) recoverBinLog() error {
+	l.commitIndex = l.binlog.LogFileIndex()
+
+	v, err := l.ldb.Get(binLogCommitKey)
+	if err != nil {
+		return err
+	} else if len(v) != 16 && len(v) != 24 {
+		//	no binlog committed yet
+		return nil
+	}
+
+	index := int64(binary.BigEndian.Uint64(v[0:8]))
+	pos := int64(binary.BigEndian.Uint64(v[8:16]))
+	if len(v) == 24 {
+		//	the commit key of the old version has no last id
+		l.binlog.advanceID(binary.BigEndian.Uint64(v[16:24]))
+		l.commitID = l.binlog.LastID()
+	}
+
+	n, err := l.binlog.TruncateAfter(index, pos)
+	if err != nil {
+		return err
+	} else if n > 0 {
+		log.Info("truncate %d bytes of binlog not committed after %s:%d", n, l.binlog.FormatLogFileName(index), pos)
+	}
+	return nil
+}
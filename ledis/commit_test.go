@@ -0,0 +1,353 @@
+package ledis
+
+import (
+	"fmt"
+	"github.com/siddontang/ledisdb/config"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupCommit(t *testing.T) {
+	cfgM := new(config.Config)
+	cfgM.DataDir = "/tmp/test_group_commit/master"
+	cfgM.BinLog.MaxFileNum = 10
+	cfgM.BinLog.MaxFileSize = 1024 * 1024
+	cfgM.BinLog.Sync = true
+	cfgM.SingleNamespace = true
+
+	os.RemoveAll(cfgM.DataDir)
+
+	master, err := Open(cfgM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+
+	db, _ := master.Select(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := []byte(fmt.Sprintf("group_%d_%d", i, j))
+				if err := db.Set(key, key); err != nil {
+					t.Error(err)
+					return
+				} else if _, err := db.HSet(append(key, 'h'), key, key); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//	a failed claim fails its tx alone
+	db.Set([]byte("group_wrong"), []byte("1"))
+	if _, err := db.HSet([]byte("group_wrong"), []byte("a"), []byte("1")); err != ErrWrongType {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 50; j++ {
+			key := []byte(fmt.Sprintf("group_%d_%d", i, j))
+			if v, _ := db.Get(key); string(v) != string(key) {
+				t.Fatal(string(v))
+			} else if v, _ := db.HGet(append(key, 'h'), key); string(v) != string(key) {
+				t.Fatal(string(v))
+			}
+		}
+	}
+
+	//	the binlog has all the writes of the groups
+	cfgS := new(config.Config)
+	cfgS.DataDir = "/tmp/test_group_commit/slave"
+
+	os.RemoveAll(cfgS.DataDir)
+
+	slave, err := Open(cfgS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+
+	for _, name := range master.binlog.LogNames() {
+		if err = slave.ReplicateFromBinLog(path.Join(master.binlog.LogPath(), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = checkLedisEqual(master, slave); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupCommitLeader(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_group_commit/leader"
+	cfg.BinLog.MaxFileNum = 10
+	cfg.BinLog.MaxFileSize = 1024 * 1024
+
+	os.RemoveAll(cfg.DataDir)
+
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	db, _ := l.Select(0)
+
+	//	a leads its group, blocked by the Ledis mutex, while b is queued
+	l.Lock()
+	a := make(chan error, 1)
+	go func() {
+		a <- db.Set([]byte("leader_a"), []byte("1"))
+	}()
+
+	for leading := false; !leading; {
+		time.Sleep(time.Millisecond)
+		l.commits.Lock()
+		leading = l.commits.leading && len(l.commits.queue) == 0
+		l.commits.Unlock()
+	}
+
+	b := newTx(l)
+	l.commits.Lock()
+	l.commits.queue = append(l.commits.queue, b)
+	l.commits.Unlock()
+	l.Unlock()
+
+	//	a returns after its own group, and hands the next one to b
+	select {
+	case err := <-a:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("leader not returned")
+	}
+
+	select {
+	case <-b.lead:
+	default:
+		t.Fatal("b must lead the next group")
+	}
+
+	select {
+	case err := <-b.done:
+		t.Fatal("b is written by a", err)
+	default:
+	}
+
+	l.commits.Lock()
+	l.commits.queue = l.commits.queue[0:0]
+	l.commits.leading = false
+	l.commits.Unlock()
+}
+
+func openRecoveryLedis(dir string) (*Ledis, error) {
+	cfg := new(config.Config)
+	cfg.DataDir = dir
+	cfg.BinLog.MaxFileNum = 10
+	cfg.BinLog.MaxFileSize = 1024 * 1024
+
+	return Open(cfg)
+}
+
+//	run in a child process, which is killed between the binlog append and the data commit
+func crashBetweenBinLogAndData(dir string) {
+	l, err := openRecoveryLedis(dir)
+	if err != nil {
+		os.Exit(2)
+	}
+
+	db, _ := l.Select(0)
+	if err = db.Set([]byte("committed"), []byte("1")); err != nil {
+		os.Exit(2)
+	}
+
+	l.Lock()
+	l.binlog.Log(encodeBinLogPut(db.encodeKVKey([]byte("lost")), []byte("1")))
+	os.Exit(3)
+}
+
+func TestBinLogRecovery(t *testing.T) {
+	if dir := os.Getenv("LEDIS_TEST_BINLOG_CRASH"); len(dir) > 0 {
+		crashBetweenBinLogAndData(dir)
+	}
+
+	dir := "/tmp/test_binlog_recovery"
+	os.RemoveAll(dir)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBinLogRecovery$")
+	cmd.Env = append(os.Environ(), "LEDIS_TEST_BINLOG_CRASH="+dir)
+	if err := cmd.Run(); err == nil {
+		t.Fatal("crash process exits normally")
+	} else if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatal(err)
+	}
+
+	master, err := openRecoveryLedis(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+
+	db, _ := master.Select(0)
+	if v, _ := db.Get([]byte("committed")); string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	cfgS := new(config.Config)
+	cfgS.DataDir = path.Join(dir, "slave")
+
+	slave, err := Open(cfgS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+
+	//	the event of the write never committed is truncated
+	for _, name := range master.binlog.LogNames() {
+		if err = slave.ReplicateFromBinLog(path.Join(master.binlog.LogPath(), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sdb, _ := slave.Select(0)
+	if v, _ := sdb.Get([]byte("lost")); v != nil {
+		t.Fatal("uncommitted event replicated")
+	} else if v, _ := sdb.Get([]byte("committed")); string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	if err = checkLedisEqual(master, slave); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//	BinLogWait is closed at once if a batch after the id is committed already,
+//	else when the next batch is committed
+func TestBinLogWait(t *testing.T) {
+	l, err := openIDLedis("wait", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	db, _ := l.Select(0)
+	db.Set([]byte("a"), []byte("1"))
+
+	lastID := l.BinLogLastID()
+	select {
+	case <-l.BinLogWait(lastID - 1):
+	default:
+		t.Fatal("a batch after lastID-1 is committed")
+	}
+
+	wait := l.BinLogWait(lastID)
+	select {
+	case <-wait:
+		t.Fatal("no batch after lastID")
+	default:
+	}
+
+	go db.Set([]byte("b"), []byte("1"))
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second):
+		t.Fatal("wait timeout")
+	}
+}
+
+func TestSessionLastID(t *testing.T) {
+	l, err := openIDLedis("session", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s := l.NewSession()
+	sdb, _ := s.Select(0)
+	db, _ := l.Select(0)
+
+	if id := s.LastID(); id != 0 {
+		t.Fatal(id)
+	}
+
+	sdb.Set([]byte("a"), []byte("1"))
+	lastID := l.BinLogLastID()
+	if id := s.LastID(); id != lastID {
+		t.Fatal(id, lastID)
+	}
+
+	//	the writes of the others are not the session's
+	db.Set([]byte("b"), []byte("1"))
+	if id := s.LastID(); id != lastID {
+		t.Fatal(id, lastID)
+	} else if l.BinLogLastID() == lastID {
+		t.Fatal("no batch committed")
+	}
+
+	//	a read writes nothing
+	sdb.Get([]byte("b"))
+	if id := s.LastID(); id != lastID {
+		t.Fatal(id, lastID)
+	}
+
+	m := s.Multi()
+	mdb, _ := m.Select(1)
+	mdb.Set([]byte("c"), []byte("1"))
+	if err := m.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := s.LastID(); id != l.BinLogLastID() {
+		t.Fatal(id, l.BinLogLastID())
+	}
+}
+
+//	with binlog sync, every group costs one fsync, so the concurrent writers share it
+func BenchmarkGroupCommitSync(b *testing.B) {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_group_commit/bench"
+	cfg.BinLog.MaxFileNum = 10
+	cfg.BinLog.MaxFileSize = 1024 * 1024 * 1024
+	cfg.BinLog.Sync = true
+
+	os.RemoveAll(cfg.DataDir)
+
+	l, err := Open(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	db, _ := l.Select(0)
+
+	var id int64
+	var m sync.Mutex
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		m.Lock()
+		id++
+		prefix := fmt.Sprintf("bench_group_%d_", id)
+		m.Unlock()
+
+		for i := 0; pb.Next(); i++ {
+			key := []byte(fmt.Sprintf("%s%d", prefix, i&1023))
+			if err := db.Set(key, key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
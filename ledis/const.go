@@ -18,11 +18,15 @@ const (
 	BitMetaType byte = 10
 	SetType     byte = 11
 	SSizeType   byte = 12
+	KeyTypeType byte = 13
+	HLLType     byte = 14
 
 	maxDataType byte = 100
 
 	ExpTimeType byte = 101
 	ExpMetaType byte = 102
+
+	TrashType byte = 103
 )
 
 var (
@@ -39,8 +43,11 @@ var (
 		BitMetaType: "bitmeta",
 		SetType:     "set",
 		SSizeType:   "ssize",
+		KeyTypeType: "keytype",
+		HLLType:     "hll",
 		ExpTimeType: "exptime",
 		ExpMetaType: "expmeta",
+		TrashType:   "trash",
 	}
 )
 
@@ -79,6 +86,8 @@ const (
 
 var (
 	ErrScoreMiss = errors.New("zset score miss")
+
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 )
 
 const (
@@ -86,3 +95,24 @@ const (
 	BinLogTypePut      uint8 = 0x1
 	BinLogTypeCommand  uint8 = 0x2
 )
+
+//	the logical commands logged as command events
+const (
+	BinLogCommandHClear   uint8 = 0x1
+	BinLogCommandLClear   uint8 = 0x2
+	BinLogCommandSClear   uint8 = 0x3
+	BinLogCommandZClear   uint8 = 0x4
+	BinLogCommandExpireAt uint8 = 0x5
+	BinLogCommandFlushDB  uint8 = 0x6
+)
+
+var (
+	BinLogCommandName = map[uint8]string{
+		BinLogCommandHClear:   "HCLEAR",
+		BinLogCommandLClear:   "LCLEAR",
+		BinLogCommandSClear:   "SCLEAR",
+		BinLogCommandZClear:   "ZCLEAR",
+		BinLogCommandExpireAt: "EXPIREAT",
+		BinLogCommandFlushDB:  "FLUSHDB",
+	}
+)
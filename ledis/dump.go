@@ -4,23 +4,48 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"github.com/siddontang/go-snappy/snappy"
 	"io"
 	"os"
 )
 
 //dump format
-// fileIndex(bigendian int64)|filePos(bigendian int64)
-// |keylen(bigendian int32)|key|valuelen(bigendian int32)|value......
+// magic "ldmp"|version(bigendian uint32)|fileIndex(bigendian int64)|filePos(bigendian int64)
+// |lastID(bigendian uint64)|keylen(bigendian int32)|key|valuelen(bigendian int32)|value......
 //
 //key and value are both compressed for fast transfer dump on network using snappy
+//
+//the dumps of the old version have no magic, version and lastID, fileIndex comes first,
+//they are loaded with lastID 0
+
+var dumpMagic = []byte("ldmp")
+
+const (
+	dumpVersion = 1
+
+	//	the max keys and bytes loaded from a dump in one commit
+	dumpLoadBatchKeys  = 1024
+	dumpLoadBatchBytes = 4 * 1024 * 1024
+)
 
 type MasterInfo struct {
 	LogFileIndex int64
 	LogPos       int64
+
+	//	the id of the last batch
+	LastID uint64
 }
 
 func (m *MasterInfo) WriteTo(w io.Writer) error {
+	if _, err := w.Write(dumpMagic); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(dumpVersion)); err != nil {
+		return err
+	}
+
 	if err := binary.Write(w, binary.BigEndian, m.LogFileIndex); err != nil {
 		return err
 	}
@@ -28,10 +53,30 @@ func (m *MasterInfo) WriteTo(w io.Writer) error {
 	if err := binary.Write(w, binary.BigEndian, m.LogPos); err != nil {
 		return err
 	}
+
+	if err := binary.Write(w, binary.BigEndian, m.LastID); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (m *MasterInfo) ReadFrom(r io.Reader) error {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(buf[0:4], dumpMagic) {
+		//	the old version, no file index starts with the magic
+		m.LogFileIndex = int64(binary.BigEndian.Uint64(buf))
+		m.LastID = 0
+		return binary.Read(r, binary.BigEndian, &m.LogPos)
+	}
+
+	if version := binary.BigEndian.Uint32(buf[4:8]); version != dumpVersion {
+		return fmt.Errorf("unsupported dump version %d", version)
+	}
+
 	err := binary.Read(r, binary.BigEndian, &m.LogFileIndex)
 	if err != nil {
 		return err
@@ -42,6 +87,11 @@ func (m *MasterInfo) ReadFrom(r io.Reader) error {
 		return err
 	}
 
+	err = binary.Read(r, binary.BigEndian, &m.LastID)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -57,12 +107,18 @@ func (l *Ledis) DumpFile(path string) error {
 
 func (l *Ledis) Dump(w io.Writer) error {
 	var m *MasterInfo = new(MasterInfo)
+
+	//	never a part of a batch being replayed
+	l.replay.Lock()
+	defer l.replay.Unlock()
+
 	l.Lock()
 	defer l.Unlock()
 
 	if l.binlog != nil {
 		m.LogFileIndex = l.binlog.LogFileIndex()
 		m.LogPos = l.binlog.LogFilePos()
+		m.LastID = l.commitID
 	}
 
 	var err error
@@ -83,6 +139,11 @@ func (l *Ledis) Dump(w io.Writer) error {
 		key = it.Key()
 		value = it.Value()
 
+		if bytes.Equal(key, binLogCommitKey) {
+			//	the binlog position of the master itself
+			continue
+		}
+
 		if key, err = snappy.Encode(compressBuf, key); err != nil {
 			return err
 		}
@@ -128,6 +189,14 @@ func (l *Ledis) LoadDumpFile(path string) (*MasterInfo, error) {
 }
 
 func (l *Ledis) LoadDump(r io.Reader) (*MasterInfo, error) {
+	l.replay.Lock()
+	defer l.replay.Unlock()
+
+	return l.loadDump(r)
+}
+
+//	the replay lock must be held
+func (l *Ledis) loadDump(r io.Reader) (*MasterInfo, error) {
 	l.Lock()
 	defer l.Unlock()
 
@@ -151,6 +220,30 @@ func (l *Ledis) LoadDump(r io.Reader) (*MasterInfo, error) {
 
 	var key, value []byte
 
+	//	the keys are committed batch by batch, all logged with the last id of the dump
+	wb := l.ldb.NewWriteBatch()
+	var events, keys [][]byte
+	size := 0
+
+	commit := func() error {
+		if len(events) == 0 {
+			return nil
+		}
+
+		if err := l.commitWithBinLogID(wb, info.LastID, events); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			l.touchKey(key)
+			l.lazy.observe(key)
+		}
+
+		wb = l.ldb.NewWriteBatch()
+		events, keys, size = events[:0], keys[:0], 0
+		return nil
+	}
+
 	for {
 		if err = binary.Read(rb, binary.BigEndian, &keyLen); err != nil && err != io.EOF {
 			return nil, err
@@ -178,20 +271,37 @@ func (l *Ledis) LoadDump(r io.Reader) (*MasterInfo, error) {
 			return nil, err
 		}
 
-		if err = l.ldb.Put(key, value); err != nil {
-			return nil, err
-		}
+		//	the decode buffers are reused, but some write batches keep the slices put until commit
+		key = append([]byte(nil), key...)
+		value = append([]byte(nil), value...)
+
+		wb.Put(key, value)
+		events = append(events, encodeBinLogPut(key, value))
+		keys = append(keys, key)
+		size += len(key) + len(value)
 
-		if l.binlog != nil {
-			err = l.binlog.Log(encodeBinLogPut(key, value))
+		if len(keys) >= dumpLoadBatchKeys || size >= dumpLoadBatchBytes {
+			if err = commit(); err != nil {
+				return nil, err
+			}
 		}
 
 		keyBuf.Reset()
 		valueBuf.Reset()
 	}
 
+	if err = commit(); err != nil {
+		return nil, err
+	}
+
 	deKeyBuf = nil
 	deValueBuf = nil
 
+	if l.binlog != nil {
+		//	the batches after the dump are logged with the ids of the master
+		l.binlog.advanceID(info.LastID)
+		l.setCommitID(l.binlog.LastID())
+	}
+
 	return info, nil
 }
@@ -0,0 +1,428 @@
+package ledis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+/*
+DumpKey serializes all the data of a key, RestoreKey creates the key from it:
+
+	version(1)|type num(1)|[data type(1)|data]...|crc32(bigendian uint32)
+
+the data of every type:
+
+	kv     : value
+	list   : num|item...
+	hash   : num|field|value...
+	set    : num|member...
+	zset   : num|member|score(bigendian int64)...
+	bitmap : tail seq|tail off|num|seq|segment...
+	hll    : value
+
+num, seq and tail off are bigendian uint32, every bytes value is len(bigendian uint32)|value,
+crc32 is the IEEE checksum of all the former bytes. Expire is not dumped.
+
+The fields of a hash and the members of a set or zset are unique, RestoreKey rejects
+a payload with duplicates, whose item num would not be the size of the key.
+*/
+
+const dumpKeyVersion byte = 1
+
+var (
+	ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+	ErrBusyKey     = errors.New("BUSYKEY Target key name already exists")
+)
+
+type dumpKeyWriter struct {
+	bytes.Buffer
+}
+
+func (w *dumpKeyWriter) writeUint32(n uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], n)
+	w.Write(buf[:])
+}
+
+func (w *dumpKeyWriter) writeBytes(b []byte) {
+	w.writeUint32(uint32(len(b)))
+	w.Write(b)
+}
+
+//	the first read error is kept, all later reads return zero values
+type dumpKeyReader struct {
+	b   []byte
+	err error
+}
+
+func (r *dumpKeyReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	} else if n > len(r.b) {
+		r.err = ErrDumpPayload
+		return nil
+	}
+
+	b := r.b[0:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *dumpKeyReader) readByte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *dumpKeyReader) readUint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *dumpKeyReader) readInt64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *dumpKeyReader) readBytes() []byte {
+	n := r.readUint32()
+	if b := r.next(int(n)); b != nil {
+		return append([]byte{}, b...)
+	}
+	return nil
+}
+
+//	read the item num of a collection, which can not be empty
+func (r *dumpKeyReader) readNum() int {
+	n := r.readUint32()
+	if r.err == nil && (n == 0 || int(n) > len(r.b)) {
+		r.err = ErrDumpPayload
+	}
+	return int(n)
+}
+
+func (db *DB) dumpKeyData(w *dumpKeyWriter, dataType byte, key []byte) error {
+	switch dataType {
+	case KVType:
+		v, err := db.db.Get(db.encodeKVKey(key))
+		if err != nil {
+			return err
+		}
+		w.writeBytes(v)
+	case HLLType:
+		v, err := db.db.Get(db.pfEncodeKey(key))
+		if err != nil {
+			return err
+		}
+		w.writeBytes(v)
+	case ListType:
+		v, err := db.LRange(key, 0, -1)
+		if err != nil {
+			return err
+		}
+
+		w.writeUint32(uint32(len(v)))
+		for _, item := range v {
+			w.writeBytes(item)
+		}
+	case HashType:
+		v, err := db.HGetAll(key)
+		if err != nil {
+			return err
+		}
+
+		w.writeUint32(uint32(len(v)))
+		for _, p := range v {
+			w.writeBytes(p.Field)
+			w.writeBytes(p.Value)
+		}
+	case SetType:
+		v, err := db.SMembers(key)
+		if err != nil {
+			return err
+		}
+
+		w.writeUint32(uint32(len(v)))
+		for _, m := range v {
+			w.writeBytes(m)
+		}
+	case ZSetType:
+		v, err := db.zRange(key, MinScore, MaxScore, 0, -1, false)
+		if err != nil {
+			return err
+		}
+
+		w.writeUint32(uint32(len(v)))
+		for _, p := range v {
+			w.writeBytes(p.Member)
+			binary.Write(w, binary.BigEndian, p.Score)
+		}
+	case BitType:
+		tailSeq, tailOff, err := db.bGetMeta(key)
+		if err != nil {
+			return err
+		}
+
+		w.writeUint32(uint32(tailSeq))
+		w.writeUint32(uint32(tailOff))
+
+		seqs := make([]uint32, 0, 4)
+		segments := make([][]byte, 0, 4)
+
+		it := db.bIterator(key)
+		for ; it.Valid(); it.Next() {
+			_, seq, err := db.bDecodeBinKey(it.RawKey())
+			if err != nil {
+				it.Close()
+				return err
+			}
+
+			seqs = append(seqs, seq)
+			segments = append(segments, it.Value())
+		}
+		it.Close()
+
+		w.writeUint32(uint32(len(seqs)))
+		for i, seq := range seqs {
+			w.writeUint32(seq)
+			w.writeBytes(segments[i])
+		}
+	default:
+		return errDataType
+	}
+
+	return nil
+}
+
+func (db *DB) restoreKeyData(t *tx, r *dumpKeyReader, dataType byte, key []byte) error {
+	switch dataType {
+	case KVType:
+		v := r.readBytes()
+		if r.err != nil {
+			return r.err
+		} else if err := checkValueSize(v); err != nil {
+			return err
+		}
+
+		t.Put(db.encodeKVKey(key), v)
+	case HLLType:
+		v := r.readBytes()
+		if r.err != nil {
+			return r.err
+		} else if _, err := decodeHLLRegisters(v); err != nil {
+			return err
+		}
+
+		t.Put(db.pfEncodeKey(key), v)
+	case ListType:
+		n := r.readNum()
+		if n >= int(listMaxSeq-listInitialSeq) {
+			return errListSeq
+		}
+
+		for i := 0; i < n && r.err == nil; i++ {
+			item := r.readBytes()
+			if err := checkValueSize(item); err != nil {
+				return err
+			}
+			t.Put(db.lEncodeListKey(key, listInitialSeq+int32(i)), item)
+		}
+
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(listInitialSeq))
+		binary.LittleEndian.PutUint32(buf[4:8], uint32(listInitialSeq+int32(n)-1))
+		t.Put(db.lEncodeMetaKey(key), buf)
+	case HashType:
+		n := r.readNum()
+		seen := make(map[string]bool, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			field := r.readBytes()
+			value := r.readBytes()
+			if err := checkHashKFSize(key, field); err != nil {
+				return err
+			} else if err := checkValueSize(value); err != nil {
+				return err
+			} else if seen[string(field)] {
+				//	the size stored must be the number of the sub-keys
+				return ErrDumpPayload
+			}
+			seen[string(field)] = true
+			t.Put(db.hEncodeHashKey(key, field), value)
+		}
+
+		t.Put(db.hEncodeSizeKey(key), PutInt64(int64(n)))
+	case SetType:
+		n := r.readNum()
+		seen := make(map[string]bool, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			member := r.readBytes()
+			if err := checkSetKMSize(key, member); err != nil {
+				return err
+			} else if seen[string(member)] {
+				return ErrDumpPayload
+			}
+			seen[string(member)] = true
+			t.Put(db.sEncodeSetKey(key, member), nil)
+		}
+
+		t.Put(db.sEncodeSizeKey(key), PutInt64(int64(n)))
+	case ZSetType:
+		n := r.readNum()
+		seen := make(map[string]bool, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			member := r.readBytes()
+			score := r.readInt64()
+			if r.err != nil {
+				break
+			} else if err := checkZSetKMSize(key, member); err != nil {
+				return err
+			} else if score <= MinScore || score >= MaxScore {
+				return errScoreOverflow
+			} else if seen[string(member)] {
+				//	the score key of the first one would be left too
+				return ErrDumpPayload
+			}
+			seen[string(member)] = true
+
+			t.Put(db.zEncodeSetKey(key, member), PutInt64(score))
+			t.Put(db.zEncodeScoreKey(key, member, score), []byte{})
+		}
+
+		t.Put(db.zEncodeSizeKey(key), PutInt64(int64(n)))
+	case BitType:
+		tailSeq := r.readUint32()
+		tailOff := r.readUint32()
+		n := int(r.readUint32())
+		for i := 0; i < n && r.err == nil; i++ {
+			seq := r.readUint32()
+			segment := r.readBytes()
+			if r.err == nil && (seq > tailSeq || uint32(len(segment)) > segByteSize) {
+				return ErrDumpPayload
+			}
+			t.Put(db.bEncodeBinKey(key, seq), segment)
+		}
+
+		if r.err == nil && (tailSeq >= maxSegCount || tailOff >= segBitSize) {
+			return ErrDumpPayload
+		}
+		db.bSetMeta(t, key, tailSeq, tailOff)
+	default:
+		return ErrDumpPayload
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+
+	db.claimKeyType(t, dataType, key)
+	return nil
+}
+
+//	DumpKey returns the serialized data of key in all data types, nil if key does not exist.
+func (db *DB) DumpKey(key []byte) ([]byte, error) {
+	if err := checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	it := db.db.NewIterator()
+	types, err := db.keyDataTypes(it, key)
+	it.Close()
+
+	if err != nil {
+		return nil, err
+	} else if len(types) == 0 {
+		return nil, nil
+	}
+
+	w := new(dumpKeyWriter)
+	w.WriteByte(dumpKeyVersion)
+	w.WriteByte(byte(len(types)))
+
+	for _, dataType := range types {
+		w.WriteByte(dataType)
+		if err = db.dumpKeyData(w, dataType, key); err != nil {
+			return nil, err
+		}
+	}
+
+	w.writeUint32(crc32.ChecksumIEEE(w.Bytes()))
+	return w.Bytes(), nil
+}
+
+//	RestoreKey creates key from the data returned by DumpKey, with ttl in seconds if ttl > 0.
+//	If key already exists, it is overwritten only if replace is true.
+func (db *DB) RestoreKey(key []byte, ttl int64, data []byte, replace bool) error {
+	if err := checkKeySize(key); err != nil {
+		return err
+	} else if ttl < 0 {
+		return errExpireValue
+	}
+
+	if len(data) < 6 || data[0] != dumpKeyVersion {
+		return ErrDumpPayload
+	}
+
+	pos := len(data) - 4
+	if crc32.ChecksumIEEE(data[0:pos]) != binary.BigEndian.Uint32(data[pos:]) {
+		return ErrDumpPayload
+	}
+
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	it := db.db.NewIterator()
+	types, err := db.keyDataTypes(it, key)
+	it.Close()
+
+	if err != nil {
+		return err
+	} else if len(types) > 0 && !replace {
+		return ErrBusyKey
+	}
+
+	if err = db.reclaimKey(t, key); err != nil {
+		return err
+	}
+
+	if err = db.deleteKey(t, key, types); err != nil {
+		return err
+	}
+
+	r := &dumpKeyReader{b: data[2:pos]}
+	for i := 0; i < int(data[1]); i++ {
+		dataType := r.readByte()
+		if r.err != nil {
+			return r.err
+		}
+
+		if err = db.restoreKeyData(t, r, dataType, key); err != nil {
+			return err
+		}
+
+		if ttl > 0 {
+			db.expireAt(t, dataType, key, time.Now().Unix()+ttl)
+		}
+	}
+
+	if len(r.b) != 0 {
+		return ErrDumpPayload
+	}
+
+	if err = t.Commit(); err != nil {
+		return err
+	}
+
+	db.notify(NotifyGeneric, "restore", key)
+	return nil
+}
@@ -0,0 +1,149 @@
+package ledis
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func TestDumpKey(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("testdb_dumpkey_a")
+	newKey := []byte("testdb_dumpkey_b")
+
+	if v, err := db.DumpKey(key); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal("must nil")
+	}
+
+	db.Set(key, []byte("value"))
+	db.HSet(key, []byte("f1"), []byte("v1"))
+	db.RPush(key, []byte("1"), []byte("2"))
+	db.ZAdd(key, ScorePair{-10, []byte("m1")}, ScorePair{20, []byte("m2")})
+	db.SAdd(key, []byte("s1"), []byte("s2"), []byte("s3"))
+	db.BSetBit(key, 100000, 1)
+
+	data, err := db.DumpKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RestoreKey(newKey, 0, data[0:len(data)-1], false); err != ErrDumpPayload {
+		t.Fatal(err)
+	}
+
+	if err := db.RestoreKey(newKey, 100, data, false); err != nil {
+		t.Fatal(err)
+	} else if err := db.RestoreKey(newKey, 0, data, false); err != ErrBusyKey {
+		t.Fatal(err)
+	}
+
+	if v, _ := db.Get(newKey); string(v) != "value" {
+		t.Fatal(string(v))
+	} else if v, _ := db.HGet(newKey, []byte("f1")); string(v) != "v1" {
+		t.Fatal(string(v))
+	} else if v, _ := db.LRange(newKey, 0, -1); len(v) != 2 || string(v[1]) != "2" {
+		t.Fatal(v)
+	} else if s, _ := db.ZScore(newKey, []byte("m1")); s != -10 {
+		t.Fatal(s)
+	} else if n, _ := db.ZCount(newKey, 0, 100); n != 1 {
+		t.Fatal(n)
+	} else if n, _ := db.SCard(newKey); n != 3 {
+		t.Fatal(n)
+	} else if v, _ := db.BGetBit(newKey, 100000); v != 1 {
+		t.Fatal(v)
+	} else if n, _ := db.BTail(newKey); n != 100000 {
+		t.Fatal(n)
+	} else if ttl, _ := db.TTL(newKey); ttl <= 0 {
+		t.Fatal(ttl)
+	} else if ttl, _ := db.STTL(newKey); ttl <= 0 {
+		t.Fatal(ttl)
+	}
+
+	db.Del(key)
+	db.HClear(key)
+	db.LClear(key)
+	db.SClear(key)
+	db.ZClear(key)
+	db.BDelete(key)
+	db.Set(key, []byte("other"))
+
+	data, _ = db.DumpKey(key)
+	if err := db.RestoreKey(newKey, 0, data, true); err != nil {
+		t.Fatal(err)
+	} else if v, _ := db.Get(newKey); string(v) != "other" {
+		t.Fatal(string(v))
+	} else if n, _ := db.HLen(newKey); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := db.ZCard(newKey); n != 0 {
+		t.Fatal(n)
+	} else if ttl, _ := db.TTL(newKey); ttl != -1 {
+		t.Fatal(ttl)
+	}
+}
+
+//	a payload of one data type built by data
+func dumpKeyPayload(dataType byte, data func(w *dumpKeyWriter)) []byte {
+	w := new(dumpKeyWriter)
+	w.WriteByte(dumpKeyVersion)
+	w.WriteByte(1)
+	w.WriteByte(dataType)
+	data(w)
+	w.writeUint32(crc32.ChecksumIEEE(w.Bytes()))
+	return w.Bytes()
+}
+
+func TestRestoreKeyInvalid(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("testdb_restorekey_invalid")
+
+	hash := dumpKeyPayload(HashType, func(w *dumpKeyWriter) {
+		w.writeUint32(2)
+		w.writeBytes([]byte("f"))
+		w.writeBytes([]byte("1"))
+		w.writeBytes([]byte("f"))
+		w.writeBytes([]byte("2"))
+	})
+
+	set := dumpKeyPayload(SetType, func(w *dumpKeyWriter) {
+		w.writeUint32(2)
+		w.writeBytes([]byte("m"))
+		w.writeBytes([]byte("m"))
+	})
+
+	zset := dumpKeyPayload(ZSetType, func(w *dumpKeyWriter) {
+		w.writeUint32(2)
+		w.writeBytes([]byte("m"))
+		binary.Write(w, binary.BigEndian, int64(1))
+		w.writeBytes([]byte("m"))
+		binary.Write(w, binary.BigEndian, int64(2))
+	})
+
+	for _, data := range [][]byte{hash, set, zset} {
+		if err := db.RestoreKey(key, 0, data, false); err != ErrDumpPayload {
+			t.Fatal(err)
+		}
+	}
+
+	list := dumpKeyPayload(ListType, func(w *dumpKeyWriter) {
+		w.writeUint32(1)
+		w.writeBytes(make([]byte, MaxValueSize+1))
+	})
+
+	if err := db.RestoreKey(key, 0, list, false); err != errValueSize {
+		t.Fatal(err)
+	}
+
+	if n, _ := db.HLen(key); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := db.SCard(key); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := db.ZCard(key); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := db.LLen(key); n != 0 {
+		t.Fatal(n)
+	}
+}
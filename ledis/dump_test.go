@@ -2,6 +2,9 @@ package ledis
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/siddontang/go-snappy/snappy"
 	"github.com/siddontang/ledisdb/config"
 	"github.com/siddontang/ledisdb/store"
 	"os"
@@ -54,3 +57,67 @@ func TestDump(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadOldDump(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_ledis_old_dump"
+	cfg.BinLog.MaxFileNum = 10
+	cfg.BinLog.MaxFileSize = 1024 * 1024
+
+	os.RemoveAll(cfg.DataDir)
+
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	db, _ := l.Select(0)
+
+	//	fileIndex|filePos, no magic, version and lastID
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int64(3))
+	binary.Write(&buf, binary.BigEndian, int64(100))
+
+	n := 2*dumpLoadBatchKeys + 3
+	for i := 0; i < n; i++ {
+		key, _ := snappy.Encode(nil, db.encodeKVKey([]byte(fmt.Sprintf("old_%d", i))))
+		value, _ := snappy.Encode(nil, []byte(fmt.Sprintf("v_%d", i)))
+
+		binary.Write(&buf, binary.BigEndian, uint16(len(key)))
+		buf.Write(key)
+		binary.Write(&buf, binary.BigEndian, uint32(len(value)))
+		buf.Write(value)
+	}
+
+	info, err := l.LoadDump(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.LogFileIndex != 3 || info.LogPos != 100 || info.LastID != 0 {
+		t.Fatal(*info)
+	}
+
+	for i := 0; i < n; i++ {
+		if v, err := db.Get([]byte(fmt.Sprintf("old_%d", i))); err != nil {
+			t.Fatal(err)
+		} else if string(v) != fmt.Sprintf("v_%d", i) {
+			t.Fatal(i, string(v))
+		}
+	}
+
+	//	a dump of this version is read back with its last id
+	db.Set([]byte("a"), []byte("1"))
+	lastID := l.BinLogLastID()
+
+	buf.Reset()
+	if err := l.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var m MasterInfo
+	if err := m.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	} else if m.LastID != lastID || m.LastID == 0 {
+		t.Fatal(m.LastID, lastID)
+	}
+}
@@ -0,0 +1,443 @@
+package ledis
+
+import (
+	"errors"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/ledisdb/store"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Lazy free deletes a huge hash, list, set or zset without blocking the writers of its
+data type for the whole deletion:
+
+	unlink : the meta key is deleted and a trash record is put in one commit,
+	         the key is invisible at once
+	free   : the worker deletes the sub-keys left in batches of lazyFreeBatch,
+	         the trash record is deleted with the last batch
+
+	trash record : index | TrashType | dataType | key -> number of sub-keys when unlinked
+
+Until the trash record is deleted, the reads of key see nothing, and the first write to key
+frees the sub-keys left in batches of lazyFreeBatch, committed one by one, before going on.
+The trash records are loaded at startup, so the freeing is resumed after restart.
+*/
+
+const (
+	//	max sub-keys deleted in one commit by the lazy free worker
+	lazyFreeBatch = 1024
+)
+
+var errTrashKey = errors.New("invalid trash key")
+
+type LazyFreeStats struct {
+	//	unlinked keys whose sub-keys are not all freed yet
+	PendingKeys int64
+
+	//	keys and sub-keys freed since startup
+	FreedKeys    int64
+	FreedSubKeys int64
+}
+
+type lazyFree struct {
+	sync.Mutex
+
+	//	trash keys which may be pending, a trash record may be freed by others already,
+	//	so it must be checked in store
+	pending map[string]struct{}
+
+	wake chan struct{}
+
+	freedKeys    int64
+	freedSubKeys int64
+}
+
+func newLazyFree() *lazyFree {
+	lf := new(lazyFree)
+
+	lf.pending = make(map[string]struct{})
+	lf.wake = make(chan struct{}, 1)
+
+	return lf
+}
+
+func (lf *lazyFree) add(tk []byte) {
+	lf.Lock()
+	lf.pending[string(tk)] = struct{}{}
+	lf.Unlock()
+}
+
+func (lf *lazyFree) remove(tk []byte) {
+	lf.Lock()
+	delete(lf.pending, String(tk))
+	lf.Unlock()
+}
+
+func (lf *lazyFree) has(tk []byte) bool {
+	lf.Lock()
+	_, ok := lf.pending[String(tk)]
+	lf.Unlock()
+	return ok
+}
+
+func (lf *lazyFree) keys() [][]byte {
+	lf.Lock()
+	tks := make([][]byte, 0, len(lf.pending))
+	for tk := range lf.pending {
+		tks = append(tks, []byte(tk))
+	}
+	lf.Unlock()
+	return tks
+}
+
+//	wake up the worker without waiting for it
+func (lf *lazyFree) signal() {
+	select {
+	case lf.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (lf *lazyFree) freed(subKeys int64, done bool) {
+	atomic.AddInt64(&lf.freedSubKeys, subKeys)
+	if done {
+		atomic.AddInt64(&lf.freedKeys, 1)
+	}
+}
+
+//	track the trash records put without a tx, by replication and loading dump
+func (lf *lazyFree) observe(ek []byte) {
+	if len(ek) > 3 && ek[1] == TrashType {
+		lf.add(ek)
+		lf.signal()
+	}
+}
+
+func (l *Ledis) LazyFreeStats() LazyFreeStats {
+	l.lazy.Lock()
+	n := len(l.lazy.pending)
+	l.lazy.Unlock()
+
+	return LazyFreeStats{
+		PendingKeys:  int64(n),
+		FreedKeys:    atomic.LoadInt64(&l.lazy.freedKeys),
+		FreedSubKeys: atomic.LoadInt64(&l.lazy.freedSubKeys),
+	}
+}
+
+func (db *DB) trashEncodeKey(dataType byte, key []byte) []byte {
+	buf := make([]byte, len(key)+3)
+
+	buf[0] = db.index
+	buf[1] = TrashType
+	buf[2] = dataType
+
+	copy(buf[3:], key)
+
+	return buf
+}
+
+func (db *DB) trashDecodeKey(tk []byte) (byte, []byte, error) {
+	if len(tk) <= 3 || tk[0] != db.index || tk[1] != TrashType {
+		return 0, nil, errTrashKey
+	}
+
+	return tk[2], tk[3:], nil
+}
+
+var lazyClearCommand = map[byte]uint8{
+	HashType: BinLogCommandHClear,
+	ListType: BinLogCommandLClear,
+	SetType:  BinLogCommandSClear,
+	ZSetType: BinLogCommandZClear,
+}
+
+//	whether dataType can be freed lazily
+func lazyType(dataType byte) bool {
+	switch dataType {
+	case HashType, ListType, SetType, ZSetType:
+		return true
+	default:
+		return false
+	}
+}
+
+func (db *DB) lazySize(dataType byte, key []byte) (int64, error) {
+	switch dataType {
+	case HashType:
+		return db.HLen(key)
+	case ListType:
+		return db.LLen(key)
+	case SetType:
+		return db.SCard(key)
+	case ZSetType:
+		return db.ZCard(key)
+	default:
+		return 0, errDataType
+	}
+}
+
+//	all the sub-keys of key besides the meta
+func (db *DB) lazyRanges(dataType byte, key []byte) []store.Range {
+	switch dataType {
+	case HashType:
+		return []store.Range{
+			{Min: db.hEncodeStartKey(key), Max: db.hEncodeStopKey(key), Type: store.RangeROpen}}
+	case ListType:
+		return []store.Range{
+			{Min: db.lEncodeListKey(key, listMinSeq), Max: db.lEncodeListKey(key, listMaxSeq), Type: store.RangeClose}}
+	case SetType:
+		return []store.Range{
+			{Min: db.sEncodeStartKey(key), Max: db.sEncodeStopKey(key), Type: store.RangeROpen}}
+	case ZSetType:
+		return []store.Range{
+			{Min: db.zEncodeStartSetKey(key), Max: db.zEncodeStopSetKey(key), Type: store.RangeROpen},
+			{Min: db.zEncodeStartScoreKey(key, MinScore), Max: db.zEncodeStopScoreKey(key, MaxScore), Type: store.RangeClose}}
+	default:
+		return nil
+	}
+}
+
+//	delete key in dataType with del, or unlink it if it has more sub-keys than the lazy free threshold,
+//	key must be locked. Returns the number of sub-keys deleted or unlinked.
+func (db *DB) lazyDelete(t *tx, dataType byte, key []byte, del retireCallback) int64 {
+	if db.trashed(dataType, key) {
+		return 0
+	}
+
+	start := len(t.batch)
+	defer t.logCommand(start, lazyClearCommand[dataType], []byte{db.index}, key)
+
+	threshold := int64(db.l.cfg.LazyFreeThreshold)
+	if db.multi != nil || threshold <= 0 {
+		//	the Multi overlay is committed at once, no need to free it lazily
+		return del(t, key)
+	}
+
+	size, err := db.lazySize(dataType, key)
+	if err != nil || size <= threshold {
+		return del(t, key)
+	}
+
+	var metaType byte
+	switch dataType {
+	case HashType:
+		metaType = HSizeType
+	case ListType:
+		metaType = LMetaType
+	case SetType:
+		metaType = SSizeType
+	case ZSetType:
+		metaType = ZSizeType
+	}
+
+	mk, _ := db.encodeMetaKey(metaType, key)
+	t.Delete(mk)
+	db.releaseKeyType(t, dataType, key)
+
+	tk := db.trashEncodeKey(dataType, key)
+	t.Put(tk, PutInt64(size))
+
+	//	the worker takes the same key lock, so it can not see the record before the commit
+	db.l.lazy.add(tk)
+	db.l.lazy.signal()
+
+	return size
+}
+
+//	the retire callback of the eliminator freeing the expired key lazily
+func (db *DB) lazyRetire(dataType byte, del retireCallback) retireCallback {
+	return func(t *tx, key []byte) int64 {
+		return db.lazyDelete(t, dataType, key, del)
+	}
+}
+
+//	whether key in dataType is unlinked but not freed yet, all reads see nothing for it
+func (db *DB) trashed(dataType byte, key []byte) bool {
+	tk := db.trashEncodeKey(dataType, key)
+	if !db.l.lazy.has(tk) {
+		return false
+	}
+
+	v, err := db.db.Get(tk)
+	return err == nil && v != nil
+}
+
+//	free all the sub-keys left of an unlinked key before writing it, key must be locked.
+//	The sub-keys are deleted and committed batch by batch like the worker does, so no
+//	commit holds the whole key, the tx is committed if anything is freed.
+func (db *DB) reclaimTrash(t *tx, dataType byte, key []byte) error {
+	if !db.trashed(dataType, key) {
+		return nil
+	}
+
+	limit := lazyFreeBatch
+	if db.multi != nil {
+		//	the Multi overlay is committed at once, no need to free it in batches
+		limit = -1
+	}
+
+	var n int64
+	for done := false; !done; {
+		var freed int64
+		freed, done = db.freeTrash(t, dataType, key, limit)
+		if err := t.Commit(); err != nil {
+			return err
+		}
+		n += freed
+	}
+
+	if db.multi == nil {
+		db.l.lazy.remove(db.trashEncodeKey(dataType, key))
+		db.l.lazy.freed(n, true)
+	}
+	return nil
+}
+
+//	free all the unlinked data types of key before writing it, key must be locked
+func (db *DB) reclaimKey(t *tx, key []byte) error {
+	for _, dataType := range []byte{HashType, ListType, SetType, ZSetType} {
+		if err := db.reclaimTrash(t, dataType, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	delete at most limit sub-keys of an unlinked key, no limit if limit < 0,
+//	done is true if all are deleted and the trash record is deleted too.
+func (db *DB) freeTrash(t *tx, dataType byte, key []byte, limit int) (n int64, done bool) {
+	for _, r := range db.lazyRanges(dataType, key) {
+		count := -1
+		if limit >= 0 {
+			if count = limit - int(n); count <= 0 {
+				return n, false
+			}
+		}
+
+		it := db.db.RangeLimitIterator(r.Min, r.Max, r.Type, 0, count)
+		for ; it.Valid(); it.Next() {
+			t.Delete(it.Key())
+			n++
+		}
+		it.Close()
+	}
+
+	if limit >= 0 && n >= int64(limit) {
+		return n, false
+	}
+
+	t.Delete(db.trashEncodeKey(dataType, key))
+	return n, true
+}
+
+//	delete all the trash records, their sub-keys are flushed with their data types
+func (db *DB) trashFlush() (drop int64, err error) {
+	minKey := []byte{db.index, TrashType}
+	maxKey := []byte{db.index, TrashType + 1}
+
+	t := db.lockAllTx()
+	defer t.Unlock()
+
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{TrashType})
+
+	if drop, err = db.flushRegion(t, minKey, maxKey); err != nil {
+		return
+	}
+
+	err = t.Commit()
+	return
+}
+
+//	load the trash records left by the last run
+func (l *Ledis) loadTrash() {
+	for _, db := range l.dbs {
+		it := db.db.RangeIterator([]byte{db.index, TrashType}, []byte{db.index, TrashType + 1}, store.RangeROpen)
+		for ; it.Valid(); it.Next() {
+			l.lazy.add(it.Key())
+		}
+		it.Close()
+	}
+}
+
+func (l *Ledis) lazyFreeCycle() {
+	l.jobs.Add(1)
+	go func() {
+		tick := time.NewTicker(1 * time.Second)
+		end := false
+		for !end {
+			select {
+			case <-tick.C:
+				l.lazyFreeAll()
+			case <-l.lazy.wake:
+				l.lazyFreeAll()
+			case <-l.quit:
+				end = true
+			}
+		}
+
+		tick.Stop()
+		l.jobs.Done()
+	}()
+}
+
+//	free all the pending keys batch by batch, so the key lock is held shortly
+func (l *Ledis) lazyFreeAll() {
+	for _, tk := range l.lazy.keys() {
+		for done := false; !done; {
+			select {
+			case <-l.quit:
+				return
+			default:
+			}
+
+			done = l.lazyFreeBatch(tk)
+		}
+	}
+}
+
+func (l *Ledis) lazyFreeBatch(tk []byte) bool {
+	if len(tk) == 0 || tk[0] >= MaxDBNumber {
+		l.lazy.remove(tk)
+		return true
+	}
+
+	db := l.dbs[tk[0]]
+
+	dataType, key, err := db.trashDecodeKey(tk)
+	if err != nil {
+		l.lazy.remove(tk)
+		return true
+	}
+
+	if !lazyType(dataType) {
+		l.lazy.remove(tk)
+		return true
+	}
+
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	if v, err := db.db.Get(tk); err != nil {
+		log.Error("get trash key error %s", err.Error())
+		return true
+	} else if v == nil {
+		//	freed by a write or flush already
+		l.lazy.remove(tk)
+		return true
+	}
+
+	n, done := db.freeTrash(t, dataType, key, lazyFreeBatch)
+	if err := t.Commit(); err != nil {
+		log.Error("lazy free commit error %s", err.Error())
+		return true
+	}
+
+	l.lazy.freed(n, done)
+	if done {
+		l.lazy.remove(tk)
+	}
+	return done
+}
@@ -0,0 +1,252 @@
+package ledis
+
+import (
+	"fmt"
+	"github.com/siddontang/ledisdb/config"
+	"github.com/siddontang/ledisdb/store"
+	"os"
+	"testing"
+	"time"
+)
+
+func openLazyFreeLedis(t *testing.T, clean bool) *Ledis {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_ledis_lazyfree"
+	cfg.LazyFreeThreshold = 4
+
+	if clean {
+		os.RemoveAll(cfg.DataDir)
+	}
+
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func waitLazyFree(t *testing.T, l *Ledis) LazyFreeStats {
+	for i := 0; i < 200; i++ {
+		if s := l.LazyFreeStats(); s.PendingKeys == 0 {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("lazy free timeout")
+	return LazyFreeStats{}
+}
+
+func checkNoSubKeys(t *testing.T, db *DB, dataType byte, key []byte) {
+	for _, r := range db.lazyRanges(dataType, key) {
+		it := db.db.RangeLimitIterator(r.Min, r.Max, r.Type, 0, -1)
+		valid := it.Valid()
+		it.Close()
+
+		if valid {
+			t.Fatalf("%s sub-keys left", TypeName[dataType])
+		}
+	}
+
+	if v, _ := db.db.Get(db.trashEncodeKey(dataType, key)); v != nil {
+		t.Fatalf("%s trash record left", TypeName[dataType])
+	}
+}
+
+func TestLazyFree(t *testing.T) {
+	l := openLazyFreeLedis(t, true)
+	defer l.Close()
+
+	db, _ := l.Select(0)
+
+	key := []byte("lazy")
+	for i := 0; i < 10; i++ {
+		m := []byte(fmt.Sprintf("m%d", i))
+		db.HSet(key, m, m)
+		db.RPush(key, m)
+		db.SAdd(key, m)
+		db.ZAdd(key, ScorePair{int64(i), m})
+	}
+
+	if n, err := db.HClear(key); err != nil {
+		t.Fatal(err)
+	} else if n != 10 {
+		t.Fatal(n)
+	}
+
+	if n, err := db.LClear(key); err != nil {
+		t.Fatal(err)
+	} else if n != 10 {
+		t.Fatal(n)
+	}
+
+	if n, err := db.SClear(key); err != nil {
+		t.Fatal(err)
+	} else if n != 10 {
+		t.Fatal(n)
+	}
+
+	if n, err := db.ZClear(key); err != nil {
+		t.Fatal(err)
+	} else if n != 10 {
+		t.Fatal(n)
+	}
+
+	//	invisible at once, whether freed or not
+	if v, _ := db.HGet(key, []byte("m1")); v != nil {
+		t.Fatal(string(v))
+	} else if v, _ := db.HGetAll(key); len(v) != 0 {
+		t.Fatal(len(v))
+	} else if v, _ := db.LIndex(key, 0); v != nil {
+		t.Fatal(string(v))
+	} else if n, _ := db.SIsMember(key, []byte("m1")); n != 0 {
+		t.Fatal(n)
+	} else if v, _ := db.ZRangeByScore(key, MinScore, MaxScore, 0, -1); len(v) != 0 {
+		t.Fatal(len(v))
+	} else if _, err := db.ZScore(key, []byte("m1")); err != ErrScoreMiss {
+		t.Fatal(err)
+	}
+
+	//	writes see a new key
+	if _, err := db.HSet(key, []byte("m1"), []byte("new")); err != nil {
+		t.Fatal(err)
+	} else if v, _ := db.HGetAll(key); len(v) != 1 || string(v[0].Value) != "new" {
+		t.Fatal(v)
+	}
+
+	if n, err := db.LPush(key, []byte("new")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	} else if v, _ := db.LRange(key, 0, -1); len(v) != 1 {
+		t.Fatal(len(v))
+	}
+
+	s := waitLazyFree(t, l)
+	if s.FreedKeys != 4 || s.FreedSubKeys != 50 {
+		t.Fatal(s)
+	}
+
+	checkNoSubKeys(t, db, SetType, key)
+	checkNoSubKeys(t, db, ZSetType, key)
+
+	if n, _ := db.HLen(key); n != 1 {
+		t.Fatal(n)
+	} else if n, _ := db.LLen(key); n != 1 {
+		t.Fatal(n)
+	}
+
+	//	small keys are deleted inline
+	db.SAdd(key, []byte("a"))
+	if n, _ := db.SClear(key); n != 1 {
+		t.Fatal(n)
+	} else if v, _ := db.db.Get(db.trashEncodeKey(SetType, key)); v != nil {
+		t.Fatal("small key unlinked")
+	}
+}
+
+func TestLazyFreeExpire(t *testing.T) {
+	l := openLazyFreeLedis(t, true)
+	defer l.Close()
+
+	db, _ := l.Select(0)
+
+	key := []byte("lazy_expire")
+	for i := 0; i < 10; i++ {
+		db.SAdd(key, []byte(fmt.Sprintf("m%d", i)))
+	}
+
+	if _, err := db.SExpire(key, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 300; i++ {
+		if n, _ := db.SCard(key); n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n, _ := db.SCard(key); n != 0 {
+		t.Fatal(n)
+	}
+
+	waitLazyFree(t, l)
+	checkNoSubKeys(t, db, SetType, key)
+}
+
+func TestLazyFreeResume(t *testing.T) {
+	l := openLazyFreeLedis(t, true)
+	db, _ := l.Select(0)
+
+	key := []byte("lazy_resume")
+	for i := 0; i < 10; i++ {
+		db.ZAdd(key, ScorePair{int64(i), []byte(fmt.Sprintf("m%d", i))})
+	}
+
+	mk := db.zEncodeSizeKey(key)
+	tk := db.trashEncodeKey(ZSetType, key)
+	l.Close()
+
+	//	unlinked by the last run, but not freed
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_ledis_lazyfree"
+	s, err := store.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Delete(mk)
+	s.Put(tk, PutInt64(10))
+	s.Close()
+
+	l = openLazyFreeLedis(t, false)
+	defer l.Close()
+
+	db, _ = l.Select(0)
+
+	if st := waitLazyFree(t, l); st.FreedKeys != 1 || st.FreedSubKeys != 20 {
+		t.Fatal(st)
+	}
+	checkNoSubKeys(t, db, ZSetType, key)
+}
+
+func TestLazyFreeWriteAfterUnlink(t *testing.T) {
+	l := openLazyFreeLedis(t, true)
+	defer l.Close()
+
+	db, _ := l.Select(0)
+
+	key := []byte("lazy_write")
+	size := 3*lazyFreeBatch + 10
+	for i := 0; i < size; i++ {
+		m := []byte(fmt.Sprintf("m%d", i))
+		db.HSet(key, m, m)
+	}
+
+	if n, err := db.HClear(key); err != nil {
+		t.Fatal(err)
+	} else if n != int64(size) {
+		t.Fatal(n)
+	}
+
+	//	the write frees the sub-keys left itself, whether the worker started or not
+	if _, err := db.HSet(key, []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := db.HLen(key); n != 1 {
+		t.Fatal(n)
+	} else if v, _ := db.HGet(key, []byte("m0")); v != nil {
+		t.Fatal(string(v))
+	} else if v, _ := db.db.Get(db.trashEncodeKey(HashType, key)); v != nil {
+		t.Fatal("trash record left")
+	}
+
+	if st := waitLazyFree(t, l); st.FreedKeys != 1 || st.FreedSubKeys != int64(size) {
+		t.Fatal(st)
+	}
+
+	if fvs, _ := db.HGetAll(key); len(fvs) != 1 {
+		t.Fatal(len(fvs))
+	}
+}
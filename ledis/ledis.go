@@ -16,12 +16,11 @@ type DB struct {
 
 	index uint8
 
-	kvTx   *tx
-	listTx *tx
-	hashTx *tx
-	zsetTx *tx
-	binTx  *tx
-	setTx  *tx
+	//	not nil if db is selected from a Multi
+	multi *Multi
+
+	//	not nil if db is selected from a Session
+	session *Session
 }
 
 type Ledis struct {
@@ -34,6 +33,35 @@ type Ledis struct {
 
 	binlog *BinLog
 
+	versions *keyVersions
+
+	notifier *notifier
+
+	lazy *lazyFree
+
+	locks *keyLocks
+
+	commits *commitQueue
+
+	//	the binlog position committed with the data, and the id of the last batch
+	//	committed whole, the events after them are not sent to slaves
+	commitIndex int64
+	commitPos   int64
+	commitID    uint64
+
+	//	closed when a batch is committed whole, see BinLogWait
+	commitWait chan struct{}
+
+	//	log the local writes with the last id replicated
+	slave bool
+
+	//	the time the last event replicated was logged by the master
+	replicateTime uint32
+
+	//	held while the batches are replayed by the replication or restore, which take
+	//	the Ledis mutex for each commit only, so a dump or load never sees a part of them
+	replay sync.Mutex
+
 	quit chan struct{}
 	jobs *sync.WaitGroup
 }
@@ -51,17 +79,33 @@ func Open(cfg *config.Config) (*Ledis, error) {
 
 	l := new(Ledis)
 
+	l.cfg = cfg
+
 	l.quit = make(chan struct{})
 	l.jobs = new(sync.WaitGroup)
 
 	l.ldb = ldb
 
+	l.versions = new(keyVersions)
+
+	l.notifier = newNotifier()
+
+	l.lazy = newLazyFree()
+
+	l.locks = new(keyLocks)
+	l.commits = new(commitQueue)
+	l.commitWait = make(chan struct{})
+
 	if cfg.BinLog.MaxFileNum > 0 && cfg.BinLog.MaxFileSize > 0 {
 		println("binlog will be refactored later, use your own risk!!!")
 		l.binlog, err = NewBinLog(cfg)
 		if err != nil {
 			return nil, err
 		}
+
+		if err = l.recoverBinLog(); err != nil {
+			return nil, err
+		}
 	} else {
 		l.binlog = nil
 	}
@@ -70,7 +114,11 @@ func Open(cfg *config.Config) (*Ledis, error) {
 		l.dbs[i] = newDB(l, i)
 	}
 
+	l.loadTrash()
+
 	l.activeExpireCycle()
+	l.lazyFreeCycle()
+	l.binLogPurgeCycle()
 
 	return l, nil
 }
@@ -84,16 +132,38 @@ func newDB(l *Ledis, index uint8) *DB {
 
 	d.index = index
 
-	d.kvTx = newTx(l)
-	d.listTx = newTx(l)
-	d.hashTx = newTx(l)
-	d.zsetTx = newTx(l)
-	d.binTx = newTx(l)
-	d.setTx = newTx(l)
+	return d
+}
+
+func newMultiDB(m *Multi, index uint8) *DB {
+	d := new(DB)
+
+	d.l = m.l
+
+	d.db = m.o.DB()
+
+	d.index = index
+
+	d.multi = m
 
 	return d
 }
 
+func (db *DB) Index() int {
+	return int(db.index)
+}
+
+//	select the DB of index from the same Multi or Session if db is selected from one, or the same Ledis
+func (db *DB) selectDB(index int) (*DB, error) {
+	if db.multi != nil {
+		return db.multi.Select(index)
+	} else if db.session != nil {
+		return db.session.Select(index)
+	}
+
+	return db.l.Select(index)
+}
+
 func (l *Ledis) Close() {
 	close(l.quit)
 	l.jobs.Wait()
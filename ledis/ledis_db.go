@@ -11,7 +11,10 @@ func (db *DB) FlushAll() (drop int64, err error) {
 		db.hFlush,
 		db.zFlush,
 		db.bFlush,
-		db.sFlush}
+		db.sFlush,
+		db.pfFlush,
+		db.ktFlush,
+		db.trashFlush}
 
 	for _, flush := range all {
 		if n, e := flush(); e != nil {
@@ -25,13 +28,41 @@ func (db *DB) FlushAll() (drop int64, err error) {
 	return
 }
 
+//	flush all the keys of dataType, or the key type index or the trash records
+func (db *DB) flushType(dataType byte) (int64, error) {
+	switch dataType {
+	case KVType:
+		return db.flush()
+	case ListType:
+		return db.lFlush()
+	case HashType:
+		return db.hFlush()
+	case ZSetType:
+		return db.zFlush()
+	case BitType:
+		return db.bFlush()
+	case SetType:
+		return db.sFlush()
+	case HLLType:
+		return db.pfFlush()
+	case KeyTypeType:
+		return db.ktFlush()
+	case TrashType:
+		return db.trashFlush()
+	default:
+		return 0, errDataType
+	}
+}
+
 func (db *DB) newEliminator() *elimination {
 	eliminator := newEliminator(db)
-	eliminator.regRetireContext(KVType, db.kvTx, db.delete)
-	eliminator.regRetireContext(ListType, db.listTx, db.lDelete)
-	eliminator.regRetireContext(HashType, db.hashTx, db.hDelete)
-	eliminator.regRetireContext(ZSetType, db.zsetTx, db.zDelete)
-	eliminator.regRetireContext(BitType, db.binTx, db.bDelete)
+	eliminator.regRetireContext(KVType, db.delete)
+	eliminator.regRetireContext(ListType, db.lazyRetire(ListType, db.lDelete))
+	eliminator.regRetireContext(HashType, db.lazyRetire(HashType, db.hDelete))
+	eliminator.regRetireContext(ZSetType, db.lazyRetire(ZSetType, db.zDelete))
+	eliminator.regRetireContext(SetType, db.lazyRetire(SetType, db.sDelete))
+	eliminator.regRetireContext(BitType, db.bDelete)
+	eliminator.regRetireContext(HLLType, db.pfDelete)
 
 	return eliminator
 }
@@ -0,0 +1,125 @@
+package ledis
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+The writers lock the keys they write instead of the whole data type, so writes to
+different keys run concurrently:
+
+	key locks : a key is hashed with its db index to one of keyLockSlotNum stripes,
+	            keys of all data types in the same stripe share one mutex
+	all lock  : held shared by every key writer, held exclusively by the writers of
+	            whole dbs, like Multi and flush, so they exclude all the key writers
+
+A writer locking more than one key locks their stripes in order, so no deadlock.
+The commits of the writers are coalesced by the group commit, see commit.go.
+*/
+
+const keyLockSlotNum = 1 << 10
+
+type keyLocks struct {
+	all sync.RWMutex
+
+	slots [keyLockSlotNum]sync.Mutex
+}
+
+func keyLockSlot(index uint8, key []byte) uint32 {
+	return keyVersionSlot(index, key) & (keyLockSlotNum - 1)
+}
+
+type uint32Slice []uint32
+
+func (s uint32Slice) Len() int           { return len(s) }
+func (s uint32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+//	add key of db to the keys locked by Lock
+func (t *tx) addKey(db *DB, key []byte) {
+	t.slots = append(t.slots, keyLockSlot(db.index, key))
+}
+
+func (t *tx) Lock() {
+	if t.locks == nil {
+		//	Multi tx, all locked by the Multi
+		return
+	}
+
+	if t.exclusive {
+		t.locks.all.Lock()
+		return
+	}
+
+	t.locks.all.RLock()
+
+	sort.Sort(uint32Slice(t.slots))
+
+	n := 0
+	for i, s := range t.slots {
+		if i > 0 && s == t.slots[n-1] {
+			continue
+		}
+		t.slots[n] = s
+		n++
+	}
+	t.slots = t.slots[0:n]
+
+	for _, s := range t.slots {
+		t.locks.slots[s].Lock()
+	}
+}
+
+func (t *tx) Unlock() {
+	t.Rollback()
+	t.command = nil
+	t.commandLogged = false
+
+	if t.locks == nil {
+		return
+	}
+
+	if t.exclusive {
+		t.locks.all.Unlock()
+		return
+	}
+
+	for i := len(t.slots) - 1; i >= 0; i-- {
+		t.locks.slots[t.slots[i]].Unlock()
+	}
+	t.slots = t.slots[0:0]
+
+	t.locks.all.RUnlock()
+}
+
+//	a tx to write db, the writes of a DB selected from a Multi go to its overlay
+func (db *DB) newTx() *tx {
+	if db.multi != nil {
+		return newMultiTx(db.l, db.db)
+	}
+
+	t := newTx(db.l)
+	t.session = db.session
+	return t
+}
+
+//	lock keys of db for writing, the returned tx must be unlocked after commit
+func (db *DB) lockTx(keys ...[]byte) *tx {
+	t := db.newTx()
+	for _, key := range keys {
+		t.addKey(db, key)
+	}
+
+	t.Lock()
+	return t
+}
+
+//	lock all the keys of all dbs for writing whole dbs
+func (db *DB) lockAllTx() *tx {
+	t := db.newTx()
+	t.exclusive = true
+
+	t.Lock()
+	return t
+}
@@ -0,0 +1,104 @@
+package ledis
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyLock(t *testing.T) {
+	db := getTestDB()
+
+	key1 := []byte("lock_key_1")
+	key2 := []byte("lock_key_2")
+	if keyLockSlot(db.index, key1) == keyLockSlot(db.index, key2) {
+		t.Skip("keys in the same stripe")
+	}
+
+	//	a writer holding key1 does not block the writers of key2
+	t1 := db.lockTx(key1)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.HSet(key2, []byte("a"), []byte("1"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write to another key blocked")
+	}
+
+	//	but blocks the writers of key1
+	go func() {
+		_, err := db.HSet(key1, []byte("a"), []byte("1"))
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("write to a locked key not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	t1.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	//	Multi blocks all the key writers
+	m := testLedis.Multi()
+	go func() {
+		_, err := db.HSet(key2, []byte("b"), []byte("2"))
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("write not blocked by multi")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.Rollback()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	db.HClear(key1)
+	db.HClear(key2)
+}
+
+func benchmarkHSetParallel(b *testing.B, sameKey bool) {
+	db := getTestDB()
+
+	var id int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		key := []byte("bench_hset")
+		if !sameKey {
+			key = []byte(fmt.Sprintf("bench_hset_%d", atomic.AddInt64(&id, 1)))
+		}
+
+		for i := 0; pb.Next(); i++ {
+			f := []byte(fmt.Sprintf("f%d", i&1023))
+			if _, err := db.HSet(key, f, f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+//	the writers of different keys run concurrently
+func BenchmarkHSetParallelKeys(b *testing.B) {
+	benchmarkHSetParallel(b, false)
+}
+
+//	the writers of one key are serialized, like the old per-type locking
+func BenchmarkHSetParallelSameKey(b *testing.B) {
+	benchmarkHSetParallel(b, true)
+}
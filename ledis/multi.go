@@ -0,0 +1,161 @@
+package ledis
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/siddontang/ledisdb/store"
+	"sync/atomic"
+)
+
+var errMultiClosed = errors.New("multi is committed or rolled back")
+
+//	keys are hashed to the version slots, a key may be taken as
+//	modified when another key in the same slot is modified
+const keyVersionSlotNum = 1 << 16
+
+type keyVersions [keyVersionSlotNum]uint64
+
+//	fnv-1a of the db index and the key
+func keyVersionSlot(index uint8, key []byte) uint32 {
+	h := uint32(2166136261)
+
+	h = (h ^ uint32(index)) * 16777619
+	for _, c := range key {
+		h = (h ^ uint32(c)) * 16777619
+	}
+
+	return h & (keyVersionSlotNum - 1)
+}
+
+//	the version slot of the user key of an encoded key, false if ek does not belong to a user key
+func dataKeySlot(ek []byte) (uint32, bool) {
+	if len(ek) < 2 {
+		return 0, false
+	}
+
+	var key []byte
+
+	switch ek[1] {
+	case KVType, HSizeType, LMetaType, ZSizeType, BitMetaType, SSizeType, HLLType, KeyTypeType:
+		key = ek[2:]
+	case HashType, ListType, ZSetType, ZScoreType, BitType, SetType:
+		if len(ek) < 4 {
+			return 0, false
+		}
+
+		n := int(binary.BigEndian.Uint16(ek[2:]))
+		if len(ek) < 4+n {
+			return 0, false
+		}
+		key = ek[4 : 4+n]
+	case ExpMetaType, TrashType:
+		if len(ek) < 3 {
+			return 0, false
+		}
+		key = ek[3:]
+	case ExpTimeType:
+		if len(ek) < 11 {
+			return 0, false
+		}
+		key = ek[11:]
+	default:
+		return 0, false
+	}
+
+	return keyVersionSlot(ek[0], key), true
+}
+
+func (l *Ledis) touchKey(ek []byte) {
+	if slot, ok := dataKeySlot(ek); ok {
+		atomic.AddUint64(&l.versions[slot], 1)
+	}
+}
+
+//	KeyVersion returns the modification counter of key, it is increased every time key is written,
+//	so a changed version means key may be modified, used to implement the check-and-set of WATCH.
+func (db *DB) KeyVersion(key []byte) uint64 {
+	return atomic.LoadUint64(&db.l.versions[keyVersionSlot(db.index, key)])
+}
+
+//	Multi is a transaction across all the DBs and data types.
+//
+//	The writes through the DBs selected from a Multi are buffered and only visible to them,
+//	they are written in one write batch and one binlog group by Commit.
+//	All the other writers are blocked until Commit or Rollback.
+type Multi struct {
+	l *Ledis
+
+	o *store.Overlay
+
+	dbs [MaxDBNumber]*DB
+
+	unlock func()
+
+	//	the session the Multi is begun from, nil if none
+	session *Session
+
+	//	key events notified after commit
+	events []*KeyEvent
+}
+
+func (l *Ledis) Multi() *Multi {
+	m := new(Multi)
+
+	m.l = l
+	l.locks.all.Lock()
+	m.unlock = l.locks.all.Unlock
+	m.o = store.NewOverlay(l.ldb)
+
+	return m
+}
+
+func (m *Multi) Select(index int) (*DB, error) {
+	if index < 0 || index >= int(MaxDBNumber) {
+		return nil, fmt.Errorf("invalid db index %d", index)
+	}
+
+	if m.dbs[index] == nil {
+		m.dbs[index] = newMultiDB(m, uint8(index))
+	}
+
+	return m.dbs[index], nil
+}
+
+func (m *Multi) Commit() error {
+	if m.unlock == nil {
+		return errMultiClosed
+	}
+
+	//	all the keys are locked by the Multi, replay the buffered writes with a tx needing no lock
+	t := newTx(m.l)
+	t.locks = nil
+	t.session = m.session
+
+	m.o.WriteTo(t)
+
+	err := t.Commit()
+	t.Unlock()
+
+	events := m.events
+	m.close()
+
+	if err == nil {
+		for _, e := range events {
+			m.l.notifier.notify(e)
+		}
+	}
+	return err
+}
+
+func (m *Multi) Rollback() {
+	if m.unlock != nil {
+		m.close()
+	}
+}
+
+func (m *Multi) close() {
+	m.unlock()
+	m.unlock = nil
+	m.events = nil
+}
@@ -0,0 +1,175 @@
+package ledis
+
+import (
+	"testing"
+)
+
+func TestMulti(t *testing.T) {
+	db := getTestDB()
+	db1, _ := testLedis.Select(1)
+
+	key := []byte("multi_a")
+	hkey := []byte("multi_b")
+	lkey := []byte("multi_c")
+	mkey := []byte("multi_d")
+
+	db.Set(mkey, []byte("1"))
+
+	version := db.KeyVersion(key)
+
+	m := testLedis.Multi()
+	mdb, _ := m.Select(0)
+
+	if err := mdb.Set(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mdb.HSet(hkey, []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mdb.LPush(lkey, []byte("1"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := mdb.Move(mkey, 1); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	//	the writes are visible in the multi only
+	if v, err := mdb.Get(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	if n, err := mdb.LLen(lkey); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	mdb1, _ := m.Select(1)
+	if v, err := mdb1.Get(mkey); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	if v, err := db.Get(key); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal("must nil")
+	}
+
+	if db.KeyVersion(key) != version {
+		t.Fatal("version must not change")
+	}
+
+	if err := m.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Commit(); err == nil {
+		t.Fatal("must error")
+	}
+
+	if db.KeyVersion(key) == version {
+		t.Fatal("version must change")
+	}
+
+	if v, err := db.Get(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	if v, err := db.HGet(hkey, []byte("a")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	if n, err := db.LLen(lkey); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	if n, _ := db.Exists(mkey); n != 0 {
+		t.Fatal(n)
+	}
+
+	if v, err := db1.Get(mkey); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	db.Del(key)
+	db.HClear(hkey)
+	db.LClear(lkey)
+	db1.Del(mkey)
+}
+
+func TestMultiRollback(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("multi_rollback")
+	db.Set(key, []byte("1"))
+
+	version := db.KeyVersion(key)
+
+	m := testLedis.Multi()
+	mdb, _ := m.Select(0)
+
+	if err := mdb.Set(key, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Rollback()
+
+	if v, err := db.Get(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	if db.KeyVersion(key) != version {
+		t.Fatal("version must not change")
+	}
+
+	//	the txs are unlocked
+	if err := db.Set(key, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.KeyVersion(key) == version {
+		t.Fatal("version must change")
+	}
+
+	db.Del(key)
+}
+
+func TestDataKeySlot(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("slot_key")
+	slot := keyVersionSlot(db.index, key)
+
+	eks := [][]byte{
+		db.encodeKVKey(key),
+		db.hEncodeHashKey(key, []byte("field")),
+		db.lEncodeListKey(key, 1),
+		db.expEncodeMetaKey(KVType, key),
+		db.expEncodeTimeKey(HashType, key, 100),
+	}
+
+	for _, ek := range eks {
+		if s, ok := dataKeySlot(ek); !ok || s != slot {
+			t.Fatal(ek)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package ledis
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+//	classes of key events, like the flags of redis notify-keyspace-events
+const (
+	NotifyGeneric = 1 << iota //	g: del, expire, rename, ...
+	NotifyString              //	$: kv and hyperloglog
+	NotifyList                //	l
+	NotifySet                 //	s
+	NotifyHash                //	h
+	NotifyZSet                //	z: zset and geo
+	NotifyExpired             //	x: keys deleted by expiration
+	NotifyBit                 //	b: bitmap
+
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash | NotifyZSet | NotifyExpired | NotifyBit
+)
+
+var notifyClassFlags = []struct {
+	flag  byte
+	class int
+}{
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyList},
+	{'s', NotifySet},
+	{'h', NotifyHash},
+	{'z', NotifyZSet},
+	{'x', NotifyExpired},
+	{'b', NotifyBit},
+	{'A', NotifyAll},
+}
+
+//	ParseNotifyClasses parses the classes from flags like "g$lshzxbA"
+func ParseNotifyClasses(flags string) (int, error) {
+	classes := 0
+	for i := 0; i < len(flags); i++ {
+		found := false
+		for _, f := range notifyClassFlags {
+			if f.flag == flags[i] {
+				classes |= f.class
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("invalid notify class %q", flags[i])
+		}
+	}
+	return classes, nil
+}
+
+type KeyEvent struct {
+	DB    int
+	Class int
+	Event string
+	Key   []byte
+}
+
+type KeyEventFilter struct {
+	//	the index of db, -1 for all the dbs
+	DB int
+
+	Classes int
+
+	//	glob-style pattern of keys, nil for all the keys
+	Pattern []byte
+}
+
+func (f *KeyEventFilter) match(e *KeyEvent) bool {
+	return (f.DB < 0 || f.DB == e.DB) &&
+		f.Classes&e.Class != 0 &&
+		(f.Pattern == nil || PatternMatch(f.Pattern, e.Key))
+}
+
+//	KeyEventHandler is called synchronously after the write is committed,
+//	it must not block or write to the same Ledis, and must copy the key to keep it.
+type KeyEventHandler func(e *KeyEvent)
+
+type keyEventHandler struct {
+	filter  KeyEventFilter
+	handler KeyEventHandler
+}
+
+type notifier struct {
+	sync.RWMutex
+
+	//	union of the classes of all the handlers, checked without lock
+	classes int32
+
+	nextID   int
+	handlers map[int]*keyEventHandler
+}
+
+func newNotifier() *notifier {
+	n := new(notifier)
+
+	n.handlers = make(map[int]*keyEventHandler)
+
+	return n
+}
+
+func (n *notifier) interested(class int) bool {
+	return int(atomic.LoadInt32(&n.classes))&class != 0
+}
+
+func (n *notifier) add(filter KeyEventFilter, handler KeyEventHandler) int {
+	n.Lock()
+	defer n.Unlock()
+
+	n.nextID++
+	n.handlers[n.nextID] = &keyEventHandler{filter, handler}
+	n.resetClasses()
+
+	return n.nextID
+}
+
+func (n *notifier) remove(id int) {
+	n.Lock()
+	defer n.Unlock()
+
+	delete(n.handlers, id)
+	n.resetClasses()
+}
+
+func (n *notifier) resetClasses() {
+	classes := 0
+	for _, h := range n.handlers {
+		classes |= h.filter.Classes
+	}
+	atomic.StoreInt32(&n.classes, int32(classes))
+}
+
+func (n *notifier) notify(e *KeyEvent) {
+	n.RLock()
+	defer n.RUnlock()
+
+	for _, h := range n.handlers {
+		if h.filter.match(e) {
+			h.handler(e)
+		}
+	}
+}
+
+//	AddKeyEventHandler registers handler for the key events matching filter, returns its id
+func (l *Ledis) AddKeyEventHandler(filter KeyEventFilter, handler KeyEventHandler) int {
+	return l.notifier.add(filter, handler)
+}
+
+func (l *Ledis) RemoveKeyEventHandler(id int) {
+	l.notifier.remove(id)
+}
+
+//	notify the event of key after it is committed, the events in a Multi
+//	are notified after the Multi is committed
+func (db *DB) notify(class int, event string, key []byte) {
+	if !db.l.notifier.interested(class) {
+		return
+	}
+
+	e := &KeyEvent{int(db.index), class, event, key}
+	if db.multi != nil {
+		e.Key = append([]byte{}, key...)
+		db.multi.events = append(db.multi.events, e)
+		return
+	}
+
+	db.l.notifier.notify(e)
+}
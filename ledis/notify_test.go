@@ -0,0 +1,143 @@
+package ledis
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testKeyEvents struct {
+	sync.Mutex
+	events []KeyEvent
+}
+
+func (r *testKeyEvents) handle(e *KeyEvent) {
+	r.Lock()
+	r.events = append(r.events, KeyEvent{e.DB, e.Class, e.Event, append([]byte{}, e.Key...)})
+	r.Unlock()
+}
+
+func (r *testKeyEvents) check(t *testing.T, expect ...string) {
+	r.Lock()
+	defer r.Unlock()
+
+	events := make([]string, len(r.events))
+	for i, e := range r.events {
+		events[i] = e.Event + " " + string(e.Key)
+	}
+	r.events = nil
+
+	if len(expect) == 0 {
+		expect = []string{}
+	}
+
+	if !reflect.DeepEqual(events, expect) {
+		t.Fatal(events, expect)
+	}
+}
+
+func TestParseNotifyClasses(t *testing.T) {
+	if c, err := ParseNotifyClasses("g$x"); err != nil {
+		t.Fatal(err)
+	} else if c != NotifyGeneric|NotifyString|NotifyExpired {
+		t.Fatal(c)
+	}
+
+	if c, err := ParseNotifyClasses("A"); err != nil {
+		t.Fatal(err)
+	} else if c != NotifyAll {
+		t.Fatal(c)
+	}
+
+	if _, err := ParseNotifyClasses("gK"); err == nil {
+		t.Fatal("must error")
+	}
+}
+
+func TestKeyEvent(t *testing.T) {
+	db := getTestDB()
+
+	r := new(testKeyEvents)
+	id := testLedis.AddKeyEventHandler(KeyEventFilter{DB: 0, Classes: NotifyAll, Pattern: []byte("notify_*")}, r.handle)
+	defer testLedis.RemoveKeyEventHandler(id)
+
+	db.Set([]byte("notify_a"), []byte("1"))
+	db.Incr([]byte("notify_a"))
+	db.Set([]byte("other"), []byte("1"))
+	db.Expire([]byte("notify_a"), 100)
+	db.Persist([]byte("notify_a"))
+	db.Del([]byte("notify_a"), []byte("other"))
+	r.check(t, "set notify_a", "incrby notify_a", "expire notify_a", "persist notify_a", "del notify_a")
+
+	db.HSet([]byte("notify_h"), []byte("f"), []byte("1"))
+	db.HDel([]byte("notify_h"), []byte("nofield"))
+	db.HDel([]byte("notify_h"), []byte("f"))
+	r.check(t, "hset notify_h", "hdel notify_h")
+
+	db.LPush([]byte("notify_l"), []byte("1"))
+	db.RPush([]byte("notify_l"), []byte("2"))
+	db.LPop([]byte("notify_l"))
+	db.LClear([]byte("notify_l"))
+	r.check(t, "lpush notify_l", "rpush notify_l", "lpop notify_l", "del notify_l")
+
+	db.SAdd([]byte("notify_s"), []byte("1"))
+	db.ZAdd([]byte("notify_z"), ScorePair{1, []byte("a")})
+	db.Rename([]byte("notify_z"), []byte("notify_z2"))
+	db.ZClear([]byte("notify_z2"))
+	db.SClear([]byte("notify_s"))
+	r.check(t, "sadd notify_s", "zadd notify_z", "rename_from notify_z", "rename_to notify_z2", "del notify_z2", "del notify_s")
+
+	//	the events in a multi are notified after commit
+	m := testLedis.Multi()
+	mdb, _ := m.Select(0)
+	mdb.Set([]byte("notify_m"), []byte("1"))
+	r.check(t)
+
+	m.Commit()
+	r.check(t, "set notify_m")
+
+	m = testLedis.Multi()
+	mdb, _ = m.Select(0)
+	mdb.Del([]byte("notify_m"))
+	m.Rollback()
+	r.check(t)
+
+	db.Del([]byte("notify_m"))
+	r.check(t, "del notify_m")
+
+	//	filtered by db and class
+	db1, _ := testLedis.Select(1)
+	db1.Set([]byte("notify_a"), []byte("1"))
+	db1.Del([]byte("notify_a"))
+	r.check(t)
+
+	id2 := testLedis.AddKeyEventHandler(KeyEventFilter{DB: -1, Classes: NotifyHash}, r.handle)
+	testLedis.RemoveKeyEventHandler(id)
+
+	db.Set([]byte("notify_a"), []byte("1"))
+	db1.HSet([]byte("notify_h"), []byte("f"), []byte("1"))
+	db1.HClear([]byte("notify_h"))
+	db.Del([]byte("notify_a"))
+	r.check(t, "hset notify_h")
+
+	testLedis.RemoveKeyEventHandler(id2)
+}
+
+func TestKeyEventExpired(t *testing.T) {
+	db := getTestDB()
+
+	r := new(testKeyEvents)
+	id := testLedis.AddKeyEventHandler(KeyEventFilter{DB: -1, Classes: NotifyExpired, Pattern: []byte("notify_*")}, r.handle)
+	defer testLedis.RemoveKeyEventHandler(id)
+
+	key := []byte("notify_expired")
+	db.Set(key, []byte("1"))
+
+	tx := db.lockTx(key)
+	db.expireAt(tx, KVType, key, 1)
+	tx.Commit()
+	tx.Unlock()
+
+	db.newEliminator().active()
+	r.check(t, "expired notify_expired")
+}
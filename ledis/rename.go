@@ -0,0 +1,321 @@
+package ledis
+
+import (
+	"errors"
+	"github.com/siddontang/ledisdb/store"
+)
+
+var (
+	errNoSuchKey = errors.New("no such key")
+	errSameKey   = errors.New("source and destination objects are the same")
+)
+
+/*
+Renaming a key rewrites all of its encoded keys to the new key, and to the new db
+index for moving:
+
+	kv     : value
+	list   : meta and items with their sequences
+	hash   : size and fields
+	zset   : size, set keys and score keys
+	set    : size and members
+	bitmap : meta and segments
+	hll    : value
+	expire : meta and time keys of every data type
+
+All the changes are written in one batch of the source kv tx with all type txs
+of the involved dbs locked, so they are committed and logged to the binlog at once.
+*/
+
+//	copy all the data of key in dataType to dstKey in dst db,
+//	the source data is deleted if del is true.
+func (db *DB) rewriteKey(t *tx, dataType byte, key []byte, dst *DB, dstKey []byte, del bool) error {
+	put := func(ek []byte, nk []byte, value []byte) {
+		t.Put(nk, value)
+		if del {
+			t.Delete(ek)
+		}
+	}
+
+	rewriteRange := func(min []byte, max []byte, rangeType uint8, f func(ek []byte) ([]byte, error)) error {
+		it := db.db.RangeIterator(min, max, rangeType)
+		defer it.Close()
+
+		for ; it.Valid(); it.Next() {
+			ek := it.Key()
+			nk, err := f(ek)
+			if err != nil {
+				return err
+			}
+			put(ek, nk, it.Value())
+		}
+		return nil
+	}
+
+	var metaType byte
+	var err error
+
+	switch dataType {
+	case KVType:
+		metaType = KVType
+	case HLLType:
+		metaType = HLLType
+	case ListType:
+		metaType = LMetaType
+		err = rewriteRange(db.lEncodeListKey(key, listMinSeq), db.lEncodeListKey(key, listMaxSeq), store.RangeClose,
+			func(ek []byte) ([]byte, error) {
+				_, seq, err := db.lDecodeListKey(ek)
+				return dst.lEncodeListKey(dstKey, seq), err
+			})
+	case HashType:
+		metaType = HSizeType
+		err = rewriteRange(db.hEncodeStartKey(key), db.hEncodeStopKey(key), store.RangeROpen,
+			func(ek []byte) ([]byte, error) {
+				_, field, err := db.hDecodeHashKey(ek)
+				return dst.hEncodeHashKey(dstKey, field), err
+			})
+	case ZSetType:
+		metaType = ZSizeType
+		err = rewriteRange(db.zEncodeStartSetKey(key), db.zEncodeStopSetKey(key), store.RangeROpen,
+			func(ek []byte) ([]byte, error) {
+				_, member, err := db.zDecodeSetKey(ek)
+				return dst.zEncodeSetKey(dstKey, member), err
+			})
+		if err == nil {
+			err = rewriteRange(db.zEncodeStartScoreKey(key, MinScore), db.zEncodeStopScoreKey(key, MaxScore), store.RangeClose,
+				func(ek []byte) ([]byte, error) {
+					_, member, score, err := db.zDecodeScoreKey(ek)
+					return dst.zEncodeScoreKey(dstKey, member, score), err
+				})
+		}
+	case SetType:
+		metaType = SSizeType
+		err = rewriteRange(db.sEncodeStartKey(key), db.sEncodeStopKey(key), store.RangeROpen,
+			func(ek []byte) ([]byte, error) {
+				_, member, err := db.sDecodeSetKey(ek)
+				return dst.sEncodeSetKey(dstKey, member), err
+			})
+	case BitType:
+		metaType = BitMetaType
+		err = rewriteRange(db.bEncodeBinKey(key, minSeq), db.bEncodeBinKey(key, maxSeq), store.RangeClose,
+			func(ek []byte) ([]byte, error) {
+				_, seq, err := db.bDecodeBinKey(ek)
+				return dst.bEncodeBinKey(dstKey, seq), err
+			})
+	default:
+		return errDataType
+	}
+
+	if err != nil {
+		return err
+	}
+
+	//	meta key, the value for kv
+	mk, _ := db.encodeMetaKey(metaType, key)
+	if v, err := db.db.Get(mk); err != nil {
+		return err
+	} else if v != nil {
+		nk, _ := dst.encodeMetaKey(metaType, dstKey)
+		put(mk, nk, v)
+	}
+
+	if del {
+		db.releaseKeyType(t, dataType, key)
+	}
+	dst.claimKeyType(t, dataType, dstKey)
+
+	//	expire
+	if when, err := Int64(db.db.Get(db.expEncodeMetaKey(dataType, key))); err != nil {
+		return err
+	} else if when > 0 {
+		if del {
+			if _, err := db.rmExpire(t, dataType, key); err != nil {
+				return err
+			}
+		}
+		dst.expireAt(t, dataType, dstKey, when)
+	}
+
+	return nil
+}
+
+//	delete key in all data types with expire
+func (db *DB) deleteKey(t *tx, key []byte, types []byte) error {
+	for _, dataType := range types {
+		switch dataType {
+		case KVType:
+			db.delete(t, key)
+		case ListType:
+			db.lDelete(t, key)
+		case HashType:
+			db.hDelete(t, key)
+		case ZSetType:
+			db.zDelete(t, key)
+		case SetType:
+			db.sDelete(t, key)
+		case BitType:
+			db.bDelete(t, key)
+		case HLLType:
+			db.pfDelete(t, key)
+		}
+
+		if _, err := db.rmExpire(t, dataType, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	copy or move key to dstKey in dst db, key and dstKey must be locked in t.
+//	If dstKey exists, nothing is done unless replace is true.
+func (db *DB) renameGeneric(t *tx, key []byte, dst *DB, dstKey []byte, del bool, replace bool) (int64, error) {
+	it := db.db.NewIterator()
+	defer it.Close()
+
+	types, err := db.keyDataTypes(it, key)
+	if err != nil {
+		return 0, err
+	} else if len(types) == 0 {
+		return 0, nil
+	}
+
+	dstTypes, err := dst.keyDataTypes(it, dstKey)
+	if err != nil {
+		return 0, err
+	} else if len(dstTypes) > 0 && !replace {
+		return 0, nil
+	}
+
+	if err = dst.reclaimKey(t, dstKey); err != nil {
+		return 0, err
+	}
+
+	if err = dst.deleteKey(t, dstKey, dstTypes); err != nil {
+		return 0, err
+	}
+
+	for _, dataType := range types {
+		if err = db.rewriteKey(t, dataType, key, dst, dstKey, del); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = t.Commit(); err != nil {
+		return 0, err
+	}
+
+	if !del {
+		dst.notify(NotifyGeneric, "copy_to", dstKey)
+	} else if dst == db {
+		db.notify(NotifyGeneric, "rename_from", key)
+		dst.notify(NotifyGeneric, "rename_to", dstKey)
+	} else {
+		db.notify(NotifyGeneric, "move_from", key)
+		dst.notify(NotifyGeneric, "move_to", dstKey)
+	}
+	return 1, nil
+}
+
+//	Rename renames key to newKey, which is overwritten if it already exists.
+func (db *DB) Rename(key []byte, newKey []byte) error {
+	if err := checkKeySize(key); err != nil {
+		return err
+	} else if err := checkKeySize(newKey); err != nil {
+		return err
+	}
+
+	t := db.lockTx(key, newKey)
+	defer t.Unlock()
+
+	if String(key) == String(newKey) {
+		it := db.db.NewIterator()
+		types, err := db.keyDataTypes(it, key)
+		it.Close()
+
+		if err != nil {
+			return err
+		} else if len(types) == 0 {
+			return errNoSuchKey
+		}
+		return nil
+	}
+
+	n, err := db.renameGeneric(t, key, db, newKey, true, true)
+	if err == nil && n == 0 {
+		err = errNoSuchKey
+	}
+	return err
+}
+
+//	RenameNX renames key to newKey only if newKey does not exist.
+func (db *DB) RenameNX(key []byte, newKey []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	} else if err := checkKeySize(newKey); err != nil {
+		return 0, err
+	}
+
+	t := db.lockTx(key, newKey)
+	defer t.Unlock()
+
+	it := db.db.NewIterator()
+	types, err := db.keyDataTypes(it, key)
+	it.Close()
+
+	if err != nil {
+		return 0, err
+	} else if len(types) == 0 {
+		return 0, errNoSuchKey
+	} else if String(key) == String(newKey) {
+		return 0, nil
+	}
+
+	return db.renameGeneric(t, key, db, newKey, true, false)
+}
+
+//	Move moves key to the db at dbIndex, does nothing if key exists in the destination db.
+func (db *DB) Move(key []byte, dbIndex int) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	dst, err := db.selectDB(dbIndex)
+	if err != nil {
+		return 0, err
+	} else if dst == db {
+		return 0, errSameKey
+	}
+
+	t := db.newTx()
+	t.addKey(db, key)
+	t.addKey(dst, key)
+	t.Lock()
+	defer t.Unlock()
+
+	return db.renameGeneric(t, key, dst, key, true, false)
+}
+
+//	Copy copies key to dstKey in the db at dstIndex, if dstKey exists,
+//	it is overwritten only if replace is true.
+func (db *DB) Copy(key []byte, dstKey []byte, dstIndex int, replace bool) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	} else if err := checkKeySize(dstKey); err != nil {
+		return 0, err
+	}
+
+	dst, err := db.selectDB(dstIndex)
+	if err != nil {
+		return 0, err
+	} else if dst == db && String(key) == String(dstKey) {
+		return 0, errSameKey
+	}
+
+	t := db.newTx()
+	t.addKey(db, key)
+	t.addKey(dst, dstKey)
+	t.Lock()
+	defer t.Unlock()
+
+	return db.renameGeneric(t, key, dst, dstKey, false, replace)
+}
@@ -0,0 +1,161 @@
+package ledis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRename(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("testdb_rename_a")
+	newKey := []byte("testdb_rename_b")
+
+	if err := db.Rename(key, newKey); err != errNoSuchKey {
+		t.Fatal(err)
+	}
+
+	db.Set(key, []byte("value"))
+	db.HSet(key, []byte("f1"), []byte("v1"))
+	db.HSet(key, []byte("f2"), []byte("v2"))
+	db.RPush(key, []byte("1"), []byte("2"), []byte("3"))
+	db.ZAdd(key, ScorePair{-1, []byte("m1")}, ScorePair{2, []byte("m2")})
+	db.SAdd(key, []byte("s1"), []byte("s2"))
+	db.BSetBit(key, 1<<20, 1)
+	db.HExpire(key, 100)
+
+	db.Set(newKey, []byte("old"))
+	db.SAdd(newKey, []byte("old"))
+
+	if err := db.Rename(key, newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := db.Get(newKey); string(v) != "value" {
+		t.Fatal(string(v))
+	} else if v, _ := db.Get(key); v != nil {
+		t.Fatal(string(v))
+	}
+
+	if v, _ := db.HGet(newKey, []byte("f2")); string(v) != "v2" {
+		t.Fatal(string(v))
+	} else if n, _ := db.HLen(key); n != 0 {
+		t.Fatal(n)
+	}
+
+	if ttl, _ := db.HTTL(newKey); ttl <= 0 {
+		t.Fatal(ttl)
+	} else if ttl, _ := db.HTTL(key); ttl != -1 {
+		t.Fatal(ttl)
+	}
+
+	if v, _ := db.LRange(newKey, 0, -1); len(v) != 3 || string(v[2]) != "3" {
+		t.Fatal(v)
+	} else if n, _ := db.LLen(key); n != 0 {
+		t.Fatal(n)
+	}
+
+	if v, _ := db.ZRange(newKey, 0, -1); len(v) != 2 || string(v[0].Member) != "m1" || v[0].Score != -1 {
+		t.Fatal(v)
+	} else if s, _ := db.ZScore(newKey, []byte("m2")); s != 2 {
+		t.Fatal(s)
+	} else if n, _ := db.ZCard(key); n != 0 {
+		t.Fatal(n)
+	}
+
+	if v, _ := db.SMembers(newKey); len(v) != 2 {
+		t.Fatal(v)
+	} else if n, _ := db.SIsMember(newKey, []byte("old")); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := db.SCard(key); n != 0 {
+		t.Fatal(n)
+	}
+
+	if v, _ := db.BGetBit(newKey, 1<<20); v != 1 {
+		t.Fatal(v)
+	} else if n, _ := db.BTail(key); n != -1 {
+		t.Fatal(n)
+	}
+
+	if n, err := db.RenameNX(key, newKey); err != errNoSuchKey {
+		t.Fatal(n, err)
+	}
+
+	db.Set(key, []byte("other"))
+	if n, err := db.RenameNX(key, newKey); err != nil || n != 0 {
+		t.Fatal(n, err)
+	} else if v, _ := db.Get(newKey); string(v) != "value" {
+		t.Fatal(string(v))
+	}
+
+	if n, err := db.RenameNX(newKey, []byte("testdb_rename_c")); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestMoveCopy(t *testing.T) {
+	db := getTestDB()
+	db1, _ := testLedis.Select(1)
+
+	key := []byte("testdb_move_a")
+	dstKey := []byte("testdb_move_b")
+
+	if _, err := db.Move(key, 0); err != errSameKey {
+		t.Fatal(err)
+	}
+
+	if n, err := db.Move(key, 1); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+
+	db.HSet(key, []byte("f"), []byte("v"))
+	db.ZAdd(key, ScorePair{3, []byte("m")})
+	db.ZExpireAt(key, time.Now().Unix()+100)
+
+	if n, err := db.Copy(key, dstKey, 0, false); err != nil || n != 1 {
+		t.Fatal(n, err)
+	} else if v, _ := db.HGet(dstKey, []byte("f")); string(v) != "v" {
+		t.Fatal(string(v))
+	} else if v, _ := db.HGet(key, []byte("f")); string(v) != "v" {
+		t.Fatal(string(v))
+	} else if ttl, _ := db.ZTTL(dstKey); ttl <= 0 {
+		t.Fatal(ttl)
+	}
+
+	if n, err := db.Copy(key, dstKey, 0, false); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+
+	db1.Set(key, []byte("value"))
+	if n, err := db.Move(key, 1); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+
+	db1.Del(key)
+	if n, err := db.Move(key, 1); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+
+	if n, _ := db.ZCard(key); n != 0 {
+		t.Fatal(n)
+	} else if s, _ := db1.ZScore(key, []byte("m")); s != 3 {
+		t.Fatal(s)
+	} else if v, _ := db1.HGet(key, []byte("f")); string(v) != "v" {
+		t.Fatal(string(v))
+	} else if ttl, _ := db1.ZTTL(key); ttl <= 0 {
+		t.Fatal(ttl)
+	} else if ttl, _ := db.ZTTL(key); ttl != -1 {
+		t.Fatal(ttl)
+	}
+
+	db1.Set(dstKey, []byte("value"))
+	if n, err := db1.Copy(dstKey, dstKey, 0, true); err != nil || n != 1 {
+		t.Fatal(n, err)
+	} else if v, _ := db.Get(dstKey); string(v) != "value" {
+		t.Fatal(string(v))
+	} else if n, _ := db.HLen(dstKey); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := db.ZCard(dstKey); n != 0 {
+		t.Fatal(n)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/ledisdb/store"
 	"io"
 	"os"
 )
@@ -19,117 +20,305 @@ var (
 	errInvalidBinLogFile  = errors.New("invalid binlog file")
 )
 
-func (l *Ledis) ReplicateEvent(event []byte) error {
+//	ReplicateEvent replicates an event as a batch of its own with id, which is logged with
+//	the same id. The events of a batch must be replicated with ReplicateFromReader instead.
+func (l *Ledis) ReplicateEvent(id uint64, event []byte) error {
+	l.replay.Lock()
+	defer l.replay.Unlock()
+
+	r := newBatchReplayer(l)
+	if err := r.replay(0, id, event); err != nil {
+		return err
+	}
+	return r.complete()
+}
+
+//	batchReplayer replays the events of the batches in order. The put and delete events of
+//	a batch are committed with one write batch and one binlog append, so a slave never holds
+//	a part of a batch of the master, nor a binlog position inside one. A command event is
+//	replayed through the DB API, after the events of its batch before it are committed.
+//
+//	The replay lock of Ledis must be held, the Ledis mutex is taken for each commit only,
+//	as the DB API takes it to commit the command events.
+type batchReplayer struct {
+	l *Ledis
+
+	id         uint64
+	createTime uint32
+
+	//	some events of the batch are replayed
+	replayed bool
+
+	wb     store.WriteBatch
+	events [][]byte
+
+	//	the keys written by the events
+	puts    [][]byte
+	deletes [][]byte
+}
+
+func newBatchReplayer(l *Ledis) *batchReplayer {
+	r := new(batchReplayer)
+
+	r.l = l
+
+	return r
+}
+
+//	replay an event of the batch with id, the batch before is committed if id is a new one.
+//	The events of the old log files have no id, each of them is a batch of its own.
+func (r *batchReplayer) replay(createTime uint32, id uint64, event []byte) error {
+	if id != r.id || id == 0 {
+		if err := r.complete(); err != nil {
+			return err
+		}
+		r.id = id
+	}
+	r.createTime = createTime
+	r.replayed = true
+
 	if len(event) == 0 {
 		return errInvalidBinLogEvent
 	}
 
 	logType := uint8(event[0])
 	switch logType {
-	case BinLogTypePut:
-		return l.replicatePutEvent(event)
-	case BinLogTypeDeletion:
-		return l.replicateDeleteEvent(event)
+	case BinLogTypePut, BinLogTypeDeletion:
+		//	the event is reused by the reader
+		event = append([]byte(nil), event...)
 	case BinLogTypeCommand:
-		return l.replicateCommandEvent(event)
+		if err := r.commit(); err != nil {
+			return err
+		}
+		return r.l.replicateCommandEvent(id, event)
 	default:
 		return errInvalidBinLogEvent
 	}
+
+	if r.wb == nil {
+		r.wb = r.l.ldb.NewWriteBatch()
+	}
+
+	if logType == BinLogTypePut {
+		key, value, err := decodeBinLogPut(event)
+		if err != nil {
+			return err
+		}
+		r.wb.Put(key, value)
+		r.puts = append(r.puts, key)
+	} else {
+		key, err := decodeBinLogDelete(event)
+		if err != nil {
+			return err
+		}
+		r.wb.Delete(key)
+		r.deletes = append(r.deletes, key)
+	}
+
+	r.events = append(r.events, event)
+	return nil
 }
 
-func (l *Ledis) replicatePutEvent(event []byte) error {
-	key, value, err := decodeBinLogPut(event)
-	if err != nil {
-		return err
+//	commit the events of the batch replayed so far
+func (r *batchReplayer) commit() error {
+	if len(r.events) == 0 {
+		return nil
+	}
+
+	l := r.l
+	l.Lock()
+	err := l.commitWithBinLogID(r.wb, r.id, r.events)
+	if err == nil {
+		for _, key := range r.puts {
+			l.touchKey(key)
+			l.lazy.observe(key)
+		}
+		for _, key := range r.deletes {
+			l.touchKey(key)
+		}
+	}
+	l.Unlock()
+
+	r.wb = nil
+	r.events = r.events[0:0]
+	r.puts = r.puts[0:0]
+	r.deletes = r.deletes[0:0]
+
+	return err
+}
+
+//	commit the rest of the batch replayed, which is whole now, so it can be sent to the
+//	slaves of the slave
+func (r *batchReplayer) complete() error {
+	if !r.replayed {
+		return nil
 	}
 
-	if err = l.ldb.Put(key, value); err != nil {
+	if err := r.commit(); err != nil {
 		return err
 	}
 
+	l := r.l
+	l.Lock()
 	if l.binlog != nil {
-		err = l.binlog.Log(event)
+		l.setCommitID(l.binlog.LastID())
 	}
+	l.replicateTime = r.createTime
+	l.Unlock()
 
-	return err
+	r.replayed = false
+	return nil
 }
 
-func (l *Ledis) replicateDeleteEvent(event []byte) error {
-	key, err := decodeBinLogDelete(event)
+//	replay a command event through the DB API, which commits through the group commit,
+//	so the Ledis mutex must not be held. The writes are logged with id in slave mode.
+func (l *Ledis) replicateCommandEvent(id uint64, event []byte) error {
+	commandType, args, err := decodeBinLogCommand(event)
 	if err != nil {
 		return err
-	}
-
-	if err = l.ldb.Delete(key); err != nil {
+	} else if err = checkBinLogCommand(commandType, args); err != nil {
 		return err
 	}
 
 	if l.binlog != nil {
-		err = l.binlog.Log(event)
+		l.Lock()
+		l.binlog.advanceID(id)
+		l.Unlock()
+	}
+
+	db := l.dbs[args[0][0]]
+
+	switch commandType {
+	case BinLogCommandHClear:
+		_, err = db.HClear(args[1])
+	case BinLogCommandLClear:
+		_, err = db.LClear(args[1])
+	case BinLogCommandSClear:
+		_, err = db.SClear(args[1])
+	case BinLogCommandZClear:
+		_, err = db.ZClear(args[1])
+	case BinLogCommandExpireAt:
+		key := args[2]
+		t := db.lockTx(key)
+		db.expireAt(t, args[1][0], key, int64(binary.BigEndian.Uint64(args[3])))
+		err = t.Commit()
+		t.Unlock()
+	case BinLogCommandFlushDB:
+		_, err = db.flushType(args[1][0])
 	}
 
 	return err
 }
 
-func (l *Ledis) replicateCommandEvent(event []byte) error {
-	return errors.New("command event not supported now")
+//	ReadEventFromReader reads the events sent to slaves, which must have checksums
+func ReadEventFromReader(rb io.Reader, f func(createTime uint32, id uint64, event []byte) error) error {
+	r := newBinLogReader(rb, BinLogVersion)
+	if b, ok := rb.(interface {
+		Len() int
+	}); ok {
+		//	the events are all buffered, like the sync data
+		r.size = int64(b.Len())
+	}
+	return readEvents(r, f)
 }
 
-func ReadEventFromReader(rb io.Reader, f func(createTime uint32, event []byte) error) error {
-	var createTime uint32
-	var dataLen uint32
-	var dataBuf bytes.Buffer
-	var err error
+//	ReadEventFromBinLog reads the events of a log file, with or without checksums by its header
+func ReadEventFromBinLog(r io.Reader, f func(createTime uint32, id uint64, event []byte) error) error {
+	rb := bufio.NewReaderSize(r, 4096)
 
-	for {
-		if err = binary.Read(rb, binary.BigEndian, &createTime); err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return err
-			}
-		}
+	version, _, err := readBinLogHeader(rb)
+	if err != nil {
+		return err
+	}
 
-		if err = binary.Read(rb, binary.BigEndian, &dataLen); err != nil {
-			return err
+	br := newBinLogReader(rb, version)
+	if file, ok := r.(*os.File); ok {
+		if st, err := file.Stat(); err == nil {
+			br.pos, br.size = binLogHeaderLen(version), st.Size()
 		}
+	}
+	return readEvents(br, f)
+}
 
-		if _, err = io.CopyN(&dataBuf, rb, int64(dataLen)); err != nil {
+func readEvents(r *binLogReader, f func(createTime uint32, id uint64, event []byte) error) error {
+	for {
+		createTime, id, event, err := r.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
 			return err
 		}
 
-		err = f(createTime, dataBuf.Bytes())
+		err = f(createTime, id, event)
 		if err != nil && err != ErrSkipEvent {
 			return err
 		}
+	}
+}
 
-		dataBuf.Reset()
+func (r *batchReplayer) replayFunc(createTime uint32, id uint64, event []byte) error {
+	err := r.replay(createTime, id, event)
+	if err != nil {
+		log.Fatal("replication error %s, skip to next", err.Error())
+		return ErrSkipEvent
 	}
 
 	return nil
 }
 
-func (l *Ledis) ReplicateFromReader(rb io.Reader) error {
-	f := func(createTime uint32, event []byte) error {
-		err := l.ReplicateEvent(event)
+//	ReplicateTime returns the time the last event replicated was logged by the master, 0 if none
+func (l *Ledis) ReplicateTime() uint32 {
+	l.Lock()
+	t := l.replicateTime
+	l.Unlock()
+	return t
+}
+
+//	BinLogBytesAfter returns the bytes of the events committed after the position,
+//	like the ones a slave synced to it has not got yet.
+func (l *Ledis) BinLogBytesAfter(index int64, pos int64) int64 {
+	if l.binlog == nil || index <= 0 {
+		return 0
+	}
+
+	l.Lock()
+	lastIndex, lastPos := l.commitIndex, l.commitPos
+	l.Unlock()
+
+	var n int64
+	for ; index < lastIndex; index, pos = index+1, 0 {
+		st, err := os.Stat(l.binlog.FormatLogFilePath(index))
 		if err != nil {
-			log.Fatal("replication error %s, skip to next", err.Error())
-			return ErrSkipEvent
+			//	purged, or not created
+			continue
+		}
+
+		if size := st.Size(); size > pos {
+			n += size - pos
 		}
-		return nil
 	}
 
-	return ReadEventFromReader(rb, f)
+	if index == lastIndex && lastPos > pos {
+		n += lastPos - pos
+	}
+
+	return n
 }
 
-func (l *Ledis) ReplicateFromData(data []byte) error {
-	rb := bytes.NewReader(data)
+//	ReplicateFromReader replicates the events sent to slaves, which are whole batches
+func (l *Ledis) ReplicateFromReader(rb io.Reader) error {
+	l.replay.Lock()
+	defer l.replay.Unlock()
 
-	l.Lock()
-	err := l.ReplicateFromReader(rb)
-	l.Unlock()
+	r := newBatchReplayer(l)
+	if err := ReadEventFromReader(rb, r.replayFunc); err != nil {
+		return err
+	}
+	return r.complete()
+}
 
-	return err
+func (l *Ledis) ReplicateFromData(data []byte) error {
+	return l.ReplicateFromReader(bytes.NewReader(data))
 }
 
 func (l *Ledis) ReplicateFromBinLog(filePath string) error {
@@ -138,11 +327,12 @@ func (l *Ledis) ReplicateFromBinLog(filePath string) error {
 		return err
 	}
 
-	rb := bufio.NewReaderSize(f, 4096)
-
-	l.Lock()
-	err = l.ReplicateFromReader(rb)
-	l.Unlock()
+	l.replay.Lock()
+	r := newBatchReplayer(l)
+	if err = ReadEventFromBinLog(f, r.replayFunc); err == nil {
+		err = r.complete()
+	}
+	l.replay.Unlock()
 
 	f.Close()
 
@@ -151,6 +341,9 @@ func (l *Ledis) ReplicateFromBinLog(filePath string) error {
 
 const maxSyncEvents = 64
 
+//	ReadEventsTo writes the batches committed after info.LastID to w, about maxSyncEvents events
+//	at most, but a batch is never split. info is set to the last event written. info.LogFileIndex
+//	is 0 if binlog is not supported, or -1 if the batches are lost, then the slave must do a full sync.
 func (l *Ledis) ReadEventsTo(info *MasterInfo, w io.Writer) (n int, err error) {
 	n = 0
 	if l.binlog == nil {
@@ -160,96 +353,115 @@ func (l *Ledis) ReadEventsTo(info *MasterInfo, w io.Writer) (n int, err error) {
 		return
 	}
 
-	index := info.LogFileIndex
-	offset := info.LogPos
-
-	filePath := l.binlog.FormatLogFilePath(index)
-
-	var f *os.File
-	f, err = os.Open(filePath)
-	if os.IsNotExist(err) {
-		lastIndex := l.binlog.LogFileIndex()
-
-		if index == lastIndex {
-			//no binlog at all
-			info.LogPos = 0
-		} else {
-			//slave binlog info had lost
-			info.LogFileIndex = -1
-		}
-	}
+	l.Lock()
+	index, pos, ok := l.binlog.findID(info.LastID)
+	lastIndex, lastPos, lastID := l.commitIndex, l.commitPos, l.commitID
+	l.Unlock()
 
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = nil
-		}
+	if !ok {
+		//the batches after LastID are purged, or not logged by us
+		info.LogFileIndex = -1
 		return
 	}
 
-	defer f.Close()
+	info.LogFileIndex = index
+	info.LogPos = pos
 
-	var fileSize int64
-	st, _ := f.Stat()
-	fileSize = st.Size()
-
-	if fileSize == info.LogPos {
-		return
-	}
-
-	if _, err = f.Seek(offset, os.SEEK_SET); err != nil {
-		//may be invliad seek offset
-		return
-	}
+	after := info.LastID
+	eventsNum := 0
 
-	var lastCreateTime uint32 = 0
-	var createTime uint32
-	var dataLen uint32
+	for ; index <= lastIndex; index, pos = index+1, 0 {
+		filePath := l.binlog.FormatLogFilePath(index)
 
-	var eventsNum int = 0
-
-	for {
-		if err = binary.Read(f, binary.BigEndian, &createTime); err != nil {
-			if err == io.EOF {
-				//we will try to use next binlog
-				if index < l.binlog.LogFileIndex() {
-					info.LogFileIndex += 1
-					info.LogPos = 0
-				}
+		var f *os.File
+		if f, err = os.Open(filePath); err != nil {
+			if os.IsNotExist(err) {
+				//the current log file may be not created yet
 				err = nil
-				return
-			} else {
-				return
+				continue
 			}
-		}
-
-		eventsNum++
-		if lastCreateTime == 0 {
-			lastCreateTime = createTime
-		} else if lastCreateTime != createTime {
-			return
-		} else if eventsNum > maxSyncEvents {
 			return
 		}
 
-		if err = binary.Read(f, binary.BigEndian, &dataLen); err != nil {
+		var r *binLogReader
+		if r, err = newBinLogReaderAt(f, pos); err != nil {
+			f.Close()
 			return
 		}
 
-		if err = binary.Write(w, binary.BigEndian, createTime); err != nil {
-			return
+		if index == lastIndex {
+			//the events after are not committed yet
+			r.r = io.LimitReader(r.r, lastPos-r.pos)
+			r.size = lastPos
 		}
 
-		if err = binary.Write(w, binary.BigEndian, dataLen); err != nil {
-			return
-		}
+		for {
+			var createTime uint32
+			var id uint64
+			var event []byte
 
-		if _, err = io.CopyN(w, f, int64(dataLen)); err != nil {
-			return
+			if createTime, id, event, err = r.next(); err == io.EOF {
+				err = nil
+				break
+			} else if err != nil {
+				//the events read are committed, the slave can not go on after a broken one, let it do a full sync
+				log.Error("binlog %s broken at %d, %s", filePath, r.pos, err.Error())
+				info.LogFileIndex = -1
+				err = nil
+				f.Close()
+				return
+			}
+
+			if id <= after {
+				continue
+			} else if id > lastID || (id != info.LastID && eventsNum >= maxSyncEvents) {
+				f.Close()
+				return
+			}
+
+			if err = writeBinLogEvent(w, createTime, id, event); err != nil {
+				f.Close()
+				return
+			}
+
+			eventsNum++
+			n += binLogEventHeadSize + len(event) + 4
+
+			info.LastID = id
+			info.LogFileIndex = index
+			info.LogPos = r.pos
 		}
 
-		n += (8 + int(dataLen))
-		info.LogPos = info.LogPos + 8 + int64(dataLen)
+		f.Close()
 	}
 
 	return
 }
+
+//	a reader of the events of the log file f from pos, which is after the header at least
+func newBinLogReaderAt(f *os.File, pos int64) (*binLogReader, error) {
+	rb := bufio.NewReaderSize(f, 4096)
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r := newBinLogReader(rb, 0)
+	r.size = st.Size()
+	if r.version, _, err = readBinLogHeader(rb); err != nil {
+		return nil, err
+	}
+
+	if size := binLogHeaderLen(r.version); pos < size {
+		pos = size
+	}
+
+	if _, err = f.Seek(pos, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	rb.Reset(f)
+	r.pos = pos
+
+	return r, nil
+}
@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"github.com/siddontang/ledisdb/config"
 	"github.com/siddontang/ledisdb/store"
+	"io/ioutil"
 	"os"
 	"path"
 	"testing"
+	"time"
 )
 
 func checkLedisEqual(master *Ledis, slave *Ledis) error {
-	it := master.ldb.RangeLimitIterator(nil, nil, store.RangeClose, 0, -1)
+	//	the data of all dbs, not the binlog position of master itself
+	it := master.ldb.RangeLimitIterator(nil, []byte{MaxDBNumber}, store.RangeROpen, 0, -1)
 	for ; it.Valid(); it.Next() {
 		key := it.Key()
 		value := it.Value()
@@ -87,8 +90,6 @@ func TestReplication(t *testing.T) {
 	db.HSet([]byte("c1"), []byte("3"), []byte("value"))
 
 	info := new(MasterInfo)
-	info.LogFileIndex = 1
-	info.LogPos = 0
 	var buf bytes.Buffer
 	var n int
 
@@ -115,3 +116,319 @@ func TestReplication(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestReplicationCommandEvents(t *testing.T) {
+	cfgM := new(config.Config)
+	cfgM.DataDir = "/tmp/test_repl_command/master"
+	cfgM.BinLog.MaxFileNum = 10
+	cfgM.BinLog.MaxFileSize = 1024 * 1024
+	cfgM.BinLog.CommandEvents = true
+
+	os.RemoveAll(cfgM.DataDir)
+
+	master, err := Open(cfgM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+
+	cfgS := new(config.Config)
+	cfgS.DataDir = "/tmp/test_repl_command/slave"
+
+	os.RemoveAll(cfgS.DataDir)
+
+	slave, err := Open(cfgS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+
+	db, _ := master.Select(0)
+	db1, _ := master.Select(1)
+
+	key := []byte("command")
+	for i := 0; i < 100; i++ {
+		m := []byte(fmt.Sprintf("m%d", i))
+		db.HSet(key, m, m)
+		db.RPush(key, m)
+		db.SAdd(key, m)
+		db.ZAdd(key, ScorePair{int64(i), m})
+		db1.Set(m, m)
+	}
+
+	db.Set(key, key)
+	db.ExpireAt(key, time.Now().Unix()+100)
+	db.HClear(key)
+	db.LClear(key)
+	db.SClear(key)
+	db.ZMclear(key)
+	db1.FlushAll()
+
+	var commands []string
+	for _, name := range master.binlog.LogNames() {
+		p := path.Join(master.binlog.LogPath(), name)
+
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ReadEventFromBinLog(f, func(createTime uint32, id uint64, event []byte) error {
+			if event[0] == BinLogTypeCommand {
+				s, err := FormatBinLogEvent(event)
+				if err != nil {
+					return err
+				}
+				commands = append(commands, s)
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = slave.ReplicateFromBinLog(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//	the flushes of the empty data types log nothing
+	if len(commands) != 6 {
+		t.Fatal(commands)
+	} else if commands[1] != `COMMAND HCLEAR DB: 0 "command"` {
+		t.Fatal(commands[1])
+	} else if commands[5] != "COMMAND FLUSHDB DB: 1 kv" {
+		t.Fatal(commands[5])
+	}
+
+	sdb, _ := slave.Select(0)
+	sdb1, _ := slave.Select(1)
+	if n, _ := sdb.HLen(key); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := sdb.LLen(key); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := sdb.SCard(key); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := sdb.ZCard(key); n != 0 {
+		t.Fatal(n)
+	} else if n, _ := sdb.TTL(key); n <= 0 {
+		t.Fatal(n)
+	} else if v, _ := sdb1.Get([]byte("m1")); v != nil {
+		t.Fatal(string(v))
+	}
+
+	if err = checkLedisEqual(master, slave); err != nil {
+		t.Fatal(err)
+	}
+
+	//	the command events are replayed by the sync without the Ledis mutex held,
+	//	the dumps and purges meanwhile never interleave with a batch
+	synced, err := openIDLedis("command_slave", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer synced.Close()
+	synced.SetSlaveMode(true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			synced.Dump(ioutil.Discard)
+			synced.PurgeBinLogBefore(time.Now())
+		}
+	}()
+
+	if err = syncByID(master, synced, new(MasterInfo), 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if err = checkLedisEqual(master, synced); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//	sync slave from master by the batch ids, rounds times at most if rounds > 0
+func syncByID(master *Ledis, slave *Ledis, info *MasterInfo, rounds int) error {
+	var buf bytes.Buffer
+	for i := 0; rounds <= 0 || i < rounds; i++ {
+		buf.Reset()
+		n, err := master.ReadEventsTo(info, &buf)
+		if err != nil {
+			return err
+		} else if info.LogFileIndex <= 0 {
+			return fmt.Errorf("invalid log file index %d", info.LogFileIndex)
+		} else if err = slave.ReplicateFromData(buf.Bytes()); err != nil {
+			return err
+		} else if n == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func openIDLedis(name string, binlog bool) (*Ledis, error) {
+	cfg := new(config.Config)
+	cfg.DataDir = path.Join("/tmp/test_repl_id", name)
+	if binlog {
+		cfg.BinLog.MaxFileNum = 100
+		cfg.BinLog.MaxFileSize = 1024
+	}
+
+	os.RemoveAll(cfg.DataDir)
+	return Open(cfg)
+}
+
+func TestReplicationResumeByID(t *testing.T) {
+	master, err := openIDLedis("master", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+
+	slave, err := openIDLedis("slave", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		slave.Close()
+	}()
+	slave.SetSlaveMode(true)
+
+	cascade, err := openIDLedis("cascade", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cascade.Close()
+
+	db, _ := master.Select(0)
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("id_%d", i))
+		db.Set(key, key)
+		db.HSet(key, key, key)
+	}
+
+	if n := len(master.binlog.LogNames()); n < 10 {
+		t.Fatal(n)
+	} else if id := master.binlog.LastID(); id != 400 {
+		t.Fatal(id)
+	}
+
+	slaveInfo := new(MasterInfo)
+	if err = syncByID(master, slave, slaveInfo, 0); err != nil {
+		t.Fatal(err)
+	} else if slaveInfo.LastID != 400 || slave.binlog.LastID() != 400 {
+		t.Fatal(slaveInfo.LastID, slave.binlog.LastID())
+	}
+
+	//	the cascaded slave syncs a part from master, then resumes from slave by the same id
+	cascadeInfo := new(MasterInfo)
+	if err = syncByID(master, cascade, cascadeInfo, 2); err != nil {
+		t.Fatal(err)
+	} else if cascadeInfo.LastID == 0 || cascadeInfo.LastID == 400 {
+		t.Fatal(cascadeInfo.LastID)
+	}
+
+	if err = syncByID(slave, cascade, cascadeInfo, 0); err != nil {
+		t.Fatal(err)
+	} else if cascadeInfo.LastID != 400 {
+		t.Fatal(cascadeInfo.LastID)
+	}
+
+	if err = checkLedisEqual(master, cascade); err != nil {
+		t.Fatal(err)
+	}
+
+	//	the ids of a slave survive its restart
+	slave.Close()
+
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_repl_id/slave"
+	cfg.BinLog.MaxFileNum = 100
+	cfg.BinLog.MaxFileSize = 1024
+	if slave, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	} else if slave.binlog.LastID() != 400 {
+		t.Fatal(slave.binlog.LastID())
+	}
+
+	info := &MasterInfo{LastID: 100}
+	var buf bytes.Buffer
+	if _, err = slave.ReadEventsTo(info, &buf); err != nil {
+		t.Fatal(err)
+	} else if info.LastID <= 100 {
+		t.Fatal(info.LastID)
+	}
+
+	//	the batches purged are lost, a full sync is needed
+	master.binlog.Purge(len(master.binlog.LogNames()) - 2)
+
+	info = &MasterInfo{LastID: 100}
+	if _, err = master.ReadEventsTo(info, &buf); err != nil {
+		t.Fatal(err)
+	} else if info.LogFileIndex != -1 {
+		t.Fatal(info.LogFileIndex)
+	}
+
+	//	so are the batches not logged by master
+	info = &MasterInfo{LastID: 401}
+	if _, err = master.ReadEventsTo(info, &buf); err != nil {
+		t.Fatal(err)
+	} else if info.LogFileIndex != -1 {
+		t.Fatal(info.LogFileIndex)
+	}
+}
+
+func TestReplicationWholeBatch(t *testing.T) {
+	master, err := openIDLedis("whole_master", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+
+	slave, err := openIDLedis("whole_slave", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	slave.SetSlaveMode(true)
+
+	key := []byte("whole")
+	db, _ := master.Select(0)
+	if err = db.HMset(key, FVPair{[]byte("a"), key}, FVPair{[]byte("b"), key}, FVPair{[]byte("c"), key}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	info := new(MasterInfo)
+	if n, err := master.ReadEventsTo(info, &buf); err != nil || n == 0 || info.LastID != 1 {
+		t.Fatal(err, n, info.LastID)
+	}
+
+	//	a batch cut in transit is never applied in part
+	sdb, _ := slave.Select(0)
+	data := buf.Bytes()
+	if err = slave.ReplicateFromData(data[0 : len(data)-4]); err == nil {
+		t.Fatal("must fail")
+	} else if n, _ := sdb.HLen(key); n != 0 {
+		t.Fatal(n)
+	} else if id := slave.BinLogLastID(); id != 0 {
+		t.Fatal(id)
+	}
+
+	//	the whole batch is committed with one binlog append
+	if err = slave.ReplicateFromData(data); err != nil {
+		t.Fatal(err)
+	} else if n, _ := sdb.HLen(key); n != 3 {
+		t.Fatal(n)
+	} else if id := slave.BinLogLastID(); id != 1 {
+		t.Fatal(id)
+	} else if pos := slave.binlog.LogFilePos(); pos != binLogHeaderSize+int64(len(data)) {
+		t.Fatal(pos, len(data))
+	}
+
+	if err = checkLedisEqual(master, slave); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+package ledis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+/*
+Point-in-time recovery:
+
+	all the data is flushed and the dump is loaded, then the events logged by the master
+	after the dump are replayed from its log files in index order, until the first one
+	logged after the stop time, or in a batch after the stop id. The batch ids are the
+	ones printed by ledis-binlog and the MASTER_LAST_ID of ledis-load.
+
+The log files are the ones in the bin_log directory of the master, listed by its index file,
+from the one the dump was taken at. They must not be purged after the dump.
+*/
+
+//	RestoreOptions tells where the log files are and where to stop replaying them
+type RestoreOptions struct {
+	//	the bin_log directory of the master dumped
+	LogPath string
+
+	//	the events logged after StopTime are not replayed, zero time for no limit
+	StopTime time.Time
+
+	//	the batches after StopID are not replayed, 0 for no limit
+	StopID uint64
+
+	//	count the events would be replayed only, the data is not changed
+	DryRun bool
+}
+
+//	RestoreResult is the position restored to
+type RestoreResult struct {
+	//	the position of the dump
+	Dump MasterInfo
+
+	//	the position after the last event replayed, or the dump one if none is replayed
+	MasterInfo
+
+	//	the number of the events replayed
+	Events int
+
+	//	the time the last event replayed was logged, 0 if none is replayed
+	LastTime uint32
+}
+
+//	RestoreToTime restores the dump, then replays the events logged until t from the log
+//	files in logPath.
+func (l *Ledis) RestoreToTime(dumpPath string, logPath string, t time.Time, dryRun bool) (*RestoreResult, error) {
+	return l.Restore(dumpPath, &RestoreOptions{LogPath: logPath, StopTime: t, DryRun: dryRun})
+}
+
+//	Restore restores the dump, then replays the events of the log files after it until
+//	the stop time or id of opts.
+func (l *Ledis) Restore(dumpPath string, opts *RestoreOptions) (*RestoreResult, error) {
+	f, err := os.Open(dumpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := new(RestoreResult)
+
+	var r *batchReplayer
+	if opts.DryRun {
+		err = res.Dump.ReadFrom(bufio.NewReader(f))
+	} else {
+		l.replay.Lock()
+		defer l.replay.Unlock()
+
+		r = newBatchReplayer(l)
+
+		if err = l.FlushAll(); err != nil {
+			return nil, err
+		}
+
+		var info *MasterInfo
+		if info, err = l.loadDump(f); err == nil {
+			res.Dump = *info
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	} else if res.Dump.LogFileIndex == 0 {
+		return nil, fmt.Errorf("dump %s has no binlog position", dumpPath)
+	}
+
+	res.MasterInfo = res.Dump
+
+	indexes, err := restoreLogFiles(opts.LogPath, res.Dump.LogFileIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, index := range indexes {
+		pos := int64(0)
+		if i == 0 {
+			pos = res.Dump.LogPos
+		}
+
+		if end, err := l.restoreLogFile(path.Join(opts.LogPath, formatLogFileName(index)), index, pos, opts, r, res); err != nil {
+			return res, err
+		} else if end {
+			break
+		}
+	}
+
+	if r != nil {
+		if err = r.complete(); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+//	the indexes of the log files in logPath from index first, which must be logged
+func restoreLogFiles(logPath string, first int64) ([]int64, error) {
+	data, err := ioutil.ReadFile(path.Join(logPath, "ledis-bin.index"))
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int64, 0, 16)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.Trim(line, "\r\n ")
+		if len(line) == 0 {
+			continue
+		}
+
+		index, err := parseLogFileIndex(line)
+		if err != nil {
+			return nil, err
+		} else if index < first {
+			continue
+		} else if len(indexes) == 0 && index != first {
+			return nil, fmt.Errorf("log file %s of the dump is purged", formatLogFileName(first))
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	//	no events logged after the dump, the log file at the position may be not created yet
+	return indexes, nil
+}
+
+//	replay the events of the log file from pos with r, nil for a dry run, end is true if
+//	a stop point is reached
+func (l *Ledis) restoreLogFile(filePath string, index int64, pos int64, opts *RestoreOptions, r *batchReplayer, res *RestoreResult) (end bool, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	br, err := newBinLogReaderAt(f, pos)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		createTime, id, event, err := br.next()
+		if err == io.EOF {
+			return false, nil
+		} else if err == io.ErrUnexpectedEOF || (err == ErrBinLogCorrupt && br.atTail()) {
+			//	the event is being logged by the master, or lost in a crash
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("read %s at %d error %s", filePath, br.pos, err.Error())
+		}
+
+		if id != 0 && id <= res.Dump.LastID {
+			//	logged before the dump
+			continue
+		} else if !opts.StopTime.IsZero() && int64(createTime) > opts.StopTime.Unix() {
+			return true, nil
+		} else if opts.StopID > 0 && id > opts.StopID {
+			return true, nil
+		}
+
+		if r != nil {
+			if err = r.replay(createTime, id, event); err != nil {
+				return false, err
+			}
+		}
+
+		res.Events++
+		res.LastTime = createTime
+		if id > res.LastID {
+			res.LastID = id
+		}
+		res.LogFileIndex = index
+		res.LogPos = br.pos
+	}
+}
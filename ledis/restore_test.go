@@ -0,0 +1,96 @@
+package ledis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRestore(t *testing.T) {
+	master, err := openIDLedis("restore_master", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+
+	db, _ := master.Select(0)
+	for i := 0; i < 10; i++ {
+		db.Set([]byte(fmt.Sprintf("a_%d", i)), []byte("1"))
+	}
+
+	dumpPath := "/tmp/test_repl_id/restore.dump"
+	if err := master.DumpFile(dumpPath); err != nil {
+		t.Fatal(err)
+	}
+
+	//	across some log files
+	for i := 0; i < 100; i++ {
+		db.Set([]byte(fmt.Sprintf("b_%d", i)), []byte("1"))
+	}
+	stopID := master.BinLogLastID()
+
+	db.HSet([]byte("c"), []byte("f"), []byte("1"))
+	db.HClear([]byte("c"))
+	db.Set([]byte("d"), []byte("1"))
+
+	slave, err := openIDLedis("restore_slave", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+
+	sdb, _ := slave.Select(0)
+	sdb.Set([]byte("e"), []byte("1"))
+
+	logPath := master.binlog.LogPath()
+
+	opts := &RestoreOptions{LogPath: logPath, StopID: stopID, DryRun: true}
+	if res, err := slave.Restore(dumpPath, opts); err != nil {
+		t.Fatal(err)
+	} else if res.Events != 100 || res.LastID != stopID {
+		t.Fatal(res.Events, res.LastID, stopID)
+	} else if n, _ := sdb.Exists([]byte("e")); n != 1 {
+		t.Fatal("dry run must not change the data")
+	}
+
+	opts.DryRun = false
+	if res, err := slave.Restore(dumpPath, opts); err != nil {
+		t.Fatal(err)
+	} else if res.Events != 100 || res.LastID != stopID {
+		t.Fatal(res.Events, res.LastID)
+	}
+
+	if n, _ := sdb.Exists([]byte("e")); n != 0 {
+		t.Fatal("data before restore must be flushed")
+	} else if n, _ := sdb.Exists([]byte("a_9")); n != 1 {
+		t.Fatal("dump not loaded")
+	} else if n, _ := sdb.Exists([]byte("b_99")); n != 1 {
+		t.Fatal("binlog not replayed")
+	} else if n, _ := sdb.HLen([]byte("c")); n != 0 {
+		t.Fatal("replayed after the stop id")
+	} else if id := slave.BinLogLastID(); id != stopID {
+		t.Fatal(id, stopID)
+	}
+
+	if res, err := slave.RestoreToTime(dumpPath, logPath, time.Now().Add(time.Hour), false); err != nil {
+		t.Fatal(err)
+	} else if res.LastID != master.BinLogLastID() {
+		t.Fatal(res.LastID, master.BinLogLastID())
+	} else if n, _ := sdb.Exists([]byte("d")); n != 1 {
+		t.Fatal("binlog not replayed")
+	}
+
+	if res, err := slave.RestoreToTime(dumpPath, logPath, time.Unix(1, 0), false); err != nil {
+		t.Fatal(err)
+	} else if res.Events != 0 || res.MasterInfo != res.Dump {
+		t.Fatal(res.Events, res.MasterInfo)
+	} else if n, _ := sdb.Exists([]byte("b_0")); n != 0 {
+		t.Fatal("replayed after the stop time")
+	}
+
+	//	the log file of the dump is purged
+	master.binlog.Purge(len(master.binlog.LogNames()) - 1)
+	if _, err := slave.RestoreToTime(dumpPath, logPath, time.Now(), true); err == nil {
+		t.Fatal("must error")
+	}
+}
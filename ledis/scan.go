@@ -78,6 +78,10 @@ func (db *DB) encodeMetaKey(dataType byte, key []byte) ([]byte, error) {
 		return db.zEncodeSizeKey(key), nil
 	case BitMetaType:
 		return db.bEncodeMetaKey(key), nil
+	case SSizeType:
+		return db.sEncodeSizeKey(key), nil
+	case HLLType:
+		return db.pfEncodeKey(key), nil
 	default:
 		return nil, errDataType
 	}
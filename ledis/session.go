@@ -0,0 +1,54 @@
+package ledis
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+//	Session selects the DBs for one client, and remembers the id of the last batch its writes
+//	are committed in, so the client can wait for the slaves to ack its own writes only.
+type Session struct {
+	l *Ledis
+
+	dbs [MaxDBNumber]*DB
+
+	lastID uint64
+}
+
+func (l *Ledis) NewSession() *Session {
+	s := new(Session)
+	s.l = l
+	return s
+}
+
+func (s *Session) Select(index int) (*DB, error) {
+	if index < 0 || index >= int(MaxDBNumber) {
+		return nil, fmt.Errorf("invalid db index %d", index)
+	}
+
+	if s.dbs[index] == nil {
+		d := new(DB)
+		*d = *s.l.dbs[index]
+		d.session = s
+		s.dbs[index] = d
+	}
+
+	return s.dbs[index], nil
+}
+
+//	Multi begins a Multi whose commit is remembered by the session
+func (s *Session) Multi() *Multi {
+	m := s.l.Multi()
+	m.session = s
+	return m
+}
+
+//	LastID returns the id of the last batch the writes of the session are committed in, 0 if none
+func (s *Session) LastID() uint64 {
+	return atomic.LoadUint64(&s.lastID)
+}
+
+//	the writes of the session are committed in batch id
+func (s *Session) committed(id uint64) {
+	atomic.StoreUint64(&s.lastID, id)
+}
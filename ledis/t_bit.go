@@ -16,10 +16,33 @@ const (
 )
 
 type BitPair struct {
-	Pos int32
+	Pos int64
 	Val uint8
 }
 
+const (
+	BitFieldGet uint8 = iota + 1
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+const (
+	BitOverflowWrap uint8 = iota
+	BitOverflowSat
+	BitOverflowFail
+)
+
+//	BitField is an operation on a signed or unsigned integer of Width bits at Offset,
+//	with the most significant bit at the lowest offset.
+type BitField struct {
+	Op       uint8
+	Signed   bool
+	Width    uint32
+	Offset   int64
+	Value    int64
+	Overflow uint8
+}
+
 type segBitInfo struct {
 	Seq uint32
 	Off uint32
@@ -37,11 +60,15 @@ const (
 	segBitWidth uint32 = segByteWidth + 3
 	segBitSize  uint32 = segByteSize << 3
 
-	maxByteSize uint32 = 8 << 20
-	maxSegCount uint32 = maxByteSize / segByteSize
+	//	a bitmap has 2^32 bits at most, only the segments not empty are stored
+	maxByteSize uint64 = 1 << 29
+	maxSegCount uint32 = uint32(maxByteSize >> segByteWidth)
 
 	minSeq uint32 = 0
 	maxSeq uint32 = uint32((maxByteSize << 3) - 1)
+
+	//	max fill segments put in one commit by BOperation NOT
+	bitOpFillBatch = 1024
 )
 
 var bitsInByte = [256]int32{0, 1, 1, 2, 1, 2, 2, 3, 1, 2, 2, 3, 2, 3, 3,
@@ -72,6 +99,8 @@ var fillSegment []byte = func() []byte {
 var errBinKey = errors.New("invalid bin key")
 var errOffset = errors.New("invalid offset")
 var errDuplicatePos = errors.New("duplicate bit pos")
+var errBitFieldType = errors.New("invalid bitfield type")
+var errInvalidBit = errors.New("bit must be 0 or 1")
 
 func getBit(sz []byte, offset uint32) uint8 {
 	index := offset >> 3
@@ -179,20 +208,21 @@ func (db *DB) bCapByteSize(seq uint32, off uint32) uint32 {
 	return seq<<segByteWidth + offByteSize
 }
 
-func (db *DB) bParseOffset(key []byte, offset int32) (seq uint32, off uint32, err error) {
+func (db *DB) bParseOffset(key []byte, offset int64) (seq uint32, off uint32, err error) {
 	if offset < 0 {
 		if tailSeq, tailOff, e := db.bGetMeta(key); e != nil {
 			err = e
 			return
 		} else if tailSeq >= 0 {
-			offset += int32((uint32(tailSeq)<<segBitWidth | uint32(tailOff)) + 1)
-			if offset < 0 {
-				err = errOffset
-				return
-			}
+			offset += int64(uint32(tailSeq)<<segBitWidth|uint32(tailOff)) + 1
 		}
 	}
 
+	if offset < 0 || offset > int64(maxSeq) {
+		err = errOffset
+		return
+	}
+
 	off = uint32(offset)
 
 	seq = off >> segBitWidth
@@ -227,6 +257,7 @@ func (db *DB) bSetMeta(t *tx, key []byte, tailSeq uint32, tailOff uint32) {
 	binary.LittleEndian.PutUint32(buf[4:8], tailOff)
 
 	t.Put(ek, buf)
+	db.claimKeyType(t, BitType, key)
 	return
 }
 
@@ -255,6 +286,7 @@ func (db *DB) bUpdateMeta(t *tx, key []byte, seq uint32, off uint32) (tailSeq ui
 func (db *DB) bDelete(t *tx, key []byte) (drop int64) {
 	mk := db.bEncodeMetaKey(key)
 	t.Delete(mk)
+	db.releaseKeyType(t, BitType, key)
 
 	minKey := db.bEncodeBinKey(key, minSeq)
 	maxKey := db.bEncodeBinKey(key, maxSeq)
@@ -285,12 +317,41 @@ func (db *DB) bAllocateSegment(key []byte, seq uint32) ([]byte, []byte, error) {
 	return bk, segment, err
 }
 
+//	put the segment, or delete it if all the bits are 0 to keep the bitmap sparse
+func (db *DB) bPutSegment(t *tx, bk []byte, segment []byte) {
+	for _, b := range segment {
+		if b != 0 {
+			t.Put(bk, segment)
+			return
+		}
+	}
+	t.Delete(bk)
+}
+
 func (db *DB) bIterator(key []byte) *store.RangeLimitIterator {
 	sk := db.bEncodeBinKey(key, minSeq)
 	ek := db.bEncodeBinKey(key, maxSeq)
 	return db.db.RangeIterator(sk, ek, store.RangeClose)
 }
 
+//	all the existing segments of key by seq
+func (db *DB) bSegments(key []byte) (map[uint32][]byte, error) {
+	segments := make(map[uint32][]byte)
+
+	it := db.bIterator(key)
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		_, seq, err := db.bDecodeBinKey(it.RawKey())
+		if err != nil {
+			return nil, err
+		}
+		segments[seq] = it.Value()
+	}
+
+	return segments, nil
+}
+
 func (db *DB) bSegAnd(a []byte, b []byte, res *[]byte) {
 	if a == nil || b == nil {
 		*res = nil
@@ -361,8 +422,7 @@ func (db *DB) bSegXor(a []byte, b []byte, res *[]byte) {
 }
 
 func (db *DB) bExpireAt(key []byte, when int64) (int64, error) {
-	t := db.binTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	if seq, _, err := db.bGetMeta(key); err != nil || seq < 0 {
@@ -372,11 +432,13 @@ func (db *DB) bExpireAt(key []byte, when int64) (int64, error) {
 		if err := t.Commit(); err != nil {
 			return 0, err
 		}
+		db.notify(NotifyGeneric, "expire", key)
 	}
 	return 1, nil
 }
 
-func (db *DB) bCountByte(val byte, soff uint32, eoff uint32) int32 {
+//	mask of the bits from soff to eoff in a byte
+func bByteMask(soff uint32, eoff uint32) uint8 {
 	if soff > eoff {
 		soff, eoff = eoff, soff
 	}
@@ -388,12 +450,14 @@ func (db *DB) bCountByte(val byte, soff uint32, eoff uint32) int32 {
 	if eoff < 7 {
 		mask |= (fillBits[7] ^ fillBits[eoff])
 	}
-	mask = fillBits[7] ^ mask
+	return fillBits[7] ^ mask
+}
 
-	return bitsInByte[val&mask]
+func (db *DB) bCountByte(val byte, soff uint32, eoff uint32) int32 {
+	return bitsInByte[val&bByteMask(soff, eoff)]
 }
 
-func (db *DB) bCountSeg(key []byte, seq uint32, soff uint32, eoff uint32) (cnt int32, err error) {
+func (db *DB) bCountSeg(key []byte, seq uint32, soff uint32, eoff uint32) (cnt int64, err error) {
 	if soff >= segBitSize || soff < 0 ||
 		eoff >= segBitSize || eoff < 0 {
 		return
@@ -418,15 +482,15 @@ func (db *DB) bCountSeg(key []byte, seq uint32, soff uint32, eoff uint32) (cnt i
 	eByteOff := eoff - ((eoff >> 3) << 3)
 
 	if headIdx == endIdx {
-		cnt = db.bCountByte(segment[headIdx], sByteOff, eByteOff)
+		cnt = int64(db.bCountByte(segment[headIdx], sByteOff, eByteOff))
 	} else {
-		cnt = db.bCountByte(segment[headIdx], sByteOff, 7) +
-			db.bCountByte(segment[endIdx], 0, eByteOff)
+		cnt = int64(db.bCountByte(segment[headIdx], sByteOff, 7) +
+			db.bCountByte(segment[endIdx], 0, eByteOff))
 	}
 
 	// sum up following bytes
 	for idx, end := headIdx+1, endIdx-1; idx <= end; idx += 1 {
-		cnt += bitsInByte[segment[idx]]
+		cnt += int64(bitsInByte[segment[idx]])
 		if idx == end {
 			break
 		}
@@ -474,18 +538,19 @@ func (db *DB) BDelete(key []byte) (drop int64, err error) {
 		return
 	}
 
-	t := db.binTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	drop = db.bDelete(t, key)
 	db.rmExpire(t, BitType, key)
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && drop > 0 {
+		db.notify(NotifyGeneric, "del", key)
+	}
 	return
 }
 
-func (db *DB) BSetBit(key []byte, offset int32, val uint8) (ori uint8, err error) {
+func (db *DB) BSetBit(key []byte, offset int64, val uint8) (ori uint8, err error) {
 	if err = checkKeySize(key); err != nil {
 		return
 	}
@@ -496,6 +561,9 @@ func (db *DB) BSetBit(key []byte, offset int32, val uint8) (ori uint8, err error
 		return 0, err
 	}
 
+	t := db.lockTx(key)
+	defer t.Unlock()
+
 	var bk, segment []byte
 	if bk, segment, err = db.bAllocateSegment(key, seq); err != nil {
 		return 0, err
@@ -504,17 +572,17 @@ func (db *DB) BSetBit(key []byte, offset int32, val uint8) (ori uint8, err error
 	if segment != nil {
 		ori = getBit(segment, off)
 		if setBit(segment, off, val) {
-			t := db.binTx
-			t.Lock()
-
-			t.Put(bk, segment)
+			db.bPutSegment(t, bk, segment)
 			if _, _, e := db.bUpdateMeta(t, key, seq, off); e != nil {
 				err = e
 				return
 			}
 
 			err = t.Commit()
-			t.Unlock()
+
+			if err == nil {
+				db.notify(NotifyBit, "setbit", key)
+			}
 		}
 	}
 
@@ -554,8 +622,7 @@ func (db *DB) BMSetBit(key []byte, args ...BitPair) (place int64, err error) {
 	}
 
 	//	#2 : execute bit set in order
-	t := db.binTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	var curBinKey, curSeg []byte
@@ -563,7 +630,7 @@ func (db *DB) BMSetBit(key []byte, args ...BitPair) (place int64, err error) {
 
 	for _, info := range bitInfos {
 		if curSeg != nil && info.Seq != curSeq {
-			t.Put(curBinKey, curSeg)
+			db.bPutSegment(t, curBinKey, curSeg)
 			curSeg = nil
 		}
 
@@ -586,7 +653,7 @@ func (db *DB) BMSetBit(key []byte, args ...BitPair) (place int64, err error) {
 	}
 
 	if curSeg != nil {
-		t.Put(curBinKey, curSeg)
+		db.bPutSegment(t, curBinKey, curSeg)
 	}
 
 	//	finally, update meta
@@ -595,13 +662,15 @@ func (db *DB) BMSetBit(key []byte, args ...BitPair) (place int64, err error) {
 			return
 		}
 
-		err = t.Commit()
+		if err = t.Commit(); err == nil {
+			db.notify(NotifyBit, "setbit", key)
+		}
 	}
 
 	return
 }
 
-func (db *DB) BGetBit(key []byte, offset int32) (uint8, error) {
+func (db *DB) BGetBit(key []byte, offset int64) (uint8, error) {
 	if seq, off, err := db.bParseOffset(key, offset); err != nil {
 		return 0, err
 	} else {
@@ -624,7 +693,7 @@ func (db *DB) BGetBit(key []byte, offset int32) (uint8, error) {
 // 	return
 // }
 
-func (db *DB) BCount(key []byte, start int32, end int32) (cnt int32, err error) {
+func (db *DB) BCount(key []byte, start int64, end int64) (cnt int64, err error) {
 	var sseq, soff uint32
 	if sseq, soff, err = db.bParseOffset(key, start); err != nil {
 		return
@@ -640,7 +709,7 @@ func (db *DB) BCount(key []byte, start int32, end int32) (cnt int32, err error)
 		soff, eoff = eoff, soff
 	}
 
-	var segCnt int32
+	var segCnt int64
 	if eseq == sseq {
 		if segCnt, err = db.bCountSeg(key, sseq, soff, eoff); err != nil {
 			return 0, err
@@ -662,7 +731,7 @@ func (db *DB) BCount(key []byte, start int32, end int32) (cnt int32, err error)
 		}
 	}
 
-	//	middle segs
+	//	middle segs, only the existing ones
 	var segment []byte
 	skey := db.bEncodeBinKey(key, sseq)
 	ekey := db.bEncodeBinKey(key, eseq)
@@ -671,7 +740,7 @@ func (db *DB) BCount(key []byte, start int32, end int32) (cnt int32, err error)
 	for ; it.Valid(); it.Next() {
 		segment = it.RawValue()
 		for _, bt := range segment {
-			cnt += bitsInByte[bt]
+			cnt += int64(bitsInByte[bt])
 		}
 	}
 	it.Close()
@@ -679,22 +748,22 @@ func (db *DB) BCount(key []byte, start int32, end int32) (cnt int32, err error)
 	return
 }
 
-func (db *DB) BTail(key []byte) (int32, error) {
+func (db *DB) BTail(key []byte) (int64, error) {
 	// effective length of data, the highest bit-pos set in history
 	tailSeq, tailOff, err := db.bGetMeta(key)
 	if err != nil {
 		return 0, err
 	}
 
-	tail := int32(-1)
+	tail := int64(-1)
 	if tailSeq >= 0 {
-		tail = int32(uint32(tailSeq)<<segBitWidth | uint32(tailOff))
+		tail = int64(uint32(tailSeq)<<segBitWidth | uint32(tailOff))
 	}
 
 	return tail, nil
 }
 
-func (db *DB) BOperation(op uint8, dstkey []byte, srckeys ...[]byte) (blen int32, err error) {
+func (db *DB) BOperation(op uint8, dstkey []byte, srckeys ...[]byte) (blen int64, err error) {
 	//	blen -
 	//		the total bit size of data stored in destination key,
 	//		that is equal to the size of the longest input string.
@@ -715,8 +784,7 @@ func (db *DB) BOperation(op uint8, dstkey []byte, srckeys ...[]byte) (blen int32
 		return
 	}
 
-	t := db.binTx
-	t.Lock()
+	t := db.lockTx(append([][]byte{dstkey}, srckeys...)...)
 	defer t.Unlock()
 
 	var srcKseq, srcKoff int32
@@ -754,8 +822,12 @@ func (db *DB) BOperation(op uint8, dstkey []byte, srckeys ...[]byte) (blen int32
 		}
 	}
 
-	// init - data
-	var segments = make([][]byte, maxDstSeq+1)
+	// init - data, only the existing segments are kept, others are all 0
+	var segments map[uint32][]byte
+	var res []byte
+
+	//	the segments of NOT with all bit set, see below
+	var fill func(seq uint32) []byte
 
 	if op == OPnot {
 		//	ps :
@@ -763,11 +835,6 @@ func (db *DB) BOperation(op uint8, dstkey []byte, srckeys ...[]byte) (blen int32
 		//		we init the result segments with all bit set,
 		//		then we can calculate through the way of 'xor'.
 
-		//	ahead segments bin format : 1111 ... 1111
-		for i := uint32(0); i < maxDstSeq; i++ {
-			segments[i] = fillSegment
-		}
-
 		//	last segment bin format : 1111..1100..0000
 		var tailSeg = make([]byte, segByteSize, segByteSize)
 		var fillByte = fillBits[7]
@@ -776,62 +843,62 @@ func (db *DB) BOperation(op uint8, dstkey []byte, srckeys ...[]byte) (blen int32
 			tailSeg[i] = fillByte
 		}
 		tailSeg[tailSegLen-1] = fillBits[maxDstOff-(tailSegLen-1)<<3]
-		segments[maxDstSeq] = tailSeg
 
+		//	ahead segments bin format : 1111 ... 1111
+		fill = func(seq uint32) []byte {
+			if seq == maxDstSeq {
+				return tailSeg
+			}
+			return fillSegment
+		}
+
+		//	only the segments of the source are kept, a sparse bitmap may have
+		//	2^20 fill segments, they are put batch by batch after
+		if segments, err = db.bSegments(srckeys[srcIdx]); err != nil {
+			return
+		}
+
+		for seq, segt := range segments {
+			res = nil
+			exeOp(fill(seq), segt, &res)
+			segments[seq] = res
+		}
 	} else {
 		// ps : init segments by data corresponding to the 1st valid source key
-		it := db.bIterator(srckeys[srcIdx])
-		for ; it.Valid(); it.Next() {
-			if _, seq, err = db.bDecodeBinKey(it.RawKey()); err != nil {
-				// to do ...
-				it.Close()
-				return
-			}
-			segments[seq] = it.Value()
+		if segments, err = db.bSegments(srckeys[srcIdx]); err != nil {
+			return
 		}
-		it.Close()
 		srcIdx++
 	}
 
 	//	operation with following keys
-	var res []byte
-	for i := srcIdx; i < keyNum; i++ {
+	for i := srcIdx; i < keyNum && op != OPnot; i++ {
 		if srckeys[i] == nil {
 			continue
 		}
 
-		it := db.bIterator(srckeys[i])
-		for idx, end := uint32(0), false; !end; it.Next() {
-			end = !it.Valid()
-			if !end {
-				if _, seq, err = db.bDecodeBinKey(it.RawKey()); err != nil {
-					// to do ...
-					it.Close()
-					return
-				}
-			} else {
-				seq = maxDstSeq + 1
-			}
-
-			// todo :
-			// 		operation 'and' can be optimize here :
-			//		if seq > max_segments_idx, this loop can be break,
-			//		which can avoid cost from Key() and bDecodeBinKey()
+		var src map[uint32][]byte
+		if src, err = db.bSegments(srckeys[i]); err != nil {
+			return
+		}
 
-			for ; idx < seq; idx++ {
+		if op == OPand {
+			for seq, segt := range segments {
 				res = nil
-				exeOp(segments[idx], nil, &res)
-				segments[idx] = res
+				exeOp(segt, src[seq], &res)
+				if res == nil {
+					delete(segments, seq)
+				} else {
+					segments[seq] = res
+				}
 			}
-
-			if !end {
-				res = it.Value()
-				exeOp(segments[seq], res, &res)
+		} else {
+			for seq, segt := range src {
+				res = nil
+				exeOp(segments[seq], segt, &res)
 				segments[seq] = res
-				idx++
 			}
 		}
-		it.Close()
 	}
 
 	// clear the old data in case
@@ -841,18 +908,30 @@ func (db *DB) BOperation(op uint8, dstkey []byte, srckeys ...[]byte) (blen int32
 	//	set data
 	db.bSetMeta(t, dstkey, maxDstSeq, maxDstOff)
 
-	var bk []byte
 	for seq, segt := range segments {
-		if segt != nil {
-			bk = db.bEncodeBinKey(dstkey, uint32(seq))
-			t.Put(bk, segt)
+		db.bPutSegment(t, db.bEncodeBinKey(dstkey, seq), segt)
+	}
+
+	if op == OPnot {
+		n := 0
+		for seq := uint32(0); seq <= maxDstSeq; seq++ {
+			if _, ok := segments[seq]; ok {
+				continue
+			}
+
+			db.bPutSegment(t, db.bEncodeBinKey(dstkey, seq), fill(seq))
+			if n++; n%bitOpFillBatch == 0 {
+				if err = t.Commit(); err != nil {
+					return
+				}
+			}
 		}
 	}
 
 	err = t.Commit()
 	if err == nil {
-		// blen = int32(db.bCapByteSize(maxDstOff, maxDstOff))
-		blen = int32(maxDstSeq<<segBitWidth | maxDstOff + 1)
+		blen = int64(maxDstSeq<<segBitWidth|maxDstOff) + 1
+		db.notify(NotifyBit, "bopt", dstkey)
 	}
 
 	return
@@ -895,8 +974,7 @@ func (db *DB) BPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.binTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	n, err := db.rmExpire(t, BitType, key)
@@ -904,7 +982,9 @@ func (db *DB) BPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && n > 0 {
+		db.notify(NotifyGeneric, "persist", key)
+	}
 	return n, err
 }
 
@@ -913,10 +993,11 @@ func (db *DB) BScan(key []byte, count int, inclusive bool) ([][]byte, error) {
 }
 
 func (db *DB) bFlush() (drop int64, err error) {
-	t := db.binTx
-	t.Lock()
+	t := db.lockAllTx()
 	defer t.Unlock()
 
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{BitType})
+
 	minKey := make([]byte, 2)
 	minKey[0] = db.index
 	minKey[1] = BitType
@@ -931,3 +1012,319 @@ func (db *DB) bFlush() (drop int64, err error) {
 	err = t.Commit()
 	return
 }
+
+//	return the offset of the first bit of value bit from soff to eoff in segment, -1 if not found
+func (db *DB) bPosSeg(segment []byte, bit uint8, soff uint32, eoff uint32) int32 {
+	for idx := soff >> 3; idx <= eoff>>3; idx++ {
+		var v uint8 = 0
+		if idx < uint32(len(segment)) {
+			v = segment[idx]
+		}
+
+		if bit == 0 {
+			v = ^v
+		}
+
+		var sByteOff, eByteOff uint32 = 0, 7
+		if idx == soff>>3 {
+			sByteOff = soff & 7
+		}
+		if idx == eoff>>3 {
+			eByteOff = eoff & 7
+		}
+
+		if v &= bByteMask(sByteOff, eByteOff); bitsInByte[v] == 0 {
+			continue
+		}
+
+		for i := sByteOff; i <= eByteOff; i++ {
+			if v>>i&1 == 1 {
+				return int32(idx<<3 + i)
+			}
+		}
+	}
+
+	return -1
+}
+
+//	BPos returns the offset of the first bit of value bit from start to end,
+//	negative offsets are from the tail, -1 if not found.
+func (db *DB) BPos(key []byte, bit uint8, start int64, end int64) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return -1, err
+	} else if bit != 0 && bit != 1 {
+		return -1, errInvalidBit
+	}
+
+	if tail, err := db.BTail(key); err != nil || tail < 0 {
+		return -1, err
+	}
+
+	sseq, soff, err := db.bParseOffset(key, start)
+	if err != nil {
+		return -1, err
+	}
+
+	eseq, eoff, err := db.bParseOffset(key, end)
+	if err != nil {
+		return -1, err
+	}
+
+	if sseq > eseq || (sseq == eseq && soff > eoff) || sseq > maxSeq {
+		return -1, nil
+	}
+
+	if eseq >= maxSegCount {
+		eseq, eoff = maxSegCount-1, segBitSize-1
+	}
+
+	//	segments not exist are all zero
+	seq := sseq
+	it := db.db.RangeIterator(db.bEncodeBinKey(key, sseq), db.bEncodeBinKey(key, eseq), store.RangeClose)
+	defer it.Close()
+
+	for ; seq <= eseq; seq++ {
+		var segment []byte
+		if it.Valid() {
+			if _, itSeq, err := db.bDecodeBinKey(it.RawKey()); err != nil {
+				return -1, err
+			} else if itSeq == seq {
+				segment = it.Value()
+				it.Next()
+			} else if bit == 1 {
+				seq = itSeq - 1
+				continue
+			}
+		} else if bit == 1 {
+			break
+		}
+
+		s, e := uint32(0), segBitSize-1
+		if seq == sseq {
+			s = soff
+		}
+		if seq == eseq {
+			e = eoff
+		}
+
+		if pos := db.bPosSeg(segment, bit, s, e); pos >= 0 {
+			return int64(seq)<<segBitWidth + int64(pos), nil
+		}
+	}
+
+	return -1, nil
+}
+
+//	segments cache of a bit field operation
+type bitFieldSegs struct {
+	db    *DB
+	key   []byte
+	segs  map[uint32][]byte
+	dirty map[uint32]bool
+}
+
+func (s *bitFieldSegs) segment(seq uint32, alloc bool) ([]byte, error) {
+	if seg, ok := s.segs[seq]; ok && (seg != nil || !alloc) {
+		return seg, nil
+	}
+
+	var seg []byte
+	var err error
+	if alloc {
+		_, seg, err = s.db.bAllocateSegment(s.key, seq)
+	} else {
+		_, seg, err = s.db.bGetSegment(s.key, seq)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.segs[seq] = seg
+	return seg, nil
+}
+
+func (s *bitFieldSegs) get(offset uint32, width uint32) (uint64, error) {
+	var v uint64
+	for i := uint32(0); i < width; i++ {
+		pos := offset + i
+		seg, err := s.segment(pos>>segBitWidth, false)
+		if err != nil {
+			return 0, err
+		}
+
+		v = v<<1 | uint64(getBit(seg, pos&(segBitSize-1)))
+	}
+	return v, nil
+}
+
+func (s *bitFieldSegs) set(offset uint32, width uint32, v uint64) error {
+	for i := uint32(0); i < width; i++ {
+		pos := offset + i
+		seq := pos >> segBitWidth
+		seg, err := s.segment(seq, true)
+		if err != nil {
+			return err
+		}
+
+		setBit(seg, pos&(segBitSize-1), uint8(v>>(width-1-i)&1))
+		s.dirty[seq] = true
+	}
+	return nil
+}
+
+//	return value + incr of a signed field, ok is false if it overflows with BitOverflowFail
+func bitFieldSigned(value int64, incr int64, width uint32, overflow uint8) (int64, bool) {
+	max := int64(uint64(1)<<(width-1) - 1)
+	min := -max - 1
+
+	up := value > max || (incr > 0 && value > max-incr)
+	down := value < min || (incr < 0 && value < min-incr)
+
+	if up || down {
+		switch overflow {
+		case BitOverflowSat:
+			if up {
+				return max, true
+			}
+			return min, true
+		case BitOverflowFail:
+			return 0, false
+		}
+	}
+
+	//	wrap
+	v := uint64(value) + uint64(incr)
+	if width < 64 {
+		mask := uint64(1)<<width - 1
+		v &= mask
+		if v&(uint64(1)<<(width-1)) != 0 {
+			v |= ^mask
+		}
+	}
+	return int64(v), true
+}
+
+//	return value + incr of an unsigned field, ok is false if it overflows with BitOverflowFail
+func bitFieldUnsigned(value uint64, incr int64, width uint32, overflow uint8) (uint64, bool) {
+	max := uint64(1)<<width - 1
+
+	up := value > max || (incr > 0 && uint64(incr) > max-value)
+	down := incr < 0 && uint64(-(incr+1))+1 > value
+
+	if up || down {
+		switch overflow {
+		case BitOverflowSat:
+			if up {
+				return max, true
+			}
+			return 0, true
+		case BitOverflowFail:
+			return 0, false
+		}
+	}
+
+	return (value + uint64(incr)) & max, true
+}
+
+//	BField executes the bit field operations in order, returns the result of every operation:
+//	the value for get, the old value for set and the new value for incrby,
+//	nil if the operation fails for overflow.
+func (db *DB) BField(key []byte, fields ...BitField) ([]*int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		if f.Width == 0 || (f.Signed && f.Width > 64) || (!f.Signed && f.Width > 63) {
+			return nil, errBitFieldType
+		} else if f.Offset < 0 || uint64(f.Offset)+uint64(f.Width) > uint64(maxSeq)+1 {
+			return nil, errOffset
+		}
+	}
+
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	s := &bitFieldSegs{db: db, key: key, segs: make(map[uint32][]byte), dirty: make(map[uint32]bool)}
+
+	var tail int64 = -1
+	res := make([]*int64, len(fields))
+	for i, f := range fields {
+		offset := uint32(f.Offset)
+
+		old, err := s.get(offset, f.Width)
+		if err != nil {
+			return nil, err
+		}
+
+		var v int64
+		if f.Signed {
+			v = int64(old<<(64-f.Width)) >> (64 - f.Width)
+		} else {
+			v = int64(old)
+		}
+
+		if f.Op == BitFieldGet {
+			res[i] = &v
+			continue
+		}
+
+		var value int64
+		var ok bool
+
+		if f.Signed {
+			if f.Op == BitFieldSet {
+				value, ok = bitFieldSigned(f.Value, 0, f.Width, f.Overflow)
+			} else {
+				value, ok = bitFieldSigned(v, f.Value, f.Width, f.Overflow)
+			}
+		} else {
+			var u uint64
+			if f.Op == BitFieldSet && f.Value < 0 {
+				u, ok = bitFieldUnsigned(0, f.Value, f.Width, f.Overflow)
+			} else if f.Op == BitFieldSet {
+				u, ok = bitFieldUnsigned(uint64(f.Value), 0, f.Width, f.Overflow)
+			} else {
+				u, ok = bitFieldUnsigned(old, f.Value, f.Width, f.Overflow)
+			}
+			value = int64(u)
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err = s.set(offset, f.Width, uint64(value)); err != nil {
+			return nil, err
+		}
+
+		if last := int64(offset) + int64(f.Width) - 1; last > tail {
+			tail = last
+		}
+
+		if f.Op == BitFieldSet {
+			res[i] = &v
+		} else {
+			res[i] = &value
+		}
+	}
+
+	if len(s.dirty) == 0 {
+		return res, nil
+	}
+
+	for seq := range s.dirty {
+		db.bPutSegment(t, db.bEncodeBinKey(key, seq), s.segs[seq])
+	}
+
+	if _, _, err := db.bUpdateMeta(t, key, uint32(tail)>>segBitWidth, uint32(tail)&(segBitSize-1)); err != nil {
+		return nil, err
+	}
+
+	err := t.Commit()
+	if err == nil {
+		db.notify(NotifyBit, "bfield", key)
+	}
+	return res, err
+}
@@ -24,7 +24,7 @@ func cmpBytes(a []byte, b []byte) bool {
 	return false
 }
 
-func newBytes(bitLen int32) []byte {
+func newBytes(bitLen int64) []byte {
 	bytes := bitLen / 8
 	if bitLen%8 > 0 {
 		bytes++
@@ -42,6 +42,10 @@ func TestBinary(t *testing.T) {
 	testOpNot(t)
 	testMSetBit(t)
 	testBitExpire(t)
+	testBitSparse(t)
+	testBitPos(t)
+	testBitField(t)
+	testBitFieldOverflow(t)
 }
 
 func testSimple(t *testing.T) {
@@ -83,7 +87,7 @@ func testSimple(t *testing.T) {
 		t.Error(data)
 	}
 
-	if tail, _ := db.BTail(key); tail != int32(50) {
+	if tail, _ := db.BTail(key); tail != int64(50) {
 		t.Error(tail)
 	}
 }
@@ -94,7 +98,7 @@ func testSimpleII(t *testing.T) {
 
 	key := []byte("test_bin_2")
 
-	pos := int32(1234567)
+	pos := int64(1234567)
 	if ori, _ := db.BSetBit(key, pos, 1); ori != 0 {
 		t.Error(ori)
 	}
@@ -252,30 +256,30 @@ func testOpAndOr(t *testing.T) {
 			...
 	*/
 	// (k0 - seg:0)
-	db.BSetBit(k0, int32(0), 1)
-	db.BSetBit(k0, int32(segBitSize-1), 1)
+	db.BSetBit(k0, int64(0), 1)
+	db.BSetBit(k0, int64(segBitSize-1), 1)
 	// (k0 - seg:2)
 	pos := segBitSize*2 + segBitSize/2
 	for i := uint32(0); i < 8; i++ {
-		db.BSetBit(k0, int32(pos+i), 1)
+		db.BSetBit(k0, int64(pos+i), 1)
 	}
 	// (k0 - seg:3)
 	pos = segBitSize * 3
-	db.BSetBit(k0, int32(pos+8), 1)
-	db.BSetBit(k0, int32(pos+15), 1)
+	db.BSetBit(k0, int64(pos+8), 1)
+	db.BSetBit(k0, int64(pos+15), 1)
 	for i := uint32(1); i < 8; i += 2 {
-		db.BSetBit(k0, int32(pos+i), 1)
+		db.BSetBit(k0, int64(pos+i), 1)
 	}
 	pos = segBitSize*4 - 8
 	for i := uint32(0); i < 8; i += 2 {
-		db.BSetBit(k0, int32(pos+i), 1)
+		db.BSetBit(k0, int64(pos+i), 1)
 	}
 	// (k0 - seg:4)
-	db.BSetBit(k0, int32(segBitSize*5-1), 1)
+	db.BSetBit(k0, int64(segBitSize*5-1), 1)
 	// (k0 - seg:5)
-	db.BSetBit(k0, int32(segBitSize*5), 1)
-	db.BSetBit(k0, int32(segBitSize*5+8), 1)
-	db.BSetBit(k0, int32(segBitSize*5+9), 1)
+	db.BSetBit(k0, int64(segBitSize*5), 1)
+	db.BSetBit(k0, int64(segBitSize*5+8), 1)
+	db.BSetBit(k0, int64(segBitSize*5+9), 1)
 
 	/*
 		<k1>
@@ -286,18 +290,18 @@ func testOpAndOr(t *testing.T) {
 			...
 	*/
 	// (k1 - seg:1)
-	db.BSetBit(k1, int32(segBitSize+7), 1)
-	db.BSetBit(k1, int32(segBitSize*2-8), 1)
+	db.BSetBit(k1, int64(segBitSize+7), 1)
+	db.BSetBit(k1, int64(segBitSize*2-8), 1)
 	// (k1 - seg:3)
 	pos = segBitSize * 3
-	db.BSetBit(k1, int32(pos+8), 1)
-	db.BSetBit(k1, int32(pos+15), 1)
+	db.BSetBit(k1, int64(pos+8), 1)
+	db.BSetBit(k1, int64(pos+15), 1)
 	for i := uint32(0); i < 8; i += 2 {
-		db.BSetBit(k0, int32(pos+i), 1)
+		db.BSetBit(k0, int64(pos+i), 1)
 	}
 	pos = segBitSize*4 - 8
 	for i := uint32(1); i < 8; i += 2 {
-		db.BSetBit(k0, int32(pos+i), 1)
+		db.BSetBit(k0, int64(pos+i), 1)
 	}
 
 	var stdData []byte
@@ -391,13 +395,13 @@ func testOpXor(t *testing.T) {
 	reqs := make([]BitPair, 4)
 	reqs[0] = BitPair{0, 1}
 	reqs[1] = BitPair{7, 1}
-	reqs[2] = BitPair{int32(segBitSize - 1), 1}
-	reqs[3] = BitPair{int32(segBitSize - 8), 1}
+	reqs[2] = BitPair{int64(segBitSize - 1), 1}
+	reqs[3] = BitPair{int64(segBitSize - 8), 1}
 	db.BMSetBit(k0, reqs...)
 
 	reqs = make([]BitPair, 2)
 	reqs[0] = BitPair{7, 1}
-	reqs[1] = BitPair{int32(segBitSize - 8), 1}
+	reqs[1] = BitPair{int64(segBitSize - 8), 1}
 	db.BMSetBit(k1, reqs...)
 
 	var stdData []byte
@@ -426,15 +430,15 @@ func testOpNot(t *testing.T) {
 	k0 := []byte("op_not_0")
 	srcKeys := [][]byte{k0}
 
-	db.BSetBit(k0, int32(0), 1)
-	db.BSetBit(k0, int32(7), 1)
+	db.BSetBit(k0, int64(0), 1)
+	db.BSetBit(k0, int64(7), 1)
 
 	pos := segBitSize
 	for i := uint32(8); i >= 1; i -= 2 {
-		db.BSetBit(k0, int32(pos-i), 1)
+		db.BSetBit(k0, int64(pos-i), 1)
 	}
 
-	db.BSetBit(k0, int32(3*segBitSize-10), 1)
+	db.BSetBit(k0, int64(3*segBitSize-10), 1)
 
 	//	std
 	stdData := make([]byte, segByteSize*3-1)
@@ -468,6 +472,25 @@ func testOpNot(t *testing.T) {
 	if cnt, _ := db.BCount(dstKey, 0, -1); cnt != 3 {
 		t.Fatal(cnt)
 	}
+
+	//	a sparse source in place, the fill segments are put in several commits
+	k2 := []byte("op_not_sparse")
+	tail := int64(2*bitOpFillBatch+10) * int64(segBitSize)
+
+	db.BSetBit(k2, 5, 1)
+	db.BSetBit(k2, tail, 1)
+
+	if blen, _ := db.BOperation(OPnot, k2, k2); blen != tail+1 {
+		t.Fatal(blen)
+	} else if cnt, _ := db.BCount(k2, 0, -1); cnt != tail-1 {
+		t.Fatal(cnt)
+	}
+
+	for _, p := range []BitPair{{0, 1}, {5, 0}, {tail - 1, 1}, {tail, 0}} {
+		if v, _ := db.BGetBit(k2, p.Pos); v != p.Val {
+			t.Fatal(p, v)
+		}
+	}
 }
 
 func testMSetBit(t *testing.T) {
@@ -483,10 +506,10 @@ func testMSetBit(t *testing.T) {
 	datas[1] = BitPair{11, 1}
 	datas[2] = BitPair{10, 1}
 	datas[3] = BitPair{2, 1}
-	datas[4] = BitPair{int32(segBitSize - 1), 1}
-	datas[5] = BitPair{int32(segBitSize), 1}
-	datas[6] = BitPair{int32(segBitSize + 1), 1}
-	datas[7] = BitPair{int32(segBitSize) + 10, 0}
+	datas[4] = BitPair{int64(segBitSize - 1), 1}
+	datas[5] = BitPair{int64(segBitSize), 1}
+	datas[6] = BitPair{int64(segBitSize + 1), 1}
+	datas[7] = BitPair{int64(segBitSize) + 10, 0}
 
 	db.BMSetBit(key, datas...)
 
@@ -494,7 +517,7 @@ func testMSetBit(t *testing.T) {
 		t.Error(sum)
 	}
 
-	if tail, _ := db.BTail(key); tail != int32(segBitSize+10) {
+	if tail, _ := db.BTail(key); tail != int64(segBitSize+10) {
 		t.Error(tail)
 	}
 
@@ -502,8 +525,8 @@ func testMSetBit(t *testing.T) {
 	datas = make([]BitPair, 5)
 
 	datas[0] = BitPair{1000, 0}
-	datas[1] = BitPair{int32(segBitSize + 1), 0}
-	datas[2] = BitPair{int32(segBitSize * 10), 1}
+	datas[1] = BitPair{int64(segBitSize + 1), 0}
+	datas[2] = BitPair{int64(segBitSize * 10), 1}
 	datas[3] = BitPair{10, 0}
 	datas[4] = BitPair{99, 0}
 
@@ -513,7 +536,7 @@ func testMSetBit(t *testing.T) {
 		t.Error(sum)
 	}
 
-	if tail, _ := db.BTail(key); tail != int32(segBitSize*10) {
+	if tail, _ := db.BTail(key); tail != int64(segBitSize*10) {
 		t.Error(tail)
 	}
 
@@ -536,3 +559,254 @@ func testBitExpire(t *testing.T) {
 		t.Fatal(false)
 	}
 }
+
+func testBitPos(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("test_b_pos")
+	db.BDelete(key)
+
+	if pos, err := db.BPos(key, 1, 0, -1); err != nil || pos != -1 {
+		t.Fatal(pos, err)
+	}
+
+	db.BSetBit(key, 0, 1)
+	db.BSetBit(key, 1, 1)
+	db.BSetBit(key, int64(segBitSize*3+5), 1)
+
+	if pos, _ := db.BPos(key, 1, 0, -1); pos != 0 {
+		t.Error(pos)
+	}
+
+	if pos, _ := db.BPos(key, 0, 0, -1); pos != 2 {
+		t.Error(pos)
+	}
+
+	//	across the missing segments
+	if pos, _ := db.BPos(key, 1, 2, -1); pos != int64(segBitSize*3+5) {
+		t.Error(pos)
+	}
+
+	if pos, _ := db.BPos(key, 0, int64(segBitSize-2), -1); pos != int64(segBitSize-2) {
+		t.Error(pos)
+	}
+
+	if pos, _ := db.BPos(key, 1, -3, -1); pos != int64(segBitSize*3+5) {
+		t.Error(pos)
+	}
+
+	if pos, _ := db.BPos(key, 1, 2, int64(segBitSize*3+4)); pos != -1 {
+		t.Error(pos)
+	}
+
+	if pos, _ := db.BPos(key, 1, 10, 5); pos != -1 {
+		t.Error(pos)
+	}
+
+	if _, err := db.BPos(key, 2, 0, -1); err == nil {
+		t.Error("must error")
+	}
+}
+
+func testBitField(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("test_b_field")
+	db.BDelete(key)
+
+	//	the field crosses the segment boundary
+	offset := int64(segBitSize - 6)
+
+	if v, err := db.BField(key, BitField{Op: BitFieldSet, Width: 16, Offset: offset, Value: 0xABCD}); err != nil {
+		t.Fatal(err)
+	} else if *v[0] != 0 {
+		t.Fatal(*v[0])
+	}
+
+	if v, err := db.BField(key, BitField{Op: BitFieldGet, Width: 16, Offset: offset},
+		BitField{Op: BitFieldGet, Width: 8, Offset: offset},
+		BitField{Op: BitFieldGet, Signed: true, Width: 8, Offset: offset + 8}); err != nil {
+		t.Fatal(err)
+	} else if *v[0] != 0xABCD || *v[1] != 0xAB || *v[2] != -51 {
+		t.Fatal(*v[0], *v[1], *v[2])
+	}
+
+	//	the most significant bit is at the lowest offset
+	if b, _ := db.BGetBit(key, offset); b != 1 {
+		t.Error(b)
+	}
+
+	if b, _ := db.BGetBit(key, offset+1); b != 0 {
+		t.Error(b)
+	}
+
+	if tail, _ := db.BTail(key); tail != offset+15 {
+		t.Error(tail)
+	}
+
+	if v, err := db.BField(key, BitField{Op: BitFieldIncrBy, Width: 16, Offset: offset, Value: 0x10}); err != nil {
+		t.Fatal(err)
+	} else if *v[0] != 0xABDD {
+		t.Fatal(*v[0])
+	}
+
+	if v, err := db.BField(key, BitField{Op: BitFieldSet, Signed: true, Width: 64, Offset: 100, Value: -2},
+		BitField{Op: BitFieldGet, Signed: true, Width: 64, Offset: 100}); err != nil {
+		t.Fatal(err)
+	} else if *v[0] != 0 || *v[1] != -2 {
+		t.Fatal(*v[0], *v[1])
+	}
+
+	if _, err := db.BField(key, BitField{Op: BitFieldGet, Width: 64, Offset: 0}); err == nil {
+		t.Error("must error")
+	}
+
+	if _, err := db.BField(key, BitField{Op: BitFieldGet, Width: 8, Offset: int64(maxSeq)}); err == nil {
+		t.Error("must error")
+	}
+}
+
+func testBitFieldOverflow(t *testing.T) {
+	type overflowCase struct {
+		signed   bool
+		width    uint32
+		value    int64
+		incr     int64
+		overflow uint8
+		res      int64
+		ok       bool
+	}
+
+	cases := []overflowCase{
+		{true, 8, 100, 100, BitOverflowWrap, -56, true},
+		{true, 8, 100, 100, BitOverflowSat, 127, true},
+		{true, 8, 100, 100, BitOverflowFail, 0, false},
+		{true, 8, -100, -100, BitOverflowWrap, 56, true},
+		{true, 8, -100, -100, BitOverflowSat, -128, true},
+		{true, 8, 300, 0, BitOverflowWrap, 44, true},
+		{true, 64, 1<<63 - 1, 1, BitOverflowWrap, -1 << 63, true},
+		{true, 64, 1<<63 - 1, 1, BitOverflowSat, 1<<63 - 1, true},
+		{false, 8, 200, 100, BitOverflowWrap, 44, true},
+		{false, 8, 200, 100, BitOverflowSat, 255, true},
+		{false, 8, 200, 100, BitOverflowFail, 0, false},
+		{false, 8, 10, -20, BitOverflowWrap, 246, true},
+		{false, 8, 10, -20, BitOverflowSat, 0, true},
+		{false, 8, 10, -20, BitOverflowFail, 0, false},
+		{false, 8, 256, 0, BitOverflowSat, 255, true},
+	}
+
+	for i, c := range cases {
+		var res int64
+		var ok bool
+		if c.signed {
+			res, ok = bitFieldSigned(c.value, c.incr, c.width, c.overflow)
+		} else {
+			var u uint64
+			u, ok = bitFieldUnsigned(uint64(c.value), c.incr, c.width, c.overflow)
+			res = int64(u)
+		}
+
+		if ok != c.ok || (ok && res != c.res) {
+			t.Errorf("case %d: %d %v", i, res, ok)
+		}
+	}
+
+	db := getTestDB()
+
+	key := []byte("test_b_field_overflow")
+	db.BDelete(key)
+
+	if v, err := db.BField(key, BitField{Op: BitFieldSet, Width: 4, Offset: 0, Value: 15},
+		BitField{Op: BitFieldIncrBy, Width: 4, Offset: 0, Value: 1, Overflow: BitOverflowFail},
+		BitField{Op: BitFieldIncrBy, Width: 4, Offset: 0, Value: 1}); err != nil {
+		t.Fatal(err)
+	} else if *v[0] != 0 || v[1] != nil || *v[2] != 0 {
+		t.Fatal(v)
+	}
+}
+
+func testBitSparse(t *testing.T) {
+	db := getTestDB()
+
+	segNum := func(key []byte) int {
+		it := db.bIterator(key)
+		defer it.Close()
+
+		n := 0
+		for ; it.Valid(); it.Next() {
+			n++
+		}
+		return n
+	}
+
+	k0 := []byte("test_b_sparse_0")
+	k1 := []byte("test_b_sparse_1")
+	dst := []byte("test_b_sparse_dst")
+	db.BDelete(k0)
+	db.BDelete(k1)
+
+	last := int64(1)<<32 - 1
+
+	if _, err := db.BSetBit(k0, last+1, 1); err == nil {
+		t.Error("must error")
+	}
+
+	db.BSetBit(k0, 10, 1)
+	db.BSetBit(k0, last, 1)
+	db.BSetBit(k1, last, 1)
+	db.BSetBit(k1, int64(segBitSize)*1000, 1)
+
+	if n := segNum(k0); n != 2 {
+		t.Error(n)
+	}
+
+	if tail, _ := db.BTail(k0); tail != last {
+		t.Error(tail)
+	}
+
+	if v, _ := db.BGetBit(k0, -1); v != 1 {
+		t.Error(v)
+	}
+
+	if cnt, _ := db.BCount(k0, 0, -1); cnt != 2 {
+		t.Error(cnt)
+	}
+
+	if cnt, _ := db.BCount(k0, 11, -2); cnt != 0 {
+		t.Error(cnt)
+	}
+
+	if blen, _ := db.BOperation(OPand, dst, k0, k1); blen != last+1 {
+		t.Error(blen)
+	}
+
+	if cnt, _ := db.BCount(dst, 0, -1); cnt != 1 {
+		t.Error(cnt)
+	} else if n := segNum(dst); n != 1 {
+		t.Error(n)
+	}
+
+	db.BOperation(OPor, dst, k0, k1)
+	if cnt, _ := db.BCount(dst, 0, -1); cnt != 3 {
+		t.Error(cnt)
+	} else if n := segNum(dst); n != 3 {
+		t.Error(n)
+	}
+
+	//	the same bits are cleared, and the empty segment is not stored
+	db.BOperation(OPxor, dst, k0, k1)
+	if cnt, _ := db.BCount(dst, 0, -1); cnt != 2 {
+		t.Error(cnt)
+	} else if n := segNum(dst); n != 2 {
+		t.Error(n)
+	}
+
+	if pos, _ := db.BPos(dst, 1, 11, -1); pos != int64(segBitSize)*1000 {
+		t.Error(pos)
+	}
+
+	db.BSetBit(k0, 10, 0)
+	if n := segNum(k0); n != 1 {
+		t.Error(n)
+	}
+}
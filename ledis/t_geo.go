@@ -0,0 +1,466 @@
+package ledis
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+/*
+Geo commands work on the zset type, the score of a member is the 52 bits geohash
+of its location:
+
+	longitude and latitude are both quantized to 26 bits, and interleaved with
+	longitude at the odd bits and latitude at the even bits.
+
+All the locations in a geohash cell of step s (2*s bits) are in the score range
+[hash << (52 - 2*s), (hash + 1) << (52 - 2*s)), so a radius or box search queries
+the cell covering the center and its eight neighbours by score, then filters the
+members by distance.
+*/
+
+const (
+	GeoLongitudeMin float64 = -180
+	GeoLongitudeMax float64 = 180
+	GeoLatitudeMin  float64 = -85.05112878
+	GeoLatitudeMax  float64 = 85.05112878
+
+	geoStepMax = 26
+
+	geoEarthRadius  float64 = 6372797.560856
+	geoMercatorMax  float64 = 20037726.37
+	geoHashAlphabet string  = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+const (
+	GeoSortNone = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+var ErrGeoLocation = errors.New("invalid longitude,latitude pair")
+
+var errGeoShape = errors.New("invalid geo search shape")
+
+type GeoMember struct {
+	Longitude float64
+	Latitude  float64
+	Member    []byte
+}
+
+type GeoPos struct {
+	Longitude float64
+	Latitude  float64
+}
+
+type GeoLocation struct {
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+	Hash      int64
+
+	//distance to the search center in meters
+	Dist float64
+}
+
+//	GeoQuery is the search condition of GeoSearch,
+//	a radius search if Radius > 0, otherwise a box search by Width and Height.
+type GeoQuery struct {
+	//	search from the location of Member if it's not nil
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+
+	//	in meters
+	Radius float64
+	Width  float64
+	Height float64
+
+	//	no limit if Count <= 0
+	Count int
+	Sort  int
+}
+
+type geoRange struct {
+	min float64
+	max float64
+}
+
+var (
+	geoLongitudeRange = geoRange{GeoLongitudeMin, GeoLongitudeMax}
+	geoLatitudeRange  = geoRange{GeoLatitudeMin, GeoLatitudeMax}
+
+	//	the standard geohash string uses the full latitude range
+	geoStdLatitudeRange = geoRange{-90, 90}
+)
+
+func checkGeoLocation(longitude float64, latitude float64) error {
+	if longitude < GeoLongitudeMin || longitude > GeoLongitudeMax ||
+		latitude < GeoLatitudeMin || latitude > GeoLatitudeMax {
+		return ErrGeoLocation
+	}
+	return nil
+}
+
+//	spread the low 32 bits of x to the even bits
+func geoSpread(x uint64) uint64 {
+	x &= 0xFFFFFFFF
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+//	squash the even bits of x to the low 32 bits
+func geoSquash(x uint64) uint64 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return x
+}
+
+func geoEncode(lonRange geoRange, latRange geoRange, longitude float64, latitude float64, step uint) uint64 {
+	lonOffset := (longitude - lonRange.min) / (lonRange.max - lonRange.min)
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+
+	//	the max value belongs to the last cell
+	cells := float64(uint64(1) << step)
+	ilon := uint64(math.Min(lonOffset*cells, cells-1))
+	ilat := uint64(math.Min(latOffset*cells, cells-1))
+
+	return geoSpread(ilat) | geoSpread(ilon)<<1
+}
+
+//	return the area of the geohash cell
+func geoDecode(hash uint64, step uint) (lon geoRange, lat geoRange) {
+	ilat := geoSquash(hash)
+	ilon := geoSquash(hash >> 1)
+
+	cells := float64(uint64(1) << step)
+	lonUnit := (GeoLongitudeMax - GeoLongitudeMin) / cells
+	latUnit := (GeoLatitudeMax - GeoLatitudeMin) / cells
+
+	lon.min = GeoLongitudeMin + float64(ilon)*lonUnit
+	lon.max = lon.min + lonUnit
+	lat.min = GeoLatitudeMin + float64(ilat)*latUnit
+	lat.max = lat.min + latUnit
+	return
+}
+
+//	return the center of the cell of a 52 bits geohash score
+func geoDecodeScore(score int64) (longitude float64, latitude float64) {
+	lon, lat := geoDecode(uint64(score), geoStepMax)
+
+	longitude = math.Max(GeoLongitudeMin, math.Min(GeoLongitudeMax, (lon.min+lon.max)/2))
+	latitude = math.Max(GeoLatitudeMin, math.Min(GeoLatitudeMax, (lat.min+lat.max)/2))
+	return
+}
+
+func geoEncodeScore(longitude float64, latitude float64) int64 {
+	return int64(geoEncode(geoLongitudeRange, geoLatitudeRange, longitude, latitude, geoStepMax))
+}
+
+//	return the 11 characters standard geohash string of a score
+func geoHashString(score int64) []byte {
+	longitude, latitude := geoDecodeScore(score)
+	hash := geoEncode(geoLongitudeRange, geoStdLatitudeRange, longitude, latitude, geoStepMax)
+
+	buf := make([]byte, 11)
+	for i := 0; i < 11; i++ {
+		idx := 0
+		if i < 10 {
+			idx = int(hash>>uint(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoHashAlphabet[idx]
+	}
+	return buf
+}
+
+func geoDegRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func geoRadDeg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+func geoLatDistance(lat1 float64, lat2 float64) float64 {
+	return geoEarthRadius * math.Abs(geoDegRad(lat2)-geoDegRad(lat1))
+}
+
+//	haversine distance in meters
+func GeoDistance(lon1 float64, lat1 float64, lon2 float64, lat2 float64) float64 {
+	lat1r := geoDegRad(lat1)
+	lon1r := geoDegRad(lon1)
+	lat2r := geoDegRad(lat2)
+	lon2r := geoDegRad(lon2)
+
+	v := math.Sin((lon2r - lon1r) / 2)
+	//	fast path for the same longitude
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(a))
+}
+
+//	the step whose cell is about the size of radius
+func geoEstimateStep(radius float64, latitude float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for radius < geoMercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+
+	//	cells get narrower near the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	} else if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
+
+//	return the bounding box of the search area
+func (q *GeoQuery) boundingBox() (lon geoRange, lat geoRange) {
+	width, height := q.Radius, q.Radius
+	if q.Radius <= 0 {
+		width, height = q.Width/2, q.Height/2
+	}
+
+	latDelta := geoRadDeg(height / geoEarthRadius)
+	lonDeltaTop := geoRadDeg(width / geoEarthRadius / math.Cos(geoDegRad(q.Latitude+latDelta)))
+	lonDeltaBottom := geoRadDeg(width / geoEarthRadius / math.Cos(geoDegRad(q.Latitude-latDelta)))
+	lonDelta := math.Max(lonDeltaTop, lonDeltaBottom)
+
+	lon = geoRange{q.Longitude - lonDelta, q.Longitude + lonDelta}
+	lat = geoRange{q.Latitude - latDelta, q.Latitude + latDelta}
+	return
+}
+
+//	return the distance to the center if the location is in the search area
+func (q *GeoQuery) contains(longitude float64, latitude float64) (float64, bool) {
+	if q.Radius > 0 {
+		d := GeoDistance(q.Longitude, q.Latitude, longitude, latitude)
+		return d, d <= q.Radius
+	}
+
+	if geoLatDistance(q.Latitude, latitude) > q.Height/2 {
+		return 0, false
+	} else if GeoDistance(q.Longitude, latitude, longitude, latitude) > q.Width/2 {
+		return 0, false
+	}
+
+	return GeoDistance(q.Longitude, q.Latitude, longitude, latitude), true
+}
+
+//	return the score ranges of the cell covering the center and its neighbours
+func (q *GeoQuery) scoreRanges() [][2]int64 {
+	radius := q.Radius
+	if radius <= 0 {
+		radius = math.Sqrt(q.Width*q.Width+q.Height*q.Height) / 2
+	}
+
+	lonBox, latBox := q.boundingBox()
+
+	step := geoEstimateStep(radius, q.Latitude)
+
+	var cells []uint64
+	for {
+		center := geoEncode(geoLongitudeRange, geoLatitudeRange, q.Longitude, q.Latitude, step)
+		lon, lat := geoDecode(center, step)
+		lonUnit := lon.max - lon.min
+		latUnit := lat.max - lat.min
+
+		cells = cells[0:0]
+		covered := true
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				cellLon := (lon.min+lon.max)/2 + float64(dx)*lonUnit
+				cellLat := (lat.min+lat.max)/2 + float64(dy)*latUnit
+
+				if cellLat < GeoLatitudeMin || cellLat > GeoLatitudeMax {
+					//	no neighbour beyond the poles
+					continue
+				}
+
+				//	wrap around the antimeridian
+				if cellLon < GeoLongitudeMin {
+					cellLon += 360
+				} else if cellLon > GeoLongitudeMax {
+					cellLon -= 360
+				}
+
+				cells = append(cells, geoEncode(geoLongitudeRange, geoLatitudeRange, cellLon, cellLat, step))
+			}
+		}
+
+		//	the neighbours must cover the bounding box, otherwise use bigger cells
+		if lat.max+latUnit < latBox.max && lat.max+latUnit < GeoLatitudeMax {
+			covered = false
+		} else if lat.min-latUnit > latBox.min && lat.min-latUnit > GeoLatitudeMin {
+			covered = false
+		} else if lonUnit*3 < 360 && (lon.max+lonUnit < lonBox.max || lon.min-lonUnit > lonBox.min) {
+			covered = false
+		}
+
+		if covered || step == 1 {
+			break
+		}
+		step--
+	}
+
+	shift := uint(geoStepMax*2 - step*2)
+	ranges := make([][2]int64, 0, len(cells))
+
+	sort.Sort(geoCells(cells))
+	for i, c := range cells {
+		if i > 0 && c == cells[i-1] {
+			continue
+		}
+
+		min := int64(c << shift)
+		max := int64((c+1)<<shift) - 1
+		ranges = append(ranges, [2]int64{min, max})
+	}
+
+	return ranges
+}
+
+type geoCells []uint64
+
+func (c geoCells) Len() int           { return len(c) }
+func (c geoCells) Less(i, j int) bool { return c[i] < c[j] }
+func (c geoCells) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+type geoLocations []GeoLocation
+
+func (l geoLocations) Len() int           { return len(l) }
+func (l geoLocations) Less(i, j int) bool { return l[i].Dist < l[j].Dist }
+func (l geoLocations) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+//	GeoAdd adds members with locations to the zset at key, returns the number of new members.
+func (db *DB) GeoAdd(key []byte, args ...GeoMember) (int64, error) {
+	pairs := make([]ScorePair, len(args))
+	for i, m := range args {
+		if err := checkGeoLocation(m.Longitude, m.Latitude); err != nil {
+			return 0, err
+		}
+
+		pairs[i] = ScorePair{Score: geoEncodeScore(m.Longitude, m.Latitude), Member: m.Member}
+	}
+
+	return db.ZAdd(key, pairs...)
+}
+
+//	GeoPos returns the locations of members, nil for the member not exists.
+func (db *DB) GeoPos(key []byte, members ...[]byte) ([]*GeoPos, error) {
+	v := make([]*GeoPos, len(members))
+	for i, m := range members {
+		score, err := db.ZScore(key, m)
+		if err == ErrScoreMiss {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		p := new(GeoPos)
+		p.Longitude, p.Latitude = geoDecodeScore(score)
+		v[i] = p
+	}
+
+	return v, nil
+}
+
+//	GeoDist returns the distance between two members in meters, ErrScoreMiss if any not exists.
+func (db *DB) GeoDist(key []byte, member1 []byte, member2 []byte) (float64, error) {
+	v, err := db.GeoPos(key, member1, member2)
+	if err != nil {
+		return 0, err
+	} else if v[0] == nil || v[1] == nil {
+		return 0, ErrScoreMiss
+	}
+
+	return GeoDistance(v[0].Longitude, v[0].Latitude, v[1].Longitude, v[1].Latitude), nil
+}
+
+//	GeoHash returns the standard geohash strings of members, nil for the member not exists.
+func (db *DB) GeoHash(key []byte, members ...[]byte) ([][]byte, error) {
+	v := make([][]byte, len(members))
+	for i, m := range members {
+		score, err := db.ZScore(key, m)
+		if err == ErrScoreMiss {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		v[i] = geoHashString(score)
+	}
+
+	return v, nil
+}
+
+//	GeoSearch returns the members in the area of q.
+func (db *DB) GeoSearch(key []byte, q *GeoQuery) ([]GeoLocation, error) {
+	if q.Radius <= 0 && (q.Width <= 0 || q.Height <= 0) {
+		return nil, errGeoShape
+	}
+
+	if q.Member != nil {
+		score, err := db.ZScore(key, q.Member)
+		if err != nil {
+			return nil, err
+		}
+		q.Longitude, q.Latitude = geoDecodeScore(score)
+	} else if err := checkGeoLocation(q.Longitude, q.Latitude); err != nil {
+		return nil, err
+	}
+
+	v := make(geoLocations, 0, 16)
+	for _, r := range q.scoreRanges() {
+		pairs, err := db.zRange(key, r[0], r[1], 0, -1, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range pairs {
+			lon, lat := geoDecodeScore(p.Score)
+			if d, ok := q.contains(lon, lat); ok {
+				v = append(v, GeoLocation{Member: p.Member, Longitude: lon, Latitude: lat, Hash: p.Score, Dist: d})
+			}
+		}
+	}
+
+	//	like redis, COUNT returns the nearest ones if no sort is given
+	if q.Sort == GeoSortAsc || (q.Sort == GeoSortNone && q.Count > 0) {
+		sort.Stable(v)
+	} else if q.Sort == GeoSortDesc {
+		sort.Stable(sort.Reverse(v))
+	}
+
+	if q.Count > 0 && len(v) > q.Count {
+		v = v[0:q.Count]
+	}
+
+	return v, nil
+}
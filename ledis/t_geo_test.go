@@ -0,0 +1,94 @@
+package ledis
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestGeoCodec(t *testing.T) {
+	score := geoEncodeScore(13.361389, 38.115556)
+	if lon, lat := geoDecodeScore(score); math.Abs(lon-13.361389) > 0.00001 || math.Abs(lat-38.115556) > 0.00001 {
+		t.Fatal(lon, lat)
+	}
+
+	if s := string(geoHashString(score)); s != "sqc8b49rny0" {
+		t.Fatal(s)
+	}
+
+	if s := string(geoHashString(geoEncodeScore(15.087269, 37.502669))); s != "sqdtr74hyu0" {
+		t.Fatal(s)
+	}
+
+	if d := GeoDistance(13.361389, 38.115556, 15.087269, 37.502669); math.Abs(d-166274.15) > 1 {
+		t.Fatal(d)
+	}
+}
+
+func TestDBGeo(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("testdb_geo_a")
+
+	if _, err := db.GeoAdd(key, GeoMember{200, 10, []byte("bad")}); err != ErrGeoLocation {
+		t.Fatal(err)
+	}
+
+	if n, err := db.GeoAdd(key,
+		GeoMember{13.361389, 38.115556, []byte("Palermo")},
+		GeoMember{15.087269, 37.502669, []byte("Catania")},
+		GeoMember{12.758489, 38.788135, []byte("edge1")},
+		GeoMember{17.241510, 38.788135, []byte("edge2")}); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatal(n)
+	}
+
+	if d, err := db.GeoDist(key, []byte("Palermo"), []byte("Catania")); err != nil {
+		t.Fatal(err)
+	} else if math.Abs(d-166274.1516) > 0.1 {
+		t.Fatal(d)
+	}
+
+	if _, err := db.GeoDist(key, []byte("Palermo"), []byte("none")); err != ErrScoreMiss {
+		t.Fatal(err)
+	}
+
+	if v, err := db.GeoPos(key, []byte("Palermo"), []byte("none")); err != nil {
+		t.Fatal(err)
+	} else if v[1] != nil || math.Abs(v[0].Longitude-13.361389) > 0.00001 {
+		t.Fatal(v)
+	}
+
+	check := func(q *GeoQuery, members ...string) {
+		v, err := db.GeoSearch(key, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := make([]string, len(v))
+		for i, l := range v {
+			s[i] = string(l.Member)
+		}
+
+		if fmt.Sprint(s) != fmt.Sprint(members) {
+			t.Fatal(s, members)
+		}
+	}
+
+	check(&GeoQuery{Longitude: 15, Latitude: 37, Radius: 200000, Sort: GeoSortAsc}, "Catania", "Palermo")
+	check(&GeoQuery{Longitude: 15, Latitude: 37, Radius: 200000, Sort: GeoSortDesc}, "Palermo", "Catania")
+	check(&GeoQuery{Longitude: 15, Latitude: 37, Radius: 100000}, "Catania")
+	check(&GeoQuery{Longitude: 15, Latitude: 37, Radius: 200000, Count: 1}, "Catania")
+	check(&GeoQuery{Longitude: 15, Latitude: 37, Width: 400000, Height: 400000, Sort: GeoSortAsc}, "Catania", "Palermo", "edge2", "edge1")
+	check(&GeoQuery{Member: []byte("Palermo"), Radius: 1000}, "Palermo")
+
+	if _, err := db.GeoSearch(key, &GeoQuery{Member: []byte("none"), Radius: 1000}); err != ErrScoreMiss {
+		t.Fatal(err)
+	}
+
+	//	search across the antimeridian
+	key = []byte("testdb_geo_b")
+	db.GeoAdd(key, GeoMember{179.99, 0, []byte("east")}, GeoMember{-179.99, 0, []byte("west")})
+	check(&GeoQuery{Longitude: 180, Latitude: 0, Radius: 10000, Sort: GeoSortAsc}, "east", "west")
+}
@@ -106,8 +106,7 @@ func (db *DB) hEncodeStopKey(key []byte) []byte {
 	return k
 }
 
-func (db *DB) hSetItem(key []byte, field []byte, value []byte) (int64, error) {
-	t := db.hashTx
+func (db *DB) hSetItem(t *tx, key []byte, field []byte, value []byte) (int64, error) {
 
 	ek := db.hEncodeHashKey(key, field)
 
@@ -115,7 +114,7 @@ func (db *DB) hSetItem(key []byte, field []byte, value []byte) (int64, error) {
 	if v, _ := db.db.Get(ek); v != nil {
 		n = 0
 	} else {
-		if _, err := db.hIncrSize(key, 1); err != nil {
+		if _, err := db.hIncrSize(t, key, 1); err != nil {
 			return 0, err
 		}
 	}
@@ -140,12 +139,12 @@ func (db *DB) hDelete(t *tx, key []byte) int64 {
 	it.Close()
 
 	t.Delete(sk)
+	db.releaseKeyType(t, HashType, key)
 	return num
 }
 
 func (db *DB) hExpireAt(key []byte, when int64) (int64, error) {
-	t := db.hashTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	if hlen, err := db.HLen(key); err != nil || hlen == 0 {
@@ -155,6 +154,7 @@ func (db *DB) hExpireAt(key []byte, when int64) (int64, error) {
 		if err := t.Commit(); err != nil {
 			return 0, err
 		}
+		db.notify(NotifyGeneric, "expire", key)
 	}
 	return 1, nil
 }
@@ -174,34 +174,44 @@ func (db *DB) HSet(key []byte, field []byte, value []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.hashTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
-	n, err := db.hSetItem(key, field, value)
+	if err := db.reclaimTrash(t, HashType, key); err != nil {
+		return 0, err
+	}
+
+	n, err := db.hSetItem(t, key, field, value)
 	if err != nil {
 		return 0, err
 	}
 
 	//todo add binlog
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		db.notify(NotifyHash, "hset", key)
+	}
 	return n, err
 }
 
 func (db *DB) HGet(key []byte, field []byte) ([]byte, error) {
 	if err := checkHashKFSize(key, field); err != nil {
 		return nil, err
+	} else if db.trashed(HashType, key) {
+		return nil, nil
 	}
 
 	return db.db.Get(db.hEncodeHashKey(key, field))
 }
 
 func (db *DB) HMset(key []byte, args ...FVPair) error {
-	t := db.hashTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, HashType, key); err != nil {
+		return err
+	}
+
 	var err error
 	var ek []byte
 	var num int64 = 0
@@ -223,16 +233,22 @@ func (db *DB) HMset(key []byte, args ...FVPair) error {
 		t.Put(ek, args[i].Value)
 	}
 
-	if _, err = db.hIncrSize(key, num); err != nil {
+	if _, err = db.hIncrSize(t, key, num); err != nil {
 		return err
 	}
 
 	//todo add binglog
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		db.notify(NotifyHash, "hset", key)
+	}
 	return err
 }
 
 func (db *DB) HMget(key []byte, args ...[]byte) ([][]byte, error) {
+	if db.trashed(HashType, key) {
+		return make([][]byte, len(args)), nil
+	}
+
 	var ek []byte
 
 	it := db.db.NewIterator()
@@ -253,15 +269,17 @@ func (db *DB) HMget(key []byte, args ...[]byte) ([][]byte, error) {
 }
 
 func (db *DB) HDel(key []byte, args ...[]byte) (int64, error) {
-	t := db.hashTx
-
 	var ek []byte
 	var v []byte
 	var err error
 
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, HashType, key); err != nil {
+		return 0, err
+	}
+
 	it := db.db.NewIterator()
 	defer it.Close()
 
@@ -282,17 +300,18 @@ func (db *DB) HDel(key []byte, args ...[]byte) (int64, error) {
 		}
 	}
 
-	if _, err = db.hIncrSize(key, -num); err != nil {
+	if _, err = db.hIncrSize(t, key, -num); err != nil {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && num > 0 {
+		db.notify(NotifyHash, "hdel", key)
+	}
 
 	return num, err
 }
 
-func (db *DB) hIncrSize(key []byte, delta int64) (int64, error) {
-	t := db.hashTx
+func (db *DB) hIncrSize(t *tx, key []byte, delta int64) (int64, error) {
 	sk := db.hEncodeSizeKey(key)
 
 	var err error
@@ -305,8 +324,10 @@ func (db *DB) hIncrSize(key []byte, delta int64) (int64, error) {
 			size = 0
 			t.Delete(sk)
 			db.rmExpire(t, HashType, key)
+			db.releaseKeyType(t, HashType, key)
 		} else {
 			t.Put(sk, PutInt64(size))
+			db.claimKeyType(t, HashType, key)
 		}
 	}
 
@@ -318,13 +339,16 @@ func (db *DB) HIncrBy(key []byte, field []byte, delta int64) (int64, error) {
 		return 0, err
 	}
 
-	t := db.hashTx
 	var ek []byte
 	var err error
 
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, HashType, key); err != nil {
+		return 0, err
+	}
+
 	ek = db.hEncodeHashKey(key, field)
 
 	var n int64 = 0
@@ -334,12 +358,14 @@ func (db *DB) HIncrBy(key []byte, field []byte, delta int64) (int64, error) {
 
 	n += delta
 
-	_, err = db.hSetItem(key, field, StrPutInt64(n))
+	_, err = db.hSetItem(t, key, field, StrPutInt64(n))
 	if err != nil {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		db.notify(NotifyHash, "hincrby", key)
+	}
 
 	return n, err
 }
@@ -347,6 +373,8 @@ func (db *DB) HIncrBy(key []byte, field []byte, delta int64) (int64, error) {
 func (db *DB) HGetAll(key []byte) ([]FVPair, error) {
 	if err := checkKeySize(key); err != nil {
 		return nil, err
+	} else if db.trashed(HashType, key) {
+		return []FVPair{}, nil
 	}
 
 	start := db.hEncodeStartKey(key)
@@ -372,6 +400,8 @@ func (db *DB) HGetAll(key []byte) ([]FVPair, error) {
 func (db *DB) HKeys(key []byte) ([][]byte, error) {
 	if err := checkKeySize(key); err != nil {
 		return nil, err
+	} else if db.trashed(HashType, key) {
+		return [][]byte{}, nil
 	}
 
 	start := db.hEncodeStartKey(key)
@@ -396,6 +426,8 @@ func (db *DB) HKeys(key []byte) ([][]byte, error) {
 func (db *DB) HValues(key []byte) ([][]byte, error) {
 	if err := checkKeySize(key); err != nil {
 		return nil, err
+	} else if db.trashed(HashType, key) {
+		return [][]byte{}, nil
 	}
 
 	start := db.hEncodeStartKey(key)
@@ -423,20 +455,21 @@ func (db *DB) HClear(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.hashTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
-	num := db.hDelete(t, key)
+	num := db.lazyDelete(t, HashType, key, db.hDelete)
 	db.rmExpire(t, HashType, key)
 
 	err := t.Commit()
+	if err == nil && num > 0 {
+		db.notify(NotifyGeneric, "del", key)
+	}
 	return num, err
 }
 
 func (db *DB) HMclear(keys ...[]byte) (int64, error) {
-	t := db.hashTx
-	t.Lock()
+	t := db.lockTx(keys...)
 	defer t.Unlock()
 
 	for _, key := range keys {
@@ -444,11 +477,16 @@ func (db *DB) HMclear(keys ...[]byte) (int64, error) {
 			return 0, err
 		}
 
-		db.hDelete(t, key)
+		db.lazyDelete(t, HashType, key, db.hDelete)
 		db.rmExpire(t, HashType, key)
 	}
 
 	err := t.Commit()
+	if err == nil {
+		for _, key := range keys {
+			db.notify(NotifyGeneric, "del", key)
+		}
+	}
 	return int64(len(keys)), err
 }
 
@@ -461,10 +499,11 @@ func (db *DB) hFlush() (drop int64, err error) {
 	maxKey[0] = db.index
 	maxKey[1] = HSizeType + 1
 
-	t := db.kvTx
-	t.Lock()
+	t := db.lockAllTx()
 	defer t.Unlock()
 
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{HashType})
+
 	drop, err = db.flushRegion(t, minKey, maxKey)
 	err = db.expFlush(t, HashType)
 
@@ -505,8 +544,7 @@ func (db *DB) HPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.hashTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	n, err := db.rmExpire(t, HashType, key)
@@ -514,6 +552,8 @@ func (db *DB) HPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && n > 0 {
+		db.notify(NotifyGeneric, "persist", key)
+	}
 	return n, err
 }
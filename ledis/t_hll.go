@@ -0,0 +1,478 @@
+package ledis
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+/*
+A HyperLogLog is stored as one value like kv:
+
+	db index|HLLType|key -> encoding(1)|registers
+
+it has 2^14 registers of 6 bits, and two encodings:
+
+	sparse : [register index(bigendian uint16)|count(1)]..., only non zero registers in index order
+	dense  : all the registers packed, 6 bits each
+
+A new HyperLogLog is sparse and becomes dense when the sparse one gets larger
+than hllSparseMaxBytes, registers never decrease so it never gets back.
+*/
+
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisterNum = 1 << hllP
+	hllBits        = 6
+	hllMaxCount    = 1<<hllBits - 1
+
+	hllDenseSize = (hllRegisterNum*hllBits + 7) / 8
+
+	hllSparseMaxBytes = 3000
+
+	hllSparse byte = 0
+	hllDense  byte = 1
+)
+
+var errHLLKey = errors.New("invalid hyperloglog key")
+var errHLLValue = errors.New("invalid hyperloglog value")
+
+type hllRegisters []uint8
+
+func newHLLRegisters() hllRegisters {
+	return make(hllRegisters, hllRegisterNum)
+}
+
+// MurmurHash64A
+func hllHash(data []byte) uint64 {
+	const m uint64 = 0xc6a4a7935bd1e995
+	const r = 47
+
+	var h uint64 = 0xadc83b19 ^ uint64(len(data))*m
+
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		var k uint64
+		for i := len(data) - 1; i >= 0; i-- {
+			k = k<<8 | uint64(data[i])
+		}
+		h ^= k
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// return the register index and count for element
+func hllPatLen(element []byte) (int, uint8) {
+	h := hllHash(element)
+	index := int(h & (hllRegisterNum - 1))
+
+	h >>= hllP
+	h |= 1 << hllQ
+
+	var count uint8 = 1
+	for h&1 == 0 {
+		count++
+		h >>= 1
+	}
+	return index, count
+}
+
+// add element, return true if a register is changed
+func (r hllRegisters) add(element []byte) bool {
+	index, count := hllPatLen(element)
+	if count > r[index] {
+		r[index] = count
+		return true
+	}
+	return false
+}
+
+func (r hllRegisters) merge(o hllRegisters) {
+	for i, c := range o {
+		if c > r[i] {
+			r[i] = c
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	var zPrime float64
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime = z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			break
+		}
+	}
+	return z / 3
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	var zPrime float64
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime = z
+		z += x * y
+		y += y
+		if zPrime == z {
+			break
+		}
+	}
+	return z
+}
+
+// cardinality estimation by Otmar Ertl, "New cardinality estimation algorithms for HyperLogLog sketches"
+func (r hllRegisters) count() int64 {
+	var histogram [hllQ + 2]int
+	for _, c := range r {
+		histogram[c]++
+	}
+
+	m := float64(hllRegisterNum)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for k := hllQ; k >= 1; k-- {
+		z += float64(histogram[k])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	return int64(math.Floor(0.5/math.Ln2*m*m/z + 0.5))
+}
+
+func (r hllRegisters) encode() []byte {
+	n := 0
+	for _, c := range r {
+		if c > 0 {
+			n++
+		}
+	}
+
+	if n*3 <= hllSparseMaxBytes {
+		buf := make([]byte, 1, 1+n*3)
+		buf[0] = hllSparse
+		for i, c := range r {
+			if c > 0 {
+				buf = append(buf, byte(i>>8), byte(i), c)
+			}
+		}
+		return buf
+	}
+
+	//	one more byte for the last register across bytes
+	buf := make([]byte, 1+hllDenseSize+1)
+	buf[0] = hllDense
+	dense := buf[1:]
+	for i, c := range r {
+		pos := i * hllBits / 8
+		off := uint(i * hllBits % 8)
+		dense[pos] |= c << off
+		dense[pos+1] |= c >> (8 - off)
+	}
+	return buf[0 : 1+hllDenseSize]
+}
+
+func decodeHLLRegisters(v []byte) (hllRegisters, error) {
+	r := newHLLRegisters()
+	if v == nil {
+		return r, nil
+	} else if len(v) == 0 {
+		return nil, errHLLValue
+	}
+
+	switch v[0] {
+	case hllSparse:
+		v = v[1:]
+		if len(v)%3 != 0 {
+			return nil, errHLLValue
+		}
+
+		for i := 0; i < len(v); i += 3 {
+			index := int(binary.BigEndian.Uint16(v[i:]))
+			if index >= hllRegisterNum || v[i+2] > hllQ+1 {
+				return nil, errHLLValue
+			}
+			r[index] = v[i+2]
+		}
+	case hllDense:
+		if len(v) != 1+hllDenseSize {
+			return nil, errHLLValue
+		}
+
+		dense := v[1:]
+		for i := range r {
+			pos := i * hllBits / 8
+			off := uint(i * hllBits % 8)
+			c := dense[pos] >> off
+			if pos+1 < len(dense) {
+				c |= dense[pos+1] << (8 - off)
+			}
+			if r[i] = c & hllMaxCount; r[i] > hllQ+1 {
+				return nil, errHLLValue
+			}
+		}
+	default:
+		return nil, errHLLValue
+	}
+
+	return r, nil
+}
+
+func (db *DB) pfEncodeKey(key []byte) []byte {
+	ek := make([]byte, len(key)+2)
+	ek[0] = db.index
+	ek[1] = HLLType
+	copy(ek[2:], key)
+	return ek
+}
+
+func (db *DB) pfDecodeKey(ek []byte) ([]byte, error) {
+	if len(ek) < 2 || ek[0] != db.index || ek[1] != HLLType {
+		return nil, errHLLKey
+	}
+
+	return ek[2:], nil
+}
+
+func (db *DB) pfGetRegisters(key []byte) (hllRegisters, error) {
+	if err := checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	v, err := db.db.Get(db.pfEncodeKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHLLRegisters(v)
+}
+
+func (db *DB) pfDelete(t *tx, key []byte) int64 {
+	t.Delete(db.pfEncodeKey(key))
+	db.releaseKeyType(t, HLLType, key)
+	return 1
+}
+
+func (db *DB) pfExpireAt(key []byte, when int64) (int64, error) {
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	if v, err := db.db.Get(db.pfEncodeKey(key)); err != nil || v == nil {
+		return 0, err
+	} else {
+		db.expireAt(t, HLLType, key, when)
+		if err := t.Commit(); err != nil {
+			return 0, err
+		}
+		db.notify(NotifyGeneric, "expire", key)
+	}
+	return 1, nil
+}
+
+// PFAdd adds elements to the HyperLogLog at key, returns 1 if the estimated cardinality may be changed,
+// or key is created.
+func (db *DB) PFAdd(key []byte, elements ...[]byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	ek := db.pfEncodeKey(key)
+	v, err := db.db.Get(ek)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := decodeHLLRegisters(v)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64 = 0
+	if v == nil {
+		n = 1
+	}
+
+	for _, e := range elements {
+		if r.add(e) {
+			n = 1
+		}
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	t.Put(ek, r.encode())
+	db.claimKeyType(t, HLLType, key)
+
+	if err = t.Commit(); err == nil {
+		db.notify(NotifyString, "pfadd", key)
+	}
+	return n, err
+}
+
+// PFCount returns the estimated cardinality of the union of the HyperLogLogs at keys.
+func (db *DB) PFCount(keys ...[]byte) (int64, error) {
+	var r hllRegisters
+	for _, key := range keys {
+		o, err := db.pfGetRegisters(key)
+		if err != nil {
+			return 0, err
+		}
+
+		if r == nil {
+			r = o
+		} else {
+			r.merge(o)
+		}
+	}
+
+	if r == nil {
+		return 0, nil
+	}
+	return r.count(), nil
+}
+
+// PFMerge merges the HyperLogLogs at srcKeys into dstKey.
+func (db *DB) PFMerge(dstKey []byte, srcKeys ...[]byte) error {
+	t := db.lockTx(append([][]byte{dstKey}, srcKeys...)...)
+	defer t.Unlock()
+
+	r, err := db.pfGetRegisters(dstKey)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range srcKeys {
+		o, err := db.pfGetRegisters(key)
+		if err != nil {
+			return err
+		}
+		r.merge(o)
+	}
+
+	t.Put(db.pfEncodeKey(dstKey), r.encode())
+	db.claimKeyType(t, HLLType, dstKey)
+
+	if err := t.Commit(); err != nil {
+		return err
+	}
+
+	db.notify(NotifyString, "pfmerge", dstKey)
+	return nil
+}
+
+func (db *DB) PFClear(key []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	if v, err := db.db.Get(db.pfEncodeKey(key)); err != nil || v == nil {
+		return 0, err
+	}
+
+	db.pfDelete(t, key)
+	db.rmExpire(t, HLLType, key)
+
+	err := t.Commit()
+	if err == nil {
+		db.notify(NotifyGeneric, "del", key)
+	}
+	return 1, err
+}
+
+func (db *DB) pfFlush() (drop int64, err error) {
+	minKey := db.pfEncodeKey(nil)
+	maxKey := db.pfEncodeKey(nil)
+	maxKey[len(maxKey)-1] = HLLType + 1
+
+	t := db.lockAllTx()
+	defer t.Unlock()
+
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{HLLType})
+
+	drop, err = db.flushRegion(t, minKey, maxKey)
+	err = db.expFlush(t, HLLType)
+
+	err = t.Commit()
+	return
+}
+
+func (db *DB) PFExpire(key []byte, duration int64) (int64, error) {
+	if duration <= 0 {
+		return 0, errExpireValue
+	}
+
+	return db.pfExpireAt(key, time.Now().Unix()+duration)
+}
+
+func (db *DB) PFExpireAt(key []byte, when int64) (int64, error) {
+	if when <= time.Now().Unix() {
+		return 0, errExpireValue
+	}
+
+	return db.pfExpireAt(key, when)
+}
+
+func (db *DB) PFTTL(key []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return -1, err
+	}
+
+	return db.ttl(HLLType, key)
+}
+
+func (db *DB) PFPersist(key []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	t := db.lockTx(key)
+	defer t.Unlock()
+
+	n, err := db.rmExpire(t, HLLType, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = t.Commit(); err == nil && n > 0 {
+		db.notify(NotifyGeneric, "persist", key)
+	}
+	return n, err
+}
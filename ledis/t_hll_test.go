@@ -0,0 +1,101 @@
+package ledis
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHLLCodec(t *testing.T) {
+	r := newHLLRegisters()
+	r[0] = 1
+	r[1] = hllQ + 1
+	r[hllRegisterNum-1] = 7
+
+	if v := r.encode(); v[0] != hllSparse {
+		t.Fatal(v[0])
+	} else if o, err := decodeHLLRegisters(v); err != nil {
+		t.Fatal(err)
+	} else if string(o) != string(r) {
+		t.Fatal("sparse registers not equal")
+	}
+
+	for i := range r {
+		r[i] = uint8(i % (hllQ + 2))
+	}
+
+	if v := r.encode(); v[0] != hllDense || len(v) != 1+hllDenseSize {
+		t.Fatal(v[0], len(v))
+	} else if o, err := decodeHLLRegisters(v); err != nil {
+		t.Fatal(err)
+	} else if string(o) != string(r) {
+		t.Fatal("dense registers not equal")
+	}
+
+	if _, err := decodeHLLRegisters([]byte{hllSparse, 0}); err != errHLLValue {
+		t.Fatal(err)
+	}
+}
+
+func TestDBHyperLogLog(t *testing.T) {
+	db := getTestDB()
+
+	key1 := []byte("testdb_hll_a")
+	key2 := []byte("testdb_hll_b")
+	key3 := []byte("testdb_hll_c")
+
+	for i := 0; i < 10000; i++ {
+		if _, err := db.PFAdd(key1, []byte(fmt.Sprintf("a_%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	elements := make([][]byte, 0, 20000)
+	for i := 5000; i < 25000; i++ {
+		elements = append(elements, []byte(fmt.Sprintf("a_%d", i)))
+	}
+
+	if n, err := db.PFAdd(key2, elements...); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+
+	checkCount := func(real int64, keys ...[]byte) {
+		if n, err := db.PFCount(keys...); err != nil {
+			t.Fatal(err)
+		} else if n < real*97/100 || n > real*103/100 {
+			t.Fatal(n, real)
+		}
+	}
+
+	checkCount(10000, key1)
+	checkCount(20000, key2)
+	checkCount(25000, key1, key2)
+
+	if err := db.PFMerge(key3, key1, key2); err != nil {
+		t.Fatal(err)
+	}
+	checkCount(25000, key3)
+
+	if v, _ := db.db.Get(db.pfEncodeKey(key3)); v[0] != hllDense {
+		t.Fatal(v[0])
+	}
+
+	if n, err := db.PFExpire(key3, 100); err != nil || n != 1 {
+		t.Fatal(n, err)
+	} else if ttl, _ := db.PFTTL(key3); ttl <= 0 {
+		t.Fatal(ttl)
+	}
+
+	if n, err := db.PFClear(key3); err != nil || n != 1 {
+		t.Fatal(n, err)
+	} else if n, _ := db.PFCount(key3); n != 0 {
+		t.Fatal(n)
+	} else if ttl, _ := db.PFTTL(key3); ttl != -1 {
+		t.Fatal(ttl)
+	}
+
+	if n, err := db.PFAdd(key3); err != nil || n != 1 {
+		t.Fatal(n, err)
+	} else if n, _ := db.PFCount(key3); n != 0 {
+		t.Fatal(n)
+	}
+}
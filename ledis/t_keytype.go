@@ -0,0 +1,262 @@
+package ledis
+
+import (
+	"errors"
+	"github.com/siddontang/ledisdb/store"
+)
+
+/*
+In single namespace mode, every key has an index entry which records the only
+data type it holds:
+
+	db index|KeyTypeType|key -> data type
+
+The entry is claimed when a key is created and released when the key is removed,
+both are checked and written in the commit of the type's tx.
+*/
+
+var errKeyTypeKey = errors.New("invalid key type key")
+
+type keyTypeClaim struct {
+	ik       []byte
+	dataType byte
+	release  bool
+}
+
+type KeyTypeCollision struct {
+	Key   []byte
+	Types []byte
+}
+
+//meta type of every data type, a key exists in the data type if its meta key exists
+var keyMetaTypes = []struct {
+	dataType byte
+	metaType byte
+}{
+	{KVType, KVType},
+	{ListType, LMetaType},
+	{HashType, HSizeType},
+	{ZSetType, ZSizeType},
+	{SetType, SSizeType},
+	{BitType, BitMetaType},
+	{HLLType, HLLType},
+}
+
+func (db *DB) ktEncodeTypeKey(key []byte) []byte {
+	buf := make([]byte, len(key)+2)
+	buf[0] = db.index
+	buf[1] = KeyTypeType
+
+	copy(buf[2:], key)
+	return buf
+}
+
+func (db *DB) ktDecodeTypeKey(ek []byte) ([]byte, error) {
+	if len(ek) < 2 || ek[0] != db.index || ek[1] != KeyTypeType {
+		return nil, errKeyTypeKey
+	}
+
+	return ek[2:], nil
+}
+
+//	claim key holding dataType, checked when t commits
+func (db *DB) claimKeyType(t *tx, dataType byte, key []byte) {
+	if !db.l.cfg.SingleNamespace {
+		return
+	}
+
+	t.claims = append(t.claims, keyTypeClaim{db.ktEncodeTypeKey(key), dataType, false})
+}
+
+//	release key from dataType, the index is removed only if it still holds dataType
+func (db *DB) releaseKeyType(t *tx, dataType byte, key []byte) {
+	if !db.l.cfg.SingleNamespace {
+		return
+	}
+
+	t.claims = append(t.claims, keyTypeClaim{db.ktEncodeTypeKey(key), dataType, true})
+}
+
+//	must be called under ledis lock, so that no other tx can change the index
+func (t *tx) applyClaims() error {
+	if len(t.claims) == 0 {
+		return nil
+	}
+
+	//a key may be claimed and released in one tx, so track the pending type
+	pending := make(map[string]byte, len(t.claims))
+
+	for _, c := range t.claims {
+		cur, ok := pending[String(c.ik)]
+		if !ok {
+			if v, err := t.db.Get(c.ik); err != nil {
+				return err
+			} else if len(v) > 0 {
+				cur = v[0]
+			} else {
+				cur = NoneType
+			}
+		}
+
+		if c.release {
+			if cur == c.dataType {
+				t.Delete(c.ik)
+				cur = NoneType
+			}
+		} else if cur == NoneType {
+			t.Put(c.ik, []byte{c.dataType})
+			cur = c.dataType
+		} else if cur != c.dataType {
+			return ErrWrongType
+		}
+
+		pending[String(c.ik)] = cur
+	}
+
+	t.claims = t.claims[0:0]
+	return nil
+}
+
+func (db *DB) ktFlush() (drop int64, err error) {
+	minKey := db.ktEncodeTypeKey(nil)
+	maxKey := db.ktEncodeTypeKey(nil)
+	maxKey[len(maxKey)-1] = KeyTypeType + 1
+
+	t := db.lockAllTx()
+	defer t.Unlock()
+
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{KeyTypeType})
+
+	drop, err = db.flushRegion(t, minKey, maxKey)
+	if err != nil {
+		return
+	}
+
+	err = t.Commit()
+	return
+}
+
+//	return all data types which key exists in
+func (db *DB) keyDataTypes(it *store.Iterator, key []byte) ([]byte, error) {
+	types := make([]byte, 0, 1)
+	for _, m := range keyMetaTypes {
+		mk, err := db.encodeMetaKey(m.metaType, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if v := it.RawFind(mk); v != nil {
+			types = append(types, m.dataType)
+		}
+	}
+
+	return types, nil
+}
+
+//	iterate all keys in db, f is called once per key with all data types it holds
+func (db *DB) keyTypeWalk(f func(key []byte, types []byte) error) error {
+	it := db.db.NewIterator()
+	defer it.Close()
+
+	for i, m := range keyMetaTypes {
+		minKey, _ := db.encodeMinKey(m.metaType)
+		maxKey, _ := db.encodeMaxKey(m.metaType)
+
+		rit := db.db.RangeIterator(minKey, maxKey, store.RangeROpen)
+		for ; rit.Valid(); rit.Next() {
+			key, err := db.decodeMetaKey(m.metaType, rit.Key())
+			if err != nil {
+				continue
+			}
+
+			types, err := db.keyDataTypes(it, key)
+			if err != nil {
+				rit.Close()
+				return err
+			}
+
+			//key has been visited in a former data type
+			if len(types) > 0 && types[0] != keyMetaTypes[i].dataType {
+				continue
+			}
+
+			if err = f(key, types); err != nil {
+				rit.Close()
+				return err
+			}
+		}
+		rit.Close()
+	}
+
+	return nil
+}
+
+//	KeyTypeCollisions returns all keys which exist in more than one data type,
+//	these keys must be handled before enabling single namespace mode.
+func (db *DB) KeyTypeCollisions() ([]KeyTypeCollision, error) {
+	v := make([]KeyTypeCollision, 0, 16)
+
+	err := db.keyTypeWalk(func(key []byte, types []byte) error {
+		if len(types) > 1 {
+			v = append(v, KeyTypeCollision{Key: key, Types: types})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+//	RebuildKeyTypeIndex drops the key type index and builds it again from the existing data,
+//	keys in more than one data type are not indexed and returned as collisions.
+//	It is not safe to write the db at the same time.
+func (db *DB) RebuildKeyTypeIndex() (num int64, collisions []KeyTypeCollision, err error) {
+	if _, err = db.ktFlush(); err != nil {
+		return
+	}
+
+	collisions = make([]KeyTypeCollision, 0, 16)
+
+	t := db.lockAllTx()
+	defer t.Unlock()
+
+	err = db.keyTypeWalk(func(key []byte, types []byte) error {
+		if len(types) > 1 {
+			collisions = append(collisions, KeyTypeCollision{Key: key, Types: types})
+			return nil
+		} else if len(types) == 0 {
+			return nil
+		}
+
+		t.Put(db.ktEncodeTypeKey(key), types)
+		num++
+		if num&1023 == 0 {
+			return t.Commit()
+		}
+		return nil
+	})
+
+	if err != nil {
+		return
+	}
+
+	err = t.Commit()
+	return
+}
+
+//	KeyType returns the data type key holds in single namespace mode, NoneType if not exists.
+func (db *DB) KeyType(key []byte) (byte, error) {
+	if err := checkKeySize(key); err != nil {
+		return NoneType, err
+	}
+
+	v, err := db.db.Get(db.ktEncodeTypeKey(key))
+	if err != nil || len(v) == 0 {
+		return NoneType, err
+	}
+
+	return v[0], nil
+}
@@ -0,0 +1,160 @@
+package ledis
+
+import (
+	"github.com/siddontang/ledisdb/config"
+	"os"
+	"testing"
+)
+
+func TestKeyTypeCodec(t *testing.T) {
+	db := getTestDB()
+
+	ek := db.ktEncodeTypeKey([]byte("key"))
+	if k, err := db.ktDecodeTypeKey(ek); err != nil {
+		t.Fatal(err)
+	} else if string(k) != "key" {
+		t.Fatal(string(k))
+	}
+}
+
+func TestKeyTypeCollisions(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("test_keytype_collision")
+	db.Set(key, []byte("1"))
+	db.HSet(key, []byte("a"), []byte("1"))
+	db.SAdd([]byte("test_keytype_single"), []byte("a"))
+
+	defer func() {
+		db.Del(key)
+		db.HClear(key)
+		db.SClear([]byte("test_keytype_single"))
+	}()
+
+	collisions, err := db.KeyTypeCollisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, c := range collisions {
+		if string(c.Key) == string(key) {
+			found = true
+			if len(c.Types) != 2 || c.Types[0] != KVType || c.Types[1] != HashType {
+				t.Fatal(c.Types)
+			}
+		} else if string(c.Key) == "test_keytype_single" {
+			t.Fatal("single type key in collisions")
+		}
+	}
+
+	if !found {
+		t.Fatal("collision not found")
+	}
+}
+
+func TestSingleNamespace(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_ledis_single_namespace"
+	cfg.SingleNamespace = true
+
+	os.RemoveAll(cfg.DataDir)
+
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	db, _ := l.Select(0)
+
+	key := []byte("a")
+
+	if err := db.Set(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.HSet(key, []byte("f"), []byte("1")); err != ErrWrongType {
+		t.Fatal(err)
+	}
+
+	if _, err := db.LPush(key, []byte("1")); err != ErrWrongType {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ZAdd(key, ScorePair{1, []byte("m")}); err != ErrWrongType {
+		t.Fatal(err)
+	}
+
+	if _, err := db.SAdd(key, []byte("m")); err != ErrWrongType {
+		t.Fatal(err)
+	}
+
+	if _, err := db.BSetBit(key, 1, 1); err != ErrWrongType {
+		t.Fatal(err)
+	}
+
+	if n, _ := db.HLen(key); n != 0 {
+		t.Fatal(n)
+	}
+
+	if tp, err := db.KeyType(key); err != nil {
+		t.Fatal(err)
+	} else if tp != KVType {
+		t.Fatal(tp)
+	}
+
+	//same type write is ok
+	if err := db.Set(key, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Del(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if tp, _ := db.KeyType(key); tp != NoneType {
+		t.Fatal(tp)
+	}
+
+	//key is released, so it can be another type now
+	if _, err := db.HSet(key, []byte("f"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Set(key, []byte("1")); err != ErrWrongType {
+		t.Fatal(err)
+	}
+
+	if _, err := db.HDel(key, []byte("f")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.RPush(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.LPop(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if tp, _ := db.KeyType(key); tp != NoneType {
+		t.Fatal(tp)
+	}
+
+	//rebuild from data
+	db.ZAdd([]byte("z"), ScorePair{1, []byte("m")})
+	db.Set([]byte("k"), []byte("v"))
+
+	if n, collisions, err := db.RebuildKeyTypeIndex(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	} else if len(collisions) != 0 {
+		t.Fatal(len(collisions))
+	}
+
+	if tp, _ := db.KeyType([]byte("z")); tp != ZSetType {
+		t.Fatal(tp)
+	}
+}
@@ -60,11 +60,10 @@ func (db *DB) incr(key []byte, delta int64) (int64, error) {
 	}
 
 	var err error
+	rawKey := key
 	key = db.encodeKVKey(key)
 
-	t := db.kvTx
-
-	t.Lock()
+	t := db.lockTx(rawKey)
 	defer t.Unlock()
 
 	var n int64
@@ -76,24 +75,31 @@ func (db *DB) incr(key []byte, delta int64) (int64, error) {
 	n += delta
 
 	t.Put(key, StrPutInt64(n))
+	db.claimKeyType(t, KVType, rawKey)
 
 	//todo binlog
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		if delta >= 0 {
+			db.notify(NotifyString, "incrby", rawKey)
+		} else {
+			db.notify(NotifyString, "decrby", rawKey)
+		}
+	}
 	return n, err
 }
 
 //	ps : here just focus on deleting the key-value data,
 //		 any other likes expire is ignore.
 func (db *DB) delete(t *tx, key []byte) int64 {
+	db.releaseKeyType(t, KVType, key)
 	key = db.encodeKVKey(key)
 	t.Delete(key)
 	return 1
 }
 
 func (db *DB) setExpireAt(key []byte, when int64) (int64, error) {
-	t := db.kvTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	if exist, err := db.Exists(key); err != nil || exist == 0 {
@@ -103,6 +109,7 @@ func (db *DB) setExpireAt(key []byte, when int64) (int64, error) {
 		if err := t.Commit(); err != nil {
 			return 0, err
 		}
+		db.notify(NotifyGeneric, "expire", key)
 	}
 	return 1, nil
 }
@@ -125,16 +132,21 @@ func (db *DB) Del(keys ...[]byte) (int64, error) {
 		codedKeys[i] = db.encodeKVKey(k)
 	}
 
-	t := db.kvTx
-	t.Lock()
+	t := db.lockTx(keys...)
 	defer t.Unlock()
 
 	for i, k := range keys {
 		t.Delete(codedKeys[i])
 		db.rmExpire(t, KVType, k)
+		db.releaseKeyType(t, KVType, k)
 	}
 
 	err := t.Commit()
+	if err == nil {
+		for _, k := range keys {
+			db.notify(NotifyGeneric, "del", k)
+		}
+	}
 	return int64(len(keys)), err
 }
 
@@ -172,11 +184,10 @@ func (db *DB) GetSet(key []byte, value []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	rawKey := key
 	key = db.encodeKVKey(key)
 
-	t := db.kvTx
-
-	t.Lock()
+	t := db.lockTx(rawKey)
 	defer t.Unlock()
 
 	oldValue, err := db.db.Get(key)
@@ -185,9 +196,12 @@ func (db *DB) GetSet(key []byte, value []byte) ([]byte, error) {
 	}
 
 	t.Put(key, value)
+	db.claimKeyType(t, KVType, rawKey)
 	//todo, binlog
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		db.notify(NotifyString, "set", rawKey)
+	}
 
 	return oldValue, err
 }
@@ -222,12 +236,15 @@ func (db *DB) MSet(args ...KVPair) error {
 		return nil
 	}
 
-	t := db.kvTx
-
 	var err error
 	var key []byte
 	var value []byte
 
+	t := db.newTx()
+	for i := 0; i < len(args); i++ {
+		t.addKey(db, args[i].Key)
+	}
+
 	t.Lock()
 	defer t.Unlock()
 
@@ -243,11 +260,16 @@ func (db *DB) MSet(args ...KVPair) error {
 		value = args[i].Value
 
 		t.Put(key, value)
+		db.claimKeyType(t, KVType, args[i].Key)
 
 		//todo binlog
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		for i := 0; i < len(args); i++ {
+			db.notify(NotifyString, "set", args[i].Key)
+		}
+	}
 	return err
 }
 
@@ -259,18 +281,20 @@ func (db *DB) Set(key []byte, value []byte) error {
 	}
 
 	var err error
+	rawKey := key
 	key = db.encodeKVKey(key)
 
-	t := db.kvTx
-
-	t.Lock()
+	t := db.lockTx(rawKey)
 	defer t.Unlock()
 
 	t.Put(key, value)
+	db.claimKeyType(t, KVType, rawKey)
 
 	//todo, binlog
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		db.notify(NotifyString, "set", rawKey)
+	}
 
 	return err
 }
@@ -283,13 +307,12 @@ func (db *DB) SetNX(key []byte, value []byte) (int64, error) {
 	}
 
 	var err error
+	rawKey := key
 	key = db.encodeKVKey(key)
 
 	var n int64 = 1
 
-	t := db.kvTx
-
-	t.Lock()
+	t := db.lockTx(rawKey)
 	defer t.Unlock()
 
 	if v, err := db.db.Get(key); err != nil {
@@ -298,10 +321,13 @@ func (db *DB) SetNX(key []byte, value []byte) (int64, error) {
 		n = 0
 	} else {
 		t.Put(key, value)
+		db.claimKeyType(t, KVType, rawKey)
 
 		//todo binlog
 
-		err = t.Commit()
+		if err = t.Commit(); err == nil {
+			db.notify(NotifyString, "set", rawKey)
+		}
 	}
 
 	return n, err
@@ -311,10 +337,11 @@ func (db *DB) flush() (drop int64, err error) {
 	minKey := db.encodeKVMinKey()
 	maxKey := db.encodeKVMaxKey()
 
-	t := db.kvTx
-	t.Lock()
+	t := db.lockAllTx()
 	defer t.Unlock()
 
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{KVType})
+
 	drop, err = db.flushRegion(t, minKey, maxKey)
 	err = db.expFlush(t, KVType)
 
@@ -356,14 +383,15 @@ func (db *DB) Persist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.kvTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 	n, err := db.rmExpire(t, KVType, key)
 	if err != nil {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && n > 0 {
+		db.notify(NotifyGeneric, "persist", key)
+	}
 	return n, err
 }
@@ -84,10 +84,13 @@ func (db *DB) lpush(key []byte, whereSeq int32, args ...[]byte) (int64, error) {
 	var size int32
 	var err error
 
-	t := db.listTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ListType, key); err != nil {
+		return 0, err
+	}
+
 	metaKey := db.lEncodeMetaKey(key)
 	headSeq, tailSeq, size, err = db.lGetMeta(nil, metaKey)
 	if err != nil {
@@ -128,9 +131,16 @@ func (db *DB) lpush(key []byte, whereSeq int32, args ...[]byte) (int64, error) {
 		tailSeq = seq
 	}
 
-	db.lSetMeta(metaKey, headSeq, tailSeq)
+	db.lSetMeta(t, metaKey, headSeq, tailSeq)
+	db.claimKeyType(t, ListType, key)
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		if whereSeq == listHeadSeq {
+			db.notify(NotifyList, "lpush", key)
+		} else {
+			db.notify(NotifyList, "rpush", key)
+		}
+	}
 	return int64(size) + int64(pushCnt), err
 }
 
@@ -139,10 +149,13 @@ func (db *DB) lpop(key []byte, whereSeq int32) ([]byte, error) {
 		return nil, err
 	}
 
-	t := db.listTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ListType, key); err != nil {
+		return nil, err
+	}
+
 	var headSeq int32
 	var tailSeq int32
 	var err error
@@ -173,12 +186,19 @@ func (db *DB) lpop(key []byte, whereSeq int32) ([]byte, error) {
 	}
 
 	t.Delete(itemKey)
-	size := db.lSetMeta(metaKey, headSeq, tailSeq)
+	size := db.lSetMeta(t, metaKey, headSeq, tailSeq)
 	if size == 0 {
 		db.rmExpire(t, HashType, key)
+		db.releaseKeyType(t, ListType, key)
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && value != nil {
+		if whereSeq == listHeadSeq {
+			db.notify(NotifyList, "lpop", key)
+		} else {
+			db.notify(NotifyList, "rpop", key)
+		}
+	}
 	return value, err
 }
 
@@ -210,6 +230,7 @@ func (db *DB) lDelete(t *tx, key []byte) int64 {
 	}
 
 	t.Delete(mk)
+	db.releaseKeyType(t, ListType, key)
 
 	return num
 }
@@ -236,9 +257,7 @@ func (db *DB) lGetMeta(it *store.Iterator, ek []byte) (headSeq int32, tailSeq in
 	return
 }
 
-func (db *DB) lSetMeta(ek []byte, headSeq int32, tailSeq int32) int32 {
-	t := db.listTx
-
+func (db *DB) lSetMeta(t *tx, ek []byte, headSeq int32, tailSeq int32) int32 {
 	var size int32 = tailSeq - headSeq + 1
 	if size < 0 {
 		//	todo : log error + panic
@@ -257,8 +276,7 @@ func (db *DB) lSetMeta(ek []byte, headSeq int32, tailSeq int32) int32 {
 }
 
 func (db *DB) lExpireAt(key []byte, when int64) (int64, error) {
-	t := db.listTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	if llen, err := db.LLen(key); err != nil || llen == 0 {
@@ -268,6 +286,7 @@ func (db *DB) lExpireAt(key []byte, when int64) (int64, error) {
 		if err := t.Commit(); err != nil {
 			return 0, err
 		}
+		db.notify(NotifyGeneric, "expire", key)
 	}
 	return 1, nil
 }
@@ -275,6 +294,8 @@ func (db *DB) lExpireAt(key []byte, when int64) (int64, error) {
 func (db *DB) LIndex(key []byte, index int32) ([]byte, error) {
 	if err := checkKeySize(key); err != nil {
 		return nil, err
+	} else if db.trashed(ListType, key) {
+		return nil, nil
 	}
 
 	var seq int32
@@ -393,20 +414,21 @@ func (db *DB) LClear(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.listTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
-	num := db.lDelete(t, key)
+	num := db.lazyDelete(t, ListType, key, db.lDelete)
 	db.rmExpire(t, ListType, key)
 
 	err := t.Commit()
+	if err == nil && num > 0 {
+		db.notify(NotifyGeneric, "del", key)
+	}
 	return num, err
 }
 
 func (db *DB) LMclear(keys ...[]byte) (int64, error) {
-	t := db.listTx
-	t.Lock()
+	t := db.lockTx(keys...)
 	defer t.Unlock()
 
 	for _, key := range keys {
@@ -414,12 +436,17 @@ func (db *DB) LMclear(keys ...[]byte) (int64, error) {
 			return 0, err
 		}
 
-		db.lDelete(t, key)
+		db.lazyDelete(t, ListType, key, db.lDelete)
 		db.rmExpire(t, ListType, key)
 
 	}
 
 	err := t.Commit()
+	if err == nil {
+		for _, key := range keys {
+			db.notify(NotifyGeneric, "del", key)
+		}
+	}
 	return int64(len(keys)), err
 }
 
@@ -432,10 +459,11 @@ func (db *DB) lFlush() (drop int64, err error) {
 	maxKey[0] = db.index
 	maxKey[1] = LMetaType + 1
 
-	t := db.listTx
-	t.Lock()
+	t := db.lockAllTx()
 	defer t.Unlock()
 
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{ListType})
+
 	drop, err = db.flushRegion(t, minKey, maxKey)
 	err = db.expFlush(t, ListType)
 
@@ -472,8 +500,7 @@ func (db *DB) LPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.listTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	n, err := db.rmExpire(t, ListType, key)
@@ -481,7 +508,9 @@ func (db *DB) LPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && n > 0 {
+		db.notify(NotifyGeneric, "persist", key)
+	}
 	return n, err
 }
 
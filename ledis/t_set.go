@@ -113,10 +113,11 @@ func (db *DB) sFlush() (drop int64, err error) {
 	maxKey[0] = db.index
 	maxKey[1] = SSizeType + 1
 
-	t := db.setTx
-	t.Lock()
+	t := db.lockAllTx()
 	defer t.Unlock()
 
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{SetType})
+
 	drop, err = db.flushRegion(t, minKey, maxKey)
 	err = db.expFlush(t, SetType)
 
@@ -139,11 +140,11 @@ func (db *DB) sDelete(t *tx, key []byte) int64 {
 	it.Close()
 
 	t.Delete(sk)
+	db.releaseKeyType(t, SetType, key)
 	return num
 }
 
-func (db *DB) sIncrSize(key []byte, delta int64) (int64, error) {
-	t := db.setTx
+func (db *DB) sIncrSize(t *tx, key []byte, delta int64) (int64, error) {
 	sk := db.sEncodeSizeKey(key)
 
 	var err error
@@ -156,8 +157,10 @@ func (db *DB) sIncrSize(key []byte, delta int64) (int64, error) {
 			size = 0
 			t.Delete(sk)
 			db.rmExpire(t, SetType, key)
+			db.releaseKeyType(t, SetType, key)
 		} else {
 			t.Put(sk, PutInt64(size))
+			db.claimKeyType(t, SetType, key)
 		}
 	}
 
@@ -165,8 +168,7 @@ func (db *DB) sIncrSize(key []byte, delta int64) (int64, error) {
 }
 
 func (db *DB) sExpireAt(key []byte, when int64) (int64, error) {
-	t := db.setTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	if scnt, err := db.SCard(key); err != nil || scnt == 0 {
@@ -176,21 +178,21 @@ func (db *DB) sExpireAt(key []byte, when int64) (int64, error) {
 		if err := t.Commit(); err != nil {
 			return 0, err
 		}
+		db.notify(NotifyGeneric, "expire", key)
 
 	}
 
 	return 1, nil
 }
 
-func (db *DB) sSetItem(key []byte, member []byte) (int64, error) {
-	t := db.setTx
+func (db *DB) sSetItem(t *tx, key []byte, member []byte) (int64, error) {
 	ek := db.sEncodeSetKey(key, member)
 
 	var n int64 = 1
 	if v, _ := db.db.Get(ek); v != nil {
 		n = 0
 	} else {
-		if _, err := db.sIncrSize(key, 1); err != nil {
+		if _, err := db.sIncrSize(t, key, 1); err != nil {
 			return 0, err
 		}
 	}
@@ -200,10 +202,13 @@ func (db *DB) sSetItem(key []byte, member []byte) (int64, error) {
 }
 
 func (db *DB) SAdd(key []byte, args ...[]byte) (int64, error) {
-	t := db.setTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, SetType, key); err != nil {
+		return 0, err
+	}
+
 	var err error
 	var ek []byte
 	var num int64 = 0
@@ -223,11 +228,13 @@ func (db *DB) SAdd(key []byte, args ...[]byte) (int64, error) {
 		t.Put(ek, nil)
 	}
 
-	if _, err = db.sIncrSize(key, num); err != nil {
+	if _, err = db.sIncrSize(t, key, num); err != nil {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && num > 0 {
+		db.notify(NotifySet, "sadd", key)
+	}
 	return num, err
 
 }
@@ -362,6 +369,10 @@ func (db *DB) SInterStore(dstKey []byte, keys ...[]byte) (int64, error) {
 }
 
 func (db *DB) SIsMember(key []byte, member []byte) (int64, error) {
+	if db.trashed(SetType, key) {
+		return 0, nil
+	}
+
 	ek := db.sEncodeSetKey(key, member)
 
 	var n int64 = 1
@@ -376,6 +387,8 @@ func (db *DB) SIsMember(key []byte, member []byte) (int64, error) {
 func (db *DB) SMembers(key []byte) ([][]byte, error) {
 	if err := checkKeySize(key); err != nil {
 		return nil, err
+	} else if db.trashed(SetType, key) {
+		return [][]byte{}, nil
 	}
 
 	start := db.sEncodeStartKey(key)
@@ -399,10 +412,13 @@ func (db *DB) SMembers(key []byte) ([][]byte, error) {
 }
 
 func (db *DB) SRem(key []byte, args ...[]byte) (int64, error) {
-	t := db.setTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, SetType, key); err != nil {
+		return 0, err
+	}
+
 	var ek []byte
 	var v []byte
 	var err error
@@ -427,11 +443,13 @@ func (db *DB) SRem(key []byte, args ...[]byte) (int64, error) {
 		}
 	}
 
-	if _, err = db.sIncrSize(key, -num); err != nil {
+	if _, err = db.sIncrSize(t, key, -num); err != nil {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && num > 0 {
+		db.notify(NotifySet, "srem", key)
+	}
 	return num, err
 
 }
@@ -482,10 +500,13 @@ func (db *DB) sStoreGeneric(dstKey []byte, optType byte, keys ...[]byte) (int64,
 		return 0, err
 	}
 
-	t := db.setTx
-	t.Lock()
+	t := db.lockTx(append([][]byte{dstKey}, keys...)...)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, SetType, dstKey); err != nil {
+		return 0, err
+	}
+
 	db.sDelete(t, dstKey)
 
 	var err error
@@ -522,10 +543,22 @@ func (db *DB) sStoreGeneric(dstKey []byte, optType byte, keys ...[]byte) (int64,
 	var num = int64(len(v))
 	sk := db.sEncodeSizeKey(dstKey)
 	t.Put(sk, PutInt64(num))
+	if num > 0 {
+		db.claimKeyType(t, SetType, dstKey)
+	}
 
 	if err = t.Commit(); err != nil {
 		return 0, err
 	}
+
+	switch optType {
+	case UnionType:
+		db.notify(NotifySet, "sunionstore", dstKey)
+	case DiffType:
+		db.notify(NotifySet, "sdiffstore", dstKey)
+	case InterType:
+		db.notify(NotifySet, "sinterstore", dstKey)
+	}
 	return num, nil
 }
 
@@ -534,20 +567,21 @@ func (db *DB) SClear(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.setTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
-	num := db.sDelete(t, key)
+	num := db.lazyDelete(t, SetType, key, db.sDelete)
 	db.rmExpire(t, SetType, key)
 
 	err := t.Commit()
+	if err == nil && num > 0 {
+		db.notify(NotifyGeneric, "del", key)
+	}
 	return num, err
 }
 
 func (db *DB) SMclear(keys ...[]byte) (int64, error) {
-	t := db.setTx
-	t.Lock()
+	t := db.lockTx(keys...)
 	defer t.Unlock()
 
 	for _, key := range keys {
@@ -555,11 +589,16 @@ func (db *DB) SMclear(keys ...[]byte) (int64, error) {
 			return 0, err
 		}
 
-		db.sDelete(t, key)
+		db.lazyDelete(t, SetType, key, db.sDelete)
 		db.rmExpire(t, SetType, key)
 	}
 
 	err := t.Commit()
+	if err == nil {
+		for _, key := range keys {
+			db.notify(NotifyGeneric, "del", key)
+		}
+	}
 	return int64(len(keys)), err
 }
 
@@ -594,14 +633,15 @@ func (db *DB) SPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.setTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	n, err := db.rmExpire(t, SetType, key)
 	if err != nil {
 		return 0, err
 	}
-	err = t.Commit()
+	if err = t.Commit(); err == nil && n > 0 {
+		db.notify(NotifyGeneric, "persist", key)
+	}
 	return n, err
 }
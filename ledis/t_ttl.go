@@ -16,7 +16,6 @@ type retireCallback func(*tx, []byte) int64
 
 type elimination struct {
 	db         *DB
-	exp2Tx     []*tx
 	exp2Retire []retireCallback
 }
 
@@ -75,8 +74,13 @@ func (db *DB) expireAt(t *tx, dataType byte, key []byte, when int64) {
 	mk := db.expEncodeMetaKey(dataType, key)
 	tk := db.expEncodeTimeKey(dataType, key, when)
 
+	start := len(t.batch)
 	t.Put(tk, mk)
 	t.Put(mk, PutInt64(when))
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(when))
+	t.logCommand(start, BinLogCommandExpireAt, []byte{db.index}, []byte{dataType}, key, buf)
 }
 
 func (db *DB) ttl(dataType byte, key []byte) (t int64, err error) {
@@ -134,16 +138,14 @@ func (db *DB) expFlush(t *tx, dataType byte) (err error) {
 func newEliminator(db *DB) *elimination {
 	eli := new(elimination)
 	eli.db = db
-	eli.exp2Tx = make([]*tx, maxDataType)
 	eli.exp2Retire = make([]retireCallback, maxDataType)
 	return eli
 }
 
-func (eli *elimination) regRetireContext(dataType byte, t *tx, onRetire retireCallback) {
+func (eli *elimination) regRetireContext(dataType byte, onRetire retireCallback) {
 
 	//	todo .. need to ensure exist - mapExpMetaType[expType]
 
-	eli.exp2Tx[dataType] = t
 	eli.exp2Retire[dataType] = onRetire
 }
 
@@ -166,22 +168,23 @@ func (eli *elimination) active() {
 			continue
 		}
 
-		t := eli.exp2Tx[dt]
 		onRetire := eli.exp2Retire[dt]
 		if tk == nil || onRetire == nil {
 			continue
 		}
 
-		t.Lock()
+		t := db.lockTx(k)
 
 		if exp, err := Int64(dbGet(mk)); err == nil {
-			// check expire again
-			if exp <= now {
+			// check expire again, it may be retired by others already
+			if exp > 0 && exp <= now {
 				onRetire(t, k)
 				t.Delete(tk)
 				t.Delete(mk)
 
-				t.Commit()
+				if err := t.Commit(); err == nil {
+					db.notify(NotifyExpired, "expired", k)
+				}
 			}
 
 		}
@@ -260,8 +260,7 @@ func (db *DB) zDelete(t *tx, key []byte) int64 {
 }
 
 func (db *DB) zExpireAt(key []byte, when int64) (int64, error) {
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	if zcnt, err := db.ZCard(key); err != nil || zcnt == 0 {
@@ -271,6 +270,7 @@ func (db *DB) zExpireAt(key []byte, when int64) (int64, error) {
 		if err := t.Commit(); err != nil {
 			return 0, err
 		}
+		db.notify(NotifyGeneric, "expire", key)
 	}
 	return 1, nil
 }
@@ -280,10 +280,13 @@ func (db *DB) ZAdd(key []byte, args ...ScorePair) (int64, error) {
 		return 0, nil
 	}
 
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ZSetType, key); err != nil {
+		return 0, err
+	}
+
 	var num int64 = 0
 	for i := 0; i < len(args); i++ {
 		score := args[i].Score
@@ -307,6 +310,9 @@ func (db *DB) ZAdd(key []byte, args ...ScorePair) (int64, error) {
 
 	//todo add binlog
 	err := t.Commit()
+	if err == nil {
+		db.notify(NotifyZSet, "zadd", key)
+	}
 	return num, err
 }
 
@@ -322,8 +328,10 @@ func (db *DB) zIncrSize(t *tx, key []byte, delta int64) (int64, error) {
 			size = 0
 			t.Delete(sk)
 			db.rmExpire(t, ZSetType, key)
+			db.releaseKeyType(t, ZSetType, key)
 		} else {
 			t.Put(sk, PutInt64(size))
+			db.claimKeyType(t, ZSetType, key)
 		}
 	}
 
@@ -344,6 +352,10 @@ func (db *DB) ZScore(key []byte, member []byte) (int64, error) {
 		return InvalidScore, err
 	}
 
+	if db.trashed(ZSetType, key) {
+		return InvalidScore, ErrScoreMiss
+	}
+
 	var score int64 = InvalidScore
 
 	k := db.zEncodeSetKey(key, member)
@@ -365,10 +377,13 @@ func (db *DB) ZRem(key []byte, members ...[]byte) (int64, error) {
 		return 0, nil
 	}
 
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ZSetType, key); err != nil {
+		return 0, err
+	}
+
 	var num int64 = 0
 	for i := 0; i < len(members); i++ {
 		if err := checkZSetKMSize(key, members[i]); err != nil {
@@ -387,6 +402,9 @@ func (db *DB) ZRem(key []byte, members ...[]byte) (int64, error) {
 	}
 
 	err := t.Commit()
+	if err == nil && num > 0 {
+		db.notify(NotifyZSet, "zrem", key)
+	}
 	return num, err
 }
 
@@ -395,10 +413,13 @@ func (db *DB) ZIncrBy(key []byte, delta int64, member []byte) (int64, error) {
 		return InvalidScore, err
 	}
 
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ZSetType, key); err != nil {
+		return InvalidScore, err
+	}
+
 	ek := db.zEncodeSetKey(key, member)
 
 	var oldScore int64 = 0
@@ -428,14 +449,19 @@ func (db *DB) ZIncrBy(key []byte, delta int64, member []byte) (int64, error) {
 		t.Delete(oldSk)
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil {
+		db.notify(NotifyZSet, "zincr", key)
+	}
 	return newScore, err
 }
 
 func (db *DB) ZCount(key []byte, min int64, max int64) (int64, error) {
 	if err := checkKeySize(key); err != nil {
 		return 0, err
+	} else if db.trashed(ZSetType, key) {
+		return 0, nil
 	}
+
 	minKey := db.zEncodeStartScoreKey(key, min)
 	maxKey := db.zEncodeStopScoreKey(key, max)
 
@@ -454,6 +480,8 @@ func (db *DB) ZCount(key []byte, min int64, max int64) (int64, error) {
 func (db *DB) zrank(key []byte, member []byte, reverse bool) (int64, error) {
 	if err := checkZSetKMSize(key, member); err != nil {
 		return 0, err
+	} else if db.trashed(ZSetType, key) {
+		return -1, nil
 	}
 
 	k := db.zEncodeSetKey(key, member)
@@ -546,7 +574,7 @@ func (db *DB) zRange(key []byte, min int64, max int64, offset int, count int, re
 		return nil, errKeySize
 	}
 
-	if offset < 0 {
+	if offset < 0 || db.trashed(ZSetType, key) {
 		return []ScorePair{}, nil
 	}
 
@@ -626,30 +654,44 @@ func (db *DB) zParseLimit(key []byte, start int, stop int) (offset int, count in
 }
 
 func (db *DB) ZClear(key []byte) (int64, error) {
-	t := db.zsetTx
-	t.Lock()
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	t := db.lockTx(key)
 	defer t.Unlock()
 
-	rmCnt, err := db.zRemRange(t, key, MinScore, MaxScore, 0, -1)
-	if err == nil {
-		err = t.Commit()
+	rmCnt := db.lazyDelete(t, ZSetType, key, db.zDelete)
+	db.rmExpire(t, ZSetType, key)
+
+	err := t.Commit()
+
+	if err == nil && rmCnt > 0 {
+		db.notify(NotifyGeneric, "del", key)
 	}
 
 	return rmCnt, err
 }
 
 func (db *DB) ZMclear(keys ...[]byte) (int64, error) {
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(keys...)
 	defer t.Unlock()
 
 	for _, key := range keys {
-		if _, err := db.zRemRange(t, key, MinScore, MaxScore, 0, -1); err != nil {
+		if err := checkKeySize(key); err != nil {
 			return 0, err
 		}
+
+		db.lazyDelete(t, ZSetType, key, db.zDelete)
+		db.rmExpire(t, ZSetType, key)
 	}
 
 	err := t.Commit()
+	if err == nil {
+		for _, key := range keys {
+			db.notify(NotifyGeneric, "del", key)
+		}
+	}
 
 	return int64(len(keys)), err
 }
@@ -677,29 +719,43 @@ func (db *DB) ZRemRangeByRank(key []byte, start int, stop int) (int64, error) {
 
 	var rmCnt int64
 
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ZSetType, key); err != nil {
+		return 0, err
+	}
+
 	rmCnt, err = db.zRemRange(t, key, MinScore, MaxScore, offset, count)
 	if err == nil {
 		err = t.Commit()
 	}
 
+	if err == nil && rmCnt > 0 {
+		db.notify(NotifyZSet, "zremrangebyrank", key)
+	}
+
 	return rmCnt, err
 }
 
 //min and max must be inclusive
 func (db *DB) ZRemRangeByScore(key []byte, min int64, max int64) (int64, error) {
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ZSetType, key); err != nil {
+		return 0, err
+	}
+
 	rmCnt, err := db.zRemRange(t, key, min, max, 0, -1)
 	if err == nil {
 		err = t.Commit()
 	}
 
+	if err == nil && rmCnt > 0 {
+		db.notify(NotifyZSet, "zremrangebyscore", key)
+	}
+
 	return rmCnt, err
 }
 
@@ -735,10 +791,11 @@ func (db *DB) ZRangeByScoreGeneric(key []byte, min int64, max int64,
 }
 
 func (db *DB) zFlush() (drop int64, err error) {
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockAllTx()
 	defer t.Unlock()
 
+	t.logAllAsCommand(BinLogCommandFlushDB, []byte{db.index}, []byte{ZSetType})
+
 	minKey := make([]byte, 2)
 	minKey[0] = db.index
 	minKey[1] = ZSetType
@@ -795,8 +852,7 @@ func (db *DB) ZPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(key)
 	defer t.Unlock()
 
 	n, err := db.rmExpire(t, ZSetType, key)
@@ -804,7 +860,9 @@ func (db *DB) ZPersist(key []byte) (int64, error) {
 		return 0, err
 	}
 
-	err = t.Commit()
+	if err = t.Commit(); err == nil && n > 0 {
+		db.notify(NotifyGeneric, "persist", key)
+	}
 	return n, err
 }
 
@@ -867,10 +925,13 @@ func (db *DB) ZUnionStore(destKey []byte, srcKeys [][]byte, weights []int64, agg
 		}
 	}
 
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(append([][]byte{destKey}, srcKeys...)...)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ZSetType, destKey); err != nil {
+		return 0, err
+	}
+
 	db.zDelete(t, destKey)
 
 	for member, score := range destMap {
@@ -886,11 +947,16 @@ func (db *DB) ZUnionStore(destKey []byte, srcKeys [][]byte, weights []int64, agg
 	var num = int64(len(destMap))
 	sk := db.zEncodeSizeKey(destKey)
 	t.Put(sk, PutInt64(num))
+	if num > 0 {
+		db.claimKeyType(t, ZSetType, destKey)
+	}
 
 	//todo add binlog
 	if err := t.Commit(); err != nil {
 		return 0, err
 	}
+
+	db.notify(NotifyZSet, "zunionstore", destKey)
 	return num, nil
 }
 
@@ -937,10 +1003,13 @@ func (db *DB) ZInterStore(destKey []byte, srcKeys [][]byte, weights []int64, agg
 		destMap = tmpMap
 	}
 
-	t := db.zsetTx
-	t.Lock()
+	t := db.lockTx(append([][]byte{destKey}, srcKeys...)...)
 	defer t.Unlock()
 
+	if err := db.reclaimTrash(t, ZSetType, destKey); err != nil {
+		return 0, err
+	}
+
 	db.zDelete(t, destKey)
 
 	for member, score := range destMap {
@@ -955,10 +1024,15 @@ func (db *DB) ZInterStore(destKey []byte, srcKeys [][]byte, weights []int64, agg
 	var num int64 = int64(len(destMap))
 	sk := db.zEncodeSizeKey(destKey)
 	t.Put(sk, PutInt64(num))
+	if num > 0 {
+		db.claimKeyType(t, ZSetType, destKey)
+	}
 	//todo add binlog
 	if err := t.Commit(); err != nil {
 		return 0, err
 	}
+
+	db.notify(NotifyZSet, "zinterstore", destKey)
 	return num, nil
 }
 
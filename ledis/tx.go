@@ -2,83 +2,219 @@ package ledis
 
 import (
 	"github.com/siddontang/ledisdb/store"
-	"sync"
+	"sync/atomic"
 )
 
 type tx struct {
-	m sync.Mutex
-
 	l  *Ledis
-	wb store.WriteBatch
+	db *store.DB
+
+	//	the writes encoded as binlog events, replayed to a write batch at commit
+	batch [][]byte
+
+	//	the writes logged as command events instead, see logCommand
+	commands []txCommand
+
+	//	all the commits of the tx logged as one command event, see logAllAsCommand
+	command       []byte
+	commandLogged bool
+
+	claims []keyTypeClaim
+
+	//	slots of the keys written, their versions are increased after commit
+	versions *keyVersions
+	touched  []uint32
+
+	//	nil for the tx of Multi, which needs no lock
+	locks     *keyLocks
+	slots     []uint32
+	exclusive bool
+
+	//	the session told the batch the tx is committed in, nil if none
+	session *Session
+
+	//	the result of the group commit
+	done chan error
 
-	binlog *BinLog
-	batch  [][]byte
+	//	signaled when the tx leads the next group
+	lead chan struct{}
+}
+
+//	the events of batch[start:end] are logged as event
+type txCommand struct {
+	start int
+	end   int
+	event []byte
 }
 
 func newTx(l *Ledis) *tx {
 	t := new(tx)
 
 	t.l = l
-	t.wb = l.ldb.NewWriteBatch()
+	t.db = l.ldb
+
+	t.batch = make([][]byte, 0, 4)
+
+	t.versions = l.versions
+
+	t.locks = l.locks
+	t.done = make(chan error, 1)
+	t.lead = make(chan struct{}, 1)
+	return t
+}
+
+//	tx writing to db without binlog, used by Multi
+func newMultiTx(l *Ledis, db *store.DB) *tx {
+	t := new(tx)
+
+	t.l = l
+	t.db = db
 
 	t.batch = make([][]byte, 0, 4)
-	t.binlog = l.binlog
 	return t
 }
 
 func (t *tx) Close() {
-	t.wb = nil
+	t.batch = nil
 }
 
-func (t *tx) Put(key []byte, value []byte) {
-	t.wb.Put(key, value)
+func (t *tx) touch(key []byte) {
+	if t.versions == nil {
+		return
+	}
 
-	if t.binlog != nil {
-		buf := encodeBinLogPut(key, value)
-		t.batch = append(t.batch, buf)
+	if slot, ok := dataKeySlot(key); ok {
+		t.touched = append(t.touched, slot)
 	}
 }
 
+func (t *tx) Put(key []byte, value []byte) {
+	t.batch = append(t.batch, encodeBinLogPut(key, value))
+	t.touch(key)
+}
+
 func (t *tx) Delete(key []byte) {
-	t.wb.Delete(key)
+	t.batch = append(t.batch, encodeBinLogDelete(key))
+	t.touch(key)
+}
+
+func (t *tx) Commit() error {
+	if len(t.batch) == 0 && len(t.claims) == 0 {
+		return nil
+	}
 
-	if t.binlog != nil {
-		buf := encodeBinLogDelete(key)
-		t.batch = append(t.batch, buf)
+	if t.db != t.l.ldb {
+		//	the tx of Multi writes to its overlay alone
+		t.l.Lock()
+		err := t.write(t.db.NewWriteBatch())
+		t.l.Unlock()
+		return err
 	}
+
+	return t.l.commits.commit(t)
 }
 
-func (t *tx) Lock() {
-	t.m.Lock()
+//	apply the key type claims, then replay the writes to wb and commit it
+func (t *tx) write(wb store.WriteBatch) error {
+	defer t.Rollback()
+
+	if err := t.applyClaims(); err != nil {
+		return err
+	}
+
+	if err := t.writeTo(wb); err != nil {
+		return err
+	}
+
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+
+	t.touchVersions()
+	return nil
 }
 
-func (t *tx) Unlock() {
-	t.batch = t.batch[0:0]
-	t.wb.Rollback()
-	t.m.Unlock()
+//	replay the writes of t to wb
+func (t *tx) writeTo(wb store.WriteBatch) error {
+	for _, event := range t.batch {
+		switch event[0] {
+		case BinLogTypePut:
+			key, value, err := decodeBinLogPut(event)
+			if err != nil {
+				return err
+			}
+			wb.Put(key, value)
+		default:
+			key, err := decodeBinLogDelete(event)
+			if err != nil {
+				return err
+			}
+			wb.Delete(key)
+		}
+	}
+	return nil
 }
 
-func (t *tx) Commit() error {
-	var err error
-	if t.binlog != nil {
-		t.l.Lock()
-		err = t.wb.Commit()
-		if err != nil {
-			t.l.Unlock()
-			return err
+//	log the writes of t since batch[start] as a command event if command events are enabled,
+//	the commands logged inside are replaced.
+func (t *tx) logCommand(start int, commandType uint8, args ...[]byte) {
+	if !t.l.cfg.BinLog.CommandEvents || t.db != t.l.ldb || start >= len(t.batch) {
+		return
+	}
+
+	for n := len(t.commands); n > 0 && t.commands[n-1].start >= start; n-- {
+		t.commands = t.commands[0 : n-1]
+	}
+
+	t.commands = append(t.commands, txCommand{start, len(t.batch), encodeBinLogCommand(commandType, args...)})
+}
+
+//	log all the commits of t as one command event, which is logged with the first one,
+//	for the writes too many to commit once, like flush
+func (t *tx) logAllAsCommand(commandType uint8, args ...[]byte) {
+	if !t.l.cfg.BinLog.CommandEvents || t.db != t.l.ldb {
+		return
+	}
+
+	t.command = encodeBinLogCommand(commandType, args...)
+}
+
+//	the binlog events of the writes to commit
+func (t *tx) binLogEvents() [][]byte {
+	if t.command != nil {
+		if t.commandLogged {
+			return nil
 		}
 
-		err = t.binlog.Log(t.batch...)
+		t.commandLogged = true
+		return [][]byte{t.command}
+	} else if len(t.commands) == 0 {
+		return t.batch
+	}
 
-		t.l.Unlock()
-	} else {
-		t.l.Lock()
-		err = t.wb.Commit()
-		t.l.Unlock()
+	events := make([][]byte, 0, len(t.batch))
+
+	pos := 0
+	for _, c := range t.commands {
+		events = append(events, t.batch[pos:c.start]...)
+		events = append(events, c.event)
+		pos = c.end
+	}
+
+	return append(events, t.batch[pos:]...)
+}
+
+//	increase the versions of the keys written after they are committed
+func (t *tx) touchVersions() {
+	for _, slot := range t.touched {
+		atomic.AddUint64(&t.versions[slot], 1)
 	}
-	return err
+	t.touched = t.touched[0:0]
 }
 
 func (t *tx) Rollback() {
-	t.wb.Rollback()
+	t.batch = t.batch[0:0]
+	t.commands = t.commands[0:0]
+	t.claims = t.claims[0:0]
+	t.touched = t.touched[0:0]
 }
@@ -111,3 +111,90 @@ func MaxInt32(a int32, b int32) int32 {
 		return b
 	}
 }
+
+//	PatternMatch matches s with the glob-style pattern like redis, supports *, ?, [...] and \ escaping
+func PatternMatch(pattern []byte, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if PatternMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						match = true
+					}
+					pattern = pattern[1:]
+				} else if len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[0] >= start && s[0] <= end {
+						match = true
+					}
+					pattern = pattern[3:]
+				} else {
+					if pattern[0] == s[0] {
+						match = true
+					}
+					pattern = pattern[1:]
+				}
+			}
+
+			if len(pattern) > 0 {
+				//	skip ]
+				pattern = pattern[1:]
+			}
+
+			if match == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(s) == 0
+}
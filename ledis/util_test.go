@@ -0,0 +1,35 @@
+package ledis
+
+import (
+	"testing"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tbl := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "", true},
+		{"a*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abcd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a[bc]d", "acd", true},
+		{"a[^bc]d", "acd", false},
+		{"a[^bc]d", "aed", true},
+		{"a[a-c]d", "abd", true},
+		{"a[c-a]d", "abd", true},
+		{"a[a-c]d", "add", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"news.*", "news.tech", true},
+	}
+
+	for _, v := range tbl {
+		if PatternMatch([]byte(v.pattern), []byte(v.s)) != v.match {
+			t.Fatal(v.pattern, v.s)
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"github.com/siddontang/go-log/log"
 	"github.com/siddontang/ledisdb/config"
 	"github.com/siddontang/ledisdb/ledis"
 	"net"
 	"net/http"
 	"path"
 	"strings"
+	"time"
 )
 
 type App struct {
@@ -25,6 +27,15 @@ type App struct {
 
 	//for slave replication
 	m *master
+
+	//the acks of the stream slaves, for semi-sync replication
+	acks *slaveAcks
+
+	pubsub *pubsub
+
+	script *script
+
+	start time.Time
 }
 
 func netType(s string) string {
@@ -47,10 +58,17 @@ func NewApp(cfg *config.Config) (*App, error) {
 
 	app.closed = false
 
+	app.start = time.Now()
+
 	app.cfg = cfg
 
 	var err error
 
+	events, err := parseKeyspaceEvents(cfg.NotifyKeyspaceEvents)
+	if err != nil {
+		return nil, err
+	}
+
 	if app.listener, err = net.Listen(netType(cfg.Addr), cfg.Addr); err != nil {
 		return nil, err
 	}
@@ -79,6 +97,14 @@ func NewApp(cfg *config.Config) (*App, error) {
 
 	app.m = newMaster(app)
 
+	app.acks = newSlaveAcks()
+
+	app.pubsub = newPubSub()
+
+	app.script = newScript()
+
+	app.publishKeyEvents(events)
+
 	return app, nil
 }
 
@@ -108,7 +134,9 @@ func (app *App) Close() {
 
 func (app *App) Run() {
 	if len(app.cfg.SlaveOf) > 0 {
-		app.slaveof(app.cfg.SlaveOf)
+		if err := app.slaveof(app.cfg.SlaveOf, false); err != nil {
+			log.Error("slaveof %s error %s", app.cfg.SlaveOf, err.Error())
+		}
 	}
 
 	go app.httpServe()
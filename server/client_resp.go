@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var errReadRequest = errors.New("invalid request protocol")
@@ -22,7 +23,18 @@ type respClient struct {
 	conn net.Conn
 	rb   *bufio.Reader
 
-	req *requestContext
+	req  *requestContext
+	resp *respWriter
+
+	//	replies and pushed messages are written under wlock
+	wlock sync.Mutex
+
+	//	subscribed channels and patterns
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	msgs     chan *pubsubMessage
+
+	quit chan struct{}
 }
 
 type respWriter struct {
@@ -35,13 +47,18 @@ func newClientRESP(conn net.Conn, app *App) {
 	c.app = app
 	c.conn = conn
 	c.ldb = app.ldb
-	c.db, _ = app.ldb.Select(0)
 
 	c.rb = bufio.NewReaderSize(conn, 256)
 
 	c.req = newRequestContext(app)
-	c.req.resp = newWriterRESP(conn)
+	c.db = c.req.db
+	c.resp = newWriterRESP(conn)
+
+	c.req.resp = c.resp
 	c.req.remoteAddr = conn.RemoteAddr().String()
+	c.req.client = c
+
+	c.quit = make(chan struct{})
 
 	go c.run()
 }
@@ -56,6 +73,13 @@ func (c *respClient) run() {
 			log.Fatal("client run panic %s:%v", buf, e)
 		}
 
+		c.unsubscribeAll()
+		close(c.quit)
+
+		if c.req.slave {
+			c.ldb.RemoveSlave(c.req.remoteAddr)
+		}
+
 		c.conn.Close()
 	}()
 
@@ -138,6 +162,10 @@ func (c *respClient) handleRequest(reqData [][]byte) {
 		c.req.cmd = strings.ToLower(ledis.String(reqData[0]))
 		c.req.args = reqData[1:]
 	}
+
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+
 	if c.req.cmd == "quit" {
 		c.req.resp.writeStatus(OK)
 		c.req.resp.flush()
@@ -145,6 +173,16 @@ func (c *respClient) handleRequest(reqData [][]byte) {
 		return
 	}
 
+	if c.subscriptions() > 0 {
+		switch c.req.cmd {
+		case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ping":
+		default:
+			c.req.resp.writeError(errPubSubContext)
+			c.req.resp.flush()
+			return
+		}
+	}
+
 	req.db = c.db
 
 	c.req.perform()
@@ -156,13 +194,22 @@ func (c *respClient) handleRequest(reqData [][]byte) {
 
 //	response writer
 
-func newWriterRESP(conn net.Conn) *respWriter {
+func newWriterRESP(conn io.Writer) *respWriter {
 	w := new(respWriter)
 	w.buff = bufio.NewWriterSize(conn, 256)
 	return w
 }
 
 func (w *respWriter) writeError(err error) {
+	if err == ledis.ErrWrongType || err == ledis.ErrBusyKey || err == errNoScript || err == errExecAbort ||
+		err == errReadOnly || err == errNotBusy {
+		//like redis, these errors have their own prefix
+		w.buff.WriteByte('-')
+		w.buff.Write(ledis.Slice(err.Error()))
+		w.buff.Write(Delims)
+		return
+	}
+
 	w.buff.Write(ledis.Slice("-ERR"))
 	if err != nil {
 		w.buff.WriteByte(' ')
@@ -287,6 +334,17 @@ func (w *respWriter) writeBulkFrom(n int64, rb io.Reader) {
 	w.buff.Write(Delims)
 }
 
+func (w *respWriter) writeArrayHeader(n int) {
+	w.buff.WriteByte('*')
+	w.buff.Write(ledis.StrPutInt64(int64(n)))
+	w.buff.Write(Delims)
+}
+
+//	write the already encoded replies
+func (w *respWriter) writeRaw(b []byte) {
+	w.buff.Write(b)
+}
+
 func (w *respWriter) flush() {
 	w.buff.Flush()
 }
@@ -2,6 +2,8 @@ package server
 
 import (
 	"github.com/siddontang/ledisdb/ledis"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -40,10 +42,10 @@ func bsetbitCommand(req *requestContext) error {
 	}
 
 	var err error
-	var offset int32
+	var offset int64
 	var val int8
 
-	offset, err = ledis.StrInt32(args[1], nil)
+	offset, err = ledis.StrInt64(args[1], nil)
 
 	if err != nil {
 		return ErrOffset
@@ -72,7 +74,7 @@ func bgetbitCommand(req *requestContext) error {
 		return ErrCmdParams
 	}
 
-	offset, err := ledis.StrInt32(args[1], nil)
+	offset, err := ledis.StrInt64(args[1], nil)
 
 	if err != nil {
 		return ErrOffset
@@ -100,12 +102,12 @@ func bmsetbitCommand(req *requestContext) error {
 	}
 
 	var err error
-	var offset int32
+	var offset int64
 	var val int8
 
 	pairs := make([]ledis.BitPair, len(args)>>1)
 	for i := 0; i < len(pairs); i++ {
-		offset, err = ledis.StrInt32(args[i<<1], nil)
+		offset, err = ledis.StrInt64(args[i<<1], nil)
 
 		if err != nil {
 			return ErrOffset
@@ -140,20 +142,20 @@ func bcountCommand(req *requestContext) error {
 		return ErrCmdParams
 	}
 
-	// BCount(key []byte, start int32, end int32) (cnt int32, err error) {
+	// BCount(key []byte, start int64, end int64) (cnt int64, err error) {
 
 	var err error
-	var start, end int32 = 0, -1
+	var start, end int64 = 0, -1
 
 	if argCnt > 1 {
-		start, err = ledis.StrInt32(args[1], nil)
+		start, err = ledis.StrInt64(args[1], nil)
 		if err != nil {
 			return ErrValue
 		}
 	}
 
 	if argCnt > 2 {
-		end, err = ledis.StrInt32(args[2], nil)
+		end, err = ledis.StrInt64(args[2], nil)
 		if err != nil {
 			return ErrValue
 		}
@@ -272,6 +274,160 @@ func bpersistCommand(req *requestContext) error {
 	return nil
 }
 
+func bposCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 && len(args) != 4 {
+		return ErrCmdParams
+	}
+
+	bit, err := ledis.StrInt8(args[1], nil)
+	if err != nil || (bit != 0 && bit != 1) {
+		return ErrBool
+	}
+
+	var start, end int64 = 0, -1
+	if len(args) == 4 {
+		if start, err = ledis.StrInt64(args[2], nil); err != nil {
+			return ErrValue
+		} else if end, err = ledis.StrInt64(args[3], nil); err != nil {
+			return ErrValue
+		}
+	}
+
+	pos, err := req.db.BPos(args[0], uint8(bit), start, end)
+	if err != nil {
+		return err
+	}
+
+	//	without an end, the bits after the tail are all 0
+	if pos < 0 && bit == 0 && len(args) == 2 {
+		if pos, err = req.db.BTail(args[0]); err != nil {
+			return err
+		}
+		pos++
+	}
+
+	req.resp.writeInteger(int64(pos))
+	return nil
+}
+
+//	parse the bitfield type like i8 or u16
+func bfieldParseType(b []byte) (bool, uint32, error) {
+	s := strings.ToLower(ledis.String(b))
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return false, 0, ErrSyntax
+	}
+
+	signed := s[0] == 'i'
+	width, err := strconv.ParseUint(s[1:], 10, 32)
+	if err != nil || width == 0 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, ErrSyntax
+	}
+
+	return signed, uint32(width), nil
+}
+
+//	parse the bitfield offset, #N means N times the width
+func bfieldParseOffset(b []byte, width uint32) (int64, error) {
+	s := ledis.String(b)
+
+	var n int64 = 1
+	if len(s) > 0 && s[0] == '#' {
+		s = s[1:]
+		n = int64(width)
+	}
+
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 || offset > math.MaxUint32/n {
+		return 0, ErrOffset
+	}
+
+	return offset * n, nil
+}
+
+//	BFIELD key [GET type offset] [SET type offset value] [INCRBY type offset incr]
+//		[OVERFLOW WRAP|SAT|FAIL]
+func bfieldCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 1 {
+		return ErrCmdParams
+	}
+
+	fields := make([]ledis.BitField, 0, 4)
+	overflow := ledis.BitOverflowWrap
+
+	for i := 1; i < len(args); {
+		op := strings.ToLower(ledis.String(args[i]))
+		if op == "overflow" {
+			if i+1 >= len(args) {
+				return ErrSyntax
+			}
+
+			switch strings.ToLower(ledis.String(args[i+1])) {
+			case "wrap":
+				overflow = ledis.BitOverflowWrap
+			case "sat":
+				overflow = ledis.BitOverflowSat
+			case "fail":
+				overflow = ledis.BitOverflowFail
+			default:
+				return ErrSyntax
+			}
+			i += 2
+			continue
+		}
+
+		f := ledis.BitField{Overflow: overflow}
+		n := 4
+		switch op {
+		case "get":
+			f.Op = ledis.BitFieldGet
+			n = 3
+		case "set":
+			f.Op = ledis.BitFieldSet
+		case "incrby":
+			f.Op = ledis.BitFieldIncrBy
+		default:
+			return ErrSyntax
+		}
+
+		if i+n > len(args) {
+			return ErrSyntax
+		}
+
+		var err error
+		if f.Signed, f.Width, err = bfieldParseType(args[i+1]); err != nil {
+			return err
+		} else if f.Offset, err = bfieldParseOffset(args[i+2], f.Width); err != nil {
+			return err
+		}
+
+		if n == 4 {
+			if f.Value, err = ledis.StrInt64(args[i+3], nil); err != nil {
+				return ErrValue
+			}
+		}
+
+		fields = append(fields, f)
+		i += n
+	}
+
+	v, err := req.db.BField(args[0], fields...)
+	if err != nil {
+		return err
+	}
+
+	ay := make([]interface{}, len(v))
+	for i, n := range v {
+		if n != nil {
+			ay[i] = *n
+		}
+	}
+
+	req.resp.writeArray(ay)
+	return nil
+}
+
 func init() {
 	register("bget", bgetCommand)
 	register("bdelete", bdeleteCommand)
@@ -284,4 +440,6 @@ func init() {
 	register("bexpireat", bexpireAtCommand)
 	register("bttl", bttlCommand)
 	register("bpersist", bpersistCommand)
+	register("bpos", bposCommand)
+	register("bfield", bfieldCommand)
 }
@@ -10,6 +10,9 @@ func TestBit(t *testing.T) {
 	testBitMset(t)
 	testBitCount(t)
 	testBitOpt(t)
+	testBitPos(t)
+	testBitField(t)
+	testBitSparse(t)
 }
 
 func testBitGetSet(t *testing.T) {
@@ -365,3 +368,121 @@ func TestBitErrorParams(t *testing.T) {
 	}
 
 }
+
+func testBitPos(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := []byte("test_cmd_bin_pos")
+
+	if pos, err := ledis.Int(c.Do("bpos", key, 0)); err != nil {
+		t.Fatal(err)
+	} else if pos != 0 {
+		t.Fatal(pos)
+	}
+
+	if pos, err := ledis.Int(c.Do("bpos", key, 1)); err != nil {
+		t.Fatal(err)
+	} else if pos != -1 {
+		t.Fatal(pos)
+	}
+
+	c.Do("bsetbit", key, 0, 1)
+	c.Do("bsetbit", key, 1, 1)
+	c.Do("bsetbit", key, 5000, 1)
+
+	if pos, err := ledis.Int(c.Do("bpos", key, 1, 2, -1)); err != nil {
+		t.Fatal(err)
+	} else if pos != 5000 {
+		t.Fatal(pos)
+	}
+
+	if pos, err := ledis.Int(c.Do("bpos", key, 0, 0, 1)); err != nil {
+		t.Fatal(err)
+	} else if pos != -1 {
+		t.Fatal(pos)
+	}
+
+	if _, err := c.Do("bpos", key, 2); err == nil {
+		t.Fatal("must error")
+	}
+}
+
+func testBitField(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := []byte("test_cmd_bin_field")
+
+	if v, err := ledis.MultiBulk(c.Do("bfield", key, "set", "u16", 4090, 0xABCD, "get", "u16", 4090, "get", "i8", 4098, "get", "u8", "#511", "incrby", "u4", 0, 1)); err != nil {
+		t.Fatal(err)
+	} else if v[0].(int64) != 0 || v[1].(int64) != 0xABCD || v[2].(int64) != -51 || v[3].(int64) != 42 || v[4].(int64) != 1 {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.MultiBulk(c.Do("bfield", key, "set", "i8", 100, 127, "incrby", "i8", 100, 1,
+		"overflow", "sat", "incrby", "i8", 100, 10, "overflow", "fail", "incrby", "i8", 100, 1)); err != nil {
+		t.Fatal(err)
+	} else if v[0].(int64) != 0 || v[1].(int64) != -128 || v[2].(int64) != -118 || v[3].(int64) != -117 {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.MultiBulk(c.Do("bfield", key, "overflow", "fail", "incrby", "u2", 200, 4)); err != nil {
+		t.Fatal(err)
+	} else if v[0] != nil {
+		t.Fatal(v)
+	}
+
+	if _, err := c.Do("bfield", key, "get", "u64", 0); err == nil {
+		t.Fatal("must error")
+	}
+
+	if _, err := c.Do("bfield", key, "set", "i8", 0); err == nil {
+		t.Fatal("must error")
+	}
+
+	if _, err := c.Do("bfield", key, "overflow", "none"); err == nil {
+		t.Fatal("must error")
+	}
+}
+
+func testBitSparse(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := []byte("test_cmd_bin_sparse")
+
+	if ori, err := ledis.Int(c.Do("bsetbit", key, 4294967295, 1)); err != nil {
+		t.Fatal(err)
+	} else if ori != 0 {
+		t.Fatal(ori)
+	}
+
+	if _, err := c.Do("bsetbit", key, 4294967296, 1); err == nil {
+		t.Fatal("must error")
+	}
+
+	if v, err := ledis.Int(c.Do("bgetbit", key, 4294967295)); err != nil {
+		t.Fatal(err)
+	} else if v != 1 {
+		t.Fatal(v)
+	}
+
+	if cnt, err := ledis.Int(c.Do("bcount", key)); err != nil {
+		t.Fatal(err)
+	} else if cnt != 1 {
+		t.Fatal(cnt)
+	}
+
+	if pos, err := ledis.Int64(c.Do("bpos", key, 1)); err != nil {
+		t.Fatal(err)
+	} else if pos != 4294967295 {
+		t.Fatal(pos)
+	}
+
+	if v, err := ledis.MultiBulk(c.Do("bfield", key, "get", "u8", "#536870911")); err != nil {
+		t.Fatal(err)
+	} else if v[0].(int64) != 1 {
+		t.Fatal(v)
+	}
+}
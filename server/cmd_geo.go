@@ -0,0 +1,368 @@
+package server
+
+import (
+	"errors"
+	"github.com/siddontang/ledisdb/ledis"
+	"strconv"
+	"strings"
+)
+
+var errGeoUnit = errors.New("unsupported unit provided. please use m, km, ft, mi")
+
+func geoParseFloat(b []byte) (float64, error) {
+	f, err := strconv.ParseFloat(ledis.String(b), 64)
+	if err != nil {
+		return 0, ErrFloat
+	}
+	return f, nil
+}
+
+//	return the meters of one unit
+func geoParseUnit(b []byte) (float64, error) {
+	switch strings.ToLower(ledis.String(b)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	default:
+		return 0, errGeoUnit
+	}
+}
+
+func geoFormatFloat(f float64) []byte {
+	return ledis.Slice(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+func geoFormatDist(d float64, unit float64) []byte {
+	return ledis.Slice(strconv.FormatFloat(d/unit, 'f', 4, 64))
+}
+
+func geoaddCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 4 || (len(args)-1)%3 != 0 {
+		return ErrCmdParams
+	}
+
+	key := args[0]
+	args = args[1:]
+
+	members := make([]ledis.GeoMember, len(args)/3)
+	for i := 0; i < len(members); i++ {
+		lon, err := geoParseFloat(args[3*i])
+		if err != nil {
+			return err
+		}
+
+		lat, err := geoParseFloat(args[3*i+1])
+		if err != nil {
+			return err
+		}
+
+		members[i] = ledis.GeoMember{Longitude: lon, Latitude: lat, Member: args[3*i+2]}
+	}
+
+	if n, err := req.db.GeoAdd(key, members...); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+func geoposCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 2 {
+		return ErrCmdParams
+	}
+
+	v, err := req.db.GeoPos(args[0], args[1:]...)
+	if err != nil {
+		return err
+	}
+
+	ay := make([]interface{}, len(v))
+	for i, p := range v {
+		if p != nil {
+			ay[i] = []interface{}{geoFormatFloat(p.Longitude), geoFormatFloat(p.Latitude)}
+		}
+	}
+
+	req.resp.writeArray(ay)
+	return nil
+}
+
+func geodistCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 3 && len(args) != 4 {
+		return ErrCmdParams
+	}
+
+	unit := 1.0
+	if len(args) == 4 {
+		var err error
+		if unit, err = geoParseUnit(args[3]); err != nil {
+			return err
+		}
+	}
+
+	if d, err := req.db.GeoDist(args[0], args[1], args[2]); err == ledis.ErrScoreMiss {
+		req.resp.writeBulk(nil)
+	} else if err != nil {
+		return err
+	} else {
+		req.resp.writeBulk(geoFormatDist(d, unit))
+	}
+
+	return nil
+}
+
+func geohashCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 2 {
+		return ErrCmdParams
+	}
+
+	if v, err := req.db.GeoHash(args[0], args[1:]...); err != nil {
+		return err
+	} else {
+		req.resp.writeSliceArray(v)
+	}
+
+	return nil
+}
+
+type geoSearchOptions struct {
+	withDist  bool
+	withCoord bool
+	withHash  bool
+}
+
+//	parse the options [WITHDIST] [WITHCOORD] [WITHHASH] [COUNT count] [ASC|DESC],
+//	shape options are handled by shape if not nil.
+func geoParseOptions(args [][]byte, q *ledis.GeoQuery, shape func(opt string, args [][]byte) (int, error)) (*geoSearchOptions, error) {
+	opts := new(geoSearchOptions)
+
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(ledis.String(args[i])); opt {
+		case "withdist":
+			opts.withDist = true
+		case "withcoord":
+			opts.withCoord = true
+		case "withhash":
+			opts.withHash = true
+		case "asc":
+			q.Sort = ledis.GeoSortAsc
+		case "desc":
+			q.Sort = ledis.GeoSortDesc
+		case "count":
+			if i+1 >= len(args) {
+				return nil, ErrSyntax
+			}
+
+			n, err := strconv.Atoi(ledis.String(args[i+1]))
+			if err != nil || n <= 0 {
+				return nil, ErrValue
+			}
+			q.Count = n
+			i++
+		default:
+			if shape == nil {
+				return nil, ErrSyntax
+			}
+
+			n, err := shape(opt, args[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			i += n
+		}
+	}
+
+	return opts, nil
+}
+
+func geoSearchGeneric(req *requestContext, key []byte, q *ledis.GeoQuery, unit float64, opts *geoSearchOptions) error {
+	v, err := req.db.GeoSearch(key, q)
+	if err == ledis.ErrScoreMiss {
+		return errors.New("could not decode requested zset member")
+	} else if err != nil {
+		return err
+	}
+
+	ay := make([]interface{}, len(v))
+	for i, l := range v {
+		if !opts.withDist && !opts.withCoord && !opts.withHash {
+			ay[i] = l.Member
+			continue
+		}
+
+		item := []interface{}{l.Member}
+		if opts.withDist {
+			item = append(item, geoFormatDist(l.Dist, unit))
+		}
+		if opts.withHash {
+			item = append(item, l.Hash)
+		}
+		if opts.withCoord {
+			item = append(item, []interface{}{geoFormatFloat(l.Longitude), geoFormatFloat(l.Latitude)})
+		}
+		ay[i] = item
+	}
+
+	req.resp.writeArray(ay)
+	return nil
+}
+
+//	GEORADIUS key longitude latitude radius m|km|ft|mi [options]
+func georadiusCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 5 {
+		return ErrCmdParams
+	}
+
+	q := new(ledis.GeoQuery)
+
+	var err error
+	if q.Longitude, err = geoParseFloat(args[1]); err != nil {
+		return err
+	} else if q.Latitude, err = geoParseFloat(args[2]); err != nil {
+		return err
+	}
+
+	return georadiusGeneric(req, q, args[3:])
+}
+
+//	GEORADIUSBYMEMBER key member radius m|km|ft|mi [options]
+func georadiusbymemberCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 4 {
+		return ErrCmdParams
+	}
+
+	q := new(ledis.GeoQuery)
+	q.Member = args[1]
+
+	return georadiusGeneric(req, q, args[2:])
+}
+
+func georadiusGeneric(req *requestContext, q *ledis.GeoQuery, args [][]byte) error {
+	radius, err := geoParseFloat(args[0])
+	if err != nil {
+		return err
+	} else if radius <= 0 {
+		return ErrFloat
+	}
+
+	unit, err := geoParseUnit(args[1])
+	if err != nil {
+		return err
+	}
+
+	q.Radius = radius * unit
+
+	opts, err := geoParseOptions(args[2:], q, nil)
+	if err != nil {
+		return err
+	}
+
+	return geoSearchGeneric(req, req.args[0], q, unit, opts)
+}
+
+//	GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude
+//		BYRADIUS radius m|km|ft|mi | BYBOX width height m|km|ft|mi [options]
+func geosearchCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 5 {
+		return ErrCmdParams
+	}
+
+	q := new(ledis.GeoQuery)
+
+	unit := 0.0
+	from := false
+
+	shape := func(opt string, args [][]byte) (int, error) {
+		var err error
+		switch opt {
+		case "frommember":
+			if len(args) < 1 || from {
+				return 0, ErrSyntax
+			}
+
+			q.Member = args[0]
+			from = true
+			return 1, nil
+		case "fromlonlat":
+			if len(args) < 2 || from {
+				return 0, ErrSyntax
+			}
+
+			if q.Longitude, err = geoParseFloat(args[0]); err != nil {
+				return 0, err
+			} else if q.Latitude, err = geoParseFloat(args[1]); err != nil {
+				return 0, err
+			}
+			from = true
+			return 2, nil
+		case "byradius":
+			if len(args) < 2 || unit != 0 {
+				return 0, ErrSyntax
+			}
+
+			if q.Radius, err = geoParseFloat(args[0]); err != nil {
+				return 0, err
+			} else if q.Radius <= 0 {
+				return 0, ErrFloat
+			} else if unit, err = geoParseUnit(args[1]); err != nil {
+				return 0, err
+			}
+
+			q.Radius *= unit
+			return 2, nil
+		case "bybox":
+			if len(args) < 3 || unit != 0 {
+				return 0, ErrSyntax
+			}
+
+			if q.Width, err = geoParseFloat(args[0]); err != nil {
+				return 0, err
+			} else if q.Height, err = geoParseFloat(args[1]); err != nil {
+				return 0, err
+			} else if q.Width <= 0 || q.Height <= 0 {
+				return 0, ErrFloat
+			} else if unit, err = geoParseUnit(args[2]); err != nil {
+				return 0, err
+			}
+
+			q.Width *= unit
+			q.Height *= unit
+			return 3, nil
+		default:
+			return 0, ErrSyntax
+		}
+	}
+
+	opts, err := geoParseOptions(args[1:], q, shape)
+	if err != nil {
+		return err
+	} else if !from || unit == 0 {
+		return ErrSyntax
+	}
+
+	return geoSearchGeneric(req, args[0], q, unit, opts)
+}
+
+func init() {
+	register("geoadd", geoaddCommand)
+	register("geopos", geoposCommand)
+	register("geodist", geodistCommand)
+	register("geohash", geohashCommand)
+	register("georadius", georadiusCommand)
+	register("georadiusbymember", georadiusbymemberCommand)
+	register("geosearch", geosearchCommand)
+}
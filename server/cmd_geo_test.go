@@ -0,0 +1,85 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"testing"
+)
+
+func TestGeo(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "geo_sicily"
+
+	if n, err := ledis.Int(c.Do("geoadd", key, "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("geoadd", key, "200", "10", "bad"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if v, err := ledis.String(c.Do("geodist", key, "Palermo", "Catania", "km")); err != nil {
+		t.Fatal(err)
+	} else if v != "166.2742" {
+		t.Fatal(v)
+	}
+
+	if v, err := c.Do("geodist", key, "Palermo", "none"); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.Strings(c.Do("geohash", key, "Palermo", "Catania")); err != nil {
+		t.Fatal(err)
+	} else if v[0] != "sqc8b49rny0" || v[1] != "sqdtr74hyu0" {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.MultiBulk(c.Do("geopos", key, "Palermo", "none")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || v[1] != nil {
+		t.Fatal(v)
+	} else if pos, _ := ledis.Strings(v[0], nil); len(pos) != 2 || pos[0][0:9] != "13.361389" {
+		t.Fatal(pos)
+	}
+
+	if v, err := ledis.Strings(c.Do("georadius", key, 15, 37, 200, "km", "asc")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || v[0] != "Catania" || v[1] != "Palermo" {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.MultiBulk(c.Do("georadius", key, 15, 37, 200, "km", "withdist", "desc", "count", 1)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 {
+		t.Fatal(v)
+	} else if item, _ := ledis.Strings(v[0], nil); item[0] != "Palermo" || item[1] != "190.4424" {
+		t.Fatal(item)
+	}
+
+	if v, err := ledis.Strings(c.Do("georadiusbymember", key, "Palermo", 200, "km", "asc")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || v[0] != "Palermo" {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.Strings(c.Do("geosearch", key, "fromlonlat", 15, 37, "bybox", 400, 400, "km", "asc")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || v[0] != "Catania" {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.Strings(c.Do("geosearch", key, "frommember", "Catania", "byradius", 100, "km")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || v[0] != "Catania" {
+		t.Fatal(v)
+	}
+
+	if _, err := c.Do("geosearch", key, "byradius", 100, "km"); err == nil {
+		t.Fatal("must error")
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/ledis"
+)
+
+func pfaddCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 1 {
+		return ErrCmdParams
+	}
+
+	if n, err := req.db.PFAdd(args[0], args[1:]...); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+func pfcountCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 1 {
+		return ErrCmdParams
+	}
+
+	if n, err := req.db.PFCount(args...); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+func pfmergeCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 1 {
+		return ErrCmdParams
+	}
+
+	if err := req.db.PFMerge(args[0], args[1:]...); err != nil {
+		return err
+	} else {
+		req.resp.writeStatus(OK)
+	}
+
+	return nil
+}
+
+func pfclearCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 1 {
+		return ErrCmdParams
+	}
+
+	if n, err := req.db.PFClear(args[0]); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+func pfexpireCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 {
+		return ErrCmdParams
+	}
+
+	duration, err := ledis.StrInt64(args[1], nil)
+	if err != nil {
+		return ErrValue
+	}
+
+	if v, err := req.db.PFExpire(args[0], duration); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(v)
+	}
+
+	return nil
+}
+
+func pfexpireAtCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 {
+		return ErrCmdParams
+	}
+
+	when, err := ledis.StrInt64(args[1], nil)
+	if err != nil {
+		return ErrValue
+	}
+
+	if v, err := req.db.PFExpireAt(args[0], when); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(v)
+	}
+
+	return nil
+}
+
+func pfttlCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 1 {
+		return ErrCmdParams
+	}
+
+	if v, err := req.db.PFTTL(args[0]); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(v)
+	}
+
+	return nil
+}
+
+func pfpersistCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 1 {
+		return ErrCmdParams
+	}
+
+	if n, err := req.db.PFPersist(args[0]); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+func init() {
+	register("pfadd", pfaddCommand)
+	register("pfcount", pfcountCommand)
+	register("pfmerge", pfmergeCommand)
+	register("pfclear", pfclearCommand)
+	register("pfexpire", pfexpireCommand)
+	register("pfexpireat", pfexpireAtCommand)
+	register("pfttl", pfttlCommand)
+	register("pfpersist", pfpersistCommand)
+}
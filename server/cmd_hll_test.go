@@ -0,0 +1,83 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if n, err := ledis.Int(c.Do("pfadd", "hll_a", "a", "b", "c")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfadd", "hll_a", "a")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfadd", "hll_b", "c", "d")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfcount", "hll_a")); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfcount", "hll_a", "hll_b")); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatal(n)
+	}
+
+	if ok, err := ledis.String(c.Do("pfmerge", "hll_c", "hll_a", "hll_b")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+
+	if n, err := ledis.Int(c.Do("pfcount", "hll_c")); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfexpire", "hll_c", 100)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfttl", "hll_c")); err != nil {
+		t.Fatal(err)
+	} else if n <= 0 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfpersist", "hll_c")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfclear", "hll_c")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("pfcount", "hll_c")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/siddontang/ledisdb/ledis"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+//	the sections of INFO in output order, each dumps its fields as name:value lines
+var infoSections = []struct {
+	name string
+	dump func(app *App, buf *bytes.Buffer)
+}{
+	{"server", (*App).dumpServerInfo},
+	{"lazyfree", (*App).dumpLazyFreeInfo},
+	{"replication", (*App).dumpReplicationInfo},
+}
+
+func (app *App) dumpServerInfo(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(buf, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(buf, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(buf, "addr:%s\r\n", app.cfg.Addr)
+	fmt.Fprintf(buf, "uptime_in_seconds:%d\r\n", int64(time.Since(app.start).Seconds()))
+}
+
+func (app *App) dumpLazyFreeInfo(buf *bytes.Buffer) {
+	s := app.ldb.LazyFreeStats()
+
+	fmt.Fprintf(buf, "lazyfree_threshold:%d\r\n", app.cfg.LazyFreeThreshold)
+	fmt.Fprintf(buf, "lazyfree_pending_keys:%d\r\n", s.PendingKeys)
+	fmt.Fprintf(buf, "lazyfree_freed_keys:%d\r\n", s.FreedKeys)
+	fmt.Fprintf(buf, "lazyfree_freed_subkeys:%d\r\n", s.FreedSubKeys)
+}
+
+func (app *App) dumpReplicationInfo(buf *bytes.Buffer) {
+	s := app.m.getState()
+
+	if s.state == replStateNone {
+		fmt.Fprintf(buf, "role:master\r\n")
+	} else {
+		host, port, _ := net.SplitHostPort(s.masterAddr)
+
+		linkStatus := "down"
+		if s.state == replStateConnected {
+			linkStatus = "up"
+		}
+
+		lastIO := int64(-1)
+		if !s.lastIO.IsZero() {
+			lastIO = int64(time.Since(s.lastIO).Seconds())
+		}
+
+		syncInProgress := 0
+		if s.state == replStateSync {
+			syncInProgress = 1
+		}
+
+		readOnly, localWrites := 0, 0
+		if app.cfg.SlaveReadOnly {
+			readOnly = 1
+		}
+		if s.localWrites {
+			localWrites = 1
+		}
+
+		//	the age of the last event replicated, if there are more after it
+		lagSeconds := int64(0)
+		if t := app.ldb.ReplicateTime(); s.lagBytes > 0 && t > 0 {
+			lagSeconds = time.Now().Unix() - int64(t)
+		}
+
+		fmt.Fprintf(buf, "role:slave\r\n")
+		fmt.Fprintf(buf, "master_host:%s\r\n", host)
+		fmt.Fprintf(buf, "master_port:%s\r\n", port)
+		fmt.Fprintf(buf, "master_link_status:%s\r\n", linkStatus)
+		fmt.Fprintf(buf, "master_last_io_seconds_ago:%d\r\n", lastIO)
+		fmt.Fprintf(buf, "master_sync_in_progress:%d\r\n", syncInProgress)
+		fmt.Fprintf(buf, "master_sync_read_bytes:%d\r\n", s.syncReadBytes)
+		fmt.Fprintf(buf, "slave_last_id:%d\r\n", s.lastID)
+		fmt.Fprintf(buf, "slave_lag_bytes:%d\r\n", s.lagBytes)
+		fmt.Fprintf(buf, "slave_lag_seconds:%d\r\n", lagSeconds)
+		fmt.Fprintf(buf, "slave_read_only:%d\r\n", readOnly)
+		fmt.Fprintf(buf, "slave_local_writes:%d\r\n", localWrites)
+	}
+
+	//	a slave may have slaves too
+	slaves := app.acks.list()
+
+	fmt.Fprintf(buf, "last_id:%d\r\n", app.ldb.BinLogLastID())
+	fmt.Fprintf(buf, "connected_slaves:%d\r\n", len(slaves))
+	for i, ack := range slaves {
+		fmt.Fprintf(buf, "slave%d:addr=%s,port=%s,last_id=%d,log_file_index=%d,log_pos=%d,lag_bytes=%d,ack_seconds_ago=%d\r\n",
+			i, ack.addr, ack.port, ack.LastID, ack.LogFileIndex, ack.LogPos,
+			app.ldb.BinLogBytesAfter(ack.LogFileIndex, ack.LogPos), int64(time.Since(ack.time).Seconds()))
+	}
+}
+
+//	INFO [section], all sections if no section is given
+func infoCommand(req *requestContext) error {
+	if len(req.args) > 1 {
+		return ErrCmdParams
+	}
+
+	section := "all"
+	if len(req.args) == 1 {
+		section = strings.ToLower(ledis.String(req.args[0]))
+	}
+
+	var buf bytes.Buffer
+	for _, s := range infoSections {
+		if section != "all" && section != "default" && section != s.name {
+			continue
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+
+		fmt.Fprintf(&buf, "# %s%s\r\n", strings.ToUpper(s.name[:1]), s.name[1:])
+		s.dump(req.app, &buf)
+	}
+
+	req.resp.writeBulk([]byte(buf.String()))
+	return nil
+}
+
+func init() {
+	register("info", infoCommand)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"strings"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if s, err := ledis.String(c.Do("info")); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(s, "# Server\r\n") || !strings.Contains(s, "# Lazyfree\r\n") ||
+		!strings.Contains(s, "# Replication\r\nrole:master\r\n") {
+		t.Fatal(s)
+	}
+
+	if s, err := ledis.String(c.Do("info", "LAZYFREE")); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(s, "# Server") || !strings.Contains(s, "lazyfree_pending_keys:") {
+		t.Fatal(s)
+	}
+
+	if s, err := ledis.String(c.Do("info", "nosection")); err != nil {
+		t.Fatal(err)
+	} else if s != "" {
+		t.Fatal(s)
+	}
+
+	if _, err := c.Do("info", "server", "lazyfree"); err == nil {
+		t.Fatal("must error")
+	}
+}
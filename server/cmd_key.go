@@ -0,0 +1,148 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/ledis"
+	"strconv"
+	"strings"
+)
+
+func renameCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 {
+		return ErrCmdParams
+	}
+
+	if err := req.db.Rename(args[0], args[1]); err != nil {
+		return err
+	} else {
+		req.resp.writeStatus(OK)
+	}
+
+	return nil
+}
+
+func renamenxCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 {
+		return ErrCmdParams
+	}
+
+	if n, err := req.db.RenameNX(args[0], args[1]); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+func moveCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 {
+		return ErrCmdParams
+	}
+
+	index, err := strconv.Atoi(ledis.String(args[1]))
+	if err != nil {
+		return ErrValue
+	}
+
+	if n, err := req.db.Move(args[0], index); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+//	COPY source destination [DB destination-db] [REPLACE]
+func copyCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 2 {
+		return ErrCmdParams
+	}
+
+	index := req.db.Index()
+	replace := false
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(ledis.String(args[i])) {
+		case "db":
+			if i+1 >= len(args) {
+				return ErrSyntax
+			}
+
+			var err error
+			if index, err = strconv.Atoi(ledis.String(args[i+1])); err != nil {
+				return ErrValue
+			}
+			i++
+		case "replace":
+			replace = true
+		default:
+			return ErrSyntax
+		}
+	}
+
+	if n, err := req.db.Copy(args[0], args[1], index, replace); err != nil {
+		return err
+	} else {
+		req.resp.writeInteger(n)
+	}
+
+	return nil
+}
+
+func dumpCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 1 {
+		return ErrCmdParams
+	}
+
+	if v, err := req.db.DumpKey(args[0]); err != nil {
+		return err
+	} else {
+		req.resp.writeBulk(v)
+	}
+
+	return nil
+}
+
+//	RESTORE key ttl serialized-value [REPLACE]
+func restoreCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 3 && len(args) != 4 {
+		return ErrCmdParams
+	}
+
+	ttl, err := ledis.StrInt64(args[1], nil)
+	if err != nil {
+		return ErrValue
+	}
+
+	replace := false
+	if len(args) == 4 {
+		if strings.ToLower(ledis.String(args[3])) != "replace" {
+			return ErrSyntax
+		}
+		replace = true
+	}
+
+	if err := req.db.RestoreKey(args[0], ttl, args[2], replace); err != nil {
+		return err
+	} else {
+		req.resp.writeStatus(OK)
+	}
+
+	return nil
+}
+
+func init() {
+	register("rename", renameCommand)
+	register("renamenx", renamenxCommand)
+	register("move", moveCommand)
+	register("copy", copyCommand)
+	register("dump", dumpCommand)
+	register("restore", restoreCommand)
+}
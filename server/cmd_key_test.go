@@ -0,0 +1,153 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"testing"
+)
+
+func TestKeyRename(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if _, err := c.Do("rename", "key_rename_a", "key_rename_b"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if _, err := c.Do("hset", "key_rename_a", "f", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := ledis.String(c.Do("rename", "key_rename_a", "key_rename_b")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+
+	if v, err := ledis.String(c.Do("hget", "key_rename_b", "f")); err != nil {
+		t.Fatal(err)
+	} else if v != "v" {
+		t.Fatal(v)
+	}
+
+	if _, err := c.Do("set", "key_rename_a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := ledis.Int(c.Do("renamenx", "key_rename_a", "key_rename_c")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("renamenx", "key_rename_c", "key_rename_b")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestKeyMoveCopy(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if _, err := c.Do("sadd", "key_move_a", "m1", "m2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := ledis.Int(c.Do("copy", "key_move_a", "key_move_b", "db", 2)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("copy", "key_move_a", "key_move_b", "DB", "2")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("copy", "key_move_a", "key_move_b", "db", 2, "replace")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("copy", "key_move_a", "key_move_b", "db"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if n, err := ledis.Int(c.Do("move", "key_move_a", 3)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("scard", "key_move_a")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("select", 3); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Do("select", 0)
+
+	if n, err := ledis.Int(c.Do("scard", "key_move_a")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("move", "key_move_a", 3); err == nil {
+		t.Fatal("must error")
+	}
+}
+
+func TestKeyDumpRestore(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if v, err := c.Do("dump", "key_dump_a"); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal(v)
+	}
+
+	if _, err := c.Do("zadd", "key_dump_a", 1, "a", 2, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ledis.Bytes(c.Do("dump", "key_dump_a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := ledis.String(c.Do("restore", "key_dump_b", 0, data)); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+
+	if _, err := c.Do("restore", "key_dump_b", 0, data); err == nil {
+		t.Fatal("must error")
+	}
+
+	if ok, err := ledis.String(c.Do("restore", "key_dump_b", 10, data, "replace")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+
+	if n, err := ledis.Int(c.Do("zscore", "key_dump_b", "b")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	if n, err := ledis.Int(c.Do("zttl", "key_dump_b")); err != nil {
+		t.Fatal(err)
+	} else if n <= 0 {
+		t.Fatal(n)
+	}
+}
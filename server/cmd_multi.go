@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"github.com/siddontang/ledisdb/ledis"
+)
+
+var (
+	errMultiNested  = errors.New("MULTI calls can not be nested")
+	errMultiWatch   = errors.New("WATCH inside MULTI is not allowed")
+	errExecNoMulti  = errors.New("EXEC without MULTI")
+	errDiscardMulti = errors.New("DISCARD without MULTI")
+	errExecAbort    = errors.New("EXECABORT Transaction discarded because of previous errors.")
+	errMultiClient  = errors.New("transaction is only supported with the RESP protocol")
+	errMultiCommand = errors.New("command is not allowed in transaction")
+)
+
+//	commands executed immediately in MULTI instead of being queued
+var multiControlCmds = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"watch":   true,
+}
+
+//	commands can not be queued in MULTI
+var multiDeniedCmds = map[string]bool{
+	"slaveof":      true,
+	"fullsync":     true,
+	"sync":         true,
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+
+	//	the Multi blocks all the writers while WAIT blocks, and the writes queued are not committed yet
+	"wait": true,
+}
+
+type queuedCommand struct {
+	cmd  string
+	args [][]byte
+}
+
+type watchKey struct {
+	index   int
+	key     []byte
+	version uint64
+}
+
+//	select the DB of index in the executing transaction, or the Ledis
+func (req *requestContext) selectDB(index int) (*ledis.DB, error) {
+	if req.multi != nil {
+		return req.multi.Select(index)
+	}
+
+	return req.session.Select(index)
+}
+
+func (req *requestContext) queueCommand() error {
+	if multiDeniedCmds[req.cmd] {
+		req.multiErr = true
+		return errMultiCommand
+	}
+
+	req.multiCmds = append(req.multiCmds, queuedCommand{req.cmd, req.args})
+	req.resp.writeStatus("QUEUED")
+	return nil
+}
+
+func (req *requestContext) resetMulti() {
+	req.inMulti = false
+	req.multiErr = false
+	req.multiCmds = nil
+	req.watches = nil
+}
+
+func multiCommand(req *requestContext) error {
+	if len(req.args) != 0 {
+		return ErrCmdParams
+	} else if req.client == nil {
+		return errMultiClient
+	} else if req.inMulti {
+		return errMultiNested
+	}
+
+	req.inMulti = true
+	req.resp.writeStatus(OK)
+	return nil
+}
+
+func discardCommand(req *requestContext) error {
+	if len(req.args) != 0 {
+		return ErrCmdParams
+	} else if !req.inMulti {
+		return errDiscardMulti
+	}
+
+	req.resetMulti()
+	req.resp.writeStatus(OK)
+	return nil
+}
+
+//	run the queued commands in one ledis Multi, the replies are buffered
+//	and written only after the Multi is committed
+func execCommand(req *requestContext) error {
+	if len(req.args) != 0 {
+		return ErrCmdParams
+	} else if !req.inMulti {
+		return errExecNoMulti
+	}
+
+	cmds, watches, aborted := req.multiCmds, req.watches, req.multiErr
+	req.resetMulti()
+
+	if aborted {
+		return errExecAbort
+	}
+
+	m := req.session.Multi()
+
+	//	all the writers are blocked now, check whether the watched keys are modified
+	for _, w := range watches {
+		db, _ := req.ldb.Select(w.index)
+		if db.KeyVersion(w.key) != w.version {
+			m.Rollback()
+			req.resp.writeArray(nil)
+			return nil
+		}
+	}
+
+	var buf bytes.Buffer
+	w := newWriterRESP(&buf)
+
+	cmd, args, resp := req.cmd, req.args, req.resp
+
+	req.multi = m
+	req.db, _ = m.Select(req.db.Index())
+	req.resp = w
+
+	for _, c := range cmds {
+		req.cmd, req.args = c.cmd, c.args
+		if err := regCmds[c.cmd](req); err != nil {
+			w.writeError(err)
+		}
+	}
+	w.flush()
+
+	req.cmd, req.args, req.resp = cmd, args, resp
+
+	req.multi = nil
+	req.db, _ = req.session.Select(req.db.Index())
+
+	if err := m.Commit(); err != nil {
+		return err
+	}
+
+	req.client.resp.writeArrayHeader(len(cmds))
+	req.client.resp.writeRaw(buf.Bytes())
+	return nil
+}
+
+func watchCommand(req *requestContext) error {
+	if len(req.args) == 0 {
+		return ErrCmdParams
+	} else if req.client == nil {
+		return errMultiClient
+	} else if req.inMulti {
+		return errMultiWatch
+	}
+
+	for _, key := range req.args {
+		req.watches = append(req.watches, watchKey{req.db.Index(), key, req.db.KeyVersion(key)})
+	}
+
+	req.resp.writeStatus(OK)
+	return nil
+}
+
+func unwatchCommand(req *requestContext) error {
+	if len(req.args) != 0 {
+		return ErrCmdParams
+	}
+
+	req.watches = nil
+	req.resp.writeStatus(OK)
+	return nil
+}
+
+func init() {
+	register("multi", multiCommand)
+	register("exec", execCommand)
+	register("discard", discardCommand)
+	register("watch", watchCommand)
+	register("unwatch", unwatchCommand)
+}
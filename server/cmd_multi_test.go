@@ -0,0 +1,204 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"strings"
+	"testing"
+)
+
+func TestMulti(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if _, err := c.Do("exec"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if ok, err := ledis.String(c.Do("multi")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+
+	if _, err := c.Do("multi"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if _, err := c.Do("watch", "multi_a"); err == nil {
+		t.Fatal("must error")
+	}
+
+	cmds := [][]interface{}{
+		{"set", "multi_a", "1"},
+		{"incr", "multi_a"},
+		{"hset", "multi_b", "a", "1"},
+		{"rpush", "multi_c", "1", "2"},
+		{"hincrby", "multi_b", "a", "x"},
+		{"select", "1"},
+		{"set", "multi_a", "3"},
+	}
+
+	for _, cmd := range cmds {
+		if s, err := ledis.String(c.Do(cmd[0].(string), cmd[1:]...)); err != nil {
+			t.Fatal(err)
+		} else if s != "QUEUED" {
+			t.Fatal(s)
+		}
+	}
+
+	v, err := ledis.MultiBulk(c.Do("exec"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(v) != len(cmds) {
+		t.Fatal(len(v))
+	}
+
+	if n, ok := v[1].(int64); !ok || n != 2 {
+		t.Fatal(v[1])
+	}
+
+	if n, ok := v[3].(int64); !ok || n != 2 {
+		t.Fatal(v[3])
+	}
+
+	//	the error of a command does not abort the others
+	if _, ok := v[4].(ledis.Error); !ok {
+		t.Fatal(v[4])
+	}
+
+	//	select in the transaction is kept after EXEC
+	if s, err := ledis.String(c.Do("get", "multi_a")); err != nil {
+		t.Fatal(err)
+	} else if s != "3" {
+		t.Fatal(s)
+	}
+
+	c.Do("del", "multi_a")
+	c.Do("select", "0")
+
+	if s, err := ledis.String(c.Do("get", "multi_a")); err != nil {
+		t.Fatal(err)
+	} else if s != "2" {
+		t.Fatal(s)
+	}
+
+	if n, err := ledis.Int(c.Do("llen", "multi_c")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	c.Do("del", "multi_a")
+	c.Do("hclear", "multi_b")
+	c.Do("lclear", "multi_c")
+}
+
+func TestMultiDiscard(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if _, err := c.Do("discard"); err == nil {
+		t.Fatal("must error")
+	}
+
+	c.Do("multi")
+	c.Do("set", "multi_discard", "1")
+
+	if ok, err := ledis.String(c.Do("discard")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+
+	if n, err := ledis.Int(c.Do("exists", "multi_discard")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	//	an unknown command aborts the transaction
+	c.Do("multi")
+	c.Do("set", "multi_discard", "1")
+	if _, err := c.Do("multi_unknown"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if _, err := c.Do("exec"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if n, err := ledis.Int(c.Do("exists", "multi_discard")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	//	WAIT would block all the writers in EXEC
+	c.Do("multi")
+	c.Do("set", "multi_discard", "1")
+	if _, err := c.Do("wait", 1, 0); err == nil || !strings.Contains(err.Error(), errMultiCommand.Error()) {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Do("exec"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if n, err := ledis.Int(c.Do("exists", "multi_discard")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestMultiWatch(t *testing.T) {
+	c1 := getTestConn()
+	defer c1.Close()
+
+	c2 := getTestConn()
+	defer c2.Close()
+
+	c1.Do("set", "multi_watch", "1")
+
+	c1.Do("watch", "multi_watch")
+	c1.Do("multi")
+	c1.Do("set", "multi_watch", "2")
+
+	//	modified by others after WATCH
+	c2.Do("set", "multi_watch", "3")
+
+	if v, err := c1.Do("exec"); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal(v)
+	}
+
+	if s, err := ledis.String(c1.Do("get", "multi_watch")); err != nil {
+		t.Fatal(err)
+	} else if s != "3" {
+		t.Fatal(s)
+	}
+
+	//	the watches are cleared after EXEC
+	c1.Do("multi")
+	c1.Do("set", "multi_watch", "2")
+	if v, err := ledis.MultiBulk(c1.Do("exec")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 {
+		t.Fatal(v)
+	}
+
+	c1.Do("watch", "multi_watch")
+	c1.Do("unwatch")
+	c2.Do("set", "multi_watch", "3")
+
+	c1.Do("multi")
+	c1.Do("get", "multi_watch")
+	if v, err := ledis.MultiBulk(c1.Do("exec")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || string(v[0].([]byte)) != "3" {
+		t.Fatal(v)
+	}
+
+	c1.Do("del", "multi_watch")
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"github.com/siddontang/ledisdb/config"
+	"os"
+	"testing"
+)
+
+func TestParseKeyspaceEvents(t *testing.T) {
+	if e, err := parseKeyspaceEvents("Kg$"); err != nil {
+		t.Fatal(err)
+	} else if !e.keyspace || e.keyevent || !e.enabled() {
+		t.Fatal(e)
+	}
+
+	if e, err := parseKeyspaceEvents("A"); err != nil {
+		t.Fatal(err)
+	} else if e.enabled() {
+		t.Fatal("must disabled without K or E")
+	}
+
+	if _, err := parseKeyspaceEvents("KEq"); err == nil {
+		t.Fatal("must error")
+	}
+}
+
+func TestKeyspaceNotify(t *testing.T) {
+	//	the notifications are off in the shared test app, like the default config
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_keyspace_notify"
+	cfg.Addr = "127.0.0.1:16381"
+	cfg.NotifyKeyspaceEvents = "KEA"
+
+	os.RemoveAll(cfg.DataDir)
+
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	go app.Run()
+
+	client := ledis.NewClient(&ledis.Config{Addr: cfg.Addr})
+	defer client.Close()
+
+	sub := client.Get()
+	defer sub.Close()
+
+	c := client.Get()
+	defer c.Close()
+
+	sub.Send("psubscribe", "__keyspace@0__:notify_*")
+	checkPubSubReply(t, sub, "psubscribe", "__keyspace@0__:notify_*", int64(1))
+
+	sub.Send("subscribe", "__keyevent@0__:hset")
+	checkPubSubReply(t, sub, "subscribe", "__keyevent@0__:hset", int64(2))
+
+	c.Do("set", "notify_a", "1")
+	checkPubSubReply(t, sub, "pmessage", "__keyspace@0__:notify_*", "__keyspace@0__:notify_a", "set")
+
+	c.Do("hset", "notify_h", "a", "1")
+	checkPubSubReply(t, sub, "pmessage", "__keyspace@0__:notify_*", "__keyspace@0__:notify_h", "hset")
+	checkPubSubReply(t, sub, "message", "__keyevent@0__:hset", "notify_h")
+
+	c.Do("multi")
+	c.Do("del", "notify_a")
+	c.Do("hclear", "notify_h")
+	c.Do("exec")
+	checkPubSubReply(t, sub, "pmessage", "__keyspace@0__:notify_*", "__keyspace@0__:notify_a", "del")
+	checkPubSubReply(t, sub, "pmessage", "__keyspace@0__:notify_*", "__keyspace@0__:notify_h", "del")
+
+	sub.Send("unsubscribe")
+	checkPubSubReply(t, sub, "unsubscribe", "__keyevent@0__:hset", int64(1))
+	sub.Send("punsubscribe")
+	checkPubSubReply(t, sub, "punsubscribe", "__keyspace@0__:notify_*", int64(0))
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/ledis"
+	"strings"
+)
+
+func subscribeCommand(req *requestContext) error {
+	if len(req.args) < 1 {
+		return ErrCmdParams
+	} else if req.client == nil {
+		return errPubSubClient
+	}
+
+	req.client.subscribe(req.args, false)
+	return nil
+}
+
+func psubscribeCommand(req *requestContext) error {
+	if len(req.args) < 1 {
+		return ErrCmdParams
+	} else if req.client == nil {
+		return errPubSubClient
+	}
+
+	req.client.subscribe(req.args, true)
+	return nil
+}
+
+func unsubscribeCommand(req *requestContext) error {
+	if req.client == nil {
+		return errPubSubClient
+	}
+
+	req.client.unsubscribe(req.args, false)
+	return nil
+}
+
+func punsubscribeCommand(req *requestContext) error {
+	if req.client == nil {
+		return errPubSubClient
+	}
+
+	req.client.unsubscribe(req.args, true)
+	return nil
+}
+
+func publishCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 {
+		return ErrCmdParams
+	}
+
+	n := req.app.pubsub.publish(args[0], args[1])
+	req.resp.writeInteger(n)
+	return nil
+}
+
+//	PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func pubsubCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 1 {
+		return ErrCmdParams
+	}
+
+	switch strings.ToLower(ledis.String(args[0])) {
+	case "channels":
+		if len(args) > 2 {
+			return ErrCmdParams
+		}
+
+		var pattern []byte
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+
+		req.resp.writeSliceArray(req.app.pubsub.activeChannels(pattern))
+	case "numsub":
+		ay := make([]interface{}, 0, 2*(len(args)-1))
+		for _, ch := range args[1:] {
+			ay = append(ay, ch, req.app.pubsub.numSub(ch))
+		}
+
+		req.resp.writeArray(ay)
+	case "numpat":
+		if len(args) != 1 {
+			return ErrCmdParams
+		}
+
+		req.resp.writeInteger(req.app.pubsub.numPat())
+	default:
+		return ErrSyntax
+	}
+
+	return nil
+}
+
+func init() {
+	register("subscribe", subscribeCommand)
+	register("psubscribe", psubscribeCommand)
+	register("unsubscribe", unsubscribeCommand)
+	register("punsubscribe", punsubscribeCommand)
+	register("publish", publishCommand)
+	register("pubsub", pubsubCommand)
+}
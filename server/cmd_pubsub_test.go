@@ -0,0 +1,137 @@
+package server
+
+import (
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func checkPubSubReply(t *testing.T, c *ledis.Conn, expect ...interface{}) {
+	v, err := ledis.MultiBulk(c.Receive())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(v) != len(expect) {
+		t.Fatal(v)
+	}
+
+	for i, e := range expect {
+		switch e := e.(type) {
+		case string:
+			if b, ok := v[i].([]byte); !ok || string(b) != e {
+				t.Fatal(v)
+			}
+		default:
+			if !reflect.DeepEqual(v[i], e) {
+				t.Fatal(v)
+			}
+		}
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	sub := getTestConn()
+	defer sub.Close()
+
+	c := getTestConn()
+	defer c.Close()
+
+	if err := sub.Send("subscribe", "ps_a", "ps_b"); err != nil {
+		t.Fatal(err)
+	}
+	checkPubSubReply(t, sub, "subscribe", "ps_a", int64(1))
+	checkPubSubReply(t, sub, "subscribe", "ps_b", int64(2))
+
+	sub.Send("psubscribe", "ps_*")
+	checkPubSubReply(t, sub, "psubscribe", "ps_*", int64(3))
+
+	//	only the subscribe commands are allowed in subscriber mode
+	sub.Send("get", "ps_a")
+	if _, err := sub.Receive(); err == nil {
+		t.Fatal("must error")
+	}
+
+	sub.Send("ping")
+	checkPubSubReply(t, sub, "pong", "")
+
+	if n, err := ledis.Int(c.Do("publish", "ps_a", "hello")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	checkPubSubReply(t, sub, "message", "ps_a", "hello")
+	checkPubSubReply(t, sub, "pmessage", "ps_*", "ps_a", "hello")
+
+	if n, err := ledis.Int(c.Do("publish", "ps_c", "world")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	checkPubSubReply(t, sub, "pmessage", "ps_*", "ps_c", "world")
+
+	if v, err := ledis.MultiBulk(c.Do("pubsub", "numsub", "ps_a", "ps_c")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []interface{}{[]byte("ps_a"), int64(1), []byte("ps_c"), int64(0)}) {
+		t.Fatal(v)
+	}
+
+	if v, err := ledis.Strings(c.Do("pubsub", "channels", "ps_?")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []string{"ps_a", "ps_b"}) {
+		t.Fatal(v)
+	}
+
+	if n, err := ledis.Int(c.Do("pubsub", "numpat")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	sub.Send("unsubscribe", "ps_a")
+	checkPubSubReply(t, sub, "unsubscribe", "ps_a", int64(2))
+
+	sub.Send("punsubscribe")
+	checkPubSubReply(t, sub, "punsubscribe", "ps_*", int64(1))
+
+	sub.Send("unsubscribe")
+	checkPubSubReply(t, sub, "unsubscribe", "ps_b", int64(0))
+
+	//	back to the normal mode
+	if _, err := sub.Do("get", "ps_a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := ledis.Int(c.Do("publish", "ps_a", "hello")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestPubSubSlowClient(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	c := new(respClient)
+	c.conn = c1
+	c.req = new(requestContext)
+	c.msgs = make(chan *pubsubMessage, 1)
+
+	m := &pubsubMessage{channel: []byte("a"), data: []byte("b")}
+	if !c.pushMessage(m) {
+		t.Fatal("must push")
+	}
+
+	//	the buffer is full, the client is disconnected
+	if c.pushMessage(m) {
+		t.Fatal("must not push")
+	}
+
+	if _, err := c1.Write([]byte("a")); err == nil {
+		t.Fatal("must be closed")
+	}
+}
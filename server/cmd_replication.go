@@ -6,19 +6,29 @@ import (
 	"github.com/siddontang/go-snappy/snappy"
 	"github.com/siddontang/ledisdb/ledis"
 	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func slaveofCommand(req *requestContext) error {
 	args := req.args
 
-	if len(args) != 2 {
+	if len(args) != 2 && len(args) != 3 {
 		return ErrCmdParams
 	}
 
 	masterAddr := ""
+	force := false
+
+	if len(args) == 3 {
+		if strings.ToLower(ledis.String(args[2])) != "force" {
+			return ErrSyntax
+		}
+		force = true
+	}
 
 	if strings.ToLower(ledis.String(args[0])) == "no" &&
 		strings.ToLower(ledis.String(args[1])) == "one" {
@@ -31,7 +41,7 @@ func slaveofCommand(req *requestContext) error {
 		masterAddr = fmt.Sprintf("%s:%s", args[0], args[1])
 	}
 
-	if err := req.app.slaveof(masterAddr); err != nil {
+	if err := req.app.slaveof(masterAddr, force); err != nil {
 		return err
 	}
 
@@ -47,6 +57,9 @@ func fullsyncCommand(req *requestContext) error {
 		return err
 	}
 
+	//keep the binlog after the dump for the slave
+	req.holdBinLog(req.app.ldb.BinLogLastID())
+
 	if err = req.app.ldb.Dump(dumpFile); err != nil {
 		return err
 	}
@@ -66,53 +79,198 @@ func fullsyncCommand(req *requestContext) error {
 	return nil
 }
 
-var reserveInfoSpace = make([]byte, 16)
+var reserveInfoSpace = make([]byte, 24)
 
 func syncCommand(req *requestContext) error {
 	args := req.args
-	if len(args) != 2 {
+	if len(args) < 1 || len(args) > 3 {
 		return ErrCmdParams
 	}
 
-	var logIndex int64
-	var logPos int64
-	var err error
-	logIndex, err = ledis.StrInt64(args[0], nil)
+	lastID, err := strconv.ParseUint(ledis.String(args[0]), 10, 64)
 	if err != nil {
 		return ErrCmdParams
 	}
 
-	logPos, err = ledis.StrInt64(args[1], nil)
+	req.holdBinLog(lastID)
+
+	if len(args) >= 2 {
+		if strings.ToLower(ledis.String(args[1])) != "stream" {
+			return ErrSyntax
+		} else if req.client == nil {
+			return errSyncStreamHttp
+		}
+
+		//	the port the slave listens on, if it tells
+		port := ""
+		if len(args) == 3 {
+			if _, err = strconv.ParseUint(ledis.String(args[2]), 10, 16); err != nil {
+				return ErrCmdParams
+			} else if ledis.String(args[2]) != "0" {
+				port = ledis.String(args[2])
+			}
+		}
+
+		return req.client.syncStream(lastID, port)
+	}
+
+	m := &ledis.MasterInfo{LastID: lastID}
+
+	buf, err := req.readSyncData(m, false)
 	if err != nil {
-		return ErrCmdParams
+		return err
 	}
 
+	req.resp.writeBulk(buf)
+	return nil
+}
+
+//	read the batches after m.LastID as the sync data, which is compressed by snappy:
+//	status(bigendian int64)|lastID(bigendian uint64)|events, the status is m.LogFileIndex.
+//	A stream frame has the bytes of the events after them too:
+//	status(bigendian int64)|lastID(bigendian uint64)|behind(bigendian int64)|events
+func (req *requestContext) readSyncData(m *ledis.MasterInfo, stream bool) ([]byte, error) {
 	req.syncBuf.Reset()
 
-	//reserve space to write master info
-	if _, err := req.syncBuf.Write(reserveInfoSpace); err != nil {
-		return err
+	headSize := 16
+	if stream {
+		headSize = 24
 	}
 
-	m := &ledis.MasterInfo{logIndex, logPos}
+	//reserve space to write master info
+	if _, err := req.syncBuf.Write(reserveInfoSpace[0:headSize]); err != nil {
+		return nil, err
+	}
 
 	if _, err := req.app.ldb.ReadEventsTo(m, &req.syncBuf); err != nil {
-		return err
-	} else {
-		buf := req.syncBuf.Bytes()
+		return nil, err
+	}
+
+	buf := req.syncBuf.Bytes()
+
+	binary.BigEndian.PutUint64(buf[0:], uint64(m.LogFileIndex))
+	binary.BigEndian.PutUint64(buf[8:], m.LastID)
+	if stream {
+		binary.BigEndian.PutUint64(buf[16:], uint64(req.app.ldb.BinLogBytesAfter(m.LogFileIndex, m.LogPos)))
+	}
 
-		binary.BigEndian.PutUint64(buf[0:], uint64(m.LogFileIndex))
-		binary.BigEndian.PutUint64(buf[8:], uint64(m.LogPos))
+	if len(req.compressBuf) < snappy.MaxEncodedLen(len(buf)) {
+		req.compressBuf = make([]byte, snappy.MaxEncodedLen(len(buf)))
+	}
+
+	return snappy.Encode(req.compressBuf, buf)
+}
+
+//	the binlog after lastID is kept until the slave disconnects
+func (req *requestContext) holdBinLog(lastID uint64) {
+	req.slave = true
+	req.app.ldb.SetSlaveLastID(req.remoteAddr, lastID)
+}
+
+func binlogCommand(req *requestContext) error {
+	args := req.args
+	if len(args) == 0 {
+		return ErrCmdParams
+	}
 
-		if len(req.compressBuf) < snappy.MaxEncodedLen(len(buf)) {
-			req.compressBuf = make([]byte, snappy.MaxEncodedLen(len(buf)))
+	switch strings.ToLower(ledis.String(args[0])) {
+	case "list":
+		if len(args) != 1 {
+			return ErrCmdParams
 		}
 
-		if buf, err = snappy.Encode(req.compressBuf, buf); err != nil {
+		names, err := req.app.ldb.BinLogNames()
+		if err != nil {
 			return err
 		}
 
-		req.resp.writeBulk(buf)
+		ay := make([][]byte, len(names))
+		for i, name := range names {
+			ay[i] = []byte(name)
+		}
+		req.resp.writeSliceArray(ay)
+	case "purge":
+		if len(args) != 3 {
+			return ErrCmdParams
+		}
+
+		v, err := ledis.StrInt64(args[2], nil)
+		if err != nil {
+			return ErrValue
+		}
+
+		var n int
+		switch strings.ToLower(ledis.String(args[1])) {
+		case "to":
+			n, err = req.app.ldb.PurgeBinLogTo(v)
+		case "before":
+			n, err = req.app.ldb.PurgeBinLogBefore(time.Unix(v, 0))
+		default:
+			return ErrSyntax
+		}
+
+		if err != nil {
+			return err
+		}
+		req.resp.writeInteger(int64(n))
+	default:
+		return ErrSyntax
+	}
+
+	return nil
+}
+
+func waitCommand(req *requestContext) error {
+	args := req.args
+	if len(args) != 2 {
+		return ErrCmdParams
+	}
+
+	numSlaves, err := strconv.Atoi(ledis.String(args[0]))
+	if err != nil {
+		return ErrValue
+	}
+
+	timeout, err := ledis.StrInt64(args[1], nil)
+	if err != nil || timeout < 0 {
+		return ErrValue
+	}
+
+	//	the last batch the writes of this client are committed in
+	id := req.session.LastID()
+
+	n := req.app.acks.wait(id, numSlaves, time.Duration(timeout)*time.Millisecond)
+	req.resp.writeInteger(int64(n))
+	return nil
+}
+
+//	ROLE returns master, the last batch id and the stream slaves,
+//	or slave, the master host and port, the replication state and the last batch id replicated
+func roleCommand(req *requestContext) error {
+	if len(req.args) != 0 {
+		return ErrCmdParams
+	}
+
+	s := req.app.m.getState()
+
+	if s.state == replStateNone {
+		slaves := req.app.acks.list()
+
+		ay := make([]interface{}, len(slaves))
+		for i, ack := range slaves {
+			host, port, _ := net.SplitHostPort(ack.addr)
+			if len(ack.port) > 0 {
+				port = ack.port
+			}
+			ay[i] = []interface{}{[]byte(host), []byte(port), []byte(strconv.FormatUint(ack.LastID, 10))}
+		}
+
+		req.resp.writeArray([]interface{}{[]byte("master"), int64(req.app.ldb.BinLogLastID()), ay})
+	} else {
+		host, port, _ := net.SplitHostPort(s.masterAddr)
+		p, _ := strconv.ParseInt(port, 10, 64)
+
+		req.resp.writeArray([]interface{}{[]byte("slave"), []byte(host), p, []byte(s.state), int64(s.lastID)})
 	}
 
 	return nil
@@ -122,4 +280,7 @@ func init() {
 	register("slaveof", slaveofCommand)
 	register("fullsync", fullsyncCommand)
 	register("sync", syncCommand)
+	register("binlog", binlogCommand)
+	register("wait", waitCommand)
+	register("role", roleCommand)
 }
@@ -3,15 +3,19 @@ package server
 import (
 	"bytes"
 	"fmt"
+	goledis "github.com/siddontang/ledisdb/client/go/ledis"
 	"github.com/siddontang/ledisdb/config"
+	"github.com/siddontang/ledisdb/ledis"
 	"github.com/siddontang/ledisdb/store"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
 
 func checkDataEqual(master *App, slave *App) error {
-	it := master.ldb.DataDB().RangeLimitIterator(nil, nil, store.RangeClose, 0, -1)
+	//	the data of all dbs, not the binlog position of master itself
+	it := master.ldb.DataDB().RangeLimitIterator(nil, []byte{ledis.MaxDBNumber}, store.RangeROpen, 0, -1)
 	for ; it.Valid(); it.Next() {
 		key := it.Key()
 		value := it.Value()
@@ -83,7 +87,7 @@ func TestReplication(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	slave.slaveof("")
+	slave.slaveof("", false)
 
 	db.Set([]byte("a2"), value)
 	db.Set([]byte("b2"), value)
@@ -99,7 +103,7 @@ func TestReplication(t *testing.T) {
 		t.Fatal("must error")
 	}
 
-	slave.slaveof(masterCfg.Addr)
+	slave.slaveof(masterCfg.Addr, false)
 	time.Sleep(1 * time.Second)
 
 	if err = checkDataEqual(master, slave); err != nil {
@@ -107,3 +111,551 @@ func TestReplication(t *testing.T) {
 	}
 
 }
+
+//	wait until the slave has the data of master, or the timeout
+func waitDataEqual(master *App, slave *App, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := checkDataEqual(master, slave)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplicationStream(t *testing.T) {
+	data_dir := "/tmp/test_replication_stream"
+	os.RemoveAll(data_dir)
+
+	masterCfg := new(config.Config)
+	masterCfg.DataDir = fmt.Sprintf("%s/master", data_dir)
+	masterCfg.Addr = "127.0.0.1:11185"
+	masterCfg.BinLog.MaxFileSize = 1 * 1024 * 1024
+	masterCfg.BinLog.MaxFileNum = 10
+
+	master, err := NewApp(masterCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go master.Run()
+
+	slaveCfg := new(config.Config)
+	slaveCfg.DataDir = fmt.Sprintf("%s/slave", data_dir)
+	slaveCfg.Addr = "127.0.0.1:11186"
+	slaveCfg.SlaveOf = masterCfg.Addr
+
+	slave, err := NewApp(slaveCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	go slave.Run()
+
+	db, _ := master.ldb.Select(0)
+	db.Set([]byte("a"), []byte("1"))
+
+	if err = waitDataEqual(master, slave, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	//	pushed as committed, not polled every second
+	for i := 0; i < 10; i++ {
+		db.Set([]byte(fmt.Sprintf("b_%d", i)), []byte("1"))
+		if err = waitDataEqual(master, slave, 300*time.Millisecond); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+
+	//	many batches, more than the frames in flight
+	for i := 0; i < 1000; i++ {
+		db.HSet([]byte("c"), []byte(fmt.Sprintf("%d", i)), []byte("1"))
+	}
+
+	if err = waitDataEqual(master, slave, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	//	the slave resumes from its last id after the master restarts
+	master.Close()
+
+	if master, err = NewApp(masterCfg); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	go master.Run()
+
+	db, _ = master.ldb.Select(0)
+	db.Set([]byte("d"), []byte("1"))
+
+	if err = waitDataEqual(master, slave, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	slave.m.Lock()
+	lastID := slave.m.info.LastID
+	slave.m.Unlock()
+	if lastID != master.ldb.BinLogLastID() {
+		t.Fatal(lastID, master.ldb.BinLogLastID())
+	}
+}
+
+func TestSemiSync(t *testing.T) {
+	data_dir := "/tmp/test_semi_sync"
+	os.RemoveAll(data_dir)
+
+	masterCfg := new(config.Config)
+	masterCfg.DataDir = fmt.Sprintf("%s/master", data_dir)
+	masterCfg.Addr = "127.0.0.1:11187"
+	masterCfg.BinLog.MaxFileSize = 1 * 1024 * 1024
+	masterCfg.BinLog.MaxFileNum = 10
+	masterCfg.SemiSyncSlaves = 1
+	masterCfg.SemiSyncTimeout = 500
+
+	master, err := NewApp(masterCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	go master.Run()
+
+	slaveCfg := new(config.Config)
+	slaveCfg.DataDir = fmt.Sprintf("%s/slave", data_dir)
+	slaveCfg.Addr = "127.0.0.1:11188"
+	slaveCfg.SlaveOf = masterCfg.Addr
+
+	slave, err := NewApp(slaveCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	go slave.Run()
+
+	c := goledis.NewClient(&goledis.Config{Addr: masterCfg.Addr})
+	defer c.Close()
+
+	//	the slave acks the batches after the full sync
+	if n, err := goledis.Int(c.Do("wait", 1, 3000)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	//	replied after the slave has it
+	for i := 0; i < 10; i++ {
+		if _, err = c.Do("set", fmt.Sprintf("a_%d", i), "1"); err != nil {
+			t.Fatal(err)
+		} else if err = checkDataEqual(master, slave); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+
+	if n, err := goledis.Int(c.Do("wait", 1, 0)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	slave.slaveof("", false)
+
+	//	a timeout, then async
+	start := time.Now()
+	if _, err = c.Do("set", "b", "1"); err != nil {
+		t.Fatal(err)
+	} else if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	start = time.Now()
+	if _, err = c.Do("set", "c", "1"); err != nil {
+		t.Fatal(err)
+	} else if d := time.Since(start); d > 400*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	if n, err := goledis.Int(c.Do("wait", 1, 100)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestReplicationRole(t *testing.T) {
+	data_dir := "/tmp/test_replication_role"
+	os.RemoveAll(data_dir)
+
+	masterCfg := new(config.Config)
+	masterCfg.DataDir = fmt.Sprintf("%s/master", data_dir)
+	masterCfg.Addr = "127.0.0.1:11189"
+	masterCfg.BinLog.MaxFileSize = 1 * 1024 * 1024
+	masterCfg.BinLog.MaxFileNum = 10
+
+	master, err := NewApp(masterCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	go master.Run()
+
+	slaveCfg := new(config.Config)
+	slaveCfg.DataDir = fmt.Sprintf("%s/slave", data_dir)
+	slaveCfg.Addr = "127.0.0.1:11190"
+	slaveCfg.SlaveOf = masterCfg.Addr
+
+	slave, err := NewApp(slaveCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	go slave.Run()
+
+	mc := goledis.NewClient(&goledis.Config{Addr: masterCfg.Addr})
+	defer mc.Close()
+
+	sc := goledis.NewClient(&goledis.Config{Addr: slaveCfg.Addr})
+	defer sc.Close()
+
+	//	the slave acks the full sync first, then the batch pushed
+	for i := 0; i < 2; i++ {
+		if _, err = mc.Do("set", "a", i); err != nil {
+			t.Fatal(err)
+		} else if _, err = mc.Do("wait", 1, 3000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ay, err := goledis.MultiBulk(mc.Do("role")); err != nil {
+		t.Fatal(err)
+	} else if len(ay) != 3 || string(ay[0].([]byte)) != "master" {
+		t.Fatal(ay)
+	} else if ay[1].(int64) != int64(master.ldb.BinLogLastID()) {
+		t.Fatal(ay[1])
+	} else if slaves := ay[2].([]interface{}); len(slaves) != 1 {
+		t.Fatal(slaves)
+	}
+
+	if ay, err := goledis.MultiBulk(sc.Do("role")); err != nil {
+		t.Fatal(err)
+	} else if len(ay) != 5 || string(ay[0].([]byte)) != "slave" {
+		t.Fatal(ay)
+	} else if ay[2].(int64) != 11189 || string(ay[3].([]byte)) != "connected" {
+		t.Fatal(ay)
+	} else if ay[4].(int64) != int64(master.ldb.BinLogLastID()) {
+		t.Fatal(ay[4])
+	}
+
+	if s, err := goledis.String(mc.Do("info", "replication")); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(s, "role:master\r\n") || !strings.Contains(s, "connected_slaves:1\r\n") ||
+		!strings.Contains(s, "slave0:addr=127.0.0.1:") {
+		t.Fatal(s)
+	}
+
+	if s, err := goledis.String(sc.Do("info", "replication")); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(s, "role:slave\r\n") || !strings.Contains(s, "master_port:11189\r\n") ||
+		!strings.Contains(s, "master_link_status:up\r\n") || !strings.Contains(s, "slave_lag_bytes:0\r\n") {
+		t.Fatal(s)
+	}
+
+	slave.slaveof("", false)
+
+	if s, err := goledis.String(sc.Do("info", "replication")); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(s, "role:master\r\n") {
+		t.Fatal(s)
+	}
+}
+
+func TestSlaveReadOnly(t *testing.T) {
+	data_dir := "/tmp/test_slave_read_only"
+	os.RemoveAll(data_dir)
+
+	masterCfg := new(config.Config)
+	masterCfg.DataDir = fmt.Sprintf("%s/master", data_dir)
+	masterCfg.Addr = "127.0.0.1:11191"
+	masterCfg.BinLog.MaxFileSize = 1 * 1024 * 1024
+	masterCfg.BinLog.MaxFileNum = 10
+
+	master, err := NewApp(masterCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	go master.Run()
+
+	slaveCfg := new(config.Config)
+	slaveCfg.DataDir = fmt.Sprintf("%s/slave", data_dir)
+	slaveCfg.Addr = "127.0.0.1:11192"
+	slaveCfg.SlaveOf = masterCfg.Addr
+	slaveCfg.SlaveReadOnly = true
+
+	slave, err := NewApp(slaveCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go slave.Run()
+
+	mc := goledis.NewClient(&goledis.Config{Addr: masterCfg.Addr})
+	defer mc.Close()
+
+	if _, err = mc.Do("set", "a", "1"); err != nil {
+		t.Fatal(err)
+	} else if _, err = mc.Do("wait", 1, 3000); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := goledis.NewClient(&goledis.Config{Addr: slaveCfg.Addr})
+
+	if _, err = sc.Do("set", "b", "1"); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Fatal(err)
+	} else if v, err := goledis.String(sc.Do("get", "a")); err != nil || v != "1" {
+		t.Fatal(v, err)
+	}
+
+	conn := sc.Get()
+	if _, err = conn.Do("multi"); err != nil {
+		t.Fatal(err)
+	} else if _, err = conn.Do("hset", "b", "1", "1"); err == nil {
+		t.Fatal("must error")
+	} else if _, err = conn.Do("exec"); err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if _, err = sc.Do("eval", "return ledis.call('set', KEYS[1], '1')", 1, "b"); err == nil || !strings.Contains(err.Error(), "READONLY") {
+		t.Fatal(err)
+	} else if v, err := goledis.String(sc.Do("eval", "return ledis.call('get', KEYS[1])", 1, "a")); err != nil || v != "1" {
+		t.Fatal(v, err)
+	}
+
+	if _, err = sc.Do("slaveof", "127.0.0.1", "11192"); err == nil || !strings.Contains(err.Error(), errSlaveofSelf.Error()) {
+		t.Fatal(err)
+	} else if _, err = sc.Do("slaveof", "localhost", "11192"); err == nil || !strings.Contains(err.Error(), errSlaveofSelf.Error()) {
+		t.Fatal(err)
+	} else if _, err = mc.Do("slaveof", "localhost", "11192"); err == nil || !strings.Contains(err.Error(), errSlaveofSlave.Error()) {
+		t.Fatal(err)
+	}
+
+	sc.Close()
+	slave.Close()
+
+	//	a read-write slave resumes from its last id
+	slaveCfg.SlaveReadOnly = false
+	if slave, err = NewApp(slaveCfg); err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	go slave.Run()
+
+	sc = goledis.NewClient(&goledis.Config{Addr: slaveCfg.Addr})
+	defer sc.Close()
+
+	if _, err = sc.Do("set", "b", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err := goledis.String(sc.Do("info", "replication")); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(s, "slave_read_only:0\r\n") || !strings.Contains(s, "slave_local_writes:1\r\n") {
+		t.Fatal(s)
+	}
+
+	//	a new master address needs a full sync, which would discard b
+	if _, err = sc.Do("slaveof", "localhost", "11191"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if n, err := goledis.Int(sc.Do("exists", "b")); err != nil || n != 1 {
+		t.Fatal(n, err)
+	} else if ay, err := goledis.MultiBulk(sc.Do("role")); err != nil {
+		t.Fatal(err)
+	} else if string(ay[3].([]byte)) != "connect" {
+		t.Fatal(string(ay[3].([]byte)))
+	}
+
+	if _, err = sc.Do("slaveof", "localhost", "11191", "force"); err != nil {
+		t.Fatal(err)
+	} else if _, err = mc.Do("wait", 1, 3000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = waitDataEqual(master, slave, 3*time.Second); err != nil {
+		t.Fatal(err)
+	} else if n, err := goledis.Int(sc.Do("exists", "b")); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+
+	if s, err := goledis.String(sc.Do("info", "replication")); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(s, "slave_local_writes:0\r\n") {
+		t.Fatal(s)
+	}
+}
+
+func TestSlaveofLocalWrites(t *testing.T) {
+	data_dir := "/tmp/test_slaveof_local_writes"
+	os.RemoveAll(data_dir)
+
+	masterCfg := new(config.Config)
+	masterCfg.DataDir = fmt.Sprintf("%s/master", data_dir)
+	masterCfg.Addr = "127.0.0.1:11193"
+	masterCfg.BinLog.MaxFileSize = 1 * 1024 * 1024
+	masterCfg.BinLog.MaxFileNum = 10
+
+	master, err := NewApp(masterCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	go master.Run()
+
+	mc := goledis.NewClient(&goledis.Config{Addr: masterCfg.Addr})
+	defer mc.Close()
+
+	if _, err = mc.Do("set", "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	slaveCfg := new(config.Config)
+	slaveCfg.DataDir = fmt.Sprintf("%s/slave", data_dir)
+	slaveCfg.Addr = "127.0.0.1:11194"
+
+	//	a full sync from the master would discard b, written before SLAVEOF
+	checkRefused := func(slave *App) {
+		sc := goledis.NewClient(&goledis.Config{Addr: slaveCfg.Addr})
+		defer sc.Close()
+
+		if _, err := sc.Do("slaveof", "127.0.0.1", "11193"); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+		if n, err := goledis.Int(sc.Do("exists", "b")); err != nil || n != 1 {
+			t.Fatal(n, err)
+		} else if ay, err := goledis.MultiBulk(sc.Do("role")); err != nil {
+			t.Fatal(err)
+		} else if string(ay[3].([]byte)) != "connect" {
+			t.Fatal(string(ay[3].([]byte)))
+		}
+	}
+
+	//	a standalone server without binlog
+	slave, err := NewApp(slaveCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go slave.Run()
+
+	sc := goledis.NewClient(&goledis.Config{Addr: slaveCfg.Addr})
+	if _, err = sc.Do("set", "b", "1"); err != nil {
+		t.Fatal(err)
+	}
+	sc.Close()
+
+	checkRefused(slave)
+	slave.Close()
+
+	//	a standalone server with binlog written before a restart
+	slaveCfg.DataDir = fmt.Sprintf("%s/slave_binlog", data_dir)
+	slaveCfg.BinLog.MaxFileSize = 1 * 1024 * 1024
+	slaveCfg.BinLog.MaxFileNum = 10
+
+	if slave, err = NewApp(slaveCfg); err != nil {
+		t.Fatal(err)
+	}
+	go slave.Run()
+
+	sc = goledis.NewClient(&goledis.Config{Addr: slaveCfg.Addr})
+	if _, err = sc.Do("set", "b", "1"); err != nil {
+		t.Fatal(err)
+	}
+	sc.Close()
+	slave.Close()
+
+	if slave, err = NewApp(slaveCfg); err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	go slave.Run()
+
+	checkRefused(slave)
+
+	sc = goledis.NewClient(&goledis.Config{Addr: slaveCfg.Addr})
+	defer sc.Close()
+
+	if _, err = sc.Do("slaveof", "127.0.0.1", "11193", "force"); err != nil {
+		t.Fatal(err)
+	} else if err = waitDataEqual(master, slave, 3*time.Second); err != nil {
+		t.Fatal(err)
+	} else if n, err := goledis.Int(sc.Do("exists", "b")); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestBinLogCommand(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/test_binlog_command"
+	cfg.Addr = "127.0.0.1:11184"
+	cfg.BinLog.MaxFileSize = 1024
+	cfg.BinLog.MaxFileNum = 100
+
+	os.RemoveAll(cfg.DataDir)
+
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	go app.Run()
+
+	db, _ := app.ldb.Select(0)
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("binlog_%d", i))
+		db.Set(key, key)
+	}
+
+	c := goledis.NewClient(&goledis.Config{Addr: cfg.Addr})
+	defer c.Close()
+
+	names, err := goledis.Strings(c.Do("binlog", "list"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(names) < 5 {
+		t.Fatal(names)
+	}
+
+	if n, err := goledis.Int(c.Do("binlog", "purge", "to", 3)); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	//	a slave synced to batch 200 keeps the log files after it until it disconnects
+	slave := c.Get()
+	if _, err = slave.Do("sync", 200); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(time.Hour).Unix()
+	if _, err = goledis.Int(c.Do("binlog", "purge", "before", before)); err != nil {
+		t.Fatal(err)
+	} else if names, _ = goledis.Strings(c.Do("binlog", "list")); len(names) < 2 {
+		t.Fatal(names)
+	}
+
+	slave.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err = goledis.Int(c.Do("binlog", "purge", "before", before)); err != nil {
+		t.Fatal(err)
+	} else if names, _ = goledis.Strings(c.Do("binlog", "list")); len(names) > 1 {
+		t.Fatal(names)
+	}
+
+	if _, err = c.Do("binlog", "purge", "after", 1); err == nil {
+		t.Fatal("must error")
+	}
+}
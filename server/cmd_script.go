@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"github.com/siddontang/ledisdb/ledis"
+	"strconv"
+	"strings"
+)
+
+var errScriptKeys = errors.New("Number of keys can't be greater than number of args")
+
+//	split KEYS and ARGV by numkeys
+func parseScriptArgs(args [][]byte) ([][]byte, [][]byte, error) {
+	n, err := strconv.Atoi(ledis.String(args[0]))
+	if err != nil {
+		return nil, nil, ErrValue
+	} else if n < 0 || n > len(args)-1 {
+		return nil, nil, errScriptKeys
+	}
+
+	return args[1 : n+1], args[n+1:], nil
+}
+
+//	EVAL script numkeys [key ...] [arg ...]
+func evalCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 2 {
+		return ErrCmdParams
+	}
+
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	src := string(args[0])
+	req.app.script.load(src)
+
+	return req.evalScript(src, keys, argv)
+}
+
+//	EVALSHA sha1 numkeys [key ...] [arg ...]
+func evalshaCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 2 {
+		return ErrCmdParams
+	}
+
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	src, ok := req.app.script.get(ledis.String(args[0]))
+	if !ok {
+		return errNoScript
+	}
+
+	return req.evalScript(src, keys, argv)
+}
+
+//	SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH | KILL
+func scriptCommand(req *requestContext) error {
+	args := req.args
+	if len(args) < 1 {
+		return ErrCmdParams
+	}
+
+	switch strings.ToLower(ledis.String(args[0])) {
+	case "load":
+		if len(args) != 2 {
+			return ErrCmdParams
+		}
+
+		sha := req.app.script.load(string(args[1]))
+		req.resp.writeBulk(ledis.Slice(sha))
+	case "exists":
+		if len(args) < 2 {
+			return ErrCmdParams
+		}
+
+		ay := make([]interface{}, len(args)-1)
+		for i, sha := range args[1:] {
+			if _, ok := req.app.script.get(ledis.String(sha)); ok {
+				ay[i] = int64(1)
+			} else {
+				ay[i] = int64(0)
+			}
+		}
+
+		req.resp.writeArray(ay)
+	case "flush":
+		if len(args) != 1 {
+			return ErrCmdParams
+		}
+
+		req.app.script.flush()
+		req.resp.writeStatus(OK)
+	case "kill":
+		if len(args) != 1 {
+			return ErrCmdParams
+		}
+
+		if req.app.script.kill() == 0 {
+			return errNotBusy
+		}
+		req.resp.writeStatus(OK)
+	default:
+		return ErrSyntax
+	}
+
+	return nil
+}
+
+func init() {
+	register("eval", evalCommand)
+	register("evalsha", evalshaCommand)
+	register("script", scriptCommand)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"errors"
+	"github.com/siddontang/ledisdb/client/go/ledis"
+	"github.com/yuin/gopher-lua"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if v, err := ledis.MultiBulk(c.Do("eval", "return {KEYS[1], KEYS[2], ARGV[1], 10, false, true}", 2, "a", "b", "c")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []interface{}{[]byte("a"), []byte("b"), []byte("c"), int64(10), nil, int64(1)}) {
+		t.Fatal(v)
+	}
+
+	if _, err := c.Do("eval", "return 1", 2, "a"); err == nil {
+		t.Fatal("must error")
+	}
+
+	//	conditional update across hash and zset
+	src := `
+local v = ledis.call("hget", KEYS[1], "status")
+if v == "open" then
+	ledis.call("hset", KEYS[1], "status", ARGV[1])
+	ledis.call("zadd", KEYS[2], 10, KEYS[1])
+	return ledis.status_reply("DONE")
+end
+return v
+`
+	c.Do("hset", "script_h", "status", "open")
+
+	if s, err := ledis.String(c.Do("eval", src, 2, "script_h", "script_z", "closed")); err != nil {
+		t.Fatal(err)
+	} else if s != "DONE" {
+		t.Fatal(s)
+	}
+
+	if s, err := ledis.String(c.Do("eval", src, 2, "script_h", "script_z", "closed")); err != nil {
+		t.Fatal(err)
+	} else if s != "closed" {
+		t.Fatal(s)
+	}
+
+	if n, err := ledis.Int(c.Do("zscore", "script_z", "script_h")); err != nil {
+		t.Fatal(err)
+	} else if n != 10 {
+		t.Fatal(n)
+	}
+
+	//	the replies of commands are converted to lua values
+	if v, err := ledis.MultiBulk(c.Do("eval", `return {ledis.call("zrange", KEYS[1], 0, -1, "withscores"), ledis.call("get", "script_nil") == false}`, 1, "script_z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []interface{}{[]interface{}{[]byte("script_h"), []byte("10")}, int64(1)}) {
+		t.Fatal(v)
+	}
+
+	//	call raises the error and pcall returns it
+	if _, err := c.Do("eval", `return ledis.call("hincrby", KEYS[1], "status", 1)`, 1, "script_h"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if _, err := c.Do("eval", `return ledis.pcall("multi")`, 0); err == nil {
+		t.Fatal("must error")
+	}
+
+	//	WAIT would block all the writers with the script, until its timeout
+	start := time.Now()
+	if _, err := c.Do("eval", `return ledis.call("wait", 1, 0)`, 0); err == nil || !strings.Contains(err.Error(), errScriptCommand.Error()) {
+		t.Fatal(err)
+	} else if d := time.Since(start); d > time.Second {
+		t.Fatal(d)
+	}
+
+	if s, err := ledis.String(c.Do("eval", `local r = ledis.pcall("hincrby", KEYS[1], "status", 1); return type(r.err)`, 1, "script_h")); err != nil {
+		t.Fatal(err)
+	} else if s != "string" {
+		t.Fatal(s)
+	}
+
+	//	the writes of a failed script are discarded
+	if _, err := c.Do("eval", `ledis.call("set", KEYS[1], "1"); error("failed")`, 1, "script_kv"); err == nil {
+		t.Fatal("must error")
+	}
+
+	if n, err := ledis.Int(c.Do("exists", "script_kv")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	c.Do("hclear", "script_h")
+	c.Do("zclear", "script_z")
+}
+
+func TestEvalSHA(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	src := `return ledis.call("incrby", KEYS[1], ARGV[1])`
+
+	sha, err := ledis.String(c.Do("script", "load", src))
+	if err != nil {
+		t.Fatal(err)
+	} else if sha != scriptSHA1(src) {
+		t.Fatal(sha)
+	}
+
+	if n, err := ledis.Int(c.Do("evalsha", sha, 1, "script_sha", 2)); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	if v, err := ledis.MultiBulk(c.Do("script", "exists", sha, "0000")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []interface{}{int64(1), int64(0)}) {
+		t.Fatal(v)
+	}
+
+	//	eval in a transaction
+	c.Do("multi")
+	c.Do("evalsha", sha, 1, "script_sha", 3)
+	c.Do("get", "script_sha")
+	if v, err := ledis.MultiBulk(c.Do("exec")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []interface{}{int64(5), []byte("5")}) {
+		t.Fatal(v)
+	}
+
+	if ok, err := ledis.String(c.Do("script", "flush")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+
+	if _, err := c.Do("evalsha", sha, 1, "script_sha", 2); err == nil {
+		t.Fatal("must error")
+	}
+
+	c.Do("del", "script_sha")
+}
+
+func TestScriptKill(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	if _, err := c.Do("script", "kill"); err == nil || !strings.HasPrefix(err.Error(), "NOTBUSY") {
+		t.Fatal(err)
+	}
+
+	//	a running script blocks the other writers until killed, its writes are discarded
+	done := make(chan error, 1)
+	go func() {
+		c1 := getTestConn()
+		defer c1.Close()
+		_, err := c1.Do("eval", `ledis.call("set", KEYS[1], "1") while true do end`, 1, "script_kill")
+		done <- err
+	}()
+
+	for {
+		if _, err := c.Do("script", "kill"); err == nil {
+			break
+		} else if !strings.HasPrefix(err.Error(), "NOTBUSY") {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := <-done; err == nil {
+		t.Fatal("must be killed")
+	}
+
+	if n, err := ledis.Int(c.Do("exists", "script_kill")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("set", "script_kill", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	//	a script is aborted after lua_time_limit
+	limit := testApp.cfg.LuaTimeLimit
+	testApp.cfg.LuaTimeLimit = 100
+	defer func() {
+		testApp.cfg.LuaTimeLimit = limit
+	}()
+
+	start := time.Now()
+	if _, err := c.Do("eval", `while true do pcall(function() while true do end end) end`, 0); err == nil {
+		t.Fatal("must be aborted")
+	} else if d := time.Since(start); d > 5*time.Second {
+		t.Fatal(d)
+	}
+
+	c.Do("del", "script_kill")
+}
+
+func TestLuaWriterArray(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	//	the elements of an unknown type are converted, never panic
+	w := &luaWriter{L: L}
+	w.writeArray([]interface{}{int64(1), []byte("a"), nil, "b", errors.New("c"), 1.5, []interface{}{int64(2)}})
+
+	ay := luaArray(w.value.(*lua.LTable))
+	if !reflect.DeepEqual(ay, []interface{}{int64(1), []byte("a"), nil, []byte("b"), []byte("c"), []byte("1.5"), []interface{}{int64(2)}}) {
+		t.Fatal(ay)
+	}
+}
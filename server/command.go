@@ -12,6 +12,10 @@ type CommandFunc func(req *requestContext) error
 
 var regCmds = map[string]CommandFunc{}
 
+//	the commands writing the data, by the readonly flags of the command table,
+//	a read-only slave refuses them from the clients
+var writeCmds = map[string]bool{}
+
 func register(name string, f CommandFunc) {
 	if _, ok := regCmds[strings.ToLower(name)]; ok {
 		panic(fmt.Sprintf("%s has been registered", name))
@@ -21,6 +25,12 @@ func register(name string, f CommandFunc) {
 }
 
 func pingCommand(req *requestContext) error {
+	if req.client != nil && req.client.subscriptions() > 0 {
+		//in subscriber mode, reply like a pushed message
+		req.resp.writeArray([]interface{}{ledis.Slice("pong"), []byte{}})
+		return nil
+	}
+
 	req.resp.writeStatus(PONG)
 	return nil
 }
@@ -42,7 +52,7 @@ func selectCommand(req *requestContext) error {
 	if index, err := strconv.Atoi(ledis.String(req.args[0])); err != nil {
 		return err
 	} else {
-		if db, err := req.ldb.Select(index); err != nil {
+		if db, err := req.selectDB(index); err != nil {
 			return err
 		} else {
 			req.db = db
@@ -52,7 +62,27 @@ func selectCommand(req *requestContext) error {
 	return nil
 }
 
+//	a slave refuses the writes from the clients if slave_read_only, or remembers them
+func (req *requestContext) checkWrite(cmd string) error {
+	if !writeCmds[cmd] {
+		return nil
+	}
+
+	return req.app.m.checkWrite()
+}
+
 func init() {
+	for _, c := range cnfCmds {
+		if !c.readonly {
+			writeCmds[strings.ToLower(c.name)] = true
+		}
+	}
+
+	//	the commands executed by them are checked one by one
+	for _, name := range []string{"exec", "eval", "evalsha"} {
+		delete(writeCmds, name)
+	}
+
 	register("ping", pingCommand)
 	register("echo", echoCommand)
 	register("select", selectCommand)
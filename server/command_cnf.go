@@ -1,510 +1,870 @@
-//This file was generated by ./generate.py on Mon Aug 11 2014 12:35:56 +0800 
+// This file was generated by ./generate.py on Mon Oct 19 2026 01:52:31 +0000
 package server
 
 type cmdConf struct {
-    name     string
-    argDesc  string
-    group    string
-    readonly bool
+	name     string
+	argDesc  string
+	group    string
+	readonly bool
 }
 
 var cnfCmds = []cmdConf{
 	{
-		"ZRANGEBYSCORE",
-		"key min max [WITHSCORES] [LIMIT offset count]",
-		"ZSet", 
+		"UNWATCH",
+		"-",
+		"Transaction",
 		true,
 	},
 	{
 		"ZPERSIST",
 		"key",
-		"ZSet", 
+		"ZSet",
 		false,
 	},
 	{
-		"LTTL",
-		"key",
-		"List", 
-		true,
-	},
-	{
-		"LINDEX",
-		"key index",
-		"List", 
+		"SLAVEOF",
+		"host port [FORCE]",
+		"Replication",
 		true,
 	},
 	{
-		"FULLSYNC",
-		"-",
-		"Replication", 
+		"MOVE",
+		"key db",
+		"Key",
 		false,
 	},
 	{
-		"ZREVRANK",
-		"key member",
-		"ZSet", 
+		"GEODIST",
+		"key member1 member2 [m|km|ft|mi]",
+		"Geo",
 		true,
 	},
 	{
-		"ZEXPIRE",
+		"EXPIRE",
 		"key seconds",
-		"ZSet", 
+		"KV",
 		false,
 	},
 	{
-		"SYNC",
-		"index offset",
-		"Replication", 
+		"BEXPIRE",
+		"key seconds",
+		"Bitmap",
 		false,
 	},
 	{
-		"BMSETBIT",
-		"key offset value [offset value ...]",
-		"Bitmap", 
+		"ZEXPIREAT",
+		"key timestamp",
+		"ZSet",
 		false,
 	},
 	{
-		"LPOP",
+		"RPOP",
 		"key",
-		"List", 
+		"List",
 		false,
 	},
 	{
-		"HPERSIST",
+		"DUMP",
 		"key",
-		"Hash", 
+		"Key",
+		true,
+	},
+	{
+		"SINTERSTORE",
+		"destination key [key ...]",
+		"Set",
 		false,
 	},
 	{
-		"EXPIRE",
-		"key seconds",
-		"KV", 
+		"ZCLEAR",
+		"key",
+		"ZSet",
 		false,
 	},
 	{
-		"DEL",
+		"LMCLEAR",
 		"key [key ...]",
-		"KV", 
+		"List",
 		false,
 	},
 	{
-		"LPUSH",
-		"key value [value ...]",
-		"List", 
+		"RENAMENX",
+		"key newkey",
+		"Key",
 		false,
 	},
 	{
-		"PERSIST",
-		"key",
-		"KV", 
+		"HMCLEAR",
+		"key [key ...]",
+		"Hash",
 		false,
 	},
 	{
-		"HTTL",
-		"key",
-		"Hash", 
+		"ZCOUNT",
+		"key min max",
+		"ZSet",
 		true,
 	},
 	{
-		"LEXPIREAT",
-		"key timestamp",
-		"List", 
-		false,
+		"GEOPOS",
+		"key member [member ...]",
+		"Geo",
+		true,
 	},
 	{
-		"ZEXPIREAT",
-		"key timestamp",
-		"ZSet", 
+		"ZUNIONSTORE",
+		"destkey numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]",
+		"ZSet",
 		false,
 	},
 	{
-		"DECR",
-		"key",
-		"KV", 
+		"HMSET",
+		"key field value [field value ...]",
+		"Hash",
 		false,
 	},
 	{
-		"SLAVEOF",
-		"host port",
-		"Replication", 
+		"EVAL",
+		"script numkeys [key ...] [arg ...]",
+		"Script",
 		false,
 	},
 	{
-		"INCR",
+		"ZCARD",
 		"key",
-		"KV", 
+		"ZSet",
+		true,
+	},
+	{
+		"ZREMRANGEBYRANK",
+		"key start stop",
+		"ZSet",
 		false,
 	},
 	{
-		"MSET",
-		"key value [key value ...]",
-		"KV", 
+		"SEXPIREAT",
+		"key timestamp",
+		"Set",
 		false,
 	},
 	{
-		"LEXPIRE",
-		"key seconds",
-		"List", 
+		"SCLEAR",
+		"key",
+		"Set",
 		false,
 	},
 	{
-		"HINCRBY",
-		"key field increment",
-		"Hash", 
+		"PFEXPIRE",
+		"key seconds",
+		"HyperLogLog",
 		false,
 	},
 	{
-		"GET",
+		"STTL",
 		"key",
-		"KV", 
+		"Set",
 		true,
 	},
 	{
-		"ZREVRANGE",
-		"key start stop [WITHSCORES]",
-		"ZSet", 
-		true,
+		"BSETBIT",
+		"key offset value",
+		"Bitmap",
+		false,
 	},
 	{
-		"ZINCRBY",
-		"key increment member",
-		"ZSet", 
+		"BEXPIREAT",
+		"key timestamp",
+		"Bitmap",
 		false,
 	},
 	{
-		"LPERSIST",
+		"HCLEAR",
 		"key",
-		"List", 
+		"Hash",
 		false,
 	},
 	{
-		"HEXISTS",
-		"key field",
-		"Hash", 
+		"BGETBIT",
+		"key offset",
+		"Bitmap",
 		true,
 	},
 	{
-		"ZREM",
-		"key member [member ...]",
-		"ZSet", 
+		"DECRBY",
+		"key decrement",
+		"KV",
 		false,
 	},
 	{
-		"BOPT",
-		"operation destkey key [key ...]",
-		"Bitmap", 
+		"RPUSH",
+		"key value [value ...]",
+		"List",
 		false,
 	},
 	{
-		"ZCLEAR",
+		"GEOSEARCH",
+		"key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius m|km|ft|mi|BYBOX width height m|km|ft|mi [ASC|DESC] [COUNT count] [WITHCOORD] [WITHDIST] [WITHHASH]",
+		"Geo",
+		true,
+	},
+	{
+		"PUNSUBSCRIBE",
+		"[pattern ...]",
+		"PubSub",
+		true,
+	},
+	{
+		"LTTL",
 		"key",
-		"ZSet", 
+		"List",
+		true,
+	},
+	{
+		"SREM",
+		"key member [member ...]",
+		"Set",
 		false,
 	},
 	{
-		"LCLEAR",
+		"SYNC",
+		"lastid [STREAM [port]]",
+		"Replication",
+		true,
+	},
+	{
+		"HPERSIST",
 		"key",
-		"List", 
+		"Hash",
 		false,
 	},
 	{
-		"ZRANK",
+		"SISMEMBER",
 		"key member",
-		"ZSet", 
+		"Set",
 		true,
 	},
 	{
-		"TTL",
+		"LRANGE",
+		"key start stop",
+		"List",
+		true,
+	},
+	{
+		"HTTL",
 		"key",
-		"KV", 
+		"Hash",
 		true,
 	},
 	{
-		"ZADD",
-		"key score member [score member ...]",
-		"ZSet", 
-		false,
+		"DISCARD",
+		"-",
+		"Transaction",
+		true,
 	},
 	{
-		"HEXPIRE",
+		"SELECT",
+		"index",
+		"Server",
+		true,
+	},
+	{
+		"GEOHASH",
+		"key member [member ...]",
+		"Geo",
+		true,
+	},
+	{
+		"SEXPIRE",
 		"key seconds",
-		"Hash", 
+		"Set",
 		false,
 	},
 	{
-		"HDEL",
-		"key field [field ...]",
-		"Hash", 
-		false,
+		"GET",
+		"key",
+		"KV",
+		true,
 	},
 	{
-		"HSET",
-		"key field value",
-		"Hash", 
+		"HEXISTS",
+		"key field",
+		"Hash",
+		true,
+	},
+	{
+		"BOPT",
+		"operation destkey key [key ...]",
+		"Bitmap",
 		false,
 	},
 	{
-		"LLEN",
+		"TTL",
 		"key",
-		"List", 
+		"KV",
 		true,
 	},
 	{
-		"HVALS",
-		"key",
-		"Hash", 
+		"HEXPIRE",
+		"key seconds",
+		"Hash",
+		false,
+	},
+	{
+		"ZRANGE",
+		"key start stop [WITHSCORES]",
+		"ZSet",
 		true,
 	},
 	{
 		"BCOUNT",
 		"key [start end]",
-		"Bitmap", 
+		"Bitmap",
 		true,
 	},
 	{
-		"BGET",
+		"ZTTL",
 		"key",
-		"Bitmap", 
+		"ZSet",
 		true,
 	},
 	{
-		"MGET",
-		"key [key ...]",
-		"KV", 
+		"ECHO",
+		"message",
+		"Server",
 		true,
 	},
 	{
-		"EXISTS",
+		"HGETALL",
 		"key",
-		"KV", 
+		"Hash",
 		true,
 	},
 	{
-		"HMCLEAR",
-		"key [key ...]",
-		"Hash", 
+		"HMGET",
+		"key field [field ...]",
+		"Hash",
+		true,
+	},
+	{
+		"INCRBY",
+		"key increment",
+		"KV",
 		false,
 	},
 	{
-		"ZCOUNT",
-		"key min max",
-		"ZSet", 
+		"SCARD",
+		"key",
+		"Set",
 		true,
 	},
 	{
-		"SELECT",
-		"index",
-		"Server", 
+		"SUNIONSTORE",
+		"destination key [key ...]",
+		"Set",
 		false,
 	},
 	{
-		"ECHO",
-		"message",
-		"Server", 
+		"GEORADIUSBYMEMBER",
+		"key member radius m|km|ft|mi [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count] [ASC|DESC]",
+		"Geo",
 		true,
 	},
 	{
-		"ZTTL",
-		"key",
-		"ZSet", 
+		"SDIFF",
+		"key [key ...]",
+		"Set",
 		true,
 	},
 	{
-		"HKEYS",
-		"key",
-		"Hash", 
+		"SMCLEAR",
+		"key [key ...]",
+		"Set",
+		false,
+	},
+	{
+		"PUBSUB",
+		"CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT",
+		"PubSub",
 		true,
 	},
 	{
-		"HGETALL",
-		"key",
-		"Hash", 
+		"MULTI",
+		"-",
+		"Transaction",
 		true,
 	},
 	{
-		"RPOP",
-		"key",
-		"List", 
-		false,
+		"FULLSYNC",
+		"-",
+		"Replication",
+		true,
 	},
 	{
-		"HMGET",
-		"key field [field ...]",
-		"Hash", 
+		"ZREVRANK",
+		"key member",
+		"ZSet",
 		true,
 	},
 	{
-		"SETNX",
+		"RESTORE",
+		"key ttl serialized-value [REPLACE]",
+		"Key",
+		false,
+	},
+	{
+		"GEOADD",
+		"key longitude latitude member [longitude latitude member ...]",
+		"Geo",
+		false,
+	},
+	{
+		"SDIFFSTORE",
+		"destination key [key ...]",
+		"Set",
+		false,
+	},
+	{
+		"LEXPIREAT",
+		"key timestamp",
+		"List",
+		false,
+	},
+	{
+		"SET",
 		"key value",
-		"KV", 
+		"KV",
 		false,
 	},
 	{
-		"HGET",
-		"key field",
-		"Hash", 
+		"SCRIPT",
+		"LOAD script | EXISTS sha1 [sha1 ...] | FLUSH | KILL",
+		"Script",
 		true,
 	},
 	{
-		"BPERSIST",
+		"BDELETE",
 		"key",
-		"Bitmap", 
+		"ZSet",
 		false,
 	},
 	{
-		"INCRBY",
-		"key increment",
-		"KV", 
+		"LPERSIST",
+		"key",
+		"List",
 		false,
 	},
 	{
-		"BDELETE",
+		"EVALSHA",
+		"sha1 numkeys [key ...] [arg ...]",
+		"Script",
+		false,
+	},
+	{
+		"LCLEAR",
 		"key",
-		"ZSet", 
+		"List",
 		false,
 	},
 	{
-		"ZMCLEAR",
+		"PFMERGE",
+		"destkey sourcekey [sourcekey ...]",
+		"HyperLogLog",
+		false,
+	},
+	{
+		"ZADD",
+		"key score member [score member ...]",
+		"ZSet",
+		false,
+	},
+	{
+		"HSET",
+		"key field value",
+		"Hash",
+		false,
+	},
+	{
+		"LLEN",
+		"key",
+		"List",
+		true,
+	},
+	{
+		"BFIELD",
+		"key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]",
+		"Bitmap",
+		false,
+	},
+	{
+		"PFEXPIREAT",
+		"key timestamp",
+		"HyperLogLog",
+		false,
+	},
+	{
+		"LEXPIRE",
+		"key seconds",
+		"List",
+		false,
+	},
+	{
+		"PUBLISH",
+		"channel message",
+		"PubSub",
+		true,
+	},
+	{
+		"HKEYS",
+		"key",
+		"Hash",
+		true,
+	},
+	{
+		"LINDEX",
+		"key index",
+		"List",
+		true,
+	},
+	{
+		"SETNX",
+		"key value",
+		"KV",
+		false,
+	},
+	{
+		"SINTER",
 		"key [key ...]",
-		"ZSet", 
+		"Set",
+		true,
+	},
+	{
+		"BPERSIST",
+		"key",
+		"Bitmap",
 		false,
 	},
 	{
-		"RPUSH",
-		"key value [value ...]",
-		"List", 
+		"MSET",
+		"key value [key value ...]",
+		"KV",
 		false,
 	},
 	{
-		"LRANGE",
-		"key start stop",
-		"List", 
+		"ZRANK",
+		"key member",
+		"ZSet",
+		true,
+	},
+	{
+		"WAIT",
+		"numslaves timeout",
+		"Replication",
+		true,
+	},
+	{
+		"INFO",
+		"[section]",
+		"Server",
 		true,
 	},
 	{
 		"HLEN",
 		"key",
-		"Hash", 
+		"Hash",
 		true,
 	},
 	{
 		"ZSCORE",
 		"key member",
-		"ZSet", 
+		"ZSet",
 		true,
 	},
 	{
-		"LMCLEAR",
+		"EXPIREAT",
+		"key timestamp",
+		"KV",
+		false,
+	},
+	{
+		"PFTTL",
+		"key",
+		"HyperLogLog",
+		true,
+	},
+	{
+		"BTTL",
+		"key",
+		"Bitmap",
+		true,
+	},
+	{
+		"SPERSIST",
+		"key",
+		"Set",
+		false,
+	},
+	{
+		"HVALS",
+		"key",
+		"Hash",
+		true,
+	},
+	{
+		"ZREVRANGEBYSCORE",
+		"key max min  [WITHSCORES][LIMIT offset count]",
+		"ZSet",
+		true,
+	},
+	{
+		"DEL",
 		"key [key ...]",
-		"List", 
+		"KV",
 		false,
 	},
 	{
-		"EXPIREAT",
+		"GETSET",
+		" key value",
+		"KV",
+		false,
+	},
+	{
+		"SMEMBERS",
+		"key",
+		"Set",
+		true,
+	},
+	{
+		"HEXPIREAT",
 		"key timestamp",
-		"KV", 
+		"Hash",
 		false,
 	},
 	{
-		"ZREMRANGEBYSCORE",
-		"key min max",
-		"ZSet", 
+		"RENAME",
+		"key newkey",
+		"Key",
 		false,
 	},
 	{
-		"ZCARD",
-		"key",
-		"ZSet", 
+		"ZRANGEBYSCORE",
+		"key min max [WITHSCORES] [LIMIT offset count]",
+		"ZSet",
 		true,
 	},
 	{
-		"ZREMRANGEBYRANK",
-		"key start stop",
-		"ZSet", 
+		"ZREVRANGE",
+		"key start stop [WITHSCORES]",
+		"ZSet",
+		true,
+	},
+	{
+		"PFADD",
+		"key [element ...]",
+		"HyperLogLog",
 		false,
 	},
 	{
 		"PING",
 		"-",
-		"Server", 
+		"Server",
 		true,
 	},
 	{
-		"HMSET",
-		"key field value [field value ...]",
-		"Hash", 
+		"PFPERSIST",
+		"key",
+		"HyperLogLog",
 		false,
 	},
 	{
-		"BTTL",
+		"BMSETBIT",
+		"key offset value [offset value ...]",
+		"Bitmap",
+		false,
+	},
+	{
+		"ZINCRBY",
+		"key increment member",
+		"ZSet",
+		false,
+	},
+	{
+		"LPUSH",
+		"key value [value ...]",
+		"List",
+		false,
+	},
+	{
+		"WATCH",
+		"key [key ...]",
+		"Transaction",
+		true,
+	},
+	{
+		"PERSIST",
 		"key",
-		"Bitmap", 
+		"KV",
+		false,
+	},
+	{
+		"DECR",
+		"key",
+		"KV",
+		false,
+	},
+	{
+		"BINLOG",
+		"LIST | PURGE TO index | PURGE BEFORE timestamp",
+		"Replication",
 		true,
 	},
 	{
-		"HCLEAR",
+		"INCR",
 		"key",
-		"Hash", 
+		"KV",
 		false,
 	},
 	{
-		"ZRANGE",
-		"key start stop [WITHSCORES]",
-		"ZSet", 
+		"HINCRBY",
+		"key field increment",
+		"Hash",
 		false,
 	},
 	{
-		"ZREVRANGEBYSCORE",
-		"key max min  [WITHSCORES][LIMIT offset count]",
-		"ZSet", 
+		"SUBSCRIBE",
+		"channel [channel ...]",
+		"PubSub",
 		true,
 	},
 	{
-		"BSETBIT",
-		"key offset value",
-		"Bitmap", 
+		"EXEC",
+		"-",
+		"Transaction",
 		false,
 	},
 	{
-		"BEXPIREAT",
-		"key timestamp",
-		"Bitmap", 
+		"BPOS",
+		"key bit [start end]",
+		"Bitmap",
+		true,
+	},
+	{
+		"PFCLEAR",
+		"key",
+		"HyperLogLog",
 		false,
 	},
 	{
-		"SET",
-		"key value",
-		"KV", 
+		"ROLE",
+		"-",
+		"Replication",
+		true,
+	},
+	{
+		"HDEL",
+		"key field [field ...]",
+		"Hash",
 		false,
 	},
 	{
-		"BGETBIT",
-		"key offset",
-		"Bitmap", 
+		"MGET",
+		"key [key ...]",
+		"KV",
 		true,
 	},
 	{
-		"BEXPIRE",
-		"key seconds",
-		"Bitmap", 
+		"BGET",
+		"key",
+		"Bitmap",
+		true,
+	},
+	{
+		"SADD",
+		"key member [member ...]",
+		"Set",
 		false,
 	},
 	{
-		"GETSET",
-		" key value",
-		"KV", 
+		"EXISTS",
+		"key",
+		"KV",
+		true,
+	},
+	{
+		"PSUBSCRIBE",
+		"pattern [pattern ...]",
+		"PubSub",
+		true,
+	},
+	{
+		"GEORADIUS",
+		"key longitude latitude radius m|km|ft|mi [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count] [ASC|DESC]",
+		"Geo",
+		true,
+	},
+	{
+		"SUNION",
+		"key [key ...]",
+		"Set",
+		true,
+	},
+	{
+		"HGET",
+		"key field",
+		"Hash",
+		true,
+	},
+	{
+		"COPY",
+		"source destination [DB destination-db] [REPLACE]",
+		"Key",
 		false,
 	},
 	{
-		"DECRBY",
-		"key decrement",
-		"KV", 
+		"ZINTERSTORE",
+		"destkey numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]",
+		"ZSet",
 		false,
 	},
 	{
-		"HEXPIREAT",
-		"key timestamp",
-		"Hash", 
+		"ZMCLEAR",
+		"key [key ...]",
+		"ZSet",
+		false,
+	},
+	{
+		"PFCOUNT",
+		"key [key ...]",
+		"HyperLogLog",
+		true,
+	},
+	{
+		"ZREMRANGEBYSCORE",
+		"key min max",
+		"ZSet",
+		false,
+	},
+	{
+		"ZEXPIRE",
+		"key seconds",
+		"ZSet",
+		false,
+	},
+	{
+		"LPOP",
+		"key",
+		"List",
+		false,
+	},
+	{
+		"UNSUBSCRIBE",
+		"[channel ...]",
+		"PubSub",
+		true,
+	},
+	{
+		"ZREM",
+		"key member [member ...]",
+		"ZSet",
 		false,
 	},
 }
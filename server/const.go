@@ -9,6 +9,7 @@ var (
 	ErrNotFound     = errors.New("command not found")
 	ErrCmdParams    = errors.New("invalid command param")
 	ErrValue        = errors.New("value is not an integer or out of range")
+	ErrFloat        = errors.New("value is not a valid float")
 	ErrSyntax       = errors.New("syntax error")
 	ErrOffset       = errors.New("offset bit is not an natural number")
 	ErrBool         = errors.New("value is not 0 or 1")
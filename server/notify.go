@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"github.com/siddontang/ledisdb/ledis"
+	"strings"
+)
+
+type keyspaceEvents struct {
+	//	publish to __keyspace@<db>__:<key> with the event
+	keyspace bool
+	//	publish to __keyevent@<db>__:<event> with the key
+	keyevent bool
+
+	classes int
+}
+
+//	parse the flags like redis notify-keyspace-events, K and E select the channels,
+//	the others are the classes of ledis.ParseNotifyClasses
+func parseKeyspaceEvents(flags string) (*keyspaceEvents, error) {
+	e := new(keyspaceEvents)
+
+	e.keyspace = strings.Contains(flags, "K")
+	e.keyevent = strings.Contains(flags, "E")
+
+	var err error
+	flags = strings.NewReplacer("K", "", "E", "").Replace(flags)
+	if e.classes, err = ledis.ParseNotifyClasses(flags); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *keyspaceEvents) enabled() bool {
+	return e.classes != 0 && (e.keyspace || e.keyevent)
+}
+
+//	publish the key events of ledis to the subscribers
+func (app *App) publishKeyEvents(events *keyspaceEvents) {
+	if !events.enabled() {
+		return
+	}
+
+	app.ldb.AddKeyEventHandler(ledis.KeyEventFilter{DB: -1, Classes: events.classes}, func(e *ledis.KeyEvent) {
+		if events.keyspace {
+			channel := append([]byte(fmt.Sprintf("__keyspace@%d__:", e.DB)), e.Key...)
+			app.pubsub.publish(channel, ledis.Slice(e.Event))
+		}
+
+		if events.keyevent {
+			channel := []byte(fmt.Sprintf("__keyevent@%d__:%s", e.DB, e.Event))
+			app.pubsub.publish(channel, append([]byte{}, e.Key...))
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"errors"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/ledisdb/ledis"
+	"sort"
+	"sync"
+)
+
+//	the messages a subscriber can buffer, a subscriber too slow to receive
+//	them is disconnected so that the publisher is never blocked
+const defaultPubSubBufferSize = 1024
+
+var (
+	errPubSubContext = errors.New("only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+	errPubSubClient  = errors.New("subscribe is only supported with the RESP protocol")
+)
+
+type pubsubMessage struct {
+	pattern []byte
+	channel []byte
+	data    []byte
+}
+
+type pubsubClients map[*respClient]struct{}
+
+type pubsub struct {
+	sync.RWMutex
+
+	channels map[string]pubsubClients
+	patterns map[string]pubsubClients
+}
+
+func newPubSub() *pubsub {
+	p := new(pubsub)
+
+	p.channels = make(map[string]pubsubClients)
+	p.patterns = make(map[string]pubsubClients)
+
+	return p
+}
+
+func (p *pubsub) subscribe(m map[string]pubsubClients, name string, c *respClient) {
+	p.Lock()
+	clients, ok := m[name]
+	if !ok {
+		clients = make(pubsubClients)
+		m[name] = clients
+	}
+	clients[c] = struct{}{}
+	p.Unlock()
+}
+
+func (p *pubsub) unsubscribe(m map[string]pubsubClients, name string, c *respClient) {
+	p.Lock()
+	if clients, ok := m[name]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(m, name)
+		}
+	}
+	p.Unlock()
+}
+
+//	publish the message to all the subscribers of channel, return the number of them received it
+func (p *pubsub) publish(channel []byte, data []byte) int64 {
+	p.RLock()
+	defer p.RUnlock()
+
+	var n int64 = 0
+
+	m := &pubsubMessage{channel: channel, data: data}
+	for c := range p.channels[ledis.String(channel)] {
+		if c.pushMessage(m) {
+			n++
+		}
+	}
+
+	for pattern, clients := range p.patterns {
+		if !ledis.PatternMatch(ledis.Slice(pattern), channel) {
+			continue
+		}
+
+		m := &pubsubMessage{pattern: ledis.Slice(pattern), channel: channel, data: data}
+		for c := range clients {
+			if c.pushMessage(m) {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+//	the active channels matching pattern, all if pattern is nil
+func (p *pubsub) activeChannels(pattern []byte) [][]byte {
+	p.RLock()
+	defer p.RUnlock()
+
+	names := make([]string, 0, len(p.channels))
+	for name := range p.channels {
+		if pattern == nil || ledis.PatternMatch(pattern, ledis.Slice(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	channels := make([][]byte, len(names))
+	for i, name := range names {
+		channels[i] = ledis.Slice(name)
+	}
+	return channels
+}
+
+func (p *pubsub) numSub(channel []byte) int64 {
+	p.RLock()
+	n := len(p.channels[ledis.String(channel)])
+	p.RUnlock()
+	return int64(n)
+}
+
+func (p *pubsub) numPat() int64 {
+	p.RLock()
+	n := len(p.patterns)
+	p.RUnlock()
+	return int64(n)
+}
+
+//	subscriptions of a client, only used in the client goroutine
+
+func (c *respClient) subscriptions() int64 {
+	return int64(len(c.channels) + len(c.patterns))
+}
+
+func (c *respClient) initPubSub() {
+	if c.msgs != nil {
+		return
+	}
+
+	size := c.app.cfg.PubSubBufferSize
+	if size <= 0 {
+		size = defaultPubSubBufferSize
+	}
+
+	c.channels = make(map[string]struct{})
+	c.patterns = make(map[string]struct{})
+	c.msgs = make(chan *pubsubMessage, size)
+
+	go c.pubsubLoop()
+}
+
+func (c *respClient) subscribe(channels [][]byte, pattern bool) {
+	c.initPubSub()
+
+	m, subs, kind := c.app.pubsub.channels, c.channels, "subscribe"
+	if pattern {
+		m, subs, kind = c.app.pubsub.patterns, c.patterns, "psubscribe"
+	}
+
+	for _, ch := range channels {
+		name := string(ch)
+		if _, ok := subs[name]; !ok {
+			subs[name] = struct{}{}
+			c.app.pubsub.subscribe(m, name, c)
+		}
+
+		c.req.resp.writeArray([]interface{}{ledis.Slice(kind), ch, c.subscriptions()})
+	}
+}
+
+//	unsubscribe channels, or all the subscribed ones if channels is empty
+func (c *respClient) unsubscribe(channels [][]byte, pattern bool) {
+	m, subs, kind := c.app.pubsub.channels, c.channels, "unsubscribe"
+	if pattern {
+		m, subs, kind = c.app.pubsub.patterns, c.patterns, "punsubscribe"
+	}
+
+	if len(channels) == 0 {
+		for name := range subs {
+			channels = append(channels, ledis.Slice(name))
+		}
+
+		if len(channels) == 0 {
+			c.req.resp.writeArray([]interface{}{ledis.Slice(kind), nil, c.subscriptions()})
+			return
+		}
+	}
+
+	for _, ch := range channels {
+		name := string(ch)
+		if _, ok := subs[name]; ok {
+			delete(subs, name)
+			c.app.pubsub.unsubscribe(m, name, c)
+		}
+
+		c.req.resp.writeArray([]interface{}{ledis.Slice(kind), ch, c.subscriptions()})
+	}
+}
+
+func (c *respClient) unsubscribeAll() {
+	for name := range c.channels {
+		c.app.pubsub.unsubscribe(c.app.pubsub.channels, name, c)
+	}
+
+	for name := range c.patterns {
+		c.app.pubsub.unsubscribe(c.app.pubsub.patterns, name, c)
+	}
+
+	c.channels = nil
+	c.patterns = nil
+}
+
+//	push the message without blocking, the client is disconnected if its buffer is full
+func (c *respClient) pushMessage(m *pubsubMessage) bool {
+	select {
+	case c.msgs <- m:
+		return true
+	default:
+		log.Error("pubsub client %s is too slow, disconnect it", c.req.remoteAddr)
+		c.conn.Close()
+		return false
+	}
+}
+
+func (c *respClient) writeMessage(m *pubsubMessage) {
+	if m.pattern != nil {
+		c.req.resp.writeArray([]interface{}{ledis.Slice("pmessage"), m.pattern, m.channel, m.data})
+	} else {
+		c.req.resp.writeArray([]interface{}{ledis.Slice("message"), m.channel, m.data})
+	}
+}
+
+func (c *respClient) pubsubLoop() {
+	for {
+		select {
+		case m := <-c.msgs:
+			c.wlock.Lock()
+			c.writeMessage(m)
+			for n := len(c.msgs); n > 0; n-- {
+				c.writeMessage(<-c.msgs)
+			}
+			c.req.resp.flush()
+			c.wlock.Unlock()
+		case <-c.quit:
+			return
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/siddontang/go-log/log"
 	"github.com/siddontang/go-snappy/snappy"
 	"github.com/siddontang/ledisdb/ledis"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -20,13 +21,21 @@ import (
 )
 
 var (
-	errConnectMaster = errors.New("connect master error")
+	errConnectMaster  = errors.New("connect master error")
+	errMasterNoBinLog = errors.New("master not support binlog")
+	errReadOnly       = errors.New("READONLY You can't write against a read only slave.")
+	errSlaveofSelf    = errors.New("can not replicate from itself")
+	errSlaveofSlave   = errors.New("can not replicate from its own slave")
 )
 
+//	the master and the id of the last batch replicated from it, which is the same
+//	on any server logging the batches, so the slave can resume from another one
 type MasterInfo struct {
-	Addr         string `json:"addr"`
-	LogFileIndex int64  `json:"log_file_index"`
-	LogPos       int64  `json:"log_pos"`
+	Addr   string `json:"addr"`
+	LastID uint64 `json:"last_id"`
+
+	//	the slave has the writes of its clients, which a full sync would discard
+	LocalWrites bool `json:"local_writes"`
 }
 
 func (m *MasterInfo) Save(filePath string) error {
@@ -72,8 +81,10 @@ func (m *MasterInfo) Load(filePath string) error {
 type master struct {
 	sync.Mutex
 
-	conn net.Conn
-	rb   *bufio.Reader
+	//	the connection is replaced by the replication goroutine, and closed by Close
+	connLock sync.Mutex
+	conn     net.Conn
+	rb       *bufio.Reader
 
 	app *App
 
@@ -88,6 +99,86 @@ type master struct {
 	syncBuf bytes.Buffer
 
 	compressBuf []byte
+
+	//	a full sync is done even if the slave has local writes, set by SLAVEOF host port FORCE
+	force bool
+
+	//	the state reported by ROLE and INFO, guarded by stateLock
+	stateLock sync.Mutex
+	slaveState
+}
+
+//	the replication state of a slave
+type slaveState struct {
+	state      string
+	masterAddr string
+
+	//	the last time read from the master
+	lastIO time.Time
+
+	//	the last batch replicated
+	lastID uint64
+
+	//	the bytes of the dump read by the full sync
+	syncReadBytes int64
+
+	//	the bytes of the binlog after the last frame, when it was pushed
+	lagBytes int64
+
+	//	the clients have written to the server since the last full sync, as a slave or not
+	localWrites bool
+}
+
+//	the replication states of a slave, like the ones of redis ROLE
+const (
+	replStateNone       = ""
+	replStateConnect    = "connect"
+	replStateConnecting = "connecting"
+	replStateSync       = "sync"
+	replStateConnected  = "connected"
+)
+
+func (m *master) getState() slaveState {
+	m.stateLock.Lock()
+	s := m.slaveState
+	m.stateLock.Unlock()
+	return s
+}
+
+func (m *master) setState(state string) {
+	m.stateLock.Lock()
+	m.state = state
+	m.stateLock.Unlock()
+}
+
+//	check a write from a client, see requestContext.checkWrite
+func (m *master) checkWrite() error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+
+	if m.state != replStateNone && m.app.cfg.SlaveReadOnly {
+		return errReadOnly
+	}
+
+	m.localWrites = true
+	return nil
+}
+
+//	count the bytes of the dump read by the full sync
+type syncProgressWriter struct {
+	m *master
+	w io.Writer
+}
+
+func (w *syncProgressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+
+	w.m.stateLock.Lock()
+	w.m.syncReadBytes += int64(n)
+	w.m.lastIO = time.Now()
+	w.m.stateLock.Unlock()
+
+	return n, err
 }
 
 func newMaster(app *App) *master {
@@ -114,19 +205,26 @@ func (m *master) Close() {
 	default:
 	}
 
+	m.connLock.Lock()
 	if m.conn != nil {
 		m.conn.Close()
-		m.conn = nil
 	}
+	m.connLock.Unlock()
 
 	m.wg.Wait()
 }
 
 func (m *master) loadInfo() error {
-	return m.info.Load(m.infoName)
+	err := m.info.Load(m.infoName)
+	m.localWrites = m.info.LocalWrites
+	return err
 }
 
 func (m *master) saveInfo() error {
+	m.stateLock.Lock()
+	m.info.LocalWrites = m.localWrites
+	m.stateLock.Unlock()
+
 	return m.info.Save(m.infoName)
 }
 
@@ -135,30 +233,40 @@ func (m *master) connect() error {
 		return fmt.Errorf("no assign master addr")
 	}
 
+	conn, err := net.Dial("tcp", m.info.Addr)
+
+	m.connLock.Lock()
 	if m.conn != nil {
 		m.conn.Close()
 		m.conn = nil
 	}
 
-	if conn, err := net.Dial("tcp", m.info.Addr); err != nil {
-		return err
-	} else {
+	if err == nil {
 		m.conn = conn
-
 		m.rb = bufio.NewReaderSize(m.conn, 4096)
 	}
-	return nil
+	m.connLock.Unlock()
+
+	return err
 }
 
 func (m *master) resetInfo(addr string) {
 	m.info.Addr = addr
-	m.info.LogFileIndex = 0
-	m.info.LogPos = 0
+	m.info.LastID = 0
 }
 
 func (m *master) stopReplication() error {
 	m.Close()
 
+	return m.endReplication()
+}
+
+//	leave the slave mode, called by the replication goroutine itself when the master
+//	does not support binlog, it can not wait for itself in Close
+func (m *master) endReplication() error {
+	m.setState(replStateNone)
+	m.app.ldb.SetSlaveMode(false)
+
 	if err := m.saveInfo(); err != nil {
 		log.Error("save master info error %s", err.Error())
 		return err
@@ -167,10 +275,18 @@ func (m *master) stopReplication() error {
 	return nil
 }
 
-func (m *master) startReplication(masterAddr string) error {
+func (m *master) startReplication(masterAddr string, force bool) error {
 	//stop last replcation, if avaliable
 	m.Close()
 
+	m.stateLock.Lock()
+	if m.state == replStateNone && m.app.ldb.BinLogLastID() > m.info.LastID {
+		//	the batches after the last one replicated are written by the clients,
+		//	before a restart too, a slave logs its local writes with the last id replicated
+		m.localWrites = true
+	}
+	m.stateLock.Unlock()
+
 	if masterAddr != m.info.Addr {
 		m.resetInfo(masterAddr)
 		if err := m.saveInfo(); err != nil {
@@ -180,13 +296,22 @@ func (m *master) startReplication(masterAddr string) error {
 	}
 
 	m.quit = make(chan struct{}, 1)
+	m.force = force
 
+	m.app.ldb.SetSlaveMode(true)
+
+	m.stateLock.Lock()
+	m.state = replStateConnect
+	m.masterAddr = m.info.Addr
+	m.lastID = m.info.LastID
+	m.stateLock.Unlock()
+
+	m.wg.Add(1)
 	go m.runReplication()
 	return nil
 }
 
 func (m *master) runReplication() {
-	m.wg.Add(1)
 	defer m.wg.Done()
 
 	for {
@@ -194,60 +319,81 @@ func (m *master) runReplication() {
 		case <-m.quit:
 			return
 		default:
-			if err := m.connect(); err != nil {
-				log.Error("connect master %s error %s, try 2s later", m.info.Addr, err.Error())
-				time.Sleep(2 * time.Second)
-				continue
-			}
 		}
 
-		if m.info.LogFileIndex == 0 {
-			//try a fullsync
-			if err := m.fullSync(); err != nil {
-				log.Warn("full sync error %s", err.Error())
-				return
-			}
+		if m.info.LastID == 0 && !m.force && m.getState().localWrites {
+			//	wait for SLAVEOF host port FORCE
+			m.setState(replStateConnect)
+			log.Error("slave has local writes, full sync from %s refused, use SLAVEOF host port FORCE to discard them", m.info.Addr)
+			return
+		}
 
-			if m.info.LogFileIndex == 0 {
-				//master not support binlog, we cannot sync, so stop replication
-				m.stopReplication()
+		m.setState(replStateConnecting)
+
+		if err := m.connect(); err != nil {
+			m.setState(replStateConnect)
+			log.Error("connect master %s error %s, try 2s later", m.info.Addr, err.Error())
+			if m.waitQuit(2 * time.Second) {
 				return
 			}
+			continue
 		}
 
-		for {
-			for {
-				lastIndex := m.info.LogFileIndex
-				lastPos := m.info.LogPos
-				if err := m.sync(); err != nil {
-					log.Warn("sync error %s", err.Error())
+		if m.info.LastID == 0 {
+			//try a fullsync
+			if err := m.fullSync(); err == errMasterNoBinLog {
+				//master not support binlog, we cannot sync, so stop replication
+				m.endReplication()
+				return
+			} else if err != nil {
+				m.setState(replStateConnect)
+				log.Warn("full sync error %s, retry 2s later", err.Error())
+				if m.waitQuit(2 * time.Second) {
 					return
 				}
-
-				if m.info.LogFileIndex == lastIndex && m.info.LogPos == lastPos {
-					//sync no data, wait 1s and retry
-					break
-				}
+				continue
 			}
+		}
 
-			select {
-			case <-m.quit:
+		if err := m.syncStream(); err == errSyncStopped {
+			m.endReplication()
+			return
+		} else if err == errSyncLost {
+			//	a full sync on the next connection, the stream one is closed by the master
+			m.info.LastID = 0
+			m.setState(replStateConnect)
+		} else if err != nil {
+			m.setState(replStateConnect)
+			log.Warn("sync stream error %s, resume 2s later", err.Error())
+			if m.waitQuit(2 * time.Second) {
 				return
-			case <-time.After(1 * time.Second):
-				break
 			}
 		}
 	}
+}
 
-	return
+//	wait d, returns true if the replication is stopped meanwhile
+func (m *master) waitQuit(d time.Duration) bool {
+	select {
+	case <-m.quit:
+		return true
+	case <-time.After(d):
+		return false
+	}
 }
 
 var (
-	fullSyncCmd   = []byte("*1\r\n$8\r\nfullsync\r\n")               //fullsync
-	syncCmdFormat = "*3\r\n$4\r\nsync\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n" //sync index pos
+	fullSyncCmd         = []byte("*1\r\n$8\r\nfullsync\r\n")                               //fullsync
+	syncStreamCmdFormat = "*4\r\n$4\r\nsync\r\n$%d\r\n%s\r\n$6\r\nstream\r\n$%d\r\n%s\r\n" //sync lastid stream port
+	syncAckCmdFormat    = "*3\r\n$8\r\nreplconf\r\n$3\r\nack\r\n$%d\r\n%s\r\n"             //replconf ack lastid
 )
 
 func (m *master) fullSync() error {
+	m.stateLock.Lock()
+	m.state = replStateSync
+	m.syncReadBytes = 0
+	m.stateLock.Unlock()
+
 	if _, err := m.conn.Write(fullSyncCmd); err != nil {
 		return err
 	}
@@ -260,7 +406,7 @@ func (m *master) fullSync() error {
 
 	defer os.Remove(dumpPath)
 
-	err = ReadBulkTo(m.rb, f)
+	err = ReadBulkTo(m.rb, &syncProgressWriter{m, f})
 	f.Close()
 	if err != nil {
 		log.Error("read dump data error %s", err.Error())
@@ -279,22 +425,61 @@ func (m *master) fullSync() error {
 		return err
 	}
 
-	m.info.LogFileIndex = head.LogFileIndex
-	m.info.LogPos = head.LogPos
+	if head.LogFileIndex == 0 {
+		return errMasterNoBinLog
+	}
+
+	m.info.LastID = head.LastID
+	m.force = false
+
+	m.stateLock.Lock()
+	m.lastID = head.LastID
+	m.localWrites = false
+	m.stateLock.Unlock()
 
 	return m.saveInfo()
 }
 
-func (m *master) sync() error {
-	logIndexStr := strconv.FormatInt(m.info.LogFileIndex, 10)
-	logPosStr := strconv.FormatInt(m.info.LogPos, 10)
+//	replicate the batches pushed by the master after our last id, and ack them, until
+//	the connection is broken, or the master is silent for syncStreamTimeout.
+func (m *master) syncStream() error {
+	lastIDStr := strconv.FormatUint(m.info.LastID, 10)
 
-	cmd := ledis.Slice(fmt.Sprintf(syncCmdFormat, len(logIndexStr),
-		logIndexStr, len(logPosStr), logPosStr))
+	//	the port we listen on, so the master knows its slaves, see checkMasterAddr
+	_, port, err := net.SplitHostPort(m.app.cfg.Addr)
+	if err != nil {
+		port = "0"
+	}
+
+	cmd := ledis.Slice(fmt.Sprintf(syncStreamCmdFormat, len(lastIDStr), lastIDStr, len(port), port))
 	if _, err := m.conn.Write(cmd); err != nil {
 		return err
 	}
 
+	for {
+		//	a heartbeat is pushed every syncHeartbeatInterval at least
+		m.conn.SetReadDeadline(time.Now().Add(syncStreamTimeout))
+
+		if err := m.replicateSyncData(); err != nil {
+			return err
+		}
+
+		lastIDStr = strconv.FormatUint(m.info.LastID, 10)
+
+		cmd = ledis.Slice(fmt.Sprintf(syncAckCmdFormat, len(lastIDStr), lastIDStr))
+		if _, err := m.conn.Write(cmd); err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	errSyncStopped = errors.New("master not support binlog, replication stopped")
+	errSyncLost    = errors.New("batches lost, full sync needed")
+)
+
+//	read the sync data from the master and replicate it
+func (m *master) replicateSyncData() error {
 	m.syncBuf.Reset()
 
 	err := ReadBulkTo(m.rb, &m.syncBuf)
@@ -310,40 +495,125 @@ func (m *master) sync() error {
 		m.compressBuf = buf
 	}
 
-	if len(buf) < 16 {
+	if len(buf) < 24 {
 		return fmt.Errorf("invalid sync data len %d", len(buf))
 	}
 
-	m.info.LogFileIndex = int64(binary.BigEndian.Uint64(buf[0:8]))
-	m.info.LogPos = int64(binary.BigEndian.Uint64(buf[8:16]))
+	status := int64(binary.BigEndian.Uint64(buf[0:8]))
+	lastID := binary.BigEndian.Uint64(buf[8:16])
+	behind := int64(binary.BigEndian.Uint64(buf[16:24]))
 
-	if m.info.LogFileIndex == 0 {
+	if status == 0 {
 		//master now not support binlog, stop replication
-		m.stopReplication()
-		return nil
-	} else if m.info.LogFileIndex == -1 {
-		//-1 means than binlog index and pos are lost, we must start a full sync instead
-		return m.fullSync()
+		return errSyncStopped
+	} else if status == -1 {
+		//-1 means than the batches after our last id are lost, we must start a full sync instead
+		return errSyncLost
 	}
 
-	err = m.app.ldb.ReplicateFromData(buf[16:])
-	if err != nil {
+	if len(buf) > 24 {
+		err = m.app.ldb.ReplicateFromData(buf[24:])
+	}
+
+	if err == ledis.ErrBinLogCorrupt {
+		//the events are corrupt in transit, we can not go on from a broken position
+		log.Error("sync data corrupt, start a full sync")
+		return errSyncLost
+	} else if err != nil {
 		return err
 	}
 
-	return m.saveInfo()
+	m.stateLock.Lock()
+	m.state = replStateConnected
+	m.lastIO = time.Now()
+	m.lastID = lastID
+	m.lagBytes = behind
+	localWrites := m.localWrites
+	m.stateLock.Unlock()
+
+	if lastID == m.info.LastID && localWrites == m.info.LocalWrites {
+		//	a heartbeat
+		return nil
+	}
+
+	m.info.LastID = lastID
 
+	return m.saveInfo()
 }
 
-func (app *App) slaveof(masterAddr string) error {
+func (app *App) slaveof(masterAddr string, force bool) error {
 	app.m.Lock()
 	defer app.m.Unlock()
 
 	if len(masterAddr) == 0 {
 		return app.m.stopReplication()
-	} else {
-		return app.m.startReplication(masterAddr)
+	} else if err := app.checkMasterAddr(masterAddr); err != nil {
+		return err
+	}
+
+	return app.m.startReplication(masterAddr, force)
+}
+
+//	a server can not replicate from itself or its own stream slaves, which would replicate
+//	their own batches back
+func (app *App) checkMasterAddr(masterAddr string) error {
+	host, port, err := net.SplitHostPort(masterAddr)
+	if err != nil {
+		return err
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+
+	if _, selfPort, err := net.SplitHostPort(app.cfg.Addr); err == nil && selfPort == port {
+		for _, ip := range ips {
+			if isLocalIP(ip) {
+				return errSlaveofSelf
+			}
+		}
+	}
+
+	for _, ack := range app.acks.list() {
+		if ack.port != port {
+			continue
+		}
+
+		slaveHost, _, _ := net.SplitHostPort(ack.addr)
+		slave := net.ParseIP(slaveHost)
+		for _, ip := range ips {
+			if sameIP(ip, slave) {
+				return errSlaveofSlave
+			}
+		}
 	}
 
 	return nil
 }
+
+//	the same ip, or both are of this host, like 127.0.0.1 and the ip of an interface
+func sameIP(a net.IP, b net.IP) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.Equal(b) || (isLocalIP(a) && isLocalIP(b))
+}
+
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
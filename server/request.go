@@ -25,15 +25,31 @@ type requestContext struct {
 	ldb *ledis.Ledis
 	db  *ledis.DB
 
+	//	the DBs are selected from session, which remembers the last batch of the writes of the client
+	session *ledis.Session
+
 	remoteAddr string
 	cmd        string
 	args       [][]byte
 
 	resp responseWriter
 
+	//	the RESP client, nil for http
+	client *respClient
+
+	//	MULTI state, the queued commands are executed by EXEC with multi
+	inMulti   bool
+	multiErr  bool
+	multiCmds []queuedCommand
+	watches   []watchKey
+	multi     *ledis.Multi
+
 	syncBuf     bytes.Buffer
 	compressBuf []byte
 
+	//	the client is a slave syncing, see holdBinLog
+	slave bool
+
 	reqErr chan error
 
 	buf bytes.Buffer
@@ -44,7 +60,8 @@ func newRequestContext(app *App) *requestContext {
 
 	req.app = app
 	req.ldb = app.ldb
-	req.db, _ = app.ldb.Select(0) //use default db
+	req.session = app.ldb.NewSession()
+	req.db, _ = req.session.Select(0) //use default db
 
 	req.compressBuf = make([]byte, 256)
 	req.reqErr = make(chan error)
@@ -61,12 +78,31 @@ func (req *requestContext) perform() {
 		err = ErrEmptyCommand
 	} else if exeCmd, ok := regCmds[req.cmd]; !ok {
 		err = ErrNotFound
+		if req.inMulti {
+			req.multiErr = true
+		}
+	} else if err = req.checkWrite(req.cmd); err != nil {
+		if req.inMulti {
+			req.multiErr = true
+		}
+	} else if req.inMulti && !multiControlCmds[req.cmd] {
+		err = req.queueCommand()
 	} else {
+		//	a write is replied after the slaves ack it in semi-sync replication
+		semiSync := req.app.cfg.SemiSyncSlaves > 0 && !req.slave && req.cmd != "wait"
+
+		lastID := req.session.LastID()
+
 		go func() {
 			req.reqErr <- exeCmd(req)
 		}()
 
 		err = <-req.reqErr
+
+		//	only wait for the batch the writes of the request are committed in
+		if id := req.session.LastID(); semiSync && err == nil && id != lastID {
+			req.app.semiSyncWait(id)
+		}
 	}
 
 	duration := time.Since(start)
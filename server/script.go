@@ -0,0 +1,473 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/siddontang/ledisdb/ledis"
+	"github.com/yuin/gopher-lua"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+//	milliseconds
+const defaultLuaTimeLimit = 5000
+
+var (
+	errNoScript      = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	errScriptCommand = errors.New("this command is not allowed from scripts")
+	errScriptArgs    = errors.New("Lua ledis() command arguments must be strings or integers")
+	errScriptKilled  = errors.New("Error running script: killed by user with SCRIPT KILL")
+	errScriptTimeout = errors.New("Error running script: exceeded lua_time_limit, aborted")
+	errNotBusy       = errors.New("NOTBUSY No scripts in execution right now.")
+)
+
+//	commands can not be called from scripts
+var scriptDeniedCmds = map[string]bool{
+	"eval":         true,
+	"evalsha":      true,
+	"script":       true,
+	"multi":        true,
+	"exec":         true,
+	"discard":      true,
+	"watch":        true,
+	"unwatch":      true,
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"slaveof":      true,
+	"fullsync":     true,
+	"sync":         true,
+
+	//	the script blocks all the writers while WAIT blocks, and its writes are not committed yet
+	"wait": true,
+}
+
+//	the loaded scripts, keyed by the hex sha1 of the source
+type script struct {
+	sync.RWMutex
+
+	scripts map[string]string
+
+	//	the cancels of the running scripts, for SCRIPT KILL
+	running map[int64]context.CancelFunc
+	runID   int64
+}
+
+func newScript() *script {
+	s := new(script)
+
+	s.scripts = make(map[string]string)
+	s.running = make(map[int64]context.CancelFunc)
+
+	return s
+}
+
+//	the context of a script run, which is canceled after timeout or by kill,
+//	done must be called after the run
+func (s *script) start(timeout time.Duration) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	s.Lock()
+	s.runID++
+	id := s.runID
+	s.running[id] = cancel
+	s.Unlock()
+
+	return ctx, func() {
+		s.Lock()
+		delete(s.running, id)
+		s.Unlock()
+		cancel()
+	}
+}
+
+//	cancel the running scripts, returns the number of them
+func (s *script) kill() int {
+	s.Lock()
+	n := len(s.running)
+	for id, cancel := range s.running {
+		cancel()
+		delete(s.running, id)
+	}
+	s.Unlock()
+	return n
+}
+
+func scriptSHA1(src string) string {
+	h := sha1.Sum(ledis.Slice(src))
+	return hex.EncodeToString(h[:])
+}
+
+func (s *script) load(src string) string {
+	sha := scriptSHA1(src)
+
+	s.Lock()
+	s.scripts[sha] = src
+	s.Unlock()
+
+	return sha
+}
+
+func (s *script) get(sha string) (string, bool) {
+	s.RLock()
+	src, ok := s.scripts[strings.ToLower(sha)]
+	s.RUnlock()
+	return src, ok
+}
+
+func (s *script) flush() {
+	s.Lock()
+	s.scripts = make(map[string]string)
+	s.Unlock()
+}
+
+//	run the script in a ledis Multi, so its writes are atomic and logged in one binlog group,
+//	the script joins the Multi of EXEC if it is called in a transaction. The Multi blocks all
+//	the other writers, so the script is aborted after lua_time_limit, or by SCRIPT KILL, and
+//	its writes are discarded.
+func (req *requestContext) evalScript(src string, keys [][]byte, argv [][]byte) error {
+	m := req.multi
+	if m == nil {
+		m = req.session.Multi()
+		defer m.Rollback()
+	}
+
+	ret, err := req.runScript(m, src, keys, argv)
+	if err != nil {
+		return err
+	}
+
+	if req.multi == nil {
+		if err = m.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return writeLuaValue(req.resp, ret)
+}
+
+func (req *requestContext) runScript(m *ledis.Multi, src string, keys [][]byte, argv [][]byte) (lua.LValue, error) {
+	db, multi, cmd, args, resp := req.db, req.multi, req.cmd, req.args, req.resp
+	defer func() {
+		req.db, req.multi, req.cmd, req.args, req.resp = db, multi, cmd, args, resp
+	}()
+
+	req.multi = m
+	req.db, _ = m.Select(db.Index())
+
+	L := newLuaState(req)
+	defer L.Close()
+
+	limit := req.app.cfg.LuaTimeLimit
+	if limit <= 0 {
+		limit = defaultLuaTimeLimit
+	}
+
+	ctx, done := req.app.script.start(time.Duration(limit) * time.Millisecond)
+	defer done()
+	L.SetContext(ctx)
+
+	L.SetGlobal("KEYS", luaStrings(L, keys))
+	L.SetGlobal("ARGV", luaStrings(L, argv))
+
+	if err := L.DoString(src); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errScriptTimeout
+		} else if ctx.Err() != nil {
+			return nil, errScriptKilled
+		} else if e, ok := err.(*lua.ApiError); ok {
+			return nil, fmt.Errorf("Error running script: %s", e.Object.String())
+		}
+		return nil, fmt.Errorf("Error running script: %s", err.Error())
+	}
+
+	if L.GetTop() == 0 {
+		return lua.LNil, nil
+	}
+	return L.Get(1), nil
+}
+
+func newLuaState(req *requestContext) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	//	no file access from scripts
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	L.SetGlobal("ledis", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return req.luaCall(L, false)
+		},
+		"pcall": func(L *lua.LState) int {
+			return req.luaCall(L, true)
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(luaReplyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(luaReplyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+	}))
+
+	return L
+}
+
+//	ledis.call and ledis.pcall, dispatch the command to regCmds in the current DB,
+//	pcall returns the error as an error reply table instead of raising it
+func (req *requestContext) luaCall(L *lua.LState, protected bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for ledis.call()")
+		return 0
+	}
+
+	args := make([][]byte, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			args[i-1] = []byte(string(v))
+		case lua.LNumber:
+			args[i-1] = []byte(v.String())
+		default:
+			L.RaiseError("%s", errScriptArgs.Error())
+			return 0
+		}
+	}
+
+	var err error
+
+	cmd := strings.ToLower(ledis.String(args[0]))
+	if exeCmd, ok := regCmds[cmd]; !ok {
+		err = ErrNotFound
+	} else if scriptDeniedCmds[cmd] {
+		err = errScriptCommand
+	} else if err = req.checkWrite(cmd); err == nil {
+		w := &luaWriter{L: L, value: lua.LNil}
+
+		req.cmd, req.args, req.resp = cmd, args[1:], w
+		if err = exeCmd(req); err == nil {
+			L.Push(w.value)
+			return 1
+		}
+	}
+
+	if protected {
+		L.Push(luaReplyTable(L, "err", err.Error()))
+		return 1
+	}
+
+	L.RaiseError("%s", err.Error())
+	return 0
+}
+
+func luaStrings(L *lua.LState, lst [][]byte) *lua.LTable {
+	t := L.CreateTable(len(lst), 0)
+	for _, v := range lst {
+		t.Append(lua.LString(v))
+	}
+	return t
+}
+
+func luaReplyTable(L *lua.LState, field string, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+//	convert the script result to the reply like redis, a table with err or ok field is an error
+//	or a status reply, other tables are arrays ending at the first nil, false is a nil reply
+func writeLuaValue(w responseWriter, v lua.LValue) error {
+	switch v := v.(type) {
+	case lua.LNumber:
+		w.writeInteger(int64(v))
+	case lua.LString:
+		w.writeBulk([]byte(string(v)))
+	case lua.LBool:
+		if v {
+			w.writeInteger(1)
+		} else {
+			w.writeBulk(nil)
+		}
+	case *lua.LTable:
+		if s, ok := v.RawGetString("err").(lua.LString); ok {
+			return errors.New(string(s))
+		} else if s, ok := v.RawGetString("ok").(lua.LString); ok {
+			w.writeStatus(string(s))
+		} else {
+			w.writeArray(luaArray(v))
+		}
+	default:
+		w.writeBulk(nil)
+	}
+
+	return nil
+}
+
+func luaArray(t *lua.LTable) []interface{} {
+	ay := make([]interface{}, 0, t.Len())
+	for i := 1; ; i++ {
+		switch v := t.RawGetInt(i).(type) {
+		case lua.LNumber:
+			ay = append(ay, int64(v))
+		case lua.LString:
+			ay = append(ay, []byte(string(v)))
+		case lua.LBool:
+			if v {
+				ay = append(ay, int64(1))
+			} else {
+				ay = append(ay, nil)
+			}
+		case *lua.LTable:
+			if s, ok := v.RawGetString("err").(lua.LString); ok {
+				ay = append(ay, []byte(string(s)))
+			} else if s, ok := v.RawGetString("ok").(lua.LString); ok {
+				ay = append(ay, []byte(string(s)))
+			} else {
+				ay = append(ay, luaArray(v))
+			}
+		case *lua.LNilType:
+			return ay
+		default:
+			ay = append(ay, nil)
+		}
+	}
+}
+
+//	luaWriter converts the reply of a command called from scripts to a lua value like redis,
+//	a nil bulk or array is false, a status is a table with ok field
+type luaWriter struct {
+	L     *lua.LState
+	value lua.LValue
+}
+
+func (w *luaWriter) bulk(b []byte) lua.LValue {
+	if b == nil {
+		return lua.LFalse
+	}
+	return lua.LString(b)
+}
+
+func (w *luaWriter) writeError(err error) {
+	w.value = luaReplyTable(w.L, "err", err.Error())
+}
+
+func (w *luaWriter) writeStatus(status string) {
+	w.value = luaReplyTable(w.L, "ok", status)
+}
+
+func (w *luaWriter) writeInteger(n int64) {
+	w.value = lua.LNumber(n)
+}
+
+func (w *luaWriter) writeBulk(b []byte) {
+	w.value = w.bulk(b)
+}
+
+func (w *luaWriter) array(lst []interface{}) lua.LValue {
+	if lst == nil {
+		return lua.LFalse
+	}
+
+	t := w.L.CreateTable(len(lst), 0)
+	for _, v := range lst {
+		switch v := v.(type) {
+		case []interface{}:
+			t.Append(w.array(v))
+		case []byte:
+			t.Append(w.bulk(v))
+		case nil:
+			t.Append(lua.LFalse)
+		case int64:
+			t.Append(lua.LNumber(v))
+		case string:
+			t.Append(lua.LString(v))
+		case error:
+			t.Append(luaReplyTable(w.L, "err", v.Error()))
+		default:
+			//	a reply shape unknown yet, never crash the server for it
+			t.Append(lua.LString(fmt.Sprint(v)))
+		}
+	}
+	return t
+}
+
+func (w *luaWriter) writeArray(lst []interface{}) {
+	w.value = w.array(lst)
+}
+
+func (w *luaWriter) writeSliceArray(lst [][]byte) {
+	if lst == nil {
+		w.value = lua.LFalse
+		return
+	}
+
+	t := w.L.CreateTable(len(lst), 0)
+	for _, v := range lst {
+		t.Append(w.bulk(v))
+	}
+	w.value = t
+}
+
+func (w *luaWriter) writeFVPairArray(lst []ledis.FVPair) {
+	if lst == nil {
+		w.value = lua.LFalse
+		return
+	}
+
+	t := w.L.CreateTable(len(lst)*2, 0)
+	for _, v := range lst {
+		t.Append(w.bulk(v.Field))
+		t.Append(w.bulk(v.Value))
+	}
+	w.value = t
+}
+
+func (w *luaWriter) writeScorePairArray(lst []ledis.ScorePair, withScores bool) {
+	if lst == nil {
+		w.value = lua.LFalse
+		return
+	}
+
+	t := w.L.CreateTable(len(lst)*2, 0)
+	for _, v := range lst {
+		t.Append(w.bulk(v.Member))
+		if withScores {
+			t.Append(lua.LString(ledis.StrPutInt64(v.Score)))
+		}
+	}
+	w.value = t
+}
+
+func (w *luaWriter) writeBulkFrom(n int64, rb io.Reader) {
+	b, _ := ioutil.ReadAll(io.LimitReader(rb, n))
+	w.value = lua.LString(b)
+}
+
+func (w *luaWriter) flush() {
+}
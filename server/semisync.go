@@ -0,0 +1,178 @@
+package server
+
+import (
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/ledisdb/config"
+	"github.com/siddontang/ledisdb/ledis"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Semi-sync replication:
+
+	a write command is replied after semi_sync_slaves stream slaves ack the batch its writes
+	are committed in, see sync_stream.go. If they do not ack it in semi_sync_timeout
+	milliseconds, the write is replied anyway and the replication falls back to async, until
+	enough slaves ack the last batch committed again.
+
+WAIT numslaves timeout waits for the acks of the last batch of the client like this, for one request only.
+*/
+
+//	the last batch acked by a stream slave, and the binlog position after it
+type slaveAck struct {
+	addr string
+
+	//	the port the slave listens on, empty if unknown
+	port string
+
+	ledis.MasterInfo
+	time time.Time
+}
+
+//	the last batches acked by the stream slaves
+type slaveAcks struct {
+	sync.Mutex
+
+	//	the remote address of the slave -> the last batch acked
+	acks map[string]*slaveAck
+
+	//	closed when a slave acks, then replaced
+	acked chan struct{}
+
+	//	semi-sync falls back to async after a timeout
+	async bool
+}
+
+func newSlaveAcks() *slaveAcks {
+	s := new(slaveAcks)
+	s.acks = make(map[string]*slaveAck)
+	s.acked = make(chan struct{})
+	return s
+}
+
+//	a stream slave starts at m
+func (s *slaveAcks) add(slave string, port string, m ledis.MasterInfo) {
+	s.Lock()
+	s.acks[slave] = &slaveAck{slave, port, m, time.Now()}
+	s.notify()
+	s.Unlock()
+}
+
+func (s *slaveAcks) ack(slave string, m ledis.MasterInfo) {
+	s.Lock()
+	if ack, ok := s.acks[slave]; ok {
+		ack.MasterInfo = m
+		ack.time = time.Now()
+		s.notify()
+	}
+	s.Unlock()
+}
+
+//	wake up the waiters, the Mutex must be held
+func (s *slaveAcks) notify() {
+	close(s.acked)
+	s.acked = make(chan struct{})
+}
+
+func (s *slaveAcks) remove(slave string) {
+	s.Lock()
+	delete(s.acks, slave)
+	s.Unlock()
+}
+
+//	the number of the slaves having acked id, the Mutex must be held
+func (s *slaveAcks) count(id uint64) int {
+	n := 0
+	for _, ack := range s.acks {
+		if ack.LastID >= id {
+			n++
+		}
+	}
+	return n
+}
+
+//	the stream slaves sorted by address
+func (s *slaveAcks) list() []slaveAck {
+	s.Lock()
+	acks := make([]slaveAck, 0, len(s.acks))
+	for _, ack := range s.acks {
+		acks = append(acks, *ack)
+	}
+	s.Unlock()
+
+	sort.Sort(slaveAckSlice(acks))
+	return acks
+}
+
+type slaveAckSlice []slaveAck
+
+func (s slaveAckSlice) Len() int           { return len(s) }
+func (s slaveAckSlice) Less(i, j int) bool { return s[i].addr < s[j].addr }
+func (s slaveAckSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+//	wait until numSlaves slaves ack id or the timeout, 0 timeout to wait forever,
+//	returns the number of the slaves having acked id.
+func (s *slaveAcks) wait(id uint64, numSlaves int, timeout time.Duration) int {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		s.Lock()
+		n := s.count(id)
+		acked := s.acked
+		s.Unlock()
+
+		if n >= numSlaves {
+			return n
+		}
+
+		select {
+		case <-acked:
+		case <-expired:
+			s.Lock()
+			n = s.count(id)
+			s.Unlock()
+			return n
+		}
+	}
+}
+
+//	wait for the acks of batch id the writes of a request are committed in, if semi-sync is enabled
+func (app *App) semiSyncWait(id uint64) {
+	numSlaves := app.cfg.SemiSyncSlaves
+	if numSlaves <= 0 {
+		return
+	}
+
+	app.acks.Lock()
+	if app.acks.async {
+		if app.acks.count(id) < numSlaves {
+			app.acks.Unlock()
+			return
+		}
+
+		app.acks.async = false
+		log.Info("semi-sync replication resumed")
+	}
+	app.acks.Unlock()
+
+	timeout := time.Duration(app.cfg.SemiSyncTimeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Duration(config.DefaultSemiSyncTimeout) * time.Millisecond
+	}
+
+	if n := app.acks.wait(id, numSlaves, timeout); n < numSlaves {
+		app.acks.Lock()
+		if !app.acks.async {
+			app.acks.async = true
+			log.Warn("semi-sync timeout, %d slaves acked %d, replication falls back to async", n, id)
+		}
+		app.acks.Unlock()
+	}
+}
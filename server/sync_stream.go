@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/ledisdb/ledis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Stream sync:
+
+	the slave sends SYNC lastid STREAM port after the handshake, then the master keeps the
+	connection and pushes the batches after lastid as they are committed, every frame
+	is a bulk of the sync data like a SYNC reply, with the bytes of the binlog after it,
+	see readSyncData. A frame with no events is pushed as a heartbeat if nothing is
+	committed in syncHeartbeatInterval.
+
+	the slave acks every frame after replicating it with REPLCONF ACK lastid, the master
+	pushes maxSyncFramesInFlight frames at most before they are acked, so a slow slave
+	is never flooded. The binlog after the last id acked is kept for the slave.
+
+	either side closes the connection if the other one is silent for syncStreamTimeout,
+	the master closes it after a frame with status 0 or -1 too. The slave reconnects and
+	resumes from the last id replicated, or does a full sync for -1.
+*/
+
+const (
+	syncHeartbeatInterval = 1 * time.Second
+	syncStreamTimeout     = 10 * time.Second
+
+	maxSyncFramesInFlight = 16
+)
+
+var errSyncStreamHttp = errors.New("sync stream is only supported with the RESP protocol")
+
+//	push the batches after lastID until the slave or the app quits, the connection is
+//	closed at the end, the client goroutine is taken meanwhile.
+func (c *respClient) syncStream(lastID uint64, port string) error {
+	acks := make(chan uint64, maxSyncFramesInFlight)
+	done := make(chan struct{})
+	go c.readSyncAcks(acks, done)
+
+	defer func() {
+		//	the ack reader ends, then the client goroutine can read the closed connection
+		c.conn.Close()
+		<-done
+	}()
+
+	c.app.acks.add(c.req.remoteAddr, port, ledis.MasterInfo{LastID: lastID})
+	defer c.app.acks.remove(c.req.remoteAddr)
+
+	//	the positions of the frames not acked yet
+	inFlight := make([]ledis.MasterInfo, 0, maxSyncFramesInFlight)
+
+	heartbeat := time.NewTimer(syncHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var wait <-chan struct{}
+		if len(inFlight) < maxSyncFramesInFlight {
+			wait = c.ldb.BinLogWait(lastID)
+		}
+
+		select {
+		case id := <-acks:
+			acked := ledis.MasterInfo{LastID: id}
+			for len(inFlight) > 0 && inFlight[0].LastID <= id {
+				acked = inFlight[0]
+				inFlight = inFlight[1:]
+			}
+			c.ldb.SetSlaveLastID(c.req.remoteAddr, id)
+			c.app.acks.ack(c.req.remoteAddr, acked)
+			continue
+		case <-wait:
+		case <-heartbeat.C:
+			if len(inFlight) >= maxSyncFramesInFlight {
+				//	the slave is busy, it acks later
+				heartbeat.Reset(syncHeartbeatInterval)
+				continue
+			}
+		case <-done:
+			return nil
+		case <-c.app.quit:
+			return nil
+		}
+
+		m := &ledis.MasterInfo{LastID: lastID}
+		buf, err := c.req.readSyncData(m, true)
+		if err != nil {
+			log.Error("read sync data error %s", err.Error())
+			return nil
+		}
+
+		c.conn.SetWriteDeadline(time.Now().Add(syncStreamTimeout))
+		c.resp.writeBulk(buf)
+		if err = c.resp.buff.Flush(); err != nil {
+			return nil
+		}
+
+		if m.LogFileIndex <= 0 {
+			//	the slave stops or does a full sync
+			return nil
+		}
+
+		lastID = m.LastID
+		inFlight = append(inFlight, *m)
+
+		if !heartbeat.Stop() {
+			select {
+			case <-heartbeat.C:
+			default:
+			}
+		}
+		heartbeat.Reset(syncHeartbeatInterval)
+	}
+}
+
+//	read the REPLCONF ACK lastid sent by the slave, done is closed when the slave is
+//	silent for syncStreamTimeout or the connection is closed.
+func (c *respClient) readSyncAcks(acks chan<- uint64, done chan struct{}) {
+	defer close(done)
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(syncStreamTimeout))
+
+		args, err := c.readRequest()
+		if err != nil {
+			return
+		}
+
+		if len(args) != 3 || strings.ToLower(ledis.String(args[0])) != "replconf" ||
+			strings.ToLower(ledis.String(args[1])) != "ack" {
+			log.Error("invalid sync ack from slave %s", c.req.remoteAddr)
+			return
+		}
+
+		id, err := strconv.ParseUint(ledis.String(args[2]), 10, 64)
+		if err != nil {
+			log.Error("invalid sync ack from slave %s", c.req.remoteAddr)
+			return
+		}
+
+		select {
+		case acks <- id:
+		default:
+			//	a slave acks the frames in flight only, the later acks cover the ones dropped
+		}
+	}
+}
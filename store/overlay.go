@@ -0,0 +1,318 @@
+package store
+
+import (
+	"bytes"
+	"github.com/siddontang/ledisdb/store/driver"
+	"sort"
+)
+
+// Overlay buffers the writes to a DB in memory.
+//
+// The reads through the overlay see the buffered writes over the DB,
+// nothing is written to the DB until the buffered writes are replayed
+// by WriteTo, in key order.
+type Overlay struct {
+	base driver.IDB
+
+	keys   []string
+	values map[string]*overlayValue
+}
+
+type overlayValue struct {
+	value   []byte
+	deleted bool
+}
+
+type overlayWriter interface {
+	Put(key []byte, value []byte)
+	Delete(key []byte)
+}
+
+func NewOverlay(db *DB) *Overlay {
+	o := new(Overlay)
+
+	o.base = db.db
+	o.values = make(map[string]*overlayValue)
+
+	return o
+}
+
+// DB returns the DB reading and writing through the overlay.
+func (o *Overlay) DB() *DB {
+	return &DB{o}
+}
+
+// Len returns the number of buffered keys.
+func (o *Overlay) Len() int {
+	return len(o.keys)
+}
+
+// WriteTo replays the buffered writes to w in key order.
+func (o *Overlay) WriteTo(w overlayWriter) {
+	for _, key := range o.keys {
+		if v := o.values[key]; v.deleted {
+			w.Delete([]byte(key))
+		} else {
+			w.Put([]byte(key), v.value)
+		}
+	}
+}
+
+func (o *Overlay) set(key []byte, value []byte, deleted bool) {
+	k := string(key)
+
+	if _, ok := o.values[k]; !ok {
+		i := sort.SearchStrings(o.keys, k)
+		o.keys = append(o.keys, "")
+		copy(o.keys[i+1:], o.keys[i:])
+		o.keys[i] = k
+	}
+
+	o.values[k] = &overlayValue{append([]byte{}, value...), deleted}
+}
+
+func (o *Overlay) Close() error {
+	return nil
+}
+
+func (o *Overlay) Get(key []byte) ([]byte, error) {
+	if v, ok := o.values[string(key)]; ok {
+		if v.deleted {
+			return nil, nil
+		}
+		return append([]byte{}, v.value...), nil
+	}
+
+	return o.base.Get(key)
+}
+
+func (o *Overlay) Put(key []byte, value []byte) error {
+	o.set(key, value, false)
+	return nil
+}
+
+func (o *Overlay) Delete(key []byte) error {
+	o.set(key, nil, true)
+	return nil
+}
+
+func (o *Overlay) NewIterator() driver.IIterator {
+	it := new(overlayIterator)
+
+	it.it = o.base.NewIterator()
+
+	//	iterate a snapshot of the buffered writes
+	it.keys = make([][]byte, len(o.keys))
+	it.values = make([]*overlayValue, len(o.keys))
+	for i, key := range o.keys {
+		it.keys[i] = []byte(key)
+		it.values[i] = o.values[key]
+	}
+
+	return it
+}
+
+func (o *Overlay) NewWriteBatch() driver.IWriteBatch {
+	return &overlayWriteBatch{o: o}
+}
+
+func (o *Overlay) Begin() (driver.Tx, error) {
+	return nil, driver.ErrTxSupport
+}
+
+type overlayWrite struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+type overlayWriteBatch struct {
+	o      *Overlay
+	writes []overlayWrite
+}
+
+func (wb *overlayWriteBatch) Put(key []byte, value []byte) {
+	wb.writes = append(wb.writes, overlayWrite{key, value, false})
+}
+
+func (wb *overlayWriteBatch) Delete(key []byte) {
+	wb.writes = append(wb.writes, overlayWrite{key, nil, true})
+}
+
+func (wb *overlayWriteBatch) Commit() error {
+	for _, w := range wb.writes {
+		wb.o.set(w.key, w.value, w.deleted)
+	}
+	wb.writes = wb.writes[0:0]
+	return nil
+}
+
+func (wb *overlayWriteBatch) Rollback() error {
+	wb.writes = wb.writes[0:0]
+	return nil
+}
+
+//	merge the base iterator and the buffered writes, the buffered one wins
+//	for the same key and the deleted keys are skipped
+type overlayIterator struct {
+	it driver.IIterator
+
+	keys   [][]byte
+	values []*overlayValue
+	pos    int
+
+	forward bool
+
+	valid   bool
+	fromMem bool
+	key     []byte
+	buf     []byte
+}
+
+func (it *overlayIterator) Close() error {
+	return it.it.Close()
+}
+
+func (it *overlayIterator) First() {
+	it.it.First()
+	it.pos = 0
+	it.forward = true
+	it.settle()
+}
+
+func (it *overlayIterator) Last() {
+	it.it.Last()
+	it.pos = len(it.keys) - 1
+	it.forward = false
+	it.settle()
+}
+
+func (it *overlayIterator) Seek(key []byte) {
+	it.it.Seek(key)
+	it.pos = it.search(key)
+	it.forward = true
+	it.settle()
+}
+
+func (it *overlayIterator) Next() {
+	if !it.valid {
+		return
+	}
+
+	cur := it.key
+	if !it.forward {
+		it.it.Seek(cur)
+		it.pos = it.search(cur)
+		it.forward = true
+	}
+
+	it.skip(cur)
+	it.settle()
+}
+
+func (it *overlayIterator) Prev() {
+	if !it.valid {
+		return
+	}
+
+	cur := it.key
+	if it.forward {
+		it.it.Seek(cur)
+		if it.it.Valid() {
+			it.it.Prev()
+		} else {
+			it.it.Last()
+		}
+		it.pos = it.search(cur) - 1
+		it.forward = false
+	} else {
+		it.skip(cur)
+	}
+
+	it.settle()
+}
+
+func (it *overlayIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *overlayIterator) Key() []byte {
+	if !it.valid {
+		return nil
+	} else if it.fromMem {
+		return it.key
+	}
+	return it.it.Key()
+}
+
+func (it *overlayIterator) Value() []byte {
+	if !it.valid {
+		return nil
+	} else if it.fromMem {
+		return it.values[it.pos].value
+	}
+	return it.it.Value()
+}
+
+//	the index of the first buffered key >= key
+func (it *overlayIterator) search(key []byte) int {
+	return sort.Search(len(it.keys), func(i int) bool {
+		return bytes.Compare(it.keys[i], key) >= 0
+	})
+}
+
+func (it *overlayIterator) memValid() bool {
+	return it.pos >= 0 && it.pos < len(it.keys)
+}
+
+//	move the base and the buffered positions at key in the current direction
+func (it *overlayIterator) skip(key []byte) {
+	if it.it.Valid() && bytes.Equal(it.it.Key(), key) {
+		if it.forward {
+			it.it.Next()
+		} else {
+			it.it.Prev()
+		}
+	}
+
+	if it.memValid() && bytes.Equal(it.keys[it.pos], key) {
+		if it.forward {
+			it.pos++
+		} else {
+			it.pos--
+		}
+	}
+}
+
+//	find the current key from the base and the buffered positions
+func (it *overlayIterator) settle() {
+	for {
+		baseValid, memValid := it.it.Valid(), it.memValid()
+		if !baseValid && !memValid {
+			it.valid = false
+			it.key = nil
+			return
+		}
+
+		it.fromMem = !baseValid
+		if baseValid && memValid {
+			c := bytes.Compare(it.keys[it.pos], it.it.Key())
+			it.fromMem = c == 0 || (c < 0) == it.forward
+		}
+
+		if !it.fromMem {
+			it.valid = true
+			it.buf = append(it.buf[0:0], it.it.Key()...)
+			it.key = it.buf
+			return
+		}
+
+		it.key = it.keys[it.pos]
+		if !it.values[it.pos].deleted {
+			it.valid = true
+			return
+		}
+
+		it.skip(it.key)
+	}
+}
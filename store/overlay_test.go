@@ -0,0 +1,92 @@
+package store
+
+import (
+	"fmt"
+	"github.com/siddontang/ledisdb/config"
+	"os"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.DataDir = "/tmp/testdb_overlay"
+	cfg.DBName = "goleveldb"
+
+	os.RemoveAll(cfg.DataDir)
+
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i += 2 {
+		db.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("%d", i)))
+	}
+
+	o := NewOverlay(db)
+	odb := o.DB()
+
+	odb.Put([]byte("key_3"), []byte("3"))
+	odb.Delete([]byte("key_4"))
+
+	wb := odb.NewWriteBatch()
+	wb.Put([]byte("key_9"), []byte("9"))
+	wb.Delete([]byte("key_0"))
+	wb.Put([]byte("key_2"), []byte("two"))
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	wb.Put([]byte("key_1"), []byte("1"))
+	wb.Rollback()
+
+	if v, _ := odb.Get([]byte("key_2")); string(v) != "two" {
+		t.Fatal(string(v))
+	} else if v, _ := odb.Get([]byte("key_4")); v != nil {
+		t.Fatal(string(v))
+	} else if v, _ := odb.Get([]byte("key_0")); v != nil {
+		t.Fatal(string(v))
+	} else if v, _ := db.Get([]byte("key_2")); string(v) != "2" {
+		t.Fatal(string(v))
+	}
+
+	it := odb.RangeIterator(nil, nil, RangeClose)
+	if err := checkIterator(it, 2, 3, 6, 8, 9); err != nil {
+		t.Fatal(err)
+	}
+
+	it = odb.RevRangeIterator(nil, nil, RangeClose)
+	if err := checkIterator(it, 9, 8, 6, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	it = odb.RangeLimitIterator([]byte("key_3"), []byte("key_8"), RangeOpen, 0, -1)
+	if err := checkIterator(it, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	//	change the direction
+	raw := odb.NewIterator()
+	raw.Seek([]byte("key_6"))
+	raw.Prev()
+	if string(raw.Key()) != "key_3" {
+		t.Fatal(string(raw.Key()))
+	}
+	raw.Next()
+	if string(raw.Key()) != "key_6" {
+		t.Fatal(string(raw.Key()))
+	}
+	raw.Close()
+
+	wb = db.NewWriteBatch()
+	o.WriteTo(wb)
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	it = db.RangeIterator(nil, nil, RangeClose)
+	if err := checkIterator(it, 2, 3, 6, 8, 9); err != nil {
+		t.Fatal(err)
+	}
+}